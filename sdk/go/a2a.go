@@ -0,0 +1,157 @@
+package ping
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// A2A agent card constants. A2ACardPath is where A2A clients look for an
+// agent's card, relative to its base URL.
+const (
+	A2AProtocolVersion = "0.3.0"
+	A2ACardPath        = "/.well-known/agent-card.json"
+	// A2AExtensionURI identifies the card extension that carries an
+	// agent's PING identity; see docs/A2A.md.
+	A2AExtensionURI = "https://github.com/aetos53t/ping/blob/main/docs/A2A.md"
+)
+
+// A2ACard is an agent card in the A2A protocol's format. Only the fields
+// PING maps are included; others, such as security schemes, are dropped
+// when a card is decoded into it.
+type A2ACard struct {
+	ProtocolVersion    string          `json:"protocolVersion"`
+	Name               string          `json:"name"`
+	Description        string          `json:"description"`
+	URL                string          `json:"url"`
+	Provider           *A2AProvider    `json:"provider,omitempty"`
+	Version            string          `json:"version"`
+	DocumentationURL   string          `json:"documentationUrl,omitempty"`
+	Capabilities       A2ACapabilities `json:"capabilities"`
+	DefaultInputModes  []string        `json:"defaultInputModes"`
+	DefaultOutputModes []string        `json:"defaultOutputModes"`
+	Skills             []A2ASkill      `json:"skills"`
+}
+
+// A2AProvider is the organization behind an A2A agent.
+type A2AProvider struct {
+	Organization string `json:"organization"`
+	URL          string `json:"url,omitempty"`
+}
+
+// A2ACapabilities lists the optional A2A features an agent supports.
+type A2ACapabilities struct {
+	Streaming              bool           `json:"streaming,omitempty"`
+	PushNotifications      bool           `json:"pushNotifications,omitempty"`
+	StateTransitionHistory bool           `json:"stateTransitionHistory,omitempty"`
+	Extensions             []A2AExtension `json:"extensions,omitempty"`
+}
+
+// A2AExtension declares an A2A protocol extension the agent supports.
+type A2AExtension struct {
+	URI         string          `json:"uri"`
+	Description string          `json:"description,omitempty"`
+	Required    bool            `json:"required,omitempty"`
+	Params      json.RawMessage `json:"params,omitempty"`
+}
+
+// A2ASkill is one capability of an A2A agent.
+type A2ASkill struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	Examples    []string `json:"examples,omitempty"`
+	InputModes  []string `json:"inputModes,omitempty"`
+	OutputModes []string `json:"outputModes,omitempty"`
+}
+
+// a2aParams are the params of the PING card extension: everything needed
+// to rebuild the Agent, including what A2A has no field for.
+type a2aParams struct {
+	AgentID      string                 `json:"agentId"`
+	PublicKey    string                 `json:"publicKey"`
+	Capabilities []string               `json:"capabilities,omitempty"`
+	Descriptors  []CapabilityDescriptor `json:"capabilityDescriptors,omitempty"`
+}
+
+// ToA2ACard describes the agent as an A2A agent card. Each capability
+// becomes a skill, with the descriptor's details where there is one, and
+// the agent's PING ID, key and full capabilities travel in the PING card
+// extension, so FromA2ACard restores them. The card's URL is the agent's
+// webhook URL; set it to wherever the card's readers should reach the
+// agent.
+func (a *Agent) ToA2ACard() (*A2ACard, error) {
+	params, err := json.Marshal(a2aParams{
+		AgentID:      a.ID,
+		PublicKey:    a.PublicKey,
+		Capabilities: a.Capabilities,
+		Descriptors:  a.Descriptors,
+	})
+	if err != nil {
+		return nil, err
+	}
+	card := &A2ACard{
+		ProtocolVersion: A2AProtocolVersion,
+		Name:            a.Name,
+		Description:     fmt.Sprintf("%s, reachable over PING as %s", a.Name, a.ID),
+		URL:             a.WebhookURL,
+		Version:         ProtocolVersion,
+		Capabilities: A2ACapabilities{
+			PushNotifications: a.WebhookURL != "",
+			Extensions: []A2AExtension{{
+				URI:         A2AExtensionURI,
+				Description: "PING agent identity",
+				Params:      params,
+			}},
+		},
+		DefaultInputModes:  []string{"application/json"},
+		DefaultOutputModes: []string{"application/json"},
+		Skills:             []A2ASkill{},
+	}
+	if a.Provider != "" {
+		card.Provider = &A2AProvider{Organization: a.Provider}
+	}
+	for _, name := range capabilityNames(a.Capabilities, a.Descriptors) {
+		d := a.Capability(name)
+		skill := A2ASkill{ID: d.Name, Name: d.Name, Description: d.Description, Tags: []string{}}
+		if skill.Description == "" {
+			skill.Description = d.Name
+		}
+		if d.Version != "" {
+			skill.Tags = append(skill.Tags, "version:"+d.Version)
+		}
+		card.Skills = append(card.Skills, skill)
+	}
+	return card, nil
+}
+
+// FromA2ACard converts an A2A agent card to an Agent. Cards made by
+// ToA2ACard restore the agent's PING ID, key and capabilities. Other
+// agents get an empty ID, since they cannot be messaged over PING, and a
+// descriptor per skill.
+func FromA2ACard(card *A2ACard) (*Agent, error) {
+	a := &Agent{Name: card.Name}
+	if card.Provider != nil {
+		a.Provider = card.Provider.Organization
+	}
+	for _, ext := range card.Capabilities.Extensions {
+		if ext.URI != A2AExtensionURI {
+			continue
+		}
+		var p a2aParams
+		if err := json.Unmarshal(ext.Params, &p); err != nil {
+			return nil, fmt.Errorf("a2a card %s: PING extension: %w", card.Name, err)
+		}
+		a.ID, a.PublicKey = p.AgentID, p.PublicKey
+		a.Capabilities, a.Descriptors = p.Capabilities, p.Descriptors
+		if card.Capabilities.PushNotifications {
+			a.WebhookURL = card.URL
+		}
+		return a, nil
+	}
+	for _, skill := range card.Skills {
+		a.Capabilities = append(a.Capabilities, skill.ID)
+		a.Descriptors = append(a.Descriptors, CapabilityDescriptor{Name: skill.ID, Description: skill.Description})
+	}
+	return a, nil
+}
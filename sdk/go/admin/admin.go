@@ -0,0 +1,213 @@
+// Package admin is a client for the operator API of self-hosted PING
+// servers, for moderation tasks that would otherwise need raw HTTP.
+//
+// Requests are authenticated with the server's admin key, sent in the
+// X-Admin-Token header:
+//
+//	ac := admin.New("http://localhost:3100", os.Getenv("ADMIN_TOKEN"))
+//	agents, err := ac.ListAgents(ctx)
+//	err = ac.Deregister(ctx, spammerID)
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	ping "github.com/aetos53t/ping/sdk/go"
+)
+
+// TokenHeader carries the admin key.
+const TokenHeader = "X-Admin-Token"
+
+// Optional operator features. Listing agents and their messages works on
+// every server with the admin API.
+const (
+	// FeatureModeration is force-deregistering agents and purging their
+	// messages.
+	FeatureModeration ping.Feature = "admin-moderation"
+	// FeatureBroadcast is sending announcements to all agents.
+	FeatureBroadcast ping.Feature = "admin-broadcast"
+)
+
+// AdminClient calls a PING server's admin API. It is safe for concurrent
+// use.
+type AdminClient struct {
+	baseURL string
+	token   string
+	http    *http.Client
+
+	// info negotiates the server's features; it has no agent identity.
+	info       *ping.Client
+	mu         sync.Mutex
+	negotiated bool
+}
+
+// Option configures an AdminClient.
+type Option func(*AdminClient)
+
+// WithHTTPClient sets the HTTP client used for admin requests.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *AdminClient) {
+		c.http = hc
+	}
+}
+
+// New creates an admin client for the server at baseURL using the
+// admin key token.
+func New(baseURL, token string, opts ...Option) *AdminClient {
+	c := &AdminClient{
+		baseURL: baseURL,
+		token:   token,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.info = ping.NewClient(baseURL, ping.WithHTTPClient(c.http))
+	return c
+}
+
+// ListAgents returns every registered agent, including private ones.
+func (c *AdminClient) ListAgents(ctx context.Context) ([]ping.Agent, error) {
+	var agents []ping.Agent
+	if err := c.do(ctx, "GET", "/admin/agents", nil, &agents); err != nil {
+		return nil, err
+	}
+	return agents, nil
+}
+
+// AgentMessages returns all messages in an agent's inbox, acknowledged or
+// not.
+func (c *AdminClient) AgentMessages(ctx context.Context, agentID string) ([]ping.Message, error) {
+	var msgs []ping.Message
+	if err := c.do(ctx, "GET", "/admin/agents/"+url.PathEscape(agentID)+"/messages", nil, &msgs); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// Deregister removes an agent and its contacts and messages, whatever its
+// owner wants. It requires FeatureModeration.
+func (c *AdminClient) Deregister(ctx context.Context, agentID string) error {
+	if err := c.require(ctx, FeatureModeration); err != nil {
+		return err
+	}
+	return c.do(ctx, "DELETE", "/admin/agents/"+url.PathEscape(agentID), nil, nil)
+}
+
+// PurgeOptions selects the messages PurgeMessages deletes.
+type PurgeOptions struct {
+	// Before, if set, deletes only messages sent before this time.
+	Before time.Time
+}
+
+// PurgeMessages deletes messages sent to or by an agent and returns how
+// many were deleted. It requires FeatureModeration.
+func (c *AdminClient) PurgeMessages(ctx context.Context, agentID string, opts PurgeOptions) (int, error) {
+	if err := c.require(ctx, FeatureModeration); err != nil {
+		return 0, err
+	}
+	path := "/admin/agents/" + url.PathEscape(agentID) + "/messages"
+	if !opts.Before.IsZero() {
+		path += "?before=" + strconv.FormatInt(opts.Before.UnixMilli(), 10)
+	}
+	var resp struct {
+		Purged int `json:"purged"`
+	}
+	if err := c.do(ctx, "DELETE", path, nil, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Purged, nil
+}
+
+// Announcement is a message from the server operator to agents.
+type Announcement struct {
+	Title string `json:"title,omitempty"`
+	Text  string `json:"text"`
+	// PublicOnly limits the announcement to agents listed in the
+	// directory.
+	PublicOnly bool `json:"publicOnly,omitempty"`
+}
+
+// Broadcast delivers an announcement to every agent's inbox and returns
+// how many agents it reached. It requires FeatureBroadcast.
+func (c *AdminClient) Broadcast(ctx context.Context, a Announcement) (int, error) {
+	if a.Text == "" {
+		return 0, fmt.Errorf("announcement has no text")
+	}
+	if err := c.require(ctx, FeatureBroadcast); err != nil {
+		return 0, err
+	}
+	var resp struct {
+		Recipients int `json:"recipients"`
+	}
+	if err := c.do(ctx, "POST", "/admin/broadcast", a, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Recipients, nil
+}
+
+// require returns an error matching ping.ErrUnsupported if the server does
+// not offer f, negotiating on first use.
+func (c *AdminClient) require(ctx context.Context, f ping.Feature) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.negotiated {
+		if _, err := c.info.Negotiate(ctx); err != nil {
+			return err
+		}
+		c.negotiated = true
+	}
+	if !c.info.Supports(f) {
+		return &ping.FeatureError{Feature: f, ServerVersion: c.info.NegotiatedVersion()}
+	}
+	return nil
+}
+
+// do sends an authenticated admin request and decodes the JSON response
+// into result, if non-nil. Error responses are returned as *ping.APIError.
+func (c *AdminClient) do(ctx context.Context, method, path string, body, result interface{}) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set(TokenHeader, c.token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain what is left, so the connection can be reused.
+	defer io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode >= 400 {
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&errResp)
+		return &ping.APIError{StatusCode: resp.StatusCode, Message: errResp.Error}
+	}
+	if result == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, ping.DefaultMaxResponseBytes)).Decode(result)
+}
@@ -0,0 +1,277 @@
+package ping
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditOp names an operation recorded in an AuditLog.
+type AuditOp string
+
+// Audited operations.
+const (
+	AuditRegister      AuditOp = "register"
+	AuditSend          AuditOp = "send"
+	AuditAck           AuditOp = "ack"
+	AuditAddContact    AuditOp = "contact.add"
+	AuditRemoveContact AuditOp = "contact.remove"
+	AuditBlock         AuditOp = "block"
+	AuditUnblock       AuditOp = "unblock"
+	AuditTrust         AuditOp = "trust"
+	AuditRevokeTrust   AuditOp = "trust.revoke"
+)
+
+// MessageTypeAudit is the type of the messages a StoreAuditLog saves its
+// entries as. It is never sent.
+const MessageTypeAudit MessageType = "audit"
+
+// AuditEntry records one operation and its outcome.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	Op   AuditOp   `json:"op"`
+	// Agent is the client's agent ID when the operation ran; for
+	// AuditRegister, the ID the server assigned.
+	Agent string `json:"agent,omitempty"`
+	// Target is the recipient of a message, or the contact or blocked
+	// agent.
+	Target string `json:"target,omitempty"`
+	// MessageID is the ID of the message sent or acknowledged.
+	MessageID string      `json:"messageId,omitempty"`
+	Type      MessageType `json:"type,omitempty"`
+	// Error is the operation's error, or "" if it succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// OK reports whether the operation succeeded.
+func (e *AuditEntry) OK() bool {
+	return e.Error == ""
+}
+
+// AuditFilter selects entries from an AuditLog. Zero fields match
+// everything.
+type AuditFilter struct {
+	Op     AuditOp
+	Target string
+	Since  time.Time
+	Until  time.Time
+	// Failed keeps only entries whose operation failed.
+	Failed bool
+	// Limit keeps only the most recent Limit matches.
+	Limit int
+}
+
+func (f AuditFilter) match(e *AuditEntry) bool {
+	if f.Op != "" && e.Op != f.Op {
+		return false
+	}
+	if f.Target != "" && e.Target != f.Target {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	return !f.Failed || !e.OK()
+}
+
+// filter returns the entries matching f, oldest first.
+func (f AuditFilter) filter(entries []AuditEntry) []AuditEntry {
+	var out []AuditEntry
+	for i := range entries {
+		if f.match(&entries[i]) {
+			out = append(out, entries[i])
+		}
+	}
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[len(out)-f.Limit:]
+	}
+	return out
+}
+
+// AuditLog is an append-only record of the operations a client performs.
+// When a client is configured with one, every Register, Send, Ack,
+// AddContact, RemoveContact, Block, Unblock, TrustAgent and RevokeTrust is
+// appended to it, whether it succeeds or not.
+type AuditLog interface {
+	// Append adds entries to the log.
+	Append(ctx context.Context, entries ...AuditEntry) error
+	// Entries returns the entries matching f, oldest first.
+	Entries(ctx context.Context, f AuditFilter) ([]AuditEntry, error)
+}
+
+// WithAuditLog records the client's operations in l. Failures to append
+// do not fail the operation being recorded.
+func WithAuditLog(l AuditLog) Option {
+	return func(c *Client) {
+		c.auditLog = l
+	}
+}
+
+// AuditLog returns the client's audit log, or nil if none is configured.
+func (c *Client) AuditLog() AuditLog {
+	return c.auditLog
+}
+
+// audit appends e to the client's audit log, if any, with err as its
+// outcome.
+func (c *Client) audit(ctx context.Context, e AuditEntry, err error) {
+	if c.auditLog == nil {
+		return
+	}
+	e.Time = time.Now()
+	if e.Agent == "" {
+		e.Agent = c.AgentID()
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	c.auditLog.Append(context.WithoutCancel(ctx), e)
+}
+
+// MemoryAuditLog is an in-memory AuditLog. The zero value is ready to use.
+type MemoryAuditLog struct {
+	mu      sync.RWMutex
+	entries []AuditEntry
+}
+
+// Append implements AuditLog.
+func (l *MemoryAuditLog) Append(ctx context.Context, entries ...AuditEntry) error {
+	l.mu.Lock()
+	l.entries = append(l.entries, entries...)
+	l.mu.Unlock()
+	return nil
+}
+
+// Entries implements AuditLog.
+func (l *MemoryAuditLog) Entries(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return f.filter(l.entries), nil
+}
+
+// FileAuditLog is an AuditLog persisted as a JSON Lines file, one entry
+// per line. The file is only ever appended to.
+type FileAuditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+// OpenAuditLog opens or creates a JSON Lines audit log at path.
+func OpenAuditLog(path string) (*FileAuditLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return &FileAuditLog{path: path}, nil
+}
+
+// Append implements AuditLog.
+func (l *FileAuditLog) Append(ctx context.Context, entries ...AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// Entries implements AuditLog by reading the file.
+func (l *FileAuditLog) Entries(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var e AuditEntry
+			if jsonErr := json.Unmarshal(line, &e); jsonErr != nil {
+				return nil, jsonErr
+			}
+			entries = append(entries, e)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return f.filter(entries), nil
+}
+
+// StoreAuditLog is an AuditLog kept in a message Store, as messages of type
+// MessageTypeAudit, so it shares the store's persistence. A Compactor
+// pruning the same store applies its policy to the audit entries too; use
+// a separate store to keep them whatever the policy.
+type StoreAuditLog struct {
+	Store Store
+}
+
+// Append implements AuditLog.
+func (l *StoreAuditLog) Append(ctx context.Context, entries ...AuditEntry) error {
+	msgs := make([]Message, 0, len(entries))
+	for _, e := range entries {
+		raw, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		var id [8]byte
+		if _, err := rand.Read(id[:]); err != nil {
+			return err
+		}
+		msgs = append(msgs, Message{
+			ID:        "audit-" + hex.EncodeToString(id[:]),
+			Type:      MessageTypeAudit,
+			Payload:   raw,
+			Timestamp: NewTimestamp(e.Time),
+		})
+	}
+	return l.Store.Save(ctx, msgs...)
+}
+
+// Entries implements AuditLog.
+func (l *StoreAuditLog) Entries(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+	msgs, err := l.Store.List(ctx, StoreFilter{Type: MessageTypeAudit})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]AuditEntry, 0, len(msgs))
+	for _, m := range msgs {
+		var e AuditEntry
+		if err := json.Unmarshal(m.Payload, &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return f.filter(entries), nil
+}
@@ -0,0 +1,52 @@
+package ping
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aetos53t/ping/sdk/go/wire"
+)
+
+// Request signature headers. See docs/REQUEST_SIGNING.md.
+const (
+	HeaderAgent     = wire.HeaderAgent
+	HeaderKey       = wire.HeaderKey
+	HeaderTimestamp = wire.HeaderTimestamp
+	HeaderNonce     = wire.HeaderNonce
+	HeaderSignature = wire.HeaderSignature
+)
+
+// DefaultMaxSkew is the clock skew VerifyRequest tolerates by default.
+const DefaultMaxSkew = wire.DefaultMaxSkew
+
+// ErrInvalidRequestSignature is returned by VerifyRequest when a request is
+// unsigned, stale or carries a bad signature.
+var ErrInvalidRequestSignature = wire.ErrInvalidRequestSignature
+
+// signRequest adds signature headers to req, covering the method, path,
+// query, a timestamp, a random nonce and the body hash. Requests are left
+// unsigned while the client has no keys.
+func (c *Client) signRequest(req *http.Request, body []byte) error {
+	id := c.identity()
+	if id.privateKey == nil {
+		return nil
+	}
+	if len(id.delegation) > 0 {
+		v, err := wire.EncodeDelegationHeader(id.delegation)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(wire.HeaderDelegation, v)
+	}
+	return wire.SignRequest(req, body, id.privateKey, id.agentID)
+}
+
+// VerifyRequest checks the signature headers on an incoming request against
+// the sender's hex public key, for servers and proxies that authenticate
+// SDK calls. It reads and restores r.Body. Timestamps further than maxSkew
+// from now are rejected (DefaultMaxSkew if zero); callers that need replay
+// protection should also remember the X-Ping-Nonce values they accept.
+// It is wire.VerifyRequest, for callers already importing ping.
+func VerifyRequest(r *http.Request, publicKeyHex string, maxSkew time.Duration) error {
+	return wire.VerifyRequest(r, publicKeyHex, maxSkew)
+}
@@ -0,0 +1,125 @@
+package ping
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff decides how long to wait between attempts: between request
+// retries, stream reconnects and Poller polls. Implementations must be
+// safe for concurrent use; the built-in ones are stateless.
+type Backoff interface {
+	// Next returns the delay before attempt, counting from 1 for the first
+	// wait, given the previous delay (0 before the first).
+	Next(attempt int, prev time.Duration) time.Duration
+}
+
+// ConstantBackoff waits the same delay every time, randomized by Jitter.
+type ConstantBackoff struct {
+	Delay time.Duration
+	// Jitter, between 0 and 1, spreads each delay uniformly over
+	// [Delay*(1-Jitter), Delay*(1+Jitter)] so agents sharing a server do
+	// not poll in lockstep.
+	Jitter float64
+}
+
+// Next implements Backoff.
+func (b ConstantBackoff) Next(attempt int, prev time.Duration) time.Duration {
+	return jitter(b.Delay, b.Jitter)
+}
+
+// ExponentialBackoff multiplies the delay by Multiplier on every attempt,
+// up to Max, randomized by Jitter.
+type ExponentialBackoff struct {
+	// Base is the first delay.
+	Base time.Duration
+	// Max caps the delay. Zero means no cap.
+	Max time.Duration
+	// Multiplier defaults to 2.
+	Multiplier float64
+	// Jitter, between 0 and 1, spreads each delay uniformly over
+	// [d*(1-Jitter), d*(1+Jitter)], still capped by Max.
+	Jitter float64
+}
+
+// Next implements Backoff.
+func (b ExponentialBackoff) Next(attempt int, prev time.Duration) time.Duration {
+	mult := b.Multiplier
+	if mult <= 0 {
+		mult = 2
+	}
+	d := float64(b.Base) * math.Pow(mult, float64(attempt-1))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	return capDelay(jitter(time.Duration(d), b.Jitter), b.Max)
+}
+
+// DecorrelatedJitterBackoff draws each delay at random between Base and
+// three times the previous delay, capped at Max. It spreads retries from
+// many clients more evenly than exponential backoff with jitter.
+type DecorrelatedJitterBackoff struct {
+	Base time.Duration
+	// Max caps the delay. Zero means no cap.
+	Max time.Duration
+}
+
+// Next implements Backoff.
+func (b DecorrelatedJitterBackoff) Next(attempt int, prev time.Duration) time.Duration {
+	upper := 3 * prev
+	if upper <= b.Base {
+		return capDelay(b.Base, b.Max)
+	}
+	return capDelay(b.Base+time.Duration(rand.Int63n(int64(upper-b.Base))), b.Max)
+}
+
+// defaultRetryBackoff is the request retry backoff used without
+// WithBackoff.
+var defaultRetryBackoff Backoff = ExponentialBackoff{Base: 250 * time.Millisecond, Max: 5 * time.Second, Jitter: 0.5}
+
+// defaultReconnectBackoff is the stream reconnect backoff used without
+// WithBackoff. The server's SSE retry field sets the minimum delay.
+var defaultReconnectBackoff Backoff = DecorrelatedJitterBackoff{Base: time.Second, Max: 30 * time.Second}
+
+// WithBackoff sets the backoff between request retries and between stream
+// reconnect attempts. By default retries back off exponentially from
+// 250ms with jitter, and reconnects use decorrelated jitter from 1s to
+// 30s.
+func WithBackoff(b Backoff) Option {
+	return func(c *Client) {
+		c.backoff = b
+	}
+}
+
+func (c *Client) retryBackoff() Backoff {
+	if c.backoff != nil {
+		return c.backoff
+	}
+	return defaultRetryBackoff
+}
+
+func (c *Client) reconnectBackoff() Backoff {
+	if c.backoff != nil {
+		return c.backoff
+	}
+	return defaultReconnectBackoff
+}
+
+// jitter spreads d uniformly over [d*(1-f), d*(1+f)].
+func jitter(d time.Duration, f float64) time.Duration {
+	if f <= 0 || d <= 0 {
+		return d
+	}
+	if f > 1 {
+		f = 1
+	}
+	return time.Duration(float64(d) * (1 - f + 2*f*rand.Float64()))
+}
+
+func capDelay(d, max time.Duration) time.Duration {
+	if max > 0 && d > max {
+		return max
+	}
+	return d
+}
@@ -0,0 +1,153 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// FeatureBlocking is the server-side block list, which stops messages from
+// blocked agents before they reach the inbox.
+const FeatureBlocking Feature = "blocking"
+
+// Block stops delivery of messages from agentID. The client drops and
+// acknowledges them in Inbox, InboxIter, Stream and Manager.Dispatch, so
+// pollers, subscriptions and routers never see them. On servers with
+// FeatureBlocking the block is also recorded server-side, so the messages
+// are refused at the source.
+func (c *Client) Block(ctx context.Context, agentID string, reqOpts ...RequestOption) error {
+	err := c.block(ctx, agentID, reqOpts)
+	c.audit(ctx, AuditEntry{Op: AuditBlock, Target: agentID}, err)
+	return err
+}
+
+func (c *Client) block(ctx context.Context, agentID string, reqOpts []RequestOption) error {
+	if c.AgentID() == "" {
+		return fmt.Errorf("not registered")
+	}
+	c.blocked.set(agentID, true)
+	err := c.requireFeature(ctx, FeatureBlocking)
+	if errors.Is(err, ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.request(ctx, "PUT", "/agents/"+c.AgentID()+"/blocked/"+agentID, nil, nil, reqOpts...)
+}
+
+// Unblock lifts a block set by Block.
+func (c *Client) Unblock(ctx context.Context, agentID string, reqOpts ...RequestOption) error {
+	err := c.unblock(ctx, agentID, reqOpts)
+	c.audit(ctx, AuditEntry{Op: AuditUnblock, Target: agentID}, err)
+	return err
+}
+
+func (c *Client) unblock(ctx context.Context, agentID string, reqOpts []RequestOption) error {
+	if c.AgentID() == "" {
+		return fmt.Errorf("not registered")
+	}
+	c.blocked.set(agentID, false)
+	err := c.requireFeature(ctx, FeatureBlocking)
+	if errors.Is(err, ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.request(ctx, "DELETE", "/agents/"+c.AgentID()+"/blocked/"+agentID, nil, nil, reqOpts...)
+}
+
+// ListBlocked returns the blocked agent IDs, sorted. On servers with
+// FeatureBlocking the server's list is authoritative and also replaces the
+// client's, so blocks made by other instances take effect here.
+func (c *Client) ListBlocked(ctx context.Context, reqOpts ...RequestOption) ([]string, error) {
+	if c.AgentID() == "" {
+		return nil, fmt.Errorf("not registered")
+	}
+	err := c.requireFeature(ctx, FeatureBlocking)
+	if errors.Is(err, ErrUnsupported) {
+		return c.blocked.list(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	if err := c.request(ctx, "GET", "/agents/"+c.AgentID()+"/blocked", nil, &ids, reqOpts...); err != nil {
+		return nil, err
+	}
+	c.blocked.replace(ids)
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// dropBlocked removes messages from blocked senders, acknowledging them so
+// they leave the inbox.
+func (c *Client) dropBlocked(ctx context.Context, msgs []Message) []Message {
+	if c.blocked.empty() {
+		return msgs
+	}
+	out := msgs[:0:0]
+	for _, m := range msgs {
+		if c.blocked.has(m.From) {
+			c.Ack(ctx, m.ID)
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// blockList is the client's set of blocked agents. The zero value is
+// ready to use.
+type blockList struct {
+	mu  sync.RWMutex
+	ids map[string]bool
+}
+
+func (b *blockList) set(id string, blocked bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !blocked {
+		delete(b.ids, id)
+		return
+	}
+	if b.ids == nil {
+		b.ids = make(map[string]bool)
+	}
+	b.ids[id] = true
+}
+
+func (b *blockList) replace(ids []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ids = make(map[string]bool, len(ids))
+	for _, id := range ids {
+		b.ids[id] = true
+	}
+}
+
+func (b *blockList) has(id string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.ids[id]
+}
+
+func (b *blockList) empty() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.ids) == 0
+}
+
+func (b *blockList) list() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	ids := make([]string, 0, len(b.ids))
+	for id := range b.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
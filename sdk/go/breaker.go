@@ -0,0 +1,152 @@
+package ping
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the server while the
+// client's circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit open: server unavailable")
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets requests through; it is the normal state.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails requests with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a single probe request through to test whether
+	// the server has recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// CircuitBreaker stops a client from waiting on a server that is down.
+// After Threshold consecutive failures (network errors and 5xx responses)
+// it opens, and requests fail at once with ErrCircuitOpen. Once Cooldown
+// has passed it goes half-open and lets one probe request through: success
+// closes it, failure opens it for another Cooldown.
+//
+// Services keep working across an outage: an Outbox holds failed sends
+// queued and retries them, and a Poller or Stream keeps polling or
+// reconnecting with its backoff.
+type CircuitBreaker struct {
+	// Threshold is how many consecutive failures open the circuit.
+	// Defaults to 5.
+	Threshold int
+	// Cooldown is how long the circuit stays open before a probe.
+	// Defaults to 30s.
+	Cooldown time.Duration
+	// OnStateChange, if set, is called after every state change.
+	OnStateChange func(CircuitState)
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// WithCircuitBreaker guards every request the client makes with b. A
+// breaker may be shared by clients that talk to the same server.
+func WithCircuitBreaker(b *CircuitBreaker) Option {
+	return func(c *Client) {
+		c.breaker = b
+	}
+}
+
+// State returns the breaker's current state. An open breaker whose
+// cooldown has passed reports CircuitHalfOpen.
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= durationOr(b.Cooldown, 30*time.Second) {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+// allow reports whether a request may go to the server. A true result
+// must be followed by record.
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	var changed bool
+	defer func() {
+		b.mu.Unlock()
+		if changed {
+			b.notify(CircuitHalfOpen)
+		}
+	}()
+	switch b.state {
+	case CircuitClosed:
+		return true
+	case CircuitOpen:
+		if time.Since(b.openedAt) < durationOr(b.Cooldown, 30*time.Second) {
+			return false
+		}
+		b.state, changed = CircuitHalfOpen, true
+	}
+	if b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record counts the outcome of a request that allow let through.
+func (b *CircuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	prev := b.state
+	b.probing = false
+	if failed {
+		b.failures++
+		threshold := b.Threshold
+		if threshold <= 0 {
+			threshold = 5
+		}
+		if b.state == CircuitHalfOpen || b.failures >= threshold {
+			b.state, b.openedAt = CircuitOpen, time.Now()
+		}
+	} else {
+		b.failures = 0
+		b.state = CircuitClosed
+	}
+	state := b.state
+	b.mu.Unlock()
+	if state != prev {
+		b.notify(state)
+	}
+}
+
+// release gives back a probe whose outcome says nothing about the server,
+// such as a request cancelled by the caller.
+func (b *CircuitBreaker) release() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+func (b *CircuitBreaker) notify(s CircuitState) {
+	if b.OnStateChange != nil {
+		b.OnStateChange(s)
+	}
+}
+
+// serverFailure reports whether a request outcome counts against the
+// server: a transport error or a 5xx response.
+func serverFailure(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= http.StatusInternalServerError
+}
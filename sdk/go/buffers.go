@@ -0,0 +1,37 @@
+package ping
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// bufferPool recycles the buffers request bodies and payloads are encoded
+// into, so busy clients don't grow a fresh buffer for every message.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// maxPooledBuffer is the largest buffer returned to the pool; bigger ones,
+// from rare huge payloads, are left to the garbage collector.
+const maxPooledBuffer = 1 << 20
+
+// encodeJSON encodes v as compact JSON into a pooled buffer and returns a
+// copy of exactly the encoded size. escapeHTML matches json.Marshal when
+// true and JSON.stringify when false.
+func encodeJSON(v interface{}, escapeHTML bool) ([]byte, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			bufferPool.Put(buf)
+		}
+	}()
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(escapeHTML)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	b := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	return append([]byte(nil), b...), nil
+}
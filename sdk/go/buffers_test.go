@@ -0,0 +1,110 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aetos53t/ping/sdk/go/pingtest"
+)
+
+var benchPayload = map[string]interface{}{
+	"text":   "Please review the quarterly report before Friday.",
+	"action": "review",
+	"tags":   []interface{}{"finance", "q3", "urgent"},
+	"meta":   map[string]interface{}{"attempt": 1, "priority": "high"},
+}
+
+// encodeJSONUnpooled is encodeJSON with a fresh buffer for every call, as
+// before buffers were pooled.
+func encodeJSONUnpooled(v interface{}, escapeHTML bool) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(escapeHTML)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func TestEncodeJSON(t *testing.T) {
+	want, err := json.Marshal(benchPayload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ { // reuse a pooled buffer
+		got, err := encodeJSON(benchPayload, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("encodeJSON = %s, want %s", got, want)
+		}
+	}
+	got, err := encodeJSON(map[string]string{"t": "<b>&"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `{"t":"<b>&"}` {
+		t.Errorf("encodeJSON without HTML escaping = %s", got)
+	}
+}
+
+func BenchmarkEncodeJSON(b *testing.B) {
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := encodeJSON(benchPayload, false); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := encodeJSONUnpooled(benchPayload, false); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkSend sends from parallel goroutines with the client's tuned
+// connection pool and with the standard library's default of 2 idle
+// connections per host.
+func BenchmarkSend(b *testing.B) {
+	srv := pingtest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	recipient := NewClient(srv.URL)
+	if _, err := recipient.Register(ctx, "recipient", nil); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"pooled", nil},
+		{"unpooled", []Option{WithHTTPClient(&http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()})}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			c := NewClient(srv.URL, bc.opts...)
+			if _, err := c.Register(ctx, "sender", nil); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := c.Send(ctx, recipient.AgentID(), MessageTypeText, benchPayload, ""); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNoReply is returned by Call and WaitReply when no reply arrives in
+// time.
+var ErrNoReply = errors.New("no reply")
+
+// Standard error codes for error messages. Agents may use others.
+const (
+	ErrorCodeInvalidRequest = "invalid_request"
+	ErrorCodeUnsupported    = "unsupported"
+	ErrorCodeForbidden      = "forbidden"
+	ErrorCodeTimeout        = "timeout"
+	ErrorCodeUnavailable    = "unavailable"
+	ErrorCodeInternal       = "internal"
+)
+
+// RemoteError is the failure an agent reported in an error message. Its
+// fields are the message's payload:
+//
+//	{"code": "invalid_request", "message": "unknown action", "retryable": false, "details": {...}}
+type RemoteError struct {
+	// Code classifies the error, e.g. ErrorCodeInvalidRequest.
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+	// Retryable says whether sending the same request again may succeed.
+	Retryable bool                   `json:"retryable,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+
+	// From and MessageID identify the error message, when received.
+	From      string `json:"-"`
+	MessageID string `json:"-"`
+}
+
+func (e *RemoteError) Error() string {
+	var b strings.Builder
+	if e.From != "" {
+		b.WriteString("agent " + e.From + ": ")
+	}
+	if e.Code != "" {
+		b.WriteString(e.Code + ": ")
+	}
+	if e.Message != "" {
+		b.WriteString(e.Message)
+	} else {
+		b.WriteString("remote error")
+	}
+	return b.String()
+}
+
+// AsError returns the error carried by an error message, or false if m
+// is not one.
+func (m Message) AsError() (*RemoteError, bool) {
+	if m.Type != MessageTypeError {
+		return nil, false
+	}
+	var e RemoteError
+	if m.DecodePayload(&e) != nil {
+		e = RemoteError{Message: "malformed error message"}
+	}
+	e.From, e.MessageID = m.From, m.ID
+	return &e, true
+}
+
+// ReplyWithError tells the sender of original that it could not be
+// handled because of err. A *RemoteError in err's chain is sent as it is,
+// so errors from agents further down a pipeline pass through unchanged.
+// Otherwise the code is derived from err: ErrForbidden, ErrUnsupported,
+// context.DeadlineExceeded and ErrCircuitOpen map to their codes, and
+// anything else is ErrorCodeInternal.
+func (c *Client) ReplyWithError(ctx context.Context, original Message, err error, reqOpts ...RequestOption) (*SendResult, error) {
+	if err == nil {
+		return nil, fmt.Errorf("reply with a nil error")
+	}
+	return c.Reply(ctx, original, MessageTypeError, remoteErrorOf(err).payload(), reqOpts...)
+}
+
+// remoteErrorOf describes err as a RemoteError.
+func remoteErrorOf(err error) *RemoteError {
+	var re *RemoteError
+	if errors.As(err, &re) {
+		return re
+	}
+	e := &RemoteError{Code: ErrorCodeInternal, Message: err.Error()}
+	switch {
+	case errors.Is(err, ErrForbidden):
+		e.Code = ErrorCodeForbidden
+	case errors.Is(err, ErrUnsupported):
+		e.Code = ErrorCodeUnsupported
+	case errors.Is(err, context.DeadlineExceeded):
+		e.Code, e.Retryable = ErrorCodeTimeout, true
+	case errors.Is(err, ErrCircuitOpen):
+		e.Code, e.Retryable = ErrorCodeUnavailable, true
+	}
+	return e
+}
+
+func (e *RemoteError) payload() map[string]interface{} {
+	p := map[string]interface{}{"message": e.Message}
+	if e.Code != "" {
+		p["code"] = e.Code
+	}
+	if e.Retryable {
+		p["retryable"] = true
+	}
+	if len(e.Details) > 0 {
+		p["details"] = e.Details
+	}
+	return p
+}
+
+// Call sends a message and waits up to timeout for the reply from its
+// recipient, as WaitReply does. reqOpts apply to the send.
+func (c *Client) Call(ctx context.Context, to string, msgType MessageType, payload map[string]interface{}, timeout time.Duration, reqOpts ...RequestOption) (*Message, error) {
+	if c.Offline() {
+		return nil, ErrOffline
+	}
+	res, err := c.Send(ctx, to, msgType, payload, "", reqOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return c.WaitReply(ctx, res.ID, to, timeout)
+}
+
+// WaitReply waits up to timeout for the reply to messageID from the agent
+// it was sent to: a message from that agent whose ReplyTo is messageID.
+// The reply is acknowledged and returned; an error reply is returned as a
+// *RemoteError instead. WaitReply polls the inbox, quickly at first and
+// backing off to every 5s, so a Poller or Subscription on the same client
+// may take the reply first. If none arrives in time it fails with
+// ErrNoReply. reqOpts apply to the inbox polls and the ack.
+func (c *Client) WaitReply(ctx context.Context, messageID, to string, timeout time.Duration, reqOpts ...RequestOption) (*Message, error) {
+	from := c.route(to)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	poll := minDeliveryPoll
+	for {
+		msgs, err := c.Inbox(ctx, reqOpts...)
+		if err != nil && ctx.Err() == nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			if m.ReplyTo != messageID || m.From != from {
+				continue
+			}
+			c.Ack(ctx, m.ID, reqOpts...)
+			if re, ok := m.AsError(); ok {
+				return nil, re
+			}
+			return &m, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("%w to %s within %s", ErrNoReply, messageID, timeout)
+			}
+			return nil, ctx.Err()
+		case <-time.After(poll):
+		}
+		if poll *= 2; poll > maxDeliveryPoll {
+			poll = maxDeliveryPoll
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package ping
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// FeatureCapabilityDescriptors means the server stores and returns
+// structured capability descriptors alongside flat capabilities.
+const FeatureCapabilityDescriptors Feature = "capability-descriptors"
+
+// CapabilityDescriptor describes one thing an agent can do, in enough
+// detail for other agents to pick it programmatically.
+type CapabilityDescriptor struct {
+	Name        string `json:"name"`
+	Version     string `json:"version,omitempty"`
+	Description string `json:"description,omitempty"`
+	// InputSchema and OutputSchema are JSON Schemas for the request
+	// payload the capability takes and the response it returns.
+	InputSchema  json.RawMessage `json:"inputSchema,omitempty"`
+	OutputSchema json.RawMessage `json:"outputSchema,omitempty"`
+}
+
+// Input compiles the descriptor's input schema, or returns nil if it has
+// none.
+func (d *CapabilityDescriptor) Input() (*Schema, error) {
+	return d.schema(d.InputSchema, "input")
+}
+
+// Output compiles the descriptor's output schema, or returns nil if it
+// has none.
+func (d *CapabilityDescriptor) Output() (*Schema, error) {
+	return d.schema(d.OutputSchema, "output")
+}
+
+func (d *CapabilityDescriptor) schema(raw json.RawMessage, which string) (*Schema, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	s, err := ParseSchema(raw)
+	if err != nil {
+		return nil, fmt.Errorf("capability %s %s: %w", d.Name, which, err)
+	}
+	return s, nil
+}
+
+// Capability returns the agent's descriptor for the named capability. An
+// agent that lists the name only as a flat capability, e.g. because its
+// server predates descriptors, gets a descriptor with just the name. It
+// returns nil if the agent does not have the capability.
+func (a *Agent) Capability(name string) *CapabilityDescriptor {
+	for i := range a.Descriptors {
+		if a.Descriptors[i].Name == name {
+			return &a.Descriptors[i]
+		}
+	}
+	for _, c := range a.Capabilities {
+		if c == name {
+			return &CapabilityDescriptor{Name: name}
+		}
+	}
+	return nil
+}
+
+// hasCapabilityVersion reports whether the agent's descriptor for name has
+// at least version minVersion.
+func (a *Agent) hasCapabilityVersion(name, minVersion string) bool {
+	d := a.Capability(name)
+	return d != nil && d.Version != "" && compareVersions(d.Version, minVersion) >= 0
+}
+
+// capabilityNames merges descriptor names into flat capabilities, so
+// search by capability finds them on every server.
+func capabilityNames(flat []string, descriptors []CapabilityDescriptor) []string {
+	out := append([]string{}, flat...)
+	for _, d := range descriptors {
+		found := false
+		for _, c := range out {
+			if c == d.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, d.Name)
+		}
+	}
+	return out
+}
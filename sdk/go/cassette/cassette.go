@@ -0,0 +1,473 @@
+// Package cassette records the HTTP interactions of a PING client to a
+// file and replays them later, so integration tests written against a
+// live server run deterministically, and offline, in CI.
+//
+// Record once against a real server, commit the cassette, and replay it
+// from then on:
+//
+//	rec, err := cassette.New("testdata/send.json", cassette.Auto)
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer rec.Save()
+//	client := ping.NewClient(serverURL, rec.Option())
+//
+// Requests are matched to recorded ones by method, path, query and body,
+// in order. Values that differ on every run, such as signatures,
+// timestamps, nonces and generated public keys, are ignored when
+// matching and masked in the cassette. Request headers are not recorded,
+// so credentials in them never reach the file.
+package cassette
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"unicode/utf8"
+
+	ping "github.com/aetos53t/ping/sdk/go"
+)
+
+// ErrNoInteraction is returned when replaying a request that matches no
+// recorded interaction.
+var ErrNoInteraction = errors.New("cassette: no recorded interaction matches request")
+
+// masked replaces volatile and redacted values in cassettes.
+const masked = "*"
+
+// DefaultVolatileFields are the JSON body fields ignored when matching
+// requests: message and challenge signatures, timestamps and nonces, and
+// the public keys of agents registered with fresh keys.
+var DefaultVolatileFields = []string{"signature", "timestamp", "nonce", "publicKey"}
+
+// DefaultVolatileParams are the query parameters ignored when matching
+// requests, which carry times relative to the run.
+var DefaultVolatileParams = []string{"since", "until", "before", "after"}
+
+// DefaultRedactFields are the JSON response fields masked in cassettes,
+// such as session tokens.
+var DefaultRedactFields = []string{"token"}
+
+// Mode says whether a Recorder records or replays.
+type Mode int
+
+const (
+	// Replay serves responses from the cassette and fails requests it has
+	// no recording for.
+	Replay Mode = iota
+	// Record sends requests to the server and records them, replacing the
+	// cassette when saved.
+	Record
+	// Auto replays the cassette if it exists and records it otherwise.
+	Auto
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Record:
+		return "record"
+	case Auto:
+		return "auto"
+	}
+	return "replay"
+}
+
+// Interaction is a recorded request and its response.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is a recorded request, normalized for matching.
+type Request struct {
+	Method string `json:"method"`
+	// URL is the path and query, without scheme and host, so cassettes
+	// replay against any server address.
+	URL  string `json:"url"`
+	Body Body   `json:"body,omitempty"`
+}
+
+// Response is a recorded response.
+type Response struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body,omitempty"`
+}
+
+// Body is a recorded body, kept as JSON or text where it is one so
+// cassettes stay readable and diffable.
+type Body struct {
+	JSON   json.RawMessage `json:"json,omitempty"`
+	Text   string          `json:"text,omitempty"`
+	Base64 []byte          `json:"base64,omitempty"`
+}
+
+func newBody(data []byte) Body {
+	switch {
+	case len(data) == 0:
+		return Body{}
+	case json.Valid(data):
+		var b bytes.Buffer
+		if json.Compact(&b, data) == nil {
+			return Body{JSON: b.Bytes()}
+		}
+		return Body{JSON: data}
+	case utf8.Valid(data):
+		return Body{Text: string(data)}
+	}
+	return Body{Base64: data}
+}
+
+// Bytes returns the body's content.
+func (b Body) Bytes() []byte {
+	switch {
+	case len(b.JSON) > 0:
+		return b.JSON
+	case b.Text != "":
+		return []byte(b.Text)
+	}
+	return b.Base64
+}
+
+func (b Body) equal(o Body) bool {
+	return bytes.Equal(b.JSON, o.JSON) && b.Text == o.Text && bytes.Equal(b.Base64, o.Base64)
+}
+
+// file is the cassette file format.
+type file struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Recorder is an http.RoundTripper that records or replays a cassette. It
+// is safe for concurrent use, but concurrent requests replay in the order
+// they arrive, which may differ from the recording.
+type Recorder struct {
+	// Transport sends requests while recording. Defaults to
+	// http.DefaultTransport.
+	Transport http.RoundTripper
+	// VolatileFields, VolatileParams and RedactFields default to the
+	// package defaults; add to them before the first request.
+	VolatileFields []string
+	VolatileParams []string
+	RedactFields   []string
+
+	path string
+	mode Mode
+
+	mu           sync.Mutex
+	interactions []Interaction
+	reading      map[*recordingBody]bool // response bodies being recorded
+	played       []bool
+	last         map[string]int // last interaction replayed by match key
+}
+
+// New returns a recorder for the cassette at path. In Replay mode, and in
+// Auto mode when the file exists, the cassette is loaded.
+func New(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{
+		VolatileFields: append([]string(nil), DefaultVolatileFields...),
+		VolatileParams: append([]string(nil), DefaultVolatileParams...),
+		RedactFields:   append([]string(nil), DefaultRedactFields...),
+		path:           path,
+		mode:           mode,
+		reading:        make(map[*recordingBody]bool),
+		last:           make(map[string]int),
+	}
+	if mode == Auto {
+		r.mode = Record
+		if _, err := os.Stat(path); err == nil {
+			r.mode = Replay
+		}
+	}
+	if r.mode == Replay {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var f file
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("cassette %s: %w", path, err)
+		}
+		for i := range f.Interactions {
+			// Compacted to match requests; the file is indented.
+			f.Interactions[i].Request.Body = newBody(f.Interactions[i].Request.Body.Bytes())
+		}
+		r.interactions = f.Interactions
+		r.played = make([]bool, len(f.Interactions))
+	}
+	return r, nil
+}
+
+// Mode returns whether r records or replays; Auto is resolved by New.
+func (r *Recorder) Mode() Mode {
+	return r.mode
+}
+
+// Client returns an HTTP client that sends through r.
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// Option configures a PING client to send through r.
+func (r *Recorder) Option() ping.Option {
+	return ping.WithHTTPClient(r.Client())
+}
+
+// Interactions returns the interactions recorded or loaded so far.
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Interaction(nil), r.interactions...)
+}
+
+// Unplayed returns the recorded interactions a replay has not used, for
+// tests that check every recorded request was made.
+func (r *Recorder) Unplayed() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []Interaction
+	for i, ok := range r.played {
+		if !ok {
+			out = append(out, r.interactions[i])
+		}
+	}
+	return out
+}
+
+// Save writes the recorded cassette, creating its directory. It does
+// nothing when replaying. Responses still being read, such as event
+// streams, are saved as far as they have been read.
+func (r *Recorder) Save() error {
+	if r.mode != Record {
+		return nil
+	}
+	r.mu.Lock()
+	for b := range r.reading {
+		r.interactions[b.i].Response.Body = r.redact(b.snapshot())
+	}
+	data, err := json.MarshalIndent(file{Version: 1, Interactions: r.interactions}, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(data, '\n'), 0o644)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	rec, err := r.normalizeRequest(req, body)
+	if err != nil {
+		return nil, err
+	}
+	if r.mode == Replay {
+		return r.replay(req, rec)
+	}
+	return r.record(req, body, rec)
+}
+
+func (r *Recorder) record(req *http.Request, body []byte, rec Request) (*http.Response, error) {
+	out := req.Clone(req.Context())
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	out.ContentLength = int64(len(body))
+	t := r.Transport
+	if t == nil {
+		t = http.DefaultTransport
+	}
+	resp, err := t.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set on replay from the body, which redaction may change.
+	header := resp.Header.Clone()
+	header.Del("Date")
+	header.Del("Content-Length")
+	r.mu.Lock()
+	r.interactions = append(r.interactions, Interaction{Request: rec, Response: Response{Status: resp.StatusCode, Header: header}})
+	b := &recordingBody{ReadCloser: resp.Body, r: r, i: len(r.interactions) - 1}
+	r.reading[b] = true
+	r.mu.Unlock()
+	resp.Body = b
+	return resp, nil
+}
+
+// recordingBody captures a response body into interaction i as it is
+// read, saving it when it ends or is closed.
+type recordingBody struct {
+	io.ReadCloser
+	r *Recorder
+	i int
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mu.Lock()
+	b.buf.Write(p[:n])
+	b.mu.Unlock()
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *recordingBody) snapshot() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func (b *recordingBody) finish() {
+	data := b.snapshot()
+	b.r.mu.Lock()
+	defer b.r.mu.Unlock()
+	if b.r.reading[b] {
+		delete(b.r.reading, b)
+		b.r.interactions[b.i].Response.Body = b.r.redact(data)
+	}
+}
+
+func (r *Recorder) replay(req *http.Request, rec Request) (*http.Response, error) {
+	key := rec.Method + " " + rec.URL
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	match := -1
+	for i, in := range r.interactions {
+		if !r.played[i] && in.Request.Method == rec.Method && in.Request.URL == rec.URL && in.Request.Body.equal(rec.Body) {
+			match = i
+			break
+		}
+	}
+	if match < 0 {
+		// Polls may repeat more often than when recording; a repeated
+		// read gets the last response recorded for it.
+		i, ok := r.last[key]
+		if !ok || rec.Method != http.MethodGet {
+			return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, rec.Method, rec.URL)
+		}
+		match = i
+	}
+	r.played[match] = true
+	if rec.Method == http.MethodGet {
+		r.last[key] = match
+	}
+
+	in := r.interactions[match].Response
+	data := in.Body.Bytes()
+	header := in.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", in.Status, http.StatusText(in.Status)),
+		StatusCode:    in.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
+
+// normalizeRequest returns req as recorded: its path and query with
+// volatile parameters masked and sorted, and its body, decompressed, with
+// volatile fields masked.
+func (r *Recorder) normalizeRequest(req *http.Request, body []byte) (Request, error) {
+	q := req.URL.Query()
+	for _, p := range r.VolatileParams {
+		if _, ok := q[p]; ok {
+			q.Set(p, masked)
+		}
+	}
+	u := req.URL.EscapedPath()
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+
+	if req.Header.Get("Content-Encoding") == "gzip" && len(body) > 0 {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return Request{}, err
+		}
+		if body, err = io.ReadAll(zr); err != nil {
+			return Request{}, err
+		}
+	}
+	return Request{Method: req.Method, URL: u, Body: newBody(maskJSON(body, r.VolatileFields))}, nil
+}
+
+// redact returns a response body with redacted fields masked.
+func (r *Recorder) redact(data []byte) Body {
+	return newBody(maskJSON(data, r.RedactFields))
+}
+
+// maskJSON returns data with the values of fields masked wherever they
+// occur, with object keys sorted. Data that is not JSON is returned as it
+// is.
+func maskJSON(data []byte, fields []string) []byte {
+	if len(fields) == 0 || !json.Valid(data) {
+		return data
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if dec.Decode(&v) != nil {
+		return data
+	}
+	set := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		set[f] = true
+	}
+	out, err := json.Marshal(mask(v, set))
+	if err != nil {
+		return data
+	}
+	return out
+}
+
+func mask(v interface{}, fields map[string]bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if fields[k] {
+				v[k] = masked
+			} else {
+				v[k] = mask(e, fields)
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = mask(e, fields)
+		}
+	}
+	return v
+}
@@ -0,0 +1,253 @@
+package ping
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MessageTypeChunk carries one piece of a payload too large to send in a
+// single message, or the manifest describing the pieces.
+const MessageTypeChunk MessageType = "chunk"
+
+// Chunk payload fields. A manifest carries chunkManifestField, each piece
+// chunkPartField.
+const (
+	chunkManifestField = "$chunks"
+	chunkPartField     = "$chunk"
+)
+
+// DefaultChunkSize is the payload size WithChunking uses when given 0.
+const DefaultChunkSize = 256 << 10
+
+// chunkTTL is how long an incomplete chunked message is kept waiting for
+// its remaining pieces.
+const chunkTTL = 10 * time.Minute
+
+// chunkOverhead leaves room in each piece for the envelope fields.
+const chunkOverhead = 256
+
+// WithChunking splits payloads larger than size bytes into ordered chunk
+// messages plus a manifest, and retries a send rejected with 413 the same
+// way. Receivers reassemble chunked messages in Inbox, InboxIter and
+// Stream before handlers see them, whether or not they enable chunking.
+// A size of 0 means DefaultChunkSize.
+func WithChunking(size int) Option {
+	return func(c *Client) {
+		if size <= 0 {
+			size = DefaultChunkSize
+		}
+		c.chunkSize = size
+	}
+}
+
+type chunkManifest struct {
+	ID     string      `json:"id"`
+	Type   MessageType `json:"type"`
+	Count  int         `json:"count"`
+	Size   int         `json:"size"`
+	SHA256 string      `json:"sha256"`
+}
+
+type chunkPart struct {
+	ID    string `json:"id"`
+	Index int    `json:"index"`
+	Data  string `json:"data"`
+}
+
+// oversized reports whether wirePayload exceeds the chunk size.
+func (c *Client) oversized(wirePayload map[string]interface{}) bool {
+	data, err := marshalPayload(wirePayload)
+	return err == nil && len(data) > c.chunkSize
+}
+
+// sendChunked sends wirePayload as a manifest followed by its pieces. The
+// result is the manifest's, which is also the ID of the reassembled
+// message; it counts as delivered only if every piece was.
+func (c *Client) sendChunked(ctx context.Context, id identity, to string, msgType MessageType, wirePayload map[string]interface{}, replyTo string, reqOpts []RequestOption) (*signedMessage, error) {
+	data, err := marshalPayload(wirePayload)
+	if err != nil {
+		return nil, err
+	}
+	var gid [16]byte
+	if _, err := rand.Read(gid[:]); err != nil {
+		return nil, err
+	}
+	partSize := (c.chunkSize - chunkOverhead) * 3 / 4
+	if partSize < 1 {
+		partSize = 1
+	}
+	sum := sha256.Sum256(data)
+	manifest := chunkManifest{
+		ID:     hex.EncodeToString(gid[:]),
+		Type:   msgType,
+		Count:  (len(data) + partSize - 1) / partSize,
+		Size:   len(data),
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+
+	sent, err := c.post(ctx, id, to, MessageTypeChunk, map[string]interface{}{chunkManifestField: manifest}, replyTo, reqOpts)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < manifest.Count; i++ {
+		end := (i + 1) * partSize
+		if end > len(data) {
+			end = len(data)
+		}
+		part := chunkPart{ID: manifest.ID, Index: i, Data: base64.StdEncoding.EncodeToString(data[i*partSize : end])}
+		res, err := c.post(ctx, id, to, MessageTypeChunk, map[string]interface{}{chunkPartField: part}, "", reqOpts)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d of %d: %w", i+1, manifest.Count, err)
+		}
+		sent.result.Delivered = sent.result.Delivered && res.result.Delivered
+	}
+	return sent, nil
+}
+
+// chunkBuffer collects the pieces of chunked messages until they are
+// complete. The zero value is ready to use.
+type chunkBuffer struct {
+	mu     sync.Mutex
+	groups map[string]*chunkGroup // by sender and manifest ID
+	// parts maps a reassembled message ID to the IDs of its pieces, so
+	// acknowledging it acknowledges them all.
+	parts map[string][]string
+}
+
+type chunkGroup struct {
+	manifest *Message
+	info     chunkManifest
+	pieces   map[int]Message
+	seen     time.Time
+}
+
+// reassemble replaces the chunk messages in msgs with the messages they
+// make up once all pieces have arrived. Pieces of incomplete messages are
+// held back and left unacknowledged, so they are redelivered until the
+// rest arrive.
+func (c *Client) reassemble(msgs []Message) []Message {
+	b := &c.chunks
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for key, g := range b.groups {
+		if now.Sub(g.seen) > chunkTTL {
+			delete(b.groups, key)
+		}
+	}
+
+	out := msgs[:0:0]
+	touched := map[string]bool{}
+	for _, m := range msgs {
+		if m.Type != MessageTypeChunk {
+			out = append(out, m)
+			continue
+		}
+		var env struct {
+			Manifest *chunkManifest `json:"$chunks"`
+			Part     *chunkPart     `json:"$chunk"`
+		}
+		if json.Unmarshal(m.Payload, &env) != nil || (env.Manifest == nil && env.Part == nil) {
+			out = append(out, m)
+			continue
+		}
+		gid := ""
+		if env.Manifest != nil {
+			gid = env.Manifest.ID
+		} else {
+			gid = env.Part.ID
+		}
+		key := m.From + "/" + gid
+		if b.groups == nil {
+			b.groups = make(map[string]*chunkGroup)
+		}
+		g := b.groups[key]
+		if g == nil {
+			g = &chunkGroup{pieces: make(map[int]Message)}
+			b.groups[key] = g
+		}
+		g.seen = now
+		if env.Manifest != nil {
+			mm := m
+			g.manifest = &mm
+			g.info = *env.Manifest
+		} else {
+			g.pieces[env.Part.Index] = m
+		}
+		touched[key] = true
+	}
+
+	keys := make([]string, 0, len(touched))
+	for key := range touched {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		g := b.groups[key]
+		if g.manifest == nil || len(g.pieces) < g.info.Count {
+			continue
+		}
+		delete(b.groups, key)
+		msg, ids, err := g.assemble()
+		if err != nil {
+			continue
+		}
+		if b.parts == nil {
+			b.parts = make(map[string][]string)
+		}
+		b.parts[msg.ID] = ids
+		out = append(out, msg)
+	}
+	return out
+}
+
+// assemble joins a complete group's pieces and checks them against the
+// manifest. It returns the message and the IDs of its pieces.
+func (g *chunkGroup) assemble() (Message, []string, error) {
+	var data []byte
+	var ids []string
+	for i := 0; i < g.info.Count; i++ {
+		piece, ok := g.pieces[i]
+		if !ok {
+			return Message{}, nil, fmt.Errorf("missing chunk %d", i)
+		}
+		var env struct {
+			Part chunkPart `json:"$chunk"`
+		}
+		if err := json.Unmarshal(piece.Payload, &env); err != nil {
+			return Message{}, nil, err
+		}
+		b, err := base64.StdEncoding.DecodeString(env.Part.Data)
+		if err != nil {
+			return Message{}, nil, err
+		}
+		data = append(data, b...)
+		ids = append(ids, piece.ID)
+	}
+	sum := sha256.Sum256(data)
+	if len(data) != g.info.Size || hex.EncodeToString(sum[:]) != g.info.SHA256 {
+		return Message{}, nil, fmt.Errorf("chunked payload does not match manifest")
+	}
+	msg := *g.manifest
+	msg.Type = g.info.Type
+	msg.Payload = json.RawMessage(data)
+	return msg, ids, nil
+}
+
+// chunkParts returns and forgets the pieces of a reassembled message.
+func (c *Client) chunkParts(messageID string) []string {
+	b := &c.chunks
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ids := b.parts[messageID]
+	delete(b.parts, messageID)
+	return ids
+}
@@ -0,0 +1,63 @@
+package ping
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// WithClientCertificate presents the PEM certificate and key in certFile
+// and keyFile to servers that require mutual TLS. The files are checked on
+// every new connection and reloaded when either changes, so a rotated
+// certificate is picked up without restarting; connections already open
+// keep the certificate they were made with.
+//
+// A pair that cannot be loaded fails the connection with an error naming
+// the files. If a reload fails, e.g. because the key has been written but
+// the certificate not yet, the previous pair stays in use.
+func WithClientCertificate(certFile, keyFile string) Option {
+	return func(c *Client) {
+		c.transport.clientCert = &certReloader{certFile: certFile, keyFile: keyFile}
+	}
+}
+
+// certReloader loads a client certificate and reloads it when its files
+// change.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// GetClientCertificate has the signature of tls.Config.GetClientCertificate.
+func (r *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	certMod, err1 := modTime(r.certFile)
+	keyMod, err2 := modTime(r.keyFile)
+	if r.cert != nil && (err1 != nil || err2 != nil || (certMod.Equal(r.certMod) && keyMod.Equal(r.keyMod))) {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("client certificate %s: %w", r.certFile, err)
+	}
+	r.cert, r.certMod, r.keyMod = &cert, certMod, keyMod
+	return r.cert, nil
+}
+
+func modTime(path string) (time.Time, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
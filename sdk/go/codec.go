@@ -0,0 +1,292 @@
+package ping
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Encoded payload envelope fields. A payload carrying these is decoded by
+// running the named codecs in reverse order over the base64 data.
+const (
+	codecField     = "$codec"
+	codecDataField = "$data"
+)
+
+// Codec transforms serialized payloads exchanged with a peer, e.g. to
+// compress or encrypt them.
+type Codec interface {
+	// Name identifies the codec on the wire.
+	Name() string
+	Encode(cc *CodecContext, data []byte) ([]byte, error)
+	Decode(cc *CodecContext, data []byte) ([]byte, error)
+}
+
+// CodecContext identifies the two ends of an exchange for codecs that need
+// keys.
+type CodecContext struct {
+	PeerID   string
+	LocalKey ed25519.PrivateKey
+	PeerKey  ed25519.PublicKey
+	// MaxSize is the largest payload Decode should produce, from
+	// WithMaxResponseBytes, or 0 for no limit. Decompressing codecs
+	// fail with ErrResponseTooLarge beyond it.
+	MaxSize int64
+}
+
+func (cc *CodecContext) maxSize() int64 {
+	if cc == nil {
+		return 0
+	}
+	return cc.MaxSize
+}
+
+// WithCodecs registers additional payload codecs, replacing built-in ones
+// with the same name. Built-in codecs are "gzip", "deflate" and "box";
+// WithSessionKeys adds "session".
+func WithCodecs(codecs ...Codec) Option {
+	return func(c *Client) {
+		for _, codec := range codecs {
+			c.codecs[codec.Name()] = codec
+		}
+	}
+}
+
+func defaultCodecs() map[string]Codec {
+	return map[string]Codec{
+		"gzip":    GzipCodec{},
+		"deflate": DeflateCodec{},
+		"box":     BoxCodec{},
+	}
+}
+
+// SetPeerCodecs sets the codec chain applied to payloads sent to peerID,
+// in encoding order (e.g. "gzip", "box"). No codecs means plain JSON.
+// Peers adopt the chain of the first encoded message they receive from us,
+// so both directions converge on the same chain.
+func (c *Client) SetPeerCodecs(peerID string, chain ...string) error {
+	for _, name := range chain {
+		if _, ok := c.codecs[name]; !ok {
+			return fmt.Errorf("unknown codec %q", name)
+		}
+	}
+	c.peers.update(peerID, func(p *PeerPrefs) {
+		p.Codecs = append([]string{}, chain...)
+	})
+	return nil
+}
+
+// encodePayload applies the peer's codec chain to payload.
+func (c *Client) encodePayload(ctx context.Context, to string, payload map[string]interface{}) (map[string]interface{}, error) {
+	chain := c.peers.get(to).Codecs
+	if len(chain) == 0 {
+		return payload, nil
+	}
+	if c.usesSessions(chain) {
+		if err := c.ensureSession(ctx, to); err != nil {
+			return nil, err
+		}
+	}
+	cc, err := c.codecContext(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+	data, err := marshalPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range chain {
+		if data, err = c.codecs[name].Encode(cc, data); err != nil {
+			return nil, fmt.Errorf("codec %s: %w", name, err)
+		}
+	}
+	return map[string]interface{}{
+		codecField:     strings.Join(chain, "+"),
+		codecDataField: base64.StdEncoding.EncodeToString(data),
+	}, nil
+}
+
+// decodePayloads decodes encoded payloads in place. Messages that cannot be
+// decoded are left untouched. The first codec chain seen from a peer
+// becomes the chain used for replies if none is set.
+func (c *Client) decodePayloads(ctx context.Context, msgs []Message) {
+	self := c.AgentID()
+	for i := range msgs {
+		m := &msgs[i]
+		var env struct {
+			Codec string `json:"$codec"`
+			Data  string `json:"$data"`
+		}
+		if !bytes.Contains(m.Payload, []byte(codecField)) ||
+			json.Unmarshal(m.Payload, &env) != nil || env.Codec == "" || env.Data == "" {
+			continue
+		}
+		peer := m.From
+		if peer == self {
+			peer = m.To
+		}
+		chain := strings.Split(env.Codec, "+")
+		payload, err := c.decodeChain(ctx, peer, chain, env.Data)
+		if err != nil {
+			continue
+		}
+		m.Payload = payload
+		if m.From != self {
+			c.peers.update(peer, func(p *PeerPrefs) {
+				if p.Codecs == nil {
+					p.Codecs = chain
+				}
+			})
+		}
+	}
+}
+
+func (c *Client) decodeChain(ctx context.Context, peer string, chain []string, data string) (json.RawMessage, error) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range chain {
+		if _, ok := c.codecs[name]; !ok {
+			return nil, fmt.Errorf("unknown codec %q", name)
+		}
+	}
+	cc, err := c.codecContext(ctx, peer)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		if raw, err = c.codecs[chain[i]].Decode(cc, raw); err != nil {
+			return nil, fmt.Errorf("codec %s: %w", chain[i], err)
+		}
+	}
+	if !json.Valid(raw) {
+		return nil, fmt.Errorf("decoded payload is not valid JSON")
+	}
+	return raw, nil
+}
+
+func (c *Client) codecContext(ctx context.Context, peer string) (*CodecContext, error) {
+	key, err := c.peerKey(ctx, peer)
+	if err != nil {
+		return nil, err
+	}
+	return &CodecContext{PeerID: peer, LocalKey: c.identity().privateKey, PeerKey: key, MaxSize: c.responseLimit()}, nil
+}
+
+// GzipCodec compresses payloads with gzip.
+type GzipCodec struct{}
+
+// Name implements Codec.
+func (GzipCodec) Name() string { return "gzip" }
+
+// Encode implements Codec.
+func (GzipCodec) Encode(_ *CodecContext, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode implements Codec.
+func (GzipCodec) Decode(cc *CodecContext, data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r, cc.maxSize())
+}
+
+// DeflateCodec compresses payloads with raw DEFLATE.
+type DeflateCodec struct{}
+
+// Name implements Codec.
+func (DeflateCodec) Name() string { return "deflate" }
+
+// Encode implements Codec.
+func (DeflateCodec) Encode(_ *CodecContext, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode implements Codec.
+func (DeflateCodec) Decode(cc *CodecContext, data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return readLimited(r, cc.maxSize())
+}
+
+// BoxCodec encrypts payloads end-to-end with AES-256-GCM under a key
+// derived by X25519 from the two agents' Ed25519 identity keys, so only
+// the sender and recipient can read them.
+type BoxCodec struct{}
+
+const boxKeyInfo = "ping-codec-box-v1"
+
+// Name implements Codec.
+func (BoxCodec) Name() string { return "box" }
+
+// Encode implements Codec.
+func (BoxCodec) Encode(cc *CodecContext, data []byte) ([]byte, error) {
+	aead, err := boxAEAD(cc)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// Decode implements Codec.
+func (BoxCodec) Decode(cc *CodecContext, data []byte) ([]byte, error) {
+	aead, err := boxAEAD(cc)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+}
+
+func boxAEAD(cc *CodecContext) (cipher.AEAD, error) {
+	if cc.LocalKey == nil || cc.PeerKey == nil {
+		return nil, fmt.Errorf("box codec needs both agent keys")
+	}
+	key, err := sharedKey(cc.LocalKey, cc.PeerKey, boxKeyInfo)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,146 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/aetos53t/ping/sdk/go/pingtest"
+)
+
+func testKeys(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+func TestBoxCodec(t *testing.T) {
+	alicePub, alice := testKeys(t)
+	bobPub, bob := testKeys(t)
+	_, eve := testKeys(t)
+	toBob := &CodecContext{PeerID: "bob", LocalKey: alice, PeerKey: bobPub}
+	fromAlice := &CodecContext{PeerID: "alice", LocalKey: bob, PeerKey: alicePub}
+
+	plain := []byte(`{"text":"meet at noon"}`)
+	sealed, err := BoxCodec{}.Encode(toBob, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("noon")) {
+		t.Error("sealed payload contains the plaintext")
+	}
+	again, err := BoxCodec{}.Encode(toBob, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(again, sealed) {
+		t.Error("two encodings used the same nonce")
+	}
+	for _, s := range [][]byte{sealed, again} {
+		got, err := BoxCodec{}.Decode(fromAlice, s)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("Decode = %s, %v, want %s", got, err, plain)
+		}
+	}
+	// Either side derives the same key, so the sender can read its own
+	// messages back.
+	if got, err := (BoxCodec{}).Decode(toBob, sealed); err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("sender Decode = %s, %v", got, err)
+	}
+
+	for name, cc := range map[string]*CodecContext{
+		"wrong recipient key": {PeerID: "alice", LocalKey: eve, PeerKey: alicePub},
+		"wrong sender key":    {PeerID: "alice", LocalKey: bob, PeerKey: bobPub},
+		"no local key":        {PeerID: "alice", PeerKey: alicePub},
+		"no peer key":         {PeerID: "alice", LocalKey: bob},
+	} {
+		if _, err := (BoxCodec{}).Decode(cc, sealed); err == nil {
+			t.Errorf("%s: Decode succeeded", name)
+		}
+	}
+	for _, i := range []int{0, 12, len(sealed) - 1} { // nonce, ciphertext, tag
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 1
+		if _, err := (BoxCodec{}).Decode(fromAlice, tampered); err == nil {
+			t.Errorf("Decode accepted a payload tampered at byte %d", i)
+		}
+	}
+	if _, err := (BoxCodec{}).Decode(fromAlice, sealed[:11]); err == nil {
+		t.Error("Decode accepted a payload shorter than the nonce")
+	}
+}
+
+// TestPeerCodecs sends through pingtest with a gzip+box chain: the
+// recipient decodes it and adopts the chain, and a payload that does not
+// decode is left as it arrived.
+func TestPeerCodecs(t *testing.T) {
+	srv := pingtest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	alice := registerTestClient(t, srv, "alice")
+	bob := registerTestClient(t, srv, "bob")
+	eve := registerTestClient(t, srv, "eve")
+
+	if err := alice.SetPeerCodecs(bob.AgentID(), "gzip", "box"); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.SetPeerCodecs(bob.AgentID(), "rot13"); err == nil {
+		t.Error("SetPeerCodecs accepted an unknown codec")
+	}
+	payload := map[string]interface{}{"text": "secret plans", "n": 1}
+	if _, err := alice.Send(ctx, bob.AgentID(), MessageTypeText, payload, ""); err != nil {
+		t.Fatal(err)
+	}
+	inbox, err := bob.Inbox(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox) != 1 || inbox[0].PayloadString("text") != "secret plans" {
+		t.Fatalf("bob's inbox = %+v, want the decoded message", inbox)
+	}
+	if got := bob.PeerPrefs(alice.AgentID()).Codecs; fmt.Sprint(got) != "[gzip box]" {
+		t.Errorf("bob adopted codecs %v for alice, want [gzip box]", got)
+	}
+
+	encoded, err := alice.encodePayload(ctx, bob.AgentID(), payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := json.Marshal(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("secret")) || encoded[codecField] != "gzip+box" {
+		t.Errorf("encoded payload %s, want a gzip+box envelope without the plaintext", raw)
+	}
+	data, _ := base64.StdEncoding.DecodeString(encoded[codecDataField].(string))
+	data[len(data)-1] ^= 1
+	tampered, err := json.Marshal(map[string]interface{}{codecField: "gzip+box", codecDataField: base64.StdEncoding.EncodeToString(data)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		c       *Client
+		payload json.RawMessage
+	}{
+		"wrong key":        {eve, raw},
+		"tampered payload": {bob, tampered},
+	} {
+		msgs := []Message{{ID: "m1", Type: MessageTypeText, From: alice.AgentID(), To: tc.c.AgentID(), Payload: tc.payload}}
+		tc.c.decodePayloads(ctx, msgs)
+		if !bytes.Equal(msgs[0].Payload, tc.payload) {
+			t.Errorf("%s: payload decoded to %s", name, msgs[0].Payload)
+		}
+	}
+	if got := eve.PeerPrefs(alice.AgentID()).Codecs; got != nil {
+		t.Errorf("eve adopted codecs %v from a payload it could not decode", got)
+	}
+}
@@ -0,0 +1,217 @@
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// CompatReport describes how well a server matches what this SDK expects.
+type CompatReport struct {
+	ServerVersion string
+	SDKVersion    string
+	// Features holds every feature the SDK knows, and any other feature
+	// the server advertises, with whether the server supports it.
+	Features  map[Feature]bool
+	Endpoints []EndpointReport
+}
+
+// EndpointReport is the result of probing one endpoint.
+type EndpointReport struct {
+	Method string
+	Path   string
+	// Status is the HTTP status returned, or 0 if the request failed.
+	Status    int
+	Available bool
+	// MissingFields lists fields the SDK reads that the response lacks.
+	MissingFields []string
+	// UnknownFields lists response fields the SDK does not know about.
+	UnknownFields []string
+	// Deprecated is set when the server sends a Deprecation or Sunset
+	// header; Notice holds its value.
+	Deprecated bool
+	Notice     string
+	Err        error
+}
+
+// Compatible reports whether every available endpoint returned the fields
+// the SDK needs and no endpoint is missing.
+func (r *CompatReport) Compatible() bool {
+	for _, e := range r.Endpoints {
+		if !e.Available || len(e.MissingFields) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MissingEndpoints lists the endpoints the server does not provide.
+func (r *CompatReport) MissingEndpoints() []string {
+	var out []string
+	for _, e := range r.Endpoints {
+		if !e.Available {
+			out = append(out, e.Method+" "+e.Path)
+		}
+	}
+	return out
+}
+
+// compatProbe describes a read-only endpoint and the response shape the
+// SDK expects from it.
+type compatProbe struct {
+	path     string
+	shape    reflect.Type
+	required []string
+	// list marks endpoints returning arrays; the first element is checked.
+	list bool
+	// notFoundOK marks probes where a JSON 404 still proves the endpoint.
+	notFoundOK bool
+}
+
+var (
+	agentType   = reflect.TypeOf(Agent{})
+	messageType = reflect.TypeOf(Message{})
+	contactType = reflect.TypeOf(Contact{})
+	infoType    = reflect.TypeOf(ServerInfo{})
+)
+
+// CompatCheck probes the server's read-only endpoints and compares their
+// responses with the shapes this SDK decodes. Endpoints that need an
+// agent are only probed once the client is registered. The inbox is not
+// probed, because fetching it marks its messages delivered; message
+// fields are checked against the conversation history instead.
+func (c *Client) CompatCheck(ctx context.Context, reqOpts ...RequestOption) (*CompatReport, error) {
+	if _, err := c.Negotiate(ctx); err != nil {
+		return nil, err
+	}
+
+	probes := []compatProbe{
+		{path: "/", shape: infoType, required: []string{"name", "version"}},
+		{path: "/directory", shape: agentType, required: []string{"id", "name"}, list: true},
+		{path: "/directory/search?q=", shape: agentType, required: []string{"id", "name"}, list: true},
+	}
+	if c.AgentID() != "" {
+		probes = append(probes,
+			compatProbe{path: "/agents/" + c.AgentID(), shape: agentType, required: []string{"id", "publicKey", "name"}},
+			compatProbe{path: "/agents/" + c.AgentID() + "/contacts", shape: contactType, required: []string{"contactId"}, list: true},
+			compatProbe{path: "/agents/" + c.AgentID() + "/messages/" + c.AgentID() + "?limit=1", shape: messageType, required: []string{"id", "type", "from", "to", "payload"}, list: true},
+		)
+	} else {
+		probes = append(probes, compatProbe{path: "/agents/00000000-0000-0000-0000-000000000000", notFoundOK: true})
+	}
+
+	report := &CompatReport{
+		ServerVersion: c.NegotiatedVersion(),
+		SDKVersion:    ProtocolVersion,
+		Features:      make(map[Feature]bool),
+	}
+	for _, f := range knownFeatures {
+		report.Features[f] = c.Supports(f)
+	}
+	// Features the server advertises that this package does not define,
+	// such as the admin package's, are reported too.
+	c.mu.RLock()
+	for f := range c.serverFeatures {
+		report.Features[f] = true
+	}
+	c.mu.RUnlock()
+	for _, p := range probes {
+		report.Endpoints = append(report.Endpoints, c.probe(ctx, p, reqOpts))
+	}
+	return report, nil
+}
+
+func (c *Client) probe(ctx context.Context, p compatProbe, reqOpts []RequestOption) EndpointReport {
+	path := p.path
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	r := EndpointReport{Method: "GET", Path: path}
+
+	resp, err := c.do(ctx, "GET", p.path, nil, reqOpts...)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	defer resp.Body.Close()
+	r.Status = resp.StatusCode
+	if d := resp.Header.Get("Deprecation"); d != "" {
+		r.Deprecated, r.Notice = true, d
+	} else if s := resp.Header.Get("Sunset"); s != "" {
+		r.Deprecated, r.Notice = true, "sunset "+s
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	isJSON := json.Valid(body)
+	switch {
+	case resp.StatusCode < 400:
+		r.Available = isJSON
+	case resp.StatusCode == 404 && p.notFoundOK:
+		r.Available = isJSON
+		return r
+	default:
+		return r
+	}
+	if !r.Available || p.shape == nil {
+		return r
+	}
+
+	var obj map[string]json.RawMessage
+	if p.list {
+		var items []map[string]json.RawMessage
+		if err := json.Unmarshal(body, &items); err != nil {
+			r.Err = err
+			return r
+		}
+		if len(items) == 0 {
+			return r
+		}
+		obj = items[0]
+	} else if err := json.Unmarshal(body, &obj); err != nil {
+		r.Err = err
+		return r
+	}
+
+	known := jsonFields(p.shape)
+	for _, f := range p.required {
+		if _, ok := obj[f]; !ok {
+			r.MissingFields = append(r.MissingFields, f)
+		}
+	}
+	for f := range obj {
+		if !known[f] {
+			r.UnknownFields = append(r.UnknownFields, f)
+		}
+	}
+	sort.Strings(r.UnknownFields)
+	return r
+}
+
+// jsonFields returns the JSON field names of struct type t.
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+		fields[name] = true
+	}
+	return fields
+}
@@ -0,0 +1,67 @@
+package ping
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aetos53t/ping/sdk/go/pingtest"
+)
+
+func TestCompatCheck(t *testing.T) {
+	srv := pingtest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+
+	for _, c := range []*Client{NewClient(srv.URL), registerTestClient(t, srv, "agent")} {
+		report, err := c.CompatCheck(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Compatible() {
+			t.Errorf("registered %v: not compatible, missing %v: %+v", c.AgentID() != "", report.MissingEndpoints(), report.Endpoints)
+		}
+		if report.ServerVersion != pingtest.Version {
+			t.Errorf("ServerVersion = %q, want %q", report.ServerVersion, pingtest.Version)
+		}
+		if len(report.Features) != len(knownFeatures) {
+			t.Errorf("report has %d features, want all %d known", len(report.Features), len(knownFeatures))
+		}
+		for _, f := range knownFeatures {
+			if got, want := report.Features[f], f == FeatureMessaging; got != want {
+				t.Errorf("Features[%s] = %v, want %v", f, got, want)
+			}
+		}
+	}
+}
+
+// TestCompatCheckAdvertised checks that features from the server's info
+// document are reported, including ones this package does not define.
+func TestCompatCheckAdvertised(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/info", "/":
+			w.Write([]byte(`{"name":"PING","version":"0.2.0","features":["messaging","stream","admin-broadcast"]}`))
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer srv.Close()
+
+	report, err := NewClient(srv.URL).CompatCheck(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for f, want := range map[Feature]bool{
+		FeatureMessaging:  true,
+		FeatureStream:     true,
+		"admin-broadcast": true,
+		FeatureOrgs:       false,
+	} {
+		if got, ok := report.Features[f]; !ok || got != want {
+			t.Errorf("Features[%s] = %v (present %v), want %v", f, got, ok, want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package ping
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// FeatureGzipRequests means the server accepts gzip-encoded request
+// bodies. Servers advertise it in their info document.
+const FeatureGzipRequests Feature = "gzip-requests"
+
+// compressMinSize is the smallest request body WithCompression gzips;
+// smaller bodies are not worth the CPU.
+const compressMinSize = 1024
+
+// WithCompression asks the server for gzip responses and decompresses them
+// transparently, whatever the HTTP transport. Request bodies of 1 KiB or
+// more are gzipped too when the server advertises FeatureGzipRequests.
+// Request signatures cover the body as sent, so compressed bodies verify
+// with VerifyRequest.
+func WithCompression() Option {
+	return func(c *Client) {
+		c.compression = true
+	}
+}
+
+// compressBody gzips body for the wire if compression is enabled, the body
+// is large enough and the server accepts it. It reports whether it did.
+func (c *Client) compressBody(ctx context.Context, body []byte) ([]byte, bool) {
+	if !c.compression || len(body) < compressMinSize {
+		return body, false
+	}
+	if c.requireFeature(ctx, FeatureGzipRequests) != nil {
+		return body, false
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(body); err != nil {
+		return body, false
+	}
+	if err := w.Close(); err != nil {
+		return body, false
+	}
+	return buf.Bytes(), true
+}
+
+// decompressResponse replaces a gzip-encoded response body with its
+// decoded form.
+func decompressResponse(resp *http.Response) error {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return nil
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		if err == io.EOF {
+			// Empty body, e.g. a 204 sent with the encoding header.
+			return nil
+		}
+		resp.Body.Close()
+		return err
+	}
+	resp.Body = &gzipBody{Reader: zr, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// gzipBody closes both the gzip reader and the underlying body.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
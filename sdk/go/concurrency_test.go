@@ -0,0 +1,151 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/aetos53t/ping/sdk/go/pingtest"
+)
+
+// These tests are meant to be run with -race.
+
+func registerTestClient(t *testing.T, srv *pingtest.Server, name string, opts ...Option) *Client {
+	t.Helper()
+	c := NewClient(srv.URL, opts...)
+	if _, err := c.Register(context.Background(), name, nil); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClientConcurrentSend(t *testing.T) {
+	srv := pingtest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	sender := registerTestClient(t, srv, "sender")
+	recipient := registerTestClient(t, srv, "recipient")
+
+	const workers, sends = 8, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*sends)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < sends; i++ {
+				payload := map[string]interface{}{"text": fmt.Sprintf("%d-%d", w, i)}
+				if _, err := sender.Send(ctx, recipient.AgentID(), MessageTypeText, payload, ""); err != nil {
+					errs <- err
+				}
+				if _, err := sender.PortableIdentity(); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	msgs, err := recipient.Inbox(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != workers*sends {
+		t.Errorf("recipient got %d messages, want %d", len(msgs), workers*sends)
+	}
+}
+
+// TestClientConcurrentIdentitySwitch switches the client between two
+// registered agents while sending. Each send must sign with the key of
+// the agent it is sent from, or the server rejects its signature.
+func TestClientConcurrentIdentitySwitch(t *testing.T) {
+	srv := pingtest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	recipient := registerTestClient(t, srv, "recipient")
+	var ids []*PortableIdentity
+	for _, name := range []string{"a", "b"} {
+		id, err := registerTestClient(t, srv, name).PortableIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	c := NewClient(srv.URL)
+	if err := c.UsePortableIdentity(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	switched := make(chan struct{})
+	go func() {
+		defer close(switched)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := c.UsePortableIdentity(ids[i%2]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				if _, err := c.Send(ctx, recipient.AgentID(), MessageTypeText, map[string]interface{}{"n": i}, ""); err != nil {
+					t.Error(err)
+					return
+				}
+				if id := c.AgentID(); id != ids[0].AgentID && id != ids[1].AgentID {
+					t.Errorf("AgentID() = %q during switch", id)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-switched
+}
+
+func TestClientConcurrentRegister(t *testing.T) {
+	srv := pingtest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	c := NewClient(srv.URL)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if _, err := c.Register(ctx, "agent", nil); err != nil {
+			t.Error(err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_ = c.AgentID()
+			_, _ = c.PortableIdentity()
+		}
+	}()
+	wg.Wait()
+
+	if c.AgentID() == "" {
+		t.Fatal("no agent ID after Register")
+	}
+	if _, err := c.GetAgent(ctx, c.AgentID()); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,292 @@
+package ping
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aetos53t/ping/sdk/go/internal/yamlite"
+)
+
+// DefaultBaseURL is the server URL used when a Config names none.
+const DefaultBaseURL = "http://localhost:3100"
+
+// Config is client configuration read from a YAML or JSON file by
+// ReadConfig or from PING_* environment variables by ConfigFromEnv. Zero
+// fields keep the client's defaults.
+//
+// In a file, durations are strings like "10s" or milliseconds:
+//
+//	url: https://ping.example.com
+//	identity: /var/lib/agent/identity.json
+//	timeout: 10s
+//	webhook:
+//	  url: https://agent.example.com/ping
+//	  events: [message, ack]
+type Config struct {
+	// URL is the server base URL. Defaults to DefaultBaseURL.
+	URL string `json:"url"`
+	// Identity is the path of a portable identity file to load. A
+	// missing file is not an error, so RegisterOrLoad can create it.
+	Identity string `json:"identity"`
+	// AgentID and PrivateKey, a hex Ed25519 seed or key, set the identity
+	// directly, e.g. from secrets in the environment.
+	AgentID    string `json:"agentId"`
+	PrivateKey string `json:"privateKey"`
+	// Timeout bounds each request. Defaults to 30s.
+	Timeout time.Duration `json:"timeout"`
+	// Retries, if set, is passed to WithRetries.
+	Retries *int `json:"retries"`
+	// Compression enables WithCompression.
+	Compression bool `json:"compression"`
+	// MaxResponseBytes, if non-zero, is passed to WithMaxResponseBytes.
+	MaxResponseBytes int64 `json:"maxResponseBytes"`
+	// Proxy is an HTTP or SOCKS5 proxy URL.
+	Proxy string `json:"proxy"`
+	// CACert is a PEM file of CA certificates to trust instead of the
+	// system roots.
+	CACert string `json:"caCert"`
+	// ClientCert and ClientKey are PEM files of a certificate for mTLS.
+	ClientCert string `json:"clientCert"`
+	ClientKey  string `json:"clientKey"`
+	// Webhook is the agent's webhook. Register uses its URL unless
+	// RegisterOptions give one; pass it to SetWebhook to apply it to an
+	// existing agent.
+	Webhook WebhookConfig `json:"webhook"`
+}
+
+// WebhookConfig is the webhook part of a Config.
+type WebhookConfig struct {
+	URL    string         `json:"url"`
+	Events []WebhookEvent `json:"events"`
+}
+
+// LoadConfig creates a client configured by the YAML or JSON file at
+// path. opts are applied after the file's settings.
+func LoadConfig(path string, opts ...Option) (*Client, error) {
+	cfg, err := ReadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.NewClient(opts...)
+}
+
+// FromEnv creates a client configured by ConfigFromEnv. opts are applied
+// after the configured settings.
+func FromEnv(opts ...Option) (*Client, error) {
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.NewClient(opts...)
+}
+
+// ReadConfig reads a Config from the YAML or JSON file at path.
+func ReadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseConfig parses a Config from YAML or JSON.
+func ParseConfig(data []byte) (*Config, error) {
+	var raw struct {
+		Config
+		Timeout json.RawMessage `json:"timeout"`
+	}
+	var err error
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		err = json.Unmarshal(trimmed, &raw)
+	} else {
+		err = yamlite.Unmarshal(data, &raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	cfg := raw.Config
+	if len(raw.Timeout) > 0 && string(raw.Timeout) != "null" {
+		if cfg.Timeout, err = parseConfigDuration(raw.Timeout); err != nil {
+			return nil, fmt.Errorf("parse config: timeout: %w", err)
+		}
+	}
+	return &cfg, nil
+}
+
+// parseConfigDuration parses a duration string like "10s" or a number of
+// milliseconds.
+func parseConfigDuration(raw json.RawMessage) (time.Duration, error) {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return time.ParseDuration(s)
+	}
+	var ms float64
+	if err := json.Unmarshal(raw, &ms); err != nil {
+		return 0, fmt.Errorf("want a string like \"10s\" or milliseconds")
+	}
+	return time.Duration(ms * float64(time.Millisecond)), nil
+}
+
+// ConfigFromEnv reads a Config from the environment. If PING_CONFIG names
+// a file it is read first, and these variables override its settings:
+//
+//	PING_URL                 server base URL
+//	PING_IDENTITY            portable identity file
+//	PING_AGENT_ID            agent ID
+//	PING_PRIVATE_KEY         hex private key
+//	PING_TIMEOUT             request timeout, e.g. 10s
+//	PING_RETRIES             retries for idempotent requests
+//	PING_COMPRESSION         true to enable compression
+//	PING_MAX_RESPONSE_BYTES  response size limit
+//	PING_PROXY               proxy URL
+//	PING_CA_CERT             CA certificates (PEM file)
+//	PING_CLIENT_CERT         mTLS certificate (PEM file)
+//	PING_CLIENT_KEY          mTLS key (PEM file)
+//	PING_WEBHOOK_URL         webhook URL
+//	PING_WEBHOOK_EVENTS      comma-separated webhook events
+func ConfigFromEnv() (*Config, error) {
+	cfg := &Config{}
+	if path := os.Getenv("PING_CONFIG"); path != "" {
+		var err error
+		if cfg, err = ReadConfig(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadEnv overrides cfg with the PING_* variables lookup finds.
+func (cfg *Config) loadEnv(lookup func(string) (string, bool)) error {
+	strs := map[string]*string{
+		"PING_URL":         &cfg.URL,
+		"PING_IDENTITY":    &cfg.Identity,
+		"PING_AGENT_ID":    &cfg.AgentID,
+		"PING_PRIVATE_KEY": &cfg.PrivateKey,
+		"PING_PROXY":       &cfg.Proxy,
+		"PING_CA_CERT":     &cfg.CACert,
+		"PING_CLIENT_CERT": &cfg.ClientCert,
+		"PING_CLIENT_KEY":  &cfg.ClientKey,
+		"PING_WEBHOOK_URL": &cfg.Webhook.URL,
+	}
+	for name, p := range strs {
+		if v, ok := lookup(name); ok {
+			*p = v
+		}
+	}
+
+	var err error
+	if v, ok := lookup("PING_TIMEOUT"); ok {
+		if cfg.Timeout, err = time.ParseDuration(v); err != nil {
+			return fmt.Errorf("PING_TIMEOUT: %w", err)
+		}
+	}
+	if v, ok := lookup("PING_RETRIES"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("PING_RETRIES: %w", err)
+		}
+		cfg.Retries = &n
+	}
+	if v, ok := lookup("PING_COMPRESSION"); ok {
+		if cfg.Compression, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("PING_COMPRESSION: %w", err)
+		}
+	}
+	if v, ok := lookup("PING_MAX_RESPONSE_BYTES"); ok {
+		if cfg.MaxResponseBytes, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return fmt.Errorf("PING_MAX_RESPONSE_BYTES: %w", err)
+		}
+	}
+	if v, ok := lookup("PING_WEBHOOK_EVENTS"); ok {
+		cfg.Webhook.Events = nil
+		for _, e := range strings.Split(v, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				cfg.Webhook.Events = append(cfg.Webhook.Events, WebhookEvent(e))
+			}
+		}
+	}
+	return nil
+}
+
+// NewClient creates a client with cfg's settings. opts are applied after
+// them.
+func (cfg *Config) NewClient(opts ...Option) (*Client, error) {
+	base := cfg.URL
+	if base == "" {
+		base = DefaultBaseURL
+	}
+
+	var cfgOpts []Option
+	if cfg.Timeout > 0 {
+		cfgOpts = append(cfgOpts, WithTimeout(cfg.Timeout))
+	}
+	if cfg.Retries != nil {
+		cfgOpts = append(cfgOpts, WithRetries(*cfg.Retries))
+	}
+	if cfg.Compression {
+		cfgOpts = append(cfgOpts, WithCompression())
+	}
+	if cfg.MaxResponseBytes != 0 {
+		cfgOpts = append(cfgOpts, WithMaxResponseBytes(cfg.MaxResponseBytes))
+	}
+	if cfg.Proxy != "" {
+		u, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: %w", err)
+		}
+		cfgOpts = append(cfgOpts, WithProxy(u))
+	}
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", cfg.CACert)
+		}
+		cfgOpts = append(cfgOpts, WithRootCAs(pool))
+	}
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		if cfg.ClientCert == "" || cfg.ClientKey == "" {
+			return nil, fmt.Errorf("client certificate needs both a certificate and a key file")
+		}
+		cfgOpts = append(cfgOpts, WithClientCertificate(cfg.ClientCert, cfg.ClientKey))
+	}
+	if cfg.Webhook.URL != "" {
+		webhookURL := cfg.Webhook.URL
+		cfgOpts = append(cfgOpts, func(c *Client) { c.webhookURL = webhookURL })
+	}
+
+	c := NewClient(base, append(cfgOpts, opts...)...)
+	if cfg.Identity != "" {
+		if err := c.LoadIdentity(cfg.Identity); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if cfg.PrivateKey != "" {
+		if err := c.SetKeys(cfg.PrivateKey); err != nil {
+			return nil, fmt.Errorf("private key: %w", err)
+		}
+	}
+	if cfg.AgentID != "" {
+		c.SetAgentID(cfg.AgentID)
+	}
+	return c, nil
+}
@@ -0,0 +1,183 @@
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultConsumerDedupWindow is how long a Consumer remembers processed
+// message IDs when DedupWindow is not set.
+const defaultConsumerDedupWindow = 24 * time.Hour
+
+// Consumer is a Service that delivers each inbox message to Handler
+// effectively once, even across crashes. It polls like Poller, but before
+// a message is acknowledged its ID is recorded as processed, and
+// redeliveries of processed messages are acknowledged without calling
+// Handler again. With Path set this state survives restarts.
+//
+// A message whose handling was interrupted by a crash is delivered again
+// after the restart, marked so Redelivered reports true for its context;
+// handlers with side effects can check it to avoid repeating them.
+type Consumer struct {
+	Client  *Client
+	Handler Handler
+	// Path, if set, is a JSON file the consumer's state is saved to after
+	// every message and loaded from on first use.
+	Path string
+	// Interval between polls. Defaults to 5s.
+	Interval time.Duration
+	// DedupWindow is how long processed message IDs are remembered, by
+	// message timestamp relative to the watermark. Defaults to 24h.
+	DedupWindow time.Duration
+	// OnError, if set, receives poll, handler, ack and state errors.
+	OnError func(error)
+
+	mu     sync.Mutex
+	loaded bool
+	state  consumerState
+}
+
+// consumerState is the persisted part of a Consumer.
+type consumerState struct {
+	// Watermark is the newest timestamp among processed messages.
+	Watermark Timestamp `json:"watermark"`
+	// Processed maps handled message IDs to their timestamps.
+	Processed map[string]Timestamp `json:"processed"`
+	// InFlight maps message IDs whose handling has started but not
+	// finished to their timestamps.
+	InFlight map[string]Timestamp `json:"inFlight"`
+}
+
+type redeliveredKey struct{}
+
+// Redelivered reports whether the message being handled was already
+// passed to the handler before a crash interrupted its processing.
+func Redelivered(ctx context.Context) bool {
+	v, _ := ctx.Value(redeliveredKey{}).(bool)
+	return v
+}
+
+// Run implements Service.
+func (c *Consumer) Run(ctx context.Context) error {
+	if err := c.load(); err != nil {
+		return err
+	}
+	p := &Poller{Client: c.Client, Handler: c.Middleware(c.Handler), Interval: c.Interval, OnError: c.OnError}
+	return p.Run(ctx)
+}
+
+// Watermark returns the resume token: the timestamp of the newest message
+// the consumer has processed, or zero if none.
+func (c *Consumer) Watermark() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loadLocked()
+	return c.state.Watermark.Time
+}
+
+// Middleware adds the consumer's deduplication to another handler, for use
+// with services that acknowledge messages after a nil return. Run applies
+// it to Handler.
+func (c *Consumer) Middleware(next Handler) Handler {
+	return func(ctx context.Context, msg Message) error {
+		c.mu.Lock()
+		if err := c.loadLocked(); err != nil {
+			c.mu.Unlock()
+			return err
+		}
+		if _, done := c.state.Processed[msg.ID]; done {
+			c.mu.Unlock()
+			return nil
+		}
+		_, redelivered := c.state.InFlight[msg.ID]
+		c.state.InFlight[msg.ID] = msg.Timestamp
+		err := c.saveLocked()
+		c.mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		if redelivered {
+			ctx = context.WithValue(ctx, redeliveredKey{}, true)
+		}
+		if err := next(ctx, msg); err != nil {
+			c.mu.Lock()
+			delete(c.state.InFlight, msg.ID)
+			c.saveLocked()
+			c.mu.Unlock()
+			return err
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.state.InFlight, msg.ID)
+		c.state.Processed[msg.ID] = msg.Timestamp
+		if msg.Timestamp.After(c.state.Watermark.Time) {
+			c.state.Watermark = msg.Timestamp
+		}
+		c.pruneLocked()
+		return c.saveLocked()
+	}
+}
+
+// pruneLocked forgets processed IDs older than the dedup window.
+func (c *Consumer) pruneLocked() {
+	cutoff := c.state.Watermark.Add(-durationOr(c.DedupWindow, defaultConsumerDedupWindow))
+	for id, ts := range c.state.Processed {
+		if ts.Before(cutoff) {
+			delete(c.state.Processed, id)
+		}
+	}
+}
+
+func (c *Consumer) load() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.loadLocked()
+}
+
+// loadLocked reads the state from Path the first time it is needed.
+func (c *Consumer) loadLocked() error {
+	if c.loaded {
+		return nil
+	}
+	if c.Path != "" {
+		data, err := os.ReadFile(c.Path)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(data, &c.state); err != nil {
+				return err
+			}
+		case !errors.Is(err, os.ErrNotExist):
+			return err
+		}
+	}
+	if c.state.Processed == nil {
+		c.state.Processed = make(map[string]Timestamp)
+	}
+	if c.state.InFlight == nil {
+		c.state.InFlight = make(map[string]Timestamp)
+	}
+	c.loaded = true
+	return nil
+}
+
+// saveLocked writes the state to Path.
+func (c *Consumer) saveLocked() error {
+	if c.Path == "" {
+		return nil
+	}
+	data, err := json.Marshal(&c.state)
+	if err != nil {
+		return err
+	}
+	tmp := c.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.Path)
+}
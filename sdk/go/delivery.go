@@ -0,0 +1,93 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// FeatureMessageLookup is the message lookup endpoint, which returns a
+// message and its delivery state by ID to its sender or recipient.
+const FeatureMessageLookup Feature = "message-lookup"
+
+// ErrNotDelivered is returned by WaitDelivered when the message is still
+// undelivered at the timeout.
+var ErrNotDelivered = errors.New("message not delivered")
+
+// Delivery status polling intervals for WaitDelivered.
+const (
+	minDeliveryPoll = 250 * time.Millisecond
+	maxDeliveryPoll = 5 * time.Second
+)
+
+// GetMessage returns a message the client sent or received, including its
+// current Delivered and Acknowledged state. Servers without
+// FeatureMessageLookup cannot report delivery state; there GetMessage
+// returns the client's stored copy, if it has a store, and otherwise an
+// error matching ErrUnsupported. Unknown IDs return ErrNotFound.
+func (c *Client) GetMessage(ctx context.Context, messageID string, reqOpts ...RequestOption) (*Message, error) {
+	if c.AgentID() == "" {
+		return nil, fmt.Errorf("not registered")
+	}
+	err := c.requireFeature(ctx, FeatureMessageLookup)
+	if errors.Is(err, ErrUnsupported) && c.store != nil {
+		return c.store.Load(ctx, messageID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var msg Message
+	err = c.request(ctx, "GET", "/messages/"+messageID, nil, &msg, reqOpts...)
+	if isStatus(err, http.StatusNotFound) {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	msgs := []Message{msg}
+	c.decodePayloads(ctx, msgs)
+	return &msgs[0], nil
+}
+
+// WaitDelivered polls GetMessage until the message has been delivered,
+// by webhook or by the recipient fetching its inbox, and returns it. It
+// polls quickly at first and backs off to every 5s. If the message is
+// still undelivered after timeout it returns the last state seen with
+// ErrNotDelivered. It requires FeatureMessageLookup.
+func (c *Client) WaitDelivered(ctx context.Context, messageID string, timeout time.Duration, reqOpts ...RequestOption) (*Message, error) {
+	if err := c.requireFeature(ctx, FeatureMessageLookup); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	poll := minDeliveryPoll
+	var last *Message
+	for {
+		msg, err := c.GetMessage(ctx, messageID, reqOpts...)
+		switch {
+		case err == nil:
+			if msg.Delivered {
+				return msg, nil
+			}
+			last = msg
+		case ctx.Err() == nil:
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && last != nil {
+				return last, ErrNotDelivered
+			}
+			return last, ctx.Err()
+		case <-time.After(poll):
+		}
+		if poll *= 2; poll > maxDeliveryPoll {
+			poll = maxDeliveryPoll
+		}
+	}
+}
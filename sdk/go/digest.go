@@ -0,0 +1,311 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MessageTypeDigest carries a DigestReport to the digest's target agent.
+const MessageTypeDigest MessageType = "digest"
+
+// defaultDigestMaxMessages is how many messages a Digest keeps per period
+// when MaxMessages is not set.
+const defaultDigestMaxMessages = 1000
+
+// Summarizer turns one period's messages into an operator-readable digest,
+// e.g. by prompting a language model.
+type Summarizer interface {
+	Summarize(ctx context.Context, msgs []Message) (string, error)
+}
+
+// SummarizerFunc adapts a function to Summarizer.
+type SummarizerFunc func(ctx context.Context, msgs []Message) (string, error)
+
+// Summarize implements Summarizer.
+func (f SummarizerFunc) Summarize(ctx context.Context, msgs []Message) (string, error) {
+	return f(ctx, msgs)
+}
+
+// DigestReport is one period's digest, as sent by Digest.
+type DigestReport struct {
+	WindowStart time.Time `json:"windowStart"`
+	WindowEnd   time.Time `json:"windowEnd"`
+	// Messages is the number of messages in the period, including any
+	// dropped beyond MaxMessages.
+	Messages int `json:"messages"`
+	// Peers lists the agents that sent the period's messages.
+	Peers   []string `json:"peers"`
+	Summary string   `json:"summary"`
+}
+
+// DecodeDigestReport reads the report carried by a digest message.
+func DecodeDigestReport(msg Message) (*DigestReport, error) {
+	if msg.Type != MessageTypeDigest {
+		return nil, fmt.Errorf("not a digest message: %s", msg.Type)
+	}
+	var r DigestReport
+	if err := msg.DecodePayload(&r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Digest collects the messages of selected conversations and periodically
+// sends a summary of them to a target agent, a webhook, or both. It is a
+// Service and a Flusher: Run sends a digest at the end of every period and
+// Flush sends the partial period on shutdown. Messages reach it through
+// Middleware or Record.
+type Digest struct {
+	Client *Client
+	// Peers, if set, restricts the digest to conversations with these
+	// agents.
+	Peers []string
+	// Types, if set, restricts the digest to these message types.
+	Types []MessageType
+	// Summarizer writes the summary. Defaults to per-peer message counts
+	// with an excerpt of each peer's latest message.
+	Summarizer Summarizer
+	// Period is how often digests are sent. Defaults to 1h.
+	Period time.Duration
+	// Target, if set, is the agent ID digests are sent to as
+	// MessageTypeDigest messages.
+	Target string
+	// Webhook, if set, is a URL the DigestReport is POSTed to as JSON.
+	Webhook string
+	// MaxMessages caps the messages kept per period; later ones are only
+	// counted. Defaults to 1000.
+	MaxMessages int
+	// OnError, if set, receives delivery errors from Run.
+	OnError func(error)
+
+	mu      sync.Mutex
+	start   time.Time
+	msgs    []Message
+	dropped int
+}
+
+// Record adds msg to the current period if it belongs to a selected
+// conversation.
+func (d *Digest) Record(msg Message) {
+	if !d.selects(msg) {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.start.IsZero() {
+		d.start = time.Now()
+	}
+	limit := d.MaxMessages
+	if limit <= 0 {
+		limit = defaultDigestMaxMessages
+	}
+	if len(d.msgs) >= limit {
+		d.dropped++
+		return
+	}
+	d.msgs = append(d.msgs, msg)
+}
+
+func (d *Digest) selects(msg Message) bool {
+	if msg.Type == MessageTypeDigest {
+		return false
+	}
+	if len(d.Peers) > 0 {
+		found := false
+		for _, p := range d.Peers {
+			if msg.From == p || msg.To == p {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(d.Types) > 0 {
+		for _, t := range d.Types {
+			if msg.Type == t {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Middleware records each handled message.
+func (d *Digest) Middleware(next Handler) Handler {
+	return func(ctx context.Context, msg Message) error {
+		d.Record(msg)
+		return next(ctx, msg)
+	}
+}
+
+// Report closes the current period and summarizes it without sending the
+// digest. It returns nil if nothing was recorded.
+func (d *Digest) Report(ctx context.Context) (*DigestReport, error) {
+	d.mu.Lock()
+	msgs, dropped, start := d.msgs, d.dropped, d.start
+	d.msgs, d.dropped, d.start = nil, 0, time.Time{}
+	d.mu.Unlock()
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+
+	var summarizer Summarizer = SummarizerFunc(summarizeCounts)
+	if d.Summarizer != nil {
+		summarizer = d.Summarizer
+	}
+	summary, err := summarizer.Summarize(ctx, msgs)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var peers []string
+	for _, m := range msgs {
+		if !seen[m.From] {
+			seen[m.From] = true
+			peers = append(peers, m.From)
+		}
+	}
+	sort.Strings(peers)
+	return &DigestReport{
+		WindowStart: start.UTC(),
+		WindowEnd:   time.Now().UTC(),
+		Messages:    len(msgs) + dropped,
+		Peers:       peers,
+		Summary:     summary,
+	}, nil
+}
+
+// Flush implements Flusher by sending the current period's digest.
+func (d *Digest) Flush(ctx context.Context) error {
+	report, err := d.Report(ctx)
+	if err != nil || report == nil {
+		return err
+	}
+	if d.Target != "" {
+		payload := map[string]interface{}{
+			"windowStart": report.WindowStart,
+			"windowEnd":   report.WindowEnd,
+			"messages":    report.Messages,
+			"peers":       report.Peers,
+			"summary":     report.Summary,
+		}
+		if _, err := d.Client.Send(ctx, d.Target, MessageTypeDigest, payload, ""); err != nil {
+			return err
+		}
+	}
+	if d.Webhook != "" {
+		return d.post(ctx, report)
+	}
+	return nil
+}
+
+func (d *Digest) post(ctx context.Context, report *DigestReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", d.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.Client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	drainBody(resp)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("digest webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+// Run implements Service, sending a digest at the end of every period
+// until ctx is done.
+func (d *Digest) Run(ctx context.Context) error {
+	ticker := time.NewTicker(durationOr(d.Period, time.Hour))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := d.Flush(ctx); err != nil {
+				reportError(d.OnError, err)
+			}
+		}
+	}
+}
+
+// summarizeCounts is the default Summarizer: one line per sender with its
+// message count by type and an excerpt of its latest message.
+func summarizeCounts(ctx context.Context, msgs []Message) (string, error) {
+	type peerSummary struct {
+		total  int
+		byType map[MessageType]int
+		latest Message
+	}
+	peers := make(map[string]*peerSummary)
+	var order []string
+	for _, m := range msgs {
+		p := peers[m.From]
+		if p == nil {
+			p = &peerSummary{byType: make(map[MessageType]int)}
+			peers[m.From] = p
+			order = append(order, m.From)
+		}
+		p.total++
+		p.byType[m.Type]++
+		if !m.Timestamp.Before(p.latest.Timestamp.Time) {
+			p.latest = m
+		}
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return peers[order[i]].total > peers[order[j]].total
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d messages from %d agents\n", len(msgs), len(order))
+	for _, id := range order {
+		p := peers[id]
+		types := make([]string, 0, len(p.byType))
+		for t, n := range p.byType {
+			types = append(types, fmt.Sprintf("%s %d", t, n))
+		}
+		sort.Strings(types)
+		fmt.Fprintf(&b, "- %s: %d (%s)", id, p.total, strings.Join(types, ", "))
+		if excerpt := messageExcerpt(p.latest, 80); excerpt != "" {
+			fmt.Fprintf(&b, ": %q", excerpt)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// messageExcerpt returns the start of a message's text payload, or of its
+// JSON when it has no "text" field.
+func messageExcerpt(msg Message, max int) string {
+	var p struct {
+		Text string `json:"text"`
+	}
+	text := string(msg.Payload)
+	if json.Unmarshal(msg.Payload, &p) == nil && p.Text != "" {
+		text = p.Text
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	if r := []rune(text); len(r) > max {
+		text = string(r[:max-1]) + "…"
+	}
+	return text
+}
@@ -0,0 +1,191 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// srvScheme prefixes base URLs that name an SRV record to discover
+// servers from, e.g. dns+srv://_ping._tcp.example.com.
+const srvScheme = "dns+srv://"
+
+// maxFailovers bounds how many other servers a request whose connection
+// was refused is sent to.
+const maxFailovers = 3
+
+// defaultDiscoveryRefresh is how often SRV records are re-resolved.
+const defaultDiscoveryRefresh = 30 * time.Second
+
+// DiscoveryOptions configures server discovery for dns+srv:// base URLs.
+type DiscoveryOptions struct {
+	// Scheme is the URL scheme of discovered servers. Defaults to
+	// "https".
+	Scheme string
+	// Resolver looks up SRV records. Defaults to net.DefaultResolver.
+	Resolver *net.Resolver
+	// Refresh is how often the records are resolved again, and how long
+	// a server that failed is passed over. Defaults to 30s.
+	Refresh time.Duration
+}
+
+// WithDiscovery configures server discovery for a client created with a
+// dns+srv:// base URL. It has no effect on other clients.
+func WithDiscovery(opts DiscoveryOptions) Option {
+	return func(c *Client) {
+		c.discoveryOpts = opts
+	}
+}
+
+// Endpoint returns the server URL the client's next request goes to. For
+// a dns+srv:// client it is the discovered server currently in use, or ""
+// before the first request.
+func (c *Client) Endpoint() string {
+	if c.discovery == nil {
+		return c.baseURL
+	}
+	return c.discovery.peek()
+}
+
+// endpoint returns the server URL to send a request to.
+func (c *Client) endpoint(ctx context.Context) (string, error) {
+	if c.discovery == nil {
+		return c.baseURL, nil
+	}
+	return c.discovery.pick(ctx)
+}
+
+// srvDiscovery finds servers from SRV records. Servers are tried in the
+// order the records give, by priority and then weight; one that fails is
+// passed over until Refresh has passed, so requests fail over to the next
+// and return to it once it has recovered.
+type srvDiscovery struct {
+	name string
+	opts DiscoveryOptions
+
+	mu         sync.Mutex
+	endpoints  []string
+	resolvedAt time.Time
+	resolving  bool
+	failed     map[string]time.Time
+}
+
+func newSRVDiscovery(name string, opts DiscoveryOptions) *srvDiscovery {
+	if opts.Scheme == "" {
+		opts.Scheme = "https"
+	}
+	if opts.Resolver == nil {
+		opts.Resolver = net.DefaultResolver
+	}
+	if opts.Refresh <= 0 {
+		opts.Refresh = defaultDiscoveryRefresh
+	}
+	return &srvDiscovery{name: strings.TrimSuffix(name, "/"), opts: opts, failed: make(map[string]time.Time)}
+}
+
+// pick returns the server to use, resolving the records on first use and
+// re-resolving them in the background once they are stale.
+func (d *srvDiscovery) pick(ctx context.Context) (string, error) {
+	d.mu.Lock()
+	if len(d.endpoints) == 0 {
+		d.mu.Unlock()
+		if err := d.resolve(ctx); err != nil {
+			return "", err
+		}
+		d.mu.Lock()
+	} else if time.Since(d.resolvedAt) > d.opts.Refresh && !d.resolving {
+		d.resolving = true
+		go d.resolve(context.Background())
+	}
+	defer d.mu.Unlock()
+	return d.choose(), nil
+}
+
+// peek returns the server pick would, without resolving.
+func (d *srvDiscovery) peek() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.endpoints) == 0 {
+		return ""
+	}
+	return d.choose()
+}
+
+// choose returns the first server that has not failed recently or, if all
+// have, the one that failed longest ago. d.mu must be held.
+func (d *srvDiscovery) choose() string {
+	best := d.endpoints[0]
+	for _, e := range d.endpoints {
+		at, ok := d.failed[e]
+		if !ok || time.Since(at) > d.opts.Refresh {
+			return e
+		}
+		if at.Before(d.failed[best]) {
+			best = e
+		}
+	}
+	return best
+}
+
+// resolve looks up the SRV records. A failed lookup keeps the servers
+// found before.
+func (d *srvDiscovery) resolve(ctx context.Context) error {
+	_, addrs, err := d.opts.Resolver.LookupSRV(ctx, "", "", d.name)
+	var endpoints []string
+	for _, a := range addrs {
+		if a.Target == "." {
+			continue // the service is decidedly not available there
+		}
+		host := strings.TrimSuffix(a.Target, ".")
+		endpoints = append(endpoints, d.opts.Scheme+"://"+net.JoinHostPort(host, strconv.Itoa(int(a.Port))))
+	}
+	if err == nil && len(endpoints) == 0 {
+		err = fmt.Errorf("no servers found for %s", d.name)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.resolving = false
+	if err != nil {
+		if len(d.endpoints) > 0 {
+			// Try again after another interval.
+			d.resolvedAt = time.Now()
+			return nil
+		}
+		return fmt.Errorf("discover servers: %w", err)
+	}
+	d.endpoints = endpoints
+	d.resolvedAt = time.Now()
+	return nil
+}
+
+// observe records the outcome of req. It reports whether its server
+// failed and another is now preferred.
+func (d *srvDiscovery) observe(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil || errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrOffline) {
+		return false
+	}
+	server := req.URL.Scheme + "://" + req.URL.Host
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !serverFailure(resp, err) {
+		delete(d.failed, server)
+		return false
+	}
+	d.failed[server] = time.Now()
+	return len(d.endpoints) > 0 && d.choose() != server
+}
+
+// refused reports whether err is a refused connection, which never
+// reached a server and so can be sent to another whatever its method.
+func refused(err error) bool {
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial" || errors.Is(err, syscall.ECONNREFUSED)
+}
@@ -0,0 +1,101 @@
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// MessageTypeEdit amends a message the sender sent earlier. Its ReplyTo
+// is the message edited and its payload replaces that message's payload.
+const MessageTypeEdit MessageType = "edit"
+
+// MessageEdit is one edit applied to a message.
+type MessageEdit struct {
+	// ID is the ID of the edit message.
+	ID        string          `json:"id"`
+	Payload   json.RawMessage `json:"payload"`
+	Timestamp Timestamp       `json:"timestamp"`
+}
+
+// Edit replaces the payload of a message the client sent by sending an
+// edit message to its recipient. The message is looked up like GetMessage
+// does, so without the message-lookup feature it must be in the client's
+// store. The recipient receives the edit as a MessageTypeEdit message;
+// History and the store apply it to the original.
+func (c *Client) Edit(ctx context.Context, messageID string, newPayload map[string]interface{}, reqOpts ...RequestOption) (*SendResult, error) {
+	msg, err := c.GetMessage(ctx, messageID, reqOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if msg.From != c.AgentID() {
+		return nil, fmt.Errorf("cannot edit message %s from %s", messageID, msg.From)
+	}
+	return c.Send(ctx, msg.To, MessageTypeEdit, newPayload, messageID, reqOpts...)
+}
+
+// applyEdit applies edit to m, once, if edit is a valid edit of m.
+func (m *Message) applyEdit(edit Message) bool {
+	if edit.Type != MessageTypeEdit || edit.ReplyTo != m.ID || edit.From != m.From {
+		return false
+	}
+	for _, e := range m.Edits {
+		if e.ID == edit.ID {
+			return false
+		}
+	}
+	if m.OriginalPayload == nil {
+		m.OriginalPayload = m.Payload
+	}
+	m.Edits = append(m.Edits, MessageEdit{ID: edit.ID, Payload: edit.Payload, Timestamp: edit.Timestamp})
+	sort.SliceStable(m.Edits, func(i, j int) bool {
+		return m.Edits[i].Timestamp.Before(m.Edits[j].Timestamp.Time)
+	})
+	m.Payload = m.Edits[len(m.Edits)-1].Payload
+	return true
+}
+
+// applyEdits folds the edit messages in msgs into the messages they edit
+// and drops them. Edits of messages not in msgs are kept as they are.
+func applyEdits(msgs []Message) []Message {
+	index := make(map[string]int)
+	for i, m := range msgs {
+		if m.Type != MessageTypeEdit {
+			index[m.ID] = i
+		}
+	}
+	applied := make(map[int]bool)
+	for i, m := range msgs {
+		if m.Type != MessageTypeEdit {
+			continue
+		}
+		if j, ok := index[m.ReplyTo]; ok && msgs[j].applyEdit(m) {
+			applied[i] = true
+		}
+	}
+	if len(applied) == 0 {
+		return msgs
+	}
+	out := msgs[:0]
+	for i, m := range msgs {
+		if !applied[i] {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// storeEdits applies the edits among msgs to the stored messages they
+// edit.
+func (c *Client) storeEdits(ctx context.Context, msgs []Message) {
+	for _, m := range msgs {
+		if m.Type != MessageTypeEdit {
+			continue
+		}
+		target, err := c.store.Load(ctx, m.ReplyTo)
+		if err == nil && target.applyEdit(m) {
+			c.store.Save(ctx, *target)
+		}
+	}
+}
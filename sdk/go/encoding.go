@@ -0,0 +1,138 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/aetos53t/ping/sdk/go/internal/binenc"
+	"github.com/aetos53t/ping/sdk/go/wire"
+)
+
+// Encoding is a media type for request and response bodies.
+type Encoding string
+
+// Encodings the client can speak. JSON is the default and always
+// available; the binary encodings are used only with servers that
+// advertise them.
+const (
+	JSON        Encoding = "application/json"
+	CBOR        Encoding = "application/cbor"
+	MessagePack Encoding = "application/msgpack"
+)
+
+// Features advertising the binary encodings.
+const (
+	FeatureCBOR        Feature = "cbor"
+	FeatureMessagePack Feature = "msgpack"
+)
+
+// WithEncoding makes the client send request bodies in e and ask for
+// responses in it, when the server advertises the encoding; otherwise it
+// keeps using JSON. Responses are decoded by their Content-Type, so a
+// server may still answer in JSON.
+//
+// Payload values of type []byte are sent as binary strings instead of
+// base64 text. Signatures still cover the JSON form of the payload, in
+// which they are base64 strings, and received binary strings read back as
+// base64 strings that decode into []byte fields.
+func WithEncoding(e Encoding) Option {
+	return func(c *Client) {
+		c.encoding = e
+	}
+}
+
+func (e Encoding) format() (binenc.Format, bool) {
+	switch e {
+	case CBOR:
+		return binenc.CBOR, true
+	case MessagePack:
+		return binenc.MessagePack, true
+	}
+	return 0, false
+}
+
+func (e Encoding) feature() Feature {
+	if e == MessagePack {
+		return FeatureMessagePack
+	}
+	return FeatureCBOR
+}
+
+// binaryEncoding reports whether the request to path should use the
+// client's binary encoding. Version negotiation itself always uses JSON.
+func (c *Client) binaryEncoding(ctx context.Context, path string) (binenc.Format, bool) {
+	f, ok := c.encoding.format()
+	if !ok || path == "/info" || path == "/" {
+		return 0, false
+	}
+	if c.requireFeature(ctx, c.encoding.feature()) != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// encodeBody encodes a request body in the client's encoding, returning
+// the bytes and their content type.
+func (c *Client) encodeBody(ctx context.Context, path string, body interface{}) ([]byte, string, error) {
+	if f, ok := c.binaryEncoding(ctx, path); ok {
+		b, err := binenc.Marshal(f, body)
+		return b, string(c.encoding), err
+	}
+	b, err := encodeJSON(body, true)
+	return b, string(JSON), err
+}
+
+// transcodeResponse replaces a binary response body with its JSON form, so
+// the rest of the client only ever decodes JSON.
+func transcodeResponse(resp *http.Response) error {
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	f, ok := Encoding(mt).format()
+	if !ok {
+		return nil
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		if data, err = binenc.ToJSON(f, data); err != nil {
+			return err
+		}
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	resp.Header.Set("Content-Type", string(JSON))
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = int64(len(data))
+	return nil
+}
+
+// messageBody is the POST /messages body for env. With a binary encoding
+// it carries wirePayload itself rather than its JSON, so []byte values go
+// out as binary strings.
+func (c *Client) messageBody(ctx context.Context, env *wire.Envelope, wirePayload map[string]interface{}) interface{} {
+	if _, ok := c.binaryEncoding(ctx, "/messages"); !ok || wirePayload == nil {
+		return env
+	}
+	body := map[string]interface{}{
+		"type":      env.Type,
+		"from":      env.From,
+		"to":        env.To,
+		"payload":   wirePayload,
+		"timestamp": env.Timestamp,
+		"signature": env.Signature,
+	}
+	if env.ReplyTo != "" {
+		body["replyTo"] = env.ReplyTo
+	}
+	if len(env.Delegation) > 0 {
+		body["delegation"] = env.Delegation
+	}
+	if len(env.Meta) > 0 {
+		body["meta"] = env.Meta
+	}
+	return body
+}
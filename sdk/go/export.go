@@ -0,0 +1,190 @@
+package ping
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ExportFormat is a transcript format for ExportConversation.
+type ExportFormat string
+
+const (
+	// ExportJSONL writes one message per line in PING's message shape, which
+	// ImportMessages reads back.
+	ExportJSONL ExportFormat = "jsonl"
+	// ExportMarkdown writes a Markdown transcript with a heading per message
+	// and structured payloads in JSON code blocks.
+	ExportMarkdown ExportFormat = "markdown"
+	// ExportText writes one plain text line per message.
+	ExportText ExportFormat = "text"
+)
+
+// ExportConversation writes the conversation with otherID to w, oldest
+// message first. History is read page by page, as HistoryIter does, and
+// edits are folded into the messages they edit. Markdown and text
+// transcripts name both agents, looking the other one up in the
+// directory; if that fails, its ID is used.
+func (c *Client) ExportConversation(ctx context.Context, otherID string, w io.Writer, format ExportFormat, reqOpts ...RequestOption) error {
+	var write func(*bufio.Writer, *Message, map[string]string) error
+	switch format {
+	case ExportJSONL:
+		write = writeJSONLMessage
+	case ExportMarkdown:
+		write = writeMarkdownMessage
+	case ExportText:
+		write = writeTextMessage
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+
+	var msgs []Message
+	var iterErr error
+	c.HistoryIter(ctx, otherID, reqOpts...)(func(m Message, err error) bool {
+		if err != nil {
+			iterErr = err
+			return false
+		}
+		msgs = append(msgs, m)
+		return true
+	})
+	if iterErr != nil {
+		return iterErr
+	}
+	msgs = applyEdits(msgs)
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+
+	id := c.identity()
+	names := map[string]string{id.agentID: id.name, otherID: otherID}
+	if id.name == "" {
+		names[id.agentID] = id.agentID
+	}
+	if format != ExportJSONL {
+		if other, err := c.GetAgent(ctx, otherID, reqOpts...); err == nil && other.Name != "" {
+			names[otherID] = other.Name
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	if format == ExportMarkdown {
+		fmt.Fprintf(bw, "# Conversation between %s and %s\n\n", names[id.agentID], names[otherID])
+		fmt.Fprintf(bw, "%d messages", len(msgs))
+		if len(msgs) > 0 {
+			fmt.Fprintf(bw, ", %s to %s", transcriptTime(msgs[0].Timestamp), transcriptTime(msgs[len(msgs)-1].Timestamp))
+		}
+		bw.WriteString(".\n")
+	}
+	for i := range msgs {
+		if err := write(bw, &msgs[i], names); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func writeJSONLMessage(w *bufio.Writer, m *Message, _ map[string]string) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("message %s: %w", m.ID, err)
+	}
+	w.Write(data)
+	return w.WriteByte('\n')
+}
+
+func writeMarkdownMessage(w *bufio.Writer, m *Message, names map[string]string) error {
+	fmt.Fprintf(w, "\n### %s · %s · %s\n\n", transcriptName(names, m.From), transcriptTime(m.Timestamp), m.Type)
+	if m.ReplyTo != "" {
+		fmt.Fprintf(w, "_In reply to %s._\n\n", m.ReplyTo)
+	}
+	text, data := transcriptBody(m)
+	if text != "" {
+		fmt.Fprintf(w, "%s\n", text)
+	}
+	if data != nil {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, data, "", "  "); err != nil {
+			buf.Reset()
+			buf.Write(data)
+		}
+		if text != "" {
+			w.WriteByte('\n')
+		}
+		fmt.Fprintf(w, "```json\n%s\n```\n", buf.Bytes())
+	}
+	if len(m.Edits) > 0 {
+		fmt.Fprintf(w, "\n_Edited %d times._\n", len(m.Edits))
+	}
+	return nil
+}
+
+func writeTextMessage(w *bufio.Writer, m *Message, names map[string]string) error {
+	text, data := transcriptBody(m)
+	if data != nil {
+		if text != "" {
+			text += " "
+		}
+		text += string(data)
+	}
+	// Continuation lines are indented so each message still starts its own
+	// line.
+	text = strings.ReplaceAll(text, "\n", "\n    ")
+	_, err := fmt.Fprintf(w, "%s %s -> %s [%s] %s\n", transcriptTime(m.Timestamp),
+		transcriptName(names, m.From), transcriptName(names, m.To), m.Type, text)
+	return err
+}
+
+// transcriptBody splits a message's payload into readable text and any
+// remaining structured data: a text payload's text, a request's action and
+// data, or otherwise the whole payload.
+func transcriptBody(m *Message) (string, json.RawMessage) {
+	if len(m.Payload) == 0 || string(m.Payload) == "null" {
+		return "", nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(m.Payload, &fields); err != nil {
+		return "", compactRaw(m.Payload)
+	}
+	if len(fields) == 0 {
+		return "", nil
+	}
+	var text string
+	if raw, ok := fields["text"]; ok && json.Unmarshal(raw, &text) == nil && len(fields) == 1 {
+		return text, nil
+	}
+	if m.Type == MessageTypeRequest {
+		var action string
+		if raw, ok := fields["action"]; ok && json.Unmarshal(raw, &action) == nil && len(fields) <= 2 {
+			if data, ok := fields["data"]; ok && string(data) != "null" {
+				return action, compactRaw(data)
+			}
+			return action, nil
+		}
+	}
+	return "", compactRaw(m.Payload)
+}
+
+func compactRaw(data json.RawMessage) json.RawMessage {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
+
+func transcriptName(names map[string]string, id string) string {
+	if name, ok := names[id]; ok {
+		return name
+	}
+	return id
+}
+
+func transcriptTime(t Timestamp) string {
+	return t.UTC().Format(time.RFC3339)
+}
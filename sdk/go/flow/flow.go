@@ -0,0 +1,444 @@
+// Package flow runs multi-step agent workflows: send a request to one
+// agent, wait for its reply, transform the result, send it on to the
+// next, with timeouts per step and compensation when a step fails. Each
+// run's progress is saved to a Store after every step, so runs resume
+// where they left off after a restart.
+//
+//	f := flow.New("summarize-and-translate",
+//		flow.Request("summary", summarizer, ping.MessageTypeRequest, func(r *flow.Run) (map[string]interface{}, error) {
+//			return map[string]interface{}{"action": "summarize", "data": r.String("url")}, nil
+//		}).WithTimeout(2*time.Minute),
+//		flow.Request("translation", translator, ping.MessageTypeRequest, func(r *flow.Run) (map[string]interface{}, error) {
+//			var s struct{ Result string }
+//			err := r.Get("summary", &s)
+//			return map[string]interface{}{"action": "translate", "data": s.Result}, err
+//		}),
+//	)
+//	engine := &flow.Engine{Client: client, Store: store}
+//	engine.Register(f)
+//	engine.Resume(ctx) // finish runs interrupted by the last shutdown
+//	run, err := engine.Start(ctx, "summarize-and-translate", map[string]interface{}{"url": url})
+package flow
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	ping "github.com/aetos53t/ping/sdk/go"
+)
+
+// DefaultTimeout bounds steps that set no timeout.
+const DefaultTimeout = time.Minute
+
+// Flow is a named sequence of steps.
+type Flow struct {
+	Name  string
+	Steps []Step
+}
+
+// New returns a flow running steps in order.
+func New(name string, steps ...Step) *Flow {
+	return &Flow{Name: name, Steps: steps}
+}
+
+// Step is one step of a flow: a local function, or a request sent to an
+// agent whose reply is saved in a run variable.
+type Step struct {
+	Name string
+	// Do, if set, runs a local step, e.g. to transform variables.
+	Do func(ctx context.Context, r *Run) error
+
+	// To, Type and Payload describe a request step. The reply's payload
+	// is saved in the variable named Save; an error reply fails the step
+	// with a *ping.RemoteError.
+	To      string
+	Type    ping.MessageType
+	Payload func(r *Run) (map[string]interface{}, error)
+	Save    string
+
+	// Timeout bounds the step. For a request step it is how long to wait
+	// for the reply after sending, including across restarts. Defaults to
+	// DefaultTimeout.
+	Timeout time.Duration
+	// Compensate, if set, undoes the step when a later step fails.
+	// Compensations run in reverse order; the failed step itself is not
+	// compensated.
+	Compensate func(ctx context.Context, r *Run) error
+}
+
+// Do returns a local step.
+func Do(name string, fn func(ctx context.Context, r *Run) error) Step {
+	return Step{Name: name, Do: fn}
+}
+
+// Request returns a step that sends a message built by payload to the
+// agent to and saves the reply's payload in the variable named name.
+func Request(name, to string, msgType ping.MessageType, payload func(r *Run) (map[string]interface{}, error)) Step {
+	return Step{Name: name, To: to, Type: msgType, Payload: payload, Save: name}
+}
+
+// WithTimeout returns s with its timeout set to d.
+func (s Step) WithTimeout(d time.Duration) Step {
+	s.Timeout = d
+	return s
+}
+
+// WithCompensation returns s with fn as its compensation.
+func (s Step) WithCompensation(fn func(ctx context.Context, r *Run) error) Step {
+	s.Compensate = fn
+	return s
+}
+
+// Send returns a function, for Do or a compensation, that sends a
+// message built by payload to the agent to without waiting for a reply.
+func Send(to string, msgType ping.MessageType, payload func(r *Run) (map[string]interface{}, error)) func(ctx context.Context, r *Run) error {
+	return func(ctx context.Context, r *Run) error {
+		var p map[string]interface{}
+		if payload != nil {
+			var err error
+			if p, err = payload(r); err != nil {
+				return err
+			}
+		}
+		_, err := r.client.Send(ctx, to, msgType, p, "", ping.WithThread(r.ID))
+		return err
+	}
+}
+
+// Status is the state of a run.
+type Status string
+
+// Run states.
+const (
+	StatusRunning      Status = "running"
+	StatusCompensating Status = "compensating"
+	StatusCompleted    Status = "completed"
+	StatusFailed       Status = "failed"
+)
+
+// Run is one execution of a flow, as saved in a Store.
+type Run struct {
+	ID     string `json:"id"`
+	Flow   string `json:"flow"`
+	Status Status `json:"status"`
+	// Step is the index of the step being run or, while compensating, of
+	// the next step to compensate.
+	Step int                        `json:"step"`
+	Vars map[string]json.RawMessage `json:"vars"`
+	// Pending is the message a request step is waiting for a reply to,
+	// sent at PendingAt.
+	Pending   string    `json:"pending,omitempty"`
+	PendingAt time.Time `json:"pendingAt,omitempty"`
+	// FailedStep and Error describe why the run failed.
+	FailedStep string `json:"failedStep,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// CompensationErrors lists compensations that failed.
+	CompensationErrors []string  `json:"compensationErrors,omitempty"`
+	StartedAt          time.Time `json:"startedAt"`
+	UpdatedAt          time.Time `json:"updatedAt"`
+
+	client *ping.Client
+}
+
+// Done reports whether the run has finished.
+func (r *Run) Done() bool {
+	return r.Status == StatusCompleted || r.Status == StatusFailed
+}
+
+// Client returns the client the run sends with.
+func (r *Run) Client() *ping.Client {
+	return r.client
+}
+
+// Set stores v, encoded as JSON, in the variable key.
+func (r *Run) Set(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if r.Vars == nil {
+		r.Vars = make(map[string]json.RawMessage)
+	}
+	r.Vars[key] = data
+	return nil
+}
+
+// Get decodes the variable key into v.
+func (r *Run) Get(key string, v interface{}) error {
+	data, ok := r.Vars[key]
+	if !ok {
+		return fmt.Errorf("flow variable %q not set", key)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// String returns the string variable key, or "" if it is missing or not
+// a string.
+func (r *Run) String(key string) string {
+	var s string
+	if r.Get(key, &s) != nil {
+		return ""
+	}
+	return s
+}
+
+// Engine starts and resumes runs of registered flows. It is safe for
+// concurrent use.
+type Engine struct {
+	Client *ping.Client
+	// Store saves run progress. Defaults to a MemoryStore, which does not
+	// survive restarts.
+	Store Store
+
+	mu     sync.Mutex
+	flows  map[string]*Flow
+	active map[string]bool // runs being executed
+}
+
+// Register makes flows available to Start and Resume.
+func (e *Engine) Register(flows ...*Flow) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.flows == nil {
+		e.flows = make(map[string]*Flow)
+	}
+	for _, f := range flows {
+		e.flows[f.Name] = f
+	}
+}
+
+// Start runs the flow name with the given initial variables until it
+// completes or fails. A failed run is compensated before Start returns
+// the step's error. If ctx is done first, the run is left for Resume.
+func (e *Engine) Start(ctx context.Context, name string, vars map[string]interface{}) (*Run, error) {
+	f, err := e.flow(name)
+	if err != nil {
+		return nil, err
+	}
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	r := &Run{ID: hex.EncodeToString(id[:]), Flow: name, Status: StatusRunning, StartedAt: now, UpdatedAt: now}
+	for k, v := range vars {
+		if err := r.Set(k, v); err != nil {
+			return nil, fmt.Errorf("flow variable %q: %w", k, err)
+		}
+	}
+	if err := e.store().Save(ctx, r); err != nil {
+		return nil, err
+	}
+	e.claim(r.ID)
+	defer e.release(r.ID)
+	return r, e.execute(ctx, f, r)
+}
+
+// Resume continues every unfinished run in the store that is not already
+// being executed, concurrently, and waits for them. It returns the errors
+// of runs that failed or could not be resumed.
+func (e *Engine) Resume(ctx context.Context) error {
+	runs, err := e.store().List(ctx)
+	if err != nil {
+		return err
+	}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, r := range runs {
+		if r.Done() || !e.claim(r.ID) {
+			continue
+		}
+		wg.Add(1)
+		go func(r *Run) {
+			defer wg.Done()
+			defer e.release(r.ID)
+			f, err := e.flow(r.Flow)
+			if err == nil {
+				err = e.execute(ctx, f, r)
+			}
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("run %s: %w", r.ID, err))
+				mu.Unlock()
+			}
+		}(r)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Get returns the saved state of run id.
+func (e *Engine) Get(ctx context.Context, id string) (*Run, error) {
+	return e.store().Load(ctx, id)
+}
+
+func (e *Engine) flow(name string) (*Flow, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	f, ok := e.flows[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown flow %q", name)
+	}
+	return f, nil
+}
+
+func (e *Engine) store() Store {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.Store == nil {
+		e.Store = NewMemoryStore()
+	}
+	return e.Store
+}
+
+// claim marks run id as being executed, reporting false if it already is.
+func (e *Engine) claim(id string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.active[id] {
+		return false
+	}
+	if e.active == nil {
+		e.active = make(map[string]bool)
+	}
+	e.active[id] = true
+	return true
+}
+
+func (e *Engine) release(id string) {
+	e.mu.Lock()
+	delete(e.active, id)
+	e.mu.Unlock()
+}
+
+// execute runs r from where it left off. Progress is saved after every
+// step, even if ctx is done, so the run can be resumed.
+func (e *Engine) execute(ctx context.Context, f *Flow, r *Run) error {
+	r.client = e.Client
+	var failure error
+	for r.Status == StatusRunning && r.Step < len(f.Steps) {
+		step := f.Steps[r.Step]
+		err := e.runStep(ctx, step, r)
+		if err != nil && ctx.Err() != nil {
+			return errors.Join(ctx.Err(), e.save(ctx, r))
+		}
+		if err != nil {
+			failure = fmt.Errorf("flow %s: step %s: %w", f.Name, step.Name, err)
+			r.Status, r.FailedStep, r.Error = StatusCompensating, step.Name, err.Error()
+			r.Step--
+		} else {
+			r.Step++
+		}
+		if err := e.save(ctx, r); err != nil {
+			return err
+		}
+	}
+	if r.Status == StatusRunning {
+		r.Status = StatusCompleted
+		return e.save(ctx, r)
+	}
+
+	for r.Status == StatusCompensating && r.Step >= 0 {
+		step := f.Steps[r.Step]
+		if step.Compensate != nil {
+			sctx, cancel := context.WithTimeout(ctx, timeoutOf(step))
+			err := step.Compensate(sctx, r)
+			cancel()
+			if err != nil && ctx.Err() != nil {
+				return errors.Join(ctx.Err(), e.save(ctx, r))
+			}
+			if err != nil {
+				r.CompensationErrors = append(r.CompensationErrors, fmt.Sprintf("%s: %v", step.Name, err))
+			}
+		}
+		r.Step--
+		if err := e.save(ctx, r); err != nil {
+			return err
+		}
+	}
+	r.Status = StatusFailed
+	if err := e.save(ctx, r); err != nil {
+		return err
+	}
+	if failure == nil {
+		// Failed before a restart; only the message survives.
+		failure = fmt.Errorf("flow %s: step %s: %s", f.Name, r.FailedStep, r.Error)
+	}
+	return failure
+}
+
+// runStep runs one step of r.
+func (e *Engine) runStep(ctx context.Context, step Step, r *Run) error {
+	timeout := timeoutOf(step)
+	if step.Do != nil {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return step.Do(ctx, r)
+	}
+
+	if r.Pending == "" {
+		var payload map[string]interface{}
+		if step.Payload != nil {
+			var err error
+			if payload, err = step.Payload(r); err != nil {
+				return err
+			}
+		}
+		res, err := e.Client.Send(ctx, step.To, step.Type, payload, "", ping.WithThread(r.ID))
+		if err != nil {
+			return err
+		}
+		if res.ID == "" {
+			return fmt.Errorf("request not sent: %w", ping.ErrOffline)
+		}
+		// Saved before waiting, so a resumed run waits for this reply
+		// instead of sending the request again.
+		r.Pending, r.PendingAt = res.ID, time.Now()
+		if err := e.save(ctx, r); err != nil {
+			return err
+		}
+	}
+
+	// Check at least once for a reply that arrived while the run was
+	// stopped, even if the timeout has passed since.
+	wait := timeout - time.Since(r.PendingAt)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	reply, err := e.Client.WaitReply(ctx, r.Pending, step.To, wait)
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+	r.Pending, r.PendingAt = "", time.Time{}
+	if err != nil {
+		return err
+	}
+	if step.Save != "" {
+		if r.Vars == nil {
+			r.Vars = make(map[string]json.RawMessage)
+		}
+		r.Vars[step.Save] = reply.Payload
+	}
+	return nil
+}
+
+func (e *Engine) save(ctx context.Context, r *Run) error {
+	r.UpdatedAt = time.Now()
+	return e.store().Save(context.WithoutCancel(ctx), r)
+}
+
+func timeoutOf(step Step) time.Duration {
+	if step.Timeout > 0 {
+		return step.Timeout
+	}
+	return DefaultTimeout
+}
@@ -0,0 +1,159 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	ping "github.com/aetos53t/ping/sdk/go"
+)
+
+// Store saves the state of runs. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Save inserts or replaces a run by ID.
+	Save(ctx context.Context, r *Run) error
+	// Load returns the run with the given ID, or ping.ErrNotFound.
+	Load(ctx context.Context, id string) (*Run, error)
+	// List returns all saved runs, oldest first.
+	List(ctx context.Context) ([]*Run, error)
+	// Delete removes a run. Unknown IDs are ignored.
+	Delete(ctx context.Context, id string) error
+}
+
+// MemoryStore is a Store held in memory.
+type MemoryStore struct {
+	mu   sync.Mutex
+	runs map[string]json.RawMessage
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{runs: make(map[string]json.RawMessage)}
+}
+
+// Save implements Store.
+func (s *MemoryStore) Save(_ context.Context, r *Run) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.runs[r.ID] = data
+	s.mu.Unlock()
+	return nil
+}
+
+// Load implements Store.
+func (s *MemoryStore) Load(_ context.Context, id string) (*Run, error) {
+	s.mu.Lock()
+	data, ok := s.runs[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: flow run %s", ping.ErrNotFound, id)
+	}
+	var r Run
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// List implements Store.
+func (s *MemoryStore) List(_ context.Context) ([]*Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs := make([]*Run, 0, len(s.runs))
+	for _, data := range s.runs {
+		var r Run
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, err
+		}
+		runs = append(runs, &r)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartedAt.Before(runs[j].StartedAt)
+	})
+	return runs, nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	delete(s.runs, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// FileStore is a Store kept in a JSON file, rewritten on every change.
+type FileStore struct {
+	path string
+	mem  MemoryStore
+}
+
+// OpenFileStore opens the run store at path, creating it on first save.
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, mem: MemoryStore{runs: make(map[string]json.RawMessage)}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.mem.runs); err != nil {
+		return nil, fmt.Errorf("flow store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Save implements Store.
+func (s *FileStore) Save(ctx context.Context, r *Run) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.mem.mu.Lock()
+	defer s.mem.mu.Unlock()
+	s.mem.runs[r.ID] = data
+	return s.writeLocked()
+}
+
+// Load implements Store.
+func (s *FileStore) Load(ctx context.Context, id string) (*Run, error) {
+	return s.mem.Load(ctx, id)
+}
+
+// List implements Store.
+func (s *FileStore) List(ctx context.Context) ([]*Run, error) {
+	return s.mem.List(ctx)
+}
+
+// Delete implements Store.
+func (s *FileStore) Delete(_ context.Context, id string) error {
+	s.mem.mu.Lock()
+	defer s.mem.mu.Unlock()
+	if _, ok := s.mem.runs[id]; !ok {
+		return nil
+	}
+	delete(s.mem.runs, id)
+	return s.writeLocked()
+}
+
+// writeLocked replaces the file with the current runs. Callers hold
+// s.mem.mu.
+func (s *FileStore) writeLocked() error {
+	data, err := json.Marshal(s.mem.runs)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,321 @@
+package ping
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Flow control message types. A producer streams a long response as
+// MessageTypeFlowData messages, and the consumer answers with
+// MessageTypeFlowAck messages that acknowledge them and advertise how many
+// more it can take.
+const (
+	MessageTypeFlowData MessageType = "flow.data"
+	MessageTypeFlowAck  MessageType = "flow.ack"
+)
+
+// flowField holds the FlowFrame in flow data and ack payloads.
+const flowField = "$flow"
+
+// Flow control defaults.
+const (
+	defaultFlowWindow       = 8
+	defaultFlowPollInterval = 250 * time.Millisecond
+	defaultFlowAckTimeout   = 2 * time.Minute
+)
+
+// ErrFlowStalled is returned by FlowWriter when the consumer stops
+// acknowledging data.
+var ErrFlowStalled = errors.New("flow stalled: consumer stopped acknowledging")
+
+// FlowFrame is the flow control header of a flow message.
+type FlowFrame struct {
+	// ID identifies the flow.
+	ID string `json:"id"`
+	// Seq numbers data messages from 1. In an ack it is the highest
+	// sequence number the consumer has processed.
+	Seq int `json:"seq"`
+	// Final marks the last data message.
+	Final bool `json:"final,omitempty"`
+	// Window, in an ack, is how many more unacknowledged data messages the
+	// consumer accepts.
+	Window int `json:"window,omitempty"`
+}
+
+// MessageFlowFrame returns the flow header of a flow data or ack message.
+func MessageFlowFrame(msg Message) (*FlowFrame, bool) {
+	if msg.Type != MessageTypeFlowData && msg.Type != MessageTypeFlowAck {
+		return nil, false
+	}
+	var env struct {
+		Flow *FlowFrame `json:"$flow"`
+	}
+	if msg.DecodePayload(&env) != nil || env.Flow == nil {
+		return nil, false
+	}
+	return env.Flow, true
+}
+
+// FlowOptions configures a FlowWriter.
+type FlowOptions struct {
+	// ReplyTo, if set, is the message the flow answers.
+	ReplyTo string
+	// Window is the number of data messages sent before the first ack.
+	// Defaults to 8.
+	Window int
+	// PollInterval is how often a blocked writer checks the inbox for
+	// acks. Defaults to 250ms.
+	PollInterval time.Duration
+	// AckTimeout is how long a blocked writer waits for an ack before
+	// failing with ErrFlowStalled. Defaults to 2m.
+	AckTimeout time.Duration
+}
+
+// FlowWriter streams a long response to one consumer, pacing itself by
+// the consumer's acks so it never has more unacknowledged messages in
+// flight than the consumer's advertised window.
+type FlowWriter struct {
+	c       *Client
+	to      string
+	id      string
+	opts    FlowOptions
+	reqOpts []RequestOption
+
+	mu      sync.Mutex
+	seq     int
+	acked   int
+	window  int
+	ackIDs  []string      // ack messages to acknowledge on the server
+	updated chan struct{} // closed and replaced on each ack
+	closed  bool
+}
+
+// OpenFlow starts a flow-controlled response to the agent to. Acks for the
+// flow are consumed by the client, in Inbox, InboxIter and Stream, and
+// never reach handlers. reqOpts apply to every request the writer makes.
+func (c *Client) OpenFlow(ctx context.Context, to string, opts *FlowOptions, reqOpts ...RequestOption) (*FlowWriter, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	w := &FlowWriter{c: c, to: to, id: hex.EncodeToString(id[:]), reqOpts: reqOpts, updated: make(chan struct{})}
+	if opts != nil {
+		w.opts = *opts
+	}
+	w.window = w.opts.Window
+	if w.window <= 0 {
+		w.window = defaultFlowWindow
+	}
+	c.flows.add(w)
+	return w, nil
+}
+
+// ID returns the flow's ID.
+func (w *FlowWriter) ID() string {
+	return w.id
+}
+
+// Write sends payload as the next data message, first waiting until the
+// consumer's window has room.
+func (w *FlowWriter) Write(ctx context.Context, payload map[string]interface{}) error {
+	return w.send(ctx, payload, false)
+}
+
+// Close sends a final, empty data message and waits until the consumer has
+// acknowledged everything.
+func (w *FlowWriter) Close(ctx context.Context) error {
+	defer w.c.flows.remove(w.id)
+	if err := w.send(ctx, nil, true); err != nil {
+		return err
+	}
+	return w.wait(ctx, func() bool { return w.acked >= w.seq })
+}
+
+func (w *FlowWriter) send(ctx context.Context, payload map[string]interface{}, final bool) error {
+	if err := w.wait(ctx, func() bool { return w.seq-w.acked < w.window }); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return errors.New("flow closed")
+	}
+	w.seq++
+	frame := FlowFrame{ID: w.id, Seq: w.seq, Final: final}
+	w.closed = final
+	w.mu.Unlock()
+
+	body := make(map[string]interface{}, len(payload)+1)
+	for k, v := range payload {
+		body[k] = v
+	}
+	body[flowField] = frame
+	_, err := w.c.Send(ctx, w.to, MessageTypeFlowData, body, w.opts.ReplyTo, w.reqOpts...)
+	return err
+}
+
+// wait blocks until ready reports true, polling the inbox for acks.
+func (w *FlowWriter) wait(ctx context.Context, ready func() bool) error {
+	poll := durationOr(w.opts.PollInterval, defaultFlowPollInterval)
+	deadline := time.Now().Add(durationOr(w.opts.AckTimeout, defaultFlowAckTimeout))
+	for {
+		w.mu.Lock()
+		ok, updated, ackIDs := ready(), w.updated, w.ackIDs
+		w.ackIDs = nil
+		w.mu.Unlock()
+		for _, id := range ackIDs {
+			w.c.Ack(ctx, id, w.reqOpts...)
+		}
+		if ok {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrFlowStalled
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-updated:
+			deadline = time.Now().Add(durationOr(w.opts.AckTimeout, defaultFlowAckTimeout))
+		case <-time.After(poll):
+			if _, err := w.c.Inbox(ctx, w.reqOpts...); err != nil && ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// onAck applies an ack from the consumer.
+func (w *FlowWriter) onAck(msgID string, f *FlowFrame) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if f.Seq > w.acked {
+		w.acked = f.Seq
+	}
+	w.window = f.Window
+	w.ackIDs = append(w.ackIDs, msgID)
+	close(w.updated)
+	w.updated = make(chan struct{})
+}
+
+// flowTable tracks the client's open flows. The zero value is ready to
+// use.
+type flowTable struct {
+	mu      sync.Mutex
+	writers map[string]*FlowWriter
+	// closed remembers recently closed flows, so acks that arrive after
+	// Close are still consumed.
+	closed map[string]time.Time
+}
+
+func (t *flowTable) add(w *FlowWriter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.writers == nil {
+		t.writers = make(map[string]*FlowWriter)
+	}
+	t.writers[w.id] = w
+}
+
+func (t *flowTable) remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.writers, id)
+	now := time.Now()
+	for old, at := range t.closed {
+		if now.Sub(at) > defaultFlowAckTimeout {
+			delete(t.closed, old)
+		}
+	}
+	if t.closed == nil {
+		t.closed = make(map[string]time.Time)
+	}
+	t.closed[id] = now
+}
+
+// lookup returns the open writer for id, or reports whether id belongs to
+// a recently closed flow.
+func (t *flowTable) lookup(id string) (w *FlowWriter, closed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, closed = t.closed[id]
+	return t.writers[id], closed
+}
+
+// consumeFlowAcks applies acks for the client's open flows and removes
+// them from msgs. Late acks for closed flows are acknowledged and dropped.
+func (c *Client) consumeFlowAcks(ctx context.Context, msgs []Message) []Message {
+	out := msgs[:0:0]
+	for _, m := range msgs {
+		if m.Type == MessageTypeFlowAck {
+			if f, ok := MessageFlowFrame(m); ok {
+				w, closed := c.flows.lookup(f.ID)
+				if w != nil && m.From == w.to {
+					w.onAck(m.ID, f)
+					continue
+				}
+				if closed {
+					c.Ack(ctx, m.ID)
+					continue
+				}
+			}
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// FlowConsumer acknowledges flow data messages after its handler has
+// processed them, advertising how many more it can take.
+type FlowConsumer struct {
+	Client *Client
+	// Window returns how many more data messages the consumer accepts,
+	// e.g. the free space in its work queue. While it returns zero the ack
+	// is held back, which pauses the producer. Defaults to a constant 8.
+	Window func() int
+	// OnError, if set, receives errors sending acks.
+	OnError func(error)
+}
+
+// Middleware acks each flow data message once next has handled it.
+func (fc *FlowConsumer) Middleware(next Handler) Handler {
+	return func(ctx context.Context, msg Message) error {
+		if err := next(ctx, msg); err != nil {
+			return err
+		}
+		f, ok := MessageFlowFrame(msg)
+		if !ok || msg.Type != MessageTypeFlowData {
+			return nil
+		}
+		window, err := fc.window(ctx)
+		if err != nil {
+			return err
+		}
+		ack := map[string]interface{}{flowField: FlowFrame{ID: f.ID, Seq: f.Seq, Window: window}}
+		if _, err := fc.Client.Send(ctx, msg.From, MessageTypeFlowAck, ack, msg.ID); err != nil {
+			reportError(fc.OnError, err)
+		}
+		return nil
+	}
+}
+
+// window waits until the consumer has room and returns its window.
+func (fc *FlowConsumer) window(ctx context.Context) (int, error) {
+	if fc.Window == nil {
+		return defaultFlowWindow, nil
+	}
+	for {
+		if n := fc.Window(); n > 0 {
+			return n, nil
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(defaultFlowPollInterval):
+		}
+	}
+}
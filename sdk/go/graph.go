@@ -0,0 +1,443 @@
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// GraphNodeKind distinguishes agents from capabilities in a Graph.
+type GraphNodeKind string
+
+// Graph node kinds.
+const (
+	NodeAgent      GraphNodeKind = "agent"
+	NodeCapability GraphNodeKind = "capability"
+)
+
+// GraphEdgeKind says how two nodes of a Graph are related.
+type GraphEdgeKind string
+
+// Graph edge kinds.
+const (
+	// EdgeContact points from an agent to one of its contacts.
+	EdgeContact GraphEdgeKind = "contact"
+	// EdgeCorrespondent points from a sender to a recipient it messaged.
+	EdgeCorrespondent GraphEdgeKind = "correspondent"
+	// EdgeCapability points from an agent to a capability it offers.
+	EdgeCapability GraphEdgeKind = "capability"
+)
+
+// GraphNode is an agent or capability in a Graph.
+type GraphNode struct {
+	ID       string        `json:"id"`
+	Kind     GraphNodeKind `json:"kind"`
+	Name     string        `json:"name,omitempty"`
+	Provider string        `json:"provider,omitempty"`
+}
+
+// GraphEdge is a directed relation between two nodes. Weight counts the
+// messages behind a correspondent edge and is 1 otherwise.
+type GraphEdge struct {
+	From   string        `json:"from"`
+	To     string        `json:"to"`
+	Kind   GraphEdgeKind `json:"kind"`
+	Weight int           `json:"weight"`
+}
+
+type graphEdgeKey struct {
+	from, to string
+	kind     GraphEdgeKind
+}
+
+// Graph is a directed graph of agents, their contacts, correspondents and
+// capabilities. Graphs built by different agents can be merged into a
+// view of a whole deployment. The zero value is an empty graph.
+type Graph struct {
+	nodes map[string]GraphNode
+	edges map[graphEdgeKey]int
+}
+
+// capabilityNodeID is the node ID of a capability, kept apart from agent
+// IDs.
+func capabilityNodeID(capability string) string {
+	return "capability:" + capability
+}
+
+// NetworkGraph builds the graph of agents known to the client: its
+// contacts, the agents it has exchanged messages with, and the
+// capabilities of each. Correspondents come from the client's store, so a
+// client without one gets only its contacts. Agents that cannot be looked up are kept
+// as bare nodes.
+func (c *Client) NetworkGraph(ctx context.Context, reqOpts ...RequestOption) (*Graph, error) {
+	self := c.AgentID()
+	if self == "" {
+		return nil, fmt.Errorf("not registered")
+	}
+	g := &Graph{}
+
+	contacts, err := c.Contacts(ctx, reqOpts...)
+	if err != nil {
+		return nil, err
+	}
+	for _, ct := range contacts {
+		g.AddEdge(GraphEdge{From: self, To: ct.ContactID, Kind: EdgeContact})
+	}
+
+	var msgs []Message
+	if c.store != nil {
+		msgs, err = c.store.List(ctx, StoreFilter{})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range msgs {
+		if m.From != "" && m.To != "" {
+			g.AddEdge(GraphEdge{From: m.From, To: m.To, Kind: EdgeCorrespondent})
+		}
+	}
+
+	for _, id := range g.agentIDs() {
+		agent, err := c.GetAgent(ctx, id, reqOpts...)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		g.AddAgent(agent)
+	}
+	return g, nil
+}
+
+// AddNode adds n, replacing a node with the same ID.
+func (g *Graph) AddNode(n GraphNode) {
+	if g.nodes == nil {
+		g.nodes = make(map[string]GraphNode)
+	}
+	g.nodes[n.ID] = n
+}
+
+// AddAgent adds an agent node and edges to its capabilities.
+func (g *Graph) AddAgent(a *Agent) {
+	g.AddNode(GraphNode{ID: a.ID, Kind: NodeAgent, Name: a.Name, Provider: a.Provider})
+	for _, capability := range a.Capabilities {
+		g.AddEdge(GraphEdge{From: a.ID, To: capabilityNodeID(capability), Kind: EdgeCapability})
+	}
+}
+
+// AddEdge adds e. The weight (at least 1) of a correspondent edge adds to
+// an existing one; other kinds keep the larger weight. Missing end nodes
+// are created.
+func (g *Graph) AddEdge(e GraphEdge) {
+	if g.edges == nil {
+		g.edges = make(map[graphEdgeKey]int)
+	}
+	for _, id := range []string{e.From, e.To} {
+		if _, ok := g.nodes[id]; !ok {
+			kind := NodeAgent
+			name := ""
+			if strings.HasPrefix(id, "capability:") {
+				kind = NodeCapability
+				name = strings.TrimPrefix(id, "capability:")
+			}
+			g.AddNode(GraphNode{ID: id, Kind: kind, Name: name})
+		}
+	}
+	w := e.Weight
+	if w < 1 {
+		w = 1
+	}
+	k := graphEdgeKey{e.From, e.To, e.Kind}
+	if e.Kind == EdgeCorrespondent {
+		g.edges[k] += w
+	} else if w > g.edges[k] {
+		g.edges[k] = w
+	}
+}
+
+// Merge adds the nodes and edges of other to g. Graphs built by different
+// agents see the same edges, so an edge present in both keeps the larger
+// weight. Named nodes win over bare ones.
+func (g *Graph) Merge(other *Graph) {
+	for _, n := range other.Nodes() {
+		if cur, ok := g.nodes[n.ID]; ok && n.Name == "" && cur.Name != "" {
+			continue
+		}
+		g.AddNode(n)
+	}
+	for _, e := range other.Edges() {
+		k := graphEdgeKey{e.From, e.To, e.Kind}
+		if e.Weight > g.edges[k] {
+			g.AddEdge(GraphEdge{From: e.From, To: e.To, Kind: e.Kind})
+			g.edges[k] = e.Weight
+		}
+	}
+}
+
+// Node returns the node with the given ID.
+func (g *Graph) Node(id string) (GraphNode, bool) {
+	n, ok := g.nodes[id]
+	return n, ok
+}
+
+// Nodes returns the graph's nodes sorted by ID.
+func (g *Graph) Nodes() []GraphNode {
+	nodes := make([]GraphNode, 0, len(g.nodes))
+	for _, n := range g.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+// Edges returns the graph's edges sorted by source, target and kind.
+func (g *Graph) Edges() []GraphEdge {
+	edges := make([]GraphEdge, 0, len(g.edges))
+	for k, w := range g.edges {
+		edges = append(edges, GraphEdge{From: k.from, To: k.to, Kind: k.kind, Weight: w})
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		a, b := edges[i], edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Kind < b.Kind
+	})
+	return edges
+}
+
+func (g *Graph) agentIDs() []string {
+	var ids []string
+	for _, n := range g.Nodes() {
+		if n.Kind == NodeAgent {
+			ids = append(ids, n.ID)
+		}
+	}
+	return ids
+}
+
+// successors returns the targets of edges from id whose kind is in kinds,
+// sorted for deterministic traversal.
+func (g *Graph) successors(id string, kinds ...GraphEdgeKind) []string {
+	var out []string
+	for k := range g.edges {
+		if k.from != id {
+			continue
+		}
+		for _, kind := range kinds {
+			if k.kind == kind {
+				out = append(out, k.to)
+				break
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// bfs walks edges of the given kinds from start and returns each reached
+// node's predecessor.
+func (g *Graph) bfs(start string, kinds ...GraphEdgeKind) map[string]string {
+	prev := map[string]string{start: ""}
+	queue := []string{start}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, next := range g.successors(id, kinds...) {
+			if _, seen := prev[next]; !seen {
+				prev[next] = id
+				queue = append(queue, next)
+			}
+		}
+	}
+	return prev
+}
+
+// TrustPath returns the shortest chain of contact edges from one agent to
+// another, both included, or nil if to is not reachable through contacts.
+func (g *Graph) TrustPath(from, to string) []string {
+	if _, ok := g.nodes[from]; !ok {
+		return nil
+	}
+	prev := g.bfs(from, EdgeContact)
+	if _, ok := prev[to]; !ok {
+		return nil
+	}
+	var path []string
+	for id := to; id != ""; id = prev[id] {
+		path = append([]string{id}, path...)
+		if id == from {
+			break
+		}
+	}
+	return path
+}
+
+// Reachable returns the agents reachable from id through contact and
+// correspondent edges, sorted and excluding id itself.
+func (g *Graph) Reachable(id string) []string {
+	if _, ok := g.nodes[id]; !ok {
+		return nil
+	}
+	var out []string
+	for n := range g.bfs(id, EdgeContact, EdgeCorrespondent) {
+		if n != id {
+			out = append(out, n)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// WithCapability returns the agents offering capability, sorted.
+func (g *Graph) WithCapability(capability string) []string {
+	target := capabilityNodeID(capability)
+	var out []string
+	for k := range g.edges {
+		if k.kind == EdgeCapability && k.to == target {
+			out = append(out, k.from)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// MarshalJSON encodes the graph as {"nodes": [...], "edges": [...]}.
+func (g *Graph) MarshalJSON() ([]byte, error) {
+	return json.Marshal(graphJSON{Nodes: g.Nodes(), Edges: g.Edges()})
+}
+
+// UnmarshalJSON decodes a graph written by MarshalJSON.
+func (g *Graph) UnmarshalJSON(data []byte) error {
+	var v graphJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*g = Graph{}
+	for _, n := range v.Nodes {
+		g.AddNode(n)
+	}
+	for _, e := range v.Edges {
+		g.AddEdge(e)
+	}
+	return nil
+}
+
+type graphJSON struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// WriteDOT writes the graph in Graphviz DOT format.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph ping {\n")
+	for _, n := range g.Nodes() {
+		label := n.Name
+		if label == "" {
+			label = n.ID
+		}
+		shape := "ellipse"
+		if n.Kind == NodeCapability {
+			shape = "box"
+		}
+		fmt.Fprintf(&b, "  %q [label=%q, shape=%s];\n", n.ID, label, shape)
+	}
+	for _, e := range g.Edges() {
+		style := "solid"
+		switch e.Kind {
+		case EdgeCorrespondent:
+			style = "dashed"
+		case EdgeCapability:
+			style = "dotted"
+		}
+		fmt.Fprintf(&b, "  %q -> %q [label=%q, style=%s, weight=%d];\n", e.From, e.To, string(e.Kind), style, e.Weight)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteGraphML writes the graph in GraphML format.
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	type data struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	}
+	type node struct {
+		ID   string `xml:"id,attr"`
+		Data []data `xml:"data"`
+	}
+	type edge struct {
+		Source string `xml:"source,attr"`
+		Target string `xml:"target,attr"`
+		Data   []data `xml:"data"`
+	}
+	type key struct {
+		ID   string `xml:"id,attr"`
+		For  string `xml:"for,attr"`
+		Name string `xml:"attr.name,attr"`
+		Type string `xml:"attr.type,attr"`
+	}
+	type graph struct {
+		ID          string `xml:"id,attr"`
+		EdgeDefault string `xml:"edgedefault,attr"`
+		Nodes       []node `xml:"node"`
+		Edges       []edge `xml:"edge"`
+	}
+	doc := struct {
+		XMLName xml.Name `xml:"graphml"`
+		XMLNS   string   `xml:"xmlns,attr"`
+		Keys    []key    `xml:"key"`
+		Graph   graph    `xml:"graph"`
+	}{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []key{
+			{ID: "kind", For: "node", Name: "kind", Type: "string"},
+			{ID: "name", For: "node", Name: "name", Type: "string"},
+			{ID: "provider", For: "node", Name: "provider", Type: "string"},
+			{ID: "relation", For: "edge", Name: "kind", Type: "string"},
+			{ID: "weight", For: "edge", Name: "weight", Type: "int"},
+		},
+		Graph: graph{ID: "ping", EdgeDefault: "directed"},
+	}
+	for _, n := range g.Nodes() {
+		d := []data{{Key: "kind", Value: string(n.Kind)}}
+		if n.Name != "" {
+			d = append(d, data{Key: "name", Value: n.Name})
+		}
+		if n.Provider != "" {
+			d = append(d, data{Key: "provider", Value: n.Provider})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, node{ID: n.ID, Data: d})
+	}
+	for _, e := range g.Edges() {
+		doc.Graph.Edges = append(doc.Graph.Edges, edge{
+			Source: e.From,
+			Target: e.To,
+			Data: []data{
+				{Key: "relation", Value: string(e.Kind)},
+				{Key: "weight", Value: fmt.Sprint(e.Weight)},
+			},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
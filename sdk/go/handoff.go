@@ -0,0 +1,188 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aetos53t/ping/sdk/go/wire"
+)
+
+// MessageTypeHandoff carries a HandoffRecord in its "handoff" payload field.
+const MessageTypeHandoff MessageType = "handoff"
+
+// HandoffRecord transfers a conversation with Peer from one agent to
+// another. It is signed by the agent handing off.
+type HandoffRecord struct {
+	ID   string `json:"id"`
+	From string `json:"from"` // agent handing off
+	To   string `json:"to"`   // agent taking over
+	Peer string `json:"peer"` // the other side of the conversation
+	// Summary describes the conversation so far for the new agent.
+	Summary string `json:"summary,omitempty"`
+	// State is opaque thread state for the new agent.
+	State map[string]interface{} `json:"state,omitempty"`
+	// Pending lists IDs of the peer's messages still awaiting a reply.
+	Pending   []string `json:"pending,omitempty"`
+	CreatedAt int64    `json:"createdAt"` // Unix milliseconds
+	Signature string   `json:"signature,omitempty"`
+}
+
+// HandoffOptions describes the conversation being handed off.
+type HandoffOptions struct {
+	Summary string
+	State   map[string]interface{}
+	Pending []string
+}
+
+// Handoff transfers the conversation with peer to agent to. The signed
+// record is sent to the new agent and then to the peer, whose SDK routes
+// its later messages for this agent to the new one.
+func (c *Client) Handoff(ctx context.Context, peer, to string, opts *HandoffOptions, reqOpts ...RequestOption) (*HandoffRecord, error) {
+	id := c.identity()
+	if id.agentID == "" {
+		return nil, fmt.Errorf("not registered")
+	}
+	if id.privateKey == nil {
+		return nil, fmt.Errorf("no keys")
+	}
+	if opts == nil {
+		opts = &HandoffOptions{}
+	}
+
+	var rid [16]byte
+	if _, err := rand.Read(rid[:]); err != nil {
+		return nil, err
+	}
+	rec := &HandoffRecord{
+		ID:        hex.EncodeToString(rid[:]),
+		From:      id.agentID,
+		To:        to,
+		Peer:      peer,
+		Summary:   opts.Summary,
+		State:     opts.State,
+		Pending:   opts.Pending,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	b, err := rec.signingBytes()
+	if err != nil {
+		return nil, err
+	}
+	rec.Signature = hex.EncodeToString(ed25519.Sign(id.privateKey, b))
+
+	payload, err := handoffPayload(rec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.Send(ctx, to, MessageTypeHandoff, payload, "", reqOpts...); err != nil {
+		return nil, fmt.Errorf("handoff to %s: %w", to, err)
+	}
+	if _, err := c.Send(ctx, peer, MessageTypeHandoff, payload, "", reqOpts...); err != nil {
+		return nil, fmt.Errorf("notify %s: %w", peer, err)
+	}
+	return rec, nil
+}
+
+// VerifyHandoff extracts the handoff record from msg and checks that it was
+// signed by the agent that sent it. reqOpts apply to the sender's key
+// lookup.
+func (c *Client) VerifyHandoff(ctx context.Context, msg Message, reqOpts ...RequestOption) (*HandoffRecord, error) {
+	if msg.Type != MessageTypeHandoff {
+		return nil, fmt.Errorf("not a handoff message")
+	}
+	var p struct {
+		Handoff HandoffRecord `json:"handoff"`
+	}
+	if err := msg.DecodePayload(&p); err != nil {
+		return nil, fmt.Errorf("invalid handoff record: %w", err)
+	}
+	rec := p.Handoff
+	if rec.From != msg.From {
+		return nil, fmt.Errorf("handoff record from %s sent by %s", rec.From, msg.From)
+	}
+	key, err := c.peerKey(ctx, rec.From, reqOpts...)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := hex.DecodeString(rec.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid handoff signature")
+	}
+	b, err := rec.signingBytes()
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(key, b, sig) {
+		return nil, fmt.Errorf("invalid handoff signature")
+	}
+	return &rec, nil
+}
+
+// SetPeerRoute sends messages addressed to peerID to agent to instead. An
+// empty to clears the route.
+func (c *Client) SetPeerRoute(peerID, to string) {
+	c.peers.update(peerID, func(p *PeerPrefs) { p.RouteTo = to })
+}
+
+// route follows handoff routes from to, stopping at a loop.
+func (c *Client) route(to string) string {
+	seen := map[string]bool{to: true}
+	for {
+		next := c.peers.get(to).RouteTo
+		if next == "" || seen[next] {
+			return to
+		}
+		seen[next] = true
+		to = next
+	}
+}
+
+// applyHandoffs updates routing for verified handoffs addressed to this
+// agent as the peer. Invalid handoff messages are ignored.
+func (c *Client) applyHandoffs(ctx context.Context, msgs []Message) {
+	self := c.AgentID()
+	for _, msg := range msgs {
+		if msg.Type != MessageTypeHandoff {
+			continue
+		}
+		rec, err := c.VerifyHandoff(ctx, msg)
+		if err != nil || rec.Peer != self {
+			continue
+		}
+		c.SetPeerRoute(rec.From, rec.To)
+	}
+}
+
+// signingBytes returns the record without its signature in canonical
+// JSON, so a record that has been through a payload round trip, with State
+// decoded into maps and its numbers into float64s, verifies as signed.
+func (rec *HandoffRecord) signingBytes() ([]byte, error) {
+	unsigned := *rec
+	unsigned.Signature = ""
+	raw, err := json.Marshal(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := wire.Canonicalize(&buf, raw); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func handoffPayload(rec *HandoffRecord) (map[string]interface{}, error) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"handoff": v}, nil
+}
@@ -0,0 +1,84 @@
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aetos53t/ping/sdk/go/pingtest"
+)
+
+// handoffState has its fields out of alphabetical order, so a round trip
+// through a map reorders its keys.
+type handoffState struct {
+	Step  int     `json:"step"`
+	Notes string  `json:"notes"`
+	Score float64 `json:"score"`
+}
+
+// TestHandoffRoundTrip signs a handoff, marshals and unmarshals the
+// record, and verifies it, directly and after delivery through pingtest.
+func TestHandoffRoundTrip(t *testing.T) {
+	srv := pingtest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	alice := registerTestClient(t, srv, "alice")
+	bob := registerTestClient(t, srv, "bob")
+	peer := registerTestClient(t, srv, "peer")
+
+	rec, err := alice.Handoff(ctx, peer.AgentID(), bob.AgentID(), &HandoffOptions{
+		Summary: "refund <pending> & approved",
+		State: map[string]interface{}{
+			"ticket": handoffState{Step: 2, Notes: "a", Score: 1.0},
+			"big":    int64(1) << 53,
+			"tags":   []string{"b", "a"},
+		},
+		Pending: []string{"m1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded HandoffRecord
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	payload, err := handoffPayload(&decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := Message{Type: MessageTypeHandoff, From: alice.AgentID(), To: bob.AgentID()}
+	if msg.Payload, err = json.Marshal(payload); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.VerifyHandoff(ctx, msg); err != nil {
+		t.Errorf("VerifyHandoff after a JSON round trip: %v", err)
+	}
+
+	inbox, err := bob.Inbox(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox) != 1 {
+		t.Fatalf("bob's inbox has %d messages, want the handoff", len(inbox))
+	}
+	got, err := bob.VerifyHandoff(ctx, inbox[0])
+	if err != nil {
+		t.Fatalf("VerifyHandoff after delivery: %v", err)
+	}
+	if got.ID != rec.ID || got.Peer != peer.AgentID() {
+		t.Errorf("VerifyHandoff = %+v, want record %s for peer %s", got, rec.ID, peer.AgentID())
+	}
+
+	got.Summary = "refund denied"
+	if msg.Payload, err = json.Marshal(map[string]interface{}{"handoff": got}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.VerifyHandoff(ctx, msg); err == nil {
+		t.Error("VerifyHandoff accepted a tampered record")
+	}
+}
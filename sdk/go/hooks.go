@@ -0,0 +1,50 @@
+package ping
+
+// OutgoingMessage is a message about to be sent, as seen by WithOnSend
+// hooks. Hooks may change any field. Payload is the caller's map, so a hook
+// that redacts fields should replace it with a copy rather than edit it.
+type OutgoingMessage struct {
+	To      string
+	Type    MessageType
+	Payload map[string]interface{}
+	ReplyTo string
+}
+
+// WithOnSend adds a hook that Send calls for every message before it is
+// validated, encoded and signed, e.g. to redact payload fields or tag
+// messages for metrics. Messages the SDK sends internally, such as flow
+// acks and digests, pass through it too. Hooks run in the order added.
+func WithOnSend(hook func(*OutgoingMessage)) Option {
+	return func(c *Client) {
+		c.onSend = append(c.onSend, hook)
+	}
+}
+
+// WithOnReceive adds a hook called for every received message, in Inbox,
+// InboxIter, Stream and Manager.Dispatch, once chunks are reassembled and
+// payloads decoded, and before the message is stored or handled. Hooks run
+// in the order added and may modify the message.
+func WithOnReceive(hook func(*Message)) Option {
+	return func(c *Client) {
+		c.onReceive = append(c.onReceive, hook)
+	}
+}
+
+// runSendHooks applies the WithOnSend hooks to a message.
+func (c *Client) runSendHooks(out *OutgoingMessage) {
+	for _, hook := range c.onSend {
+		hook(out)
+	}
+}
+
+// runReceiveHooks applies the WithOnReceive hooks to msgs in place.
+func (c *Client) runReceiveHooks(msgs []Message) {
+	if len(c.onReceive) == 0 {
+		return
+	}
+	for i := range msgs {
+		for _, hook := range c.onReceive {
+			hook(&msgs[i])
+		}
+	}
+}
@@ -0,0 +1,425 @@
+package ping
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Errors returned for passphrase-protected identities.
+var (
+	// ErrIdentityLocked is returned when an identity's private key is
+	// encrypted and it has not been decrypted with Decrypt.
+	ErrIdentityLocked = errors.New("identity is encrypted")
+	// ErrWrongPassphrase is returned by Decrypt when the passphrase does
+	// not decrypt the private key.
+	ErrWrongPassphrase = errors.New("wrong identity passphrase")
+)
+
+// Parameters of encrypted identity private keys.
+const (
+	identityKDF        = "pbkdf2-sha256"
+	identityCipher     = "aes-256-gcm"
+	identityIterations = 600000
+	// maxIdentityIterations bounds the work a file can ask Decrypt for.
+	maxIdentityIterations = 10000000
+)
+
+// PortableIdentityVersion is the identity file format version written by
+// this SDK. See docs/IDENTITY.md for the format shared with the JS and
+// Python SDKs.
+const PortableIdentityVersion = 1
+
+// PortableIdentity is an agent identity and its SDK state in the format
+// shared by all PING SDKs. Private keys are stored as the 32-byte Ed25519
+// seed in hex, which is what the JS and Python SDKs use.
+type PortableIdentity struct {
+	Version    int    `json:"version"`
+	AgentID    string `json:"agentId"`
+	PrivateKey string `json:"privateKey,omitempty"`
+	// EncryptedPrivateKey holds the private key encrypted with a
+	// passphrase, in place of PrivateKey. See Encrypt.
+	EncryptedPrivateKey *EncryptedKey `json:"encryptedPrivateKey,omitempty"`
+	PublicKey           string        `json:"publicKey"`
+	Name                string        `json:"name,omitempty"`
+	BaseURL             string        `json:"baseUrl,omitempty"`
+	CreatedAt           string        `json:"createdAt,omitempty"`
+	// State holds SDK-specific state by namespace. Entries written by other
+	// SDKs are preserved when the identity is re-saved.
+	State map[string]json.RawMessage `json:"state,omitempty"`
+}
+
+// EncryptedKey is a private key seed encrypted with AES-256-GCM under a
+// key derived from a passphrase with PBKDF2-HMAC-SHA256. The agent ID is
+// the additional authenticated data, so the key cannot be moved to another
+// identity. Binary fields are lowercase hex.
+type EncryptedKey struct {
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"`
+	Cipher     string `json:"cipher"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// Locked reports whether the identity's private key is encrypted.
+func (id *PortableIdentity) Locked() bool {
+	return id.PrivateKey == "" && id.EncryptedPrivateKey != nil
+}
+
+// Validate checks the identity's version and that its keys agree. For a
+// locked identity it checks only that the public key is present.
+func (id *PortableIdentity) Validate() error {
+	if id.Version < 1 || id.Version > PortableIdentityVersion {
+		return fmt.Errorf("unsupported identity version: %d", id.Version)
+	}
+	if id.Locked() {
+		if _, err := hex.DecodeString(id.PublicKey); err != nil || len(id.PublicKey) != 2*ed25519.PublicKeySize {
+			return fmt.Errorf("encrypted identity needs a valid public key")
+		}
+		return nil
+	}
+	priv, err := parsePrivateKey(id.PrivateKey)
+	if err != nil {
+		return err
+	}
+	pub := hex.EncodeToString(priv.Public().(ed25519.PublicKey))
+	if id.PublicKey != "" && !strings.EqualFold(id.PublicKey, pub) {
+		return fmt.Errorf("identity public key does not match private key")
+	}
+	return nil
+}
+
+// Encrypt replaces the identity's private key with one encrypted with
+// passphrase, so the file can be stored where it may be read by others.
+func (id *PortableIdentity) Encrypt(passphrase string) error {
+	if id.Locked() {
+		return fmt.Errorf("identity is already encrypted")
+	}
+	if err := id.Validate(); err != nil {
+		return err
+	}
+	priv, err := parsePrivateKey(id.PrivateKey)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, 16)
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	aead, err := identityAEAD(passphrase, salt, identityIterations)
+	if err != nil {
+		return err
+	}
+	ciphertext := aead.Seal(nil, nonce, priv.Seed(), []byte(id.AgentID))
+	id.PublicKey = hex.EncodeToString(priv.Public().(ed25519.PublicKey))
+	id.PrivateKey = ""
+	id.EncryptedPrivateKey = &EncryptedKey{
+		KDF:        identityKDF,
+		Iterations: identityIterations,
+		Salt:       hex.EncodeToString(salt),
+		Cipher:     identityCipher,
+		Nonce:      hex.EncodeToString(nonce),
+		Ciphertext: hex.EncodeToString(ciphertext),
+	}
+	return nil
+}
+
+// Decrypt restores the identity's private key from its encrypted form. It
+// returns ErrWrongPassphrase if passphrase does not decrypt it, and does
+// nothing if the identity is not encrypted.
+func (id *PortableIdentity) Decrypt(passphrase string) error {
+	if !id.Locked() {
+		return nil
+	}
+	ek := id.EncryptedPrivateKey
+	if ek.KDF != identityKDF || ek.Cipher != identityCipher {
+		return fmt.Errorf("unsupported identity encryption: %s/%s", ek.KDF, ek.Cipher)
+	}
+	if ek.Iterations < 1 || ek.Iterations > maxIdentityIterations {
+		return fmt.Errorf("invalid identity iteration count: %d", ek.Iterations)
+	}
+	salt, err := hex.DecodeString(ek.Salt)
+	if err != nil {
+		return fmt.Errorf("identity salt: %w", err)
+	}
+	nonce, err := hex.DecodeString(ek.Nonce)
+	if err != nil {
+		return fmt.Errorf("identity nonce: %w", err)
+	}
+	ciphertext, err := hex.DecodeString(ek.Ciphertext)
+	if err != nil {
+		return fmt.Errorf("identity ciphertext: %w", err)
+	}
+	aead, err := identityAEAD(passphrase, salt, ek.Iterations)
+	if err != nil {
+		return err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return fmt.Errorf("invalid identity nonce length: %d", len(nonce))
+	}
+	seed, err := aead.Open(nil, nonce, ciphertext, []byte(id.AgentID))
+	if err != nil {
+		return ErrWrongPassphrase
+	}
+	plain := *id
+	plain.PrivateKey = hex.EncodeToString(seed)
+	plain.EncryptedPrivateKey = nil
+	if err := plain.Validate(); err != nil {
+		return err
+	}
+	*id = plain
+	return nil
+}
+
+// identityAEAD returns the AES-256-GCM cipher keyed from passphrase.
+func identityAEAD(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2SHA256([]byte(passphrase), salt, iterations, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2SHA256 derives a keyLen-byte key with PBKDF2-HMAC-SHA256 (RFC
+// 8018).
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hLen := prf.Size()
+	var dk []byte
+	u := make([]byte, hLen)
+	var counter [4]byte
+	for block := uint32(1); len(dk) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], block)
+		prf.Write(counter[:])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hLen:]
+		copy(u, t)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range u {
+				t[j] ^= u[j]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
+
+// LoadPortableIdentity reads and validates an identity file. An encrypted
+// identity is returned locked; call Decrypt before using it.
+func LoadPortableIdentity(path string) (*PortableIdentity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var id PortableIdentity
+	if err := json.Unmarshal(data, &id); err != nil {
+		return nil, fmt.Errorf("parse identity: %w", err)
+	}
+	if err := id.Validate(); err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// SavePortableIdentity validates id and writes it to path with owner-only
+// permissions, replacing any existing file atomically.
+func SavePortableIdentity(path string, id *PortableIdentity) error {
+	if id.Version == 0 {
+		id.Version = PortableIdentityVersion
+	}
+	if err := id.Validate(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(id, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".identity-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// PortableIdentity exports the client's identity. The client must have keys.
+func (c *Client) PortableIdentity() (*PortableIdentity, error) {
+	cur := c.identity()
+	if cur.privateKey == nil {
+		return nil, fmt.Errorf("no keys")
+	}
+	return &PortableIdentity{
+		Version:    PortableIdentityVersion,
+		AgentID:    cur.agentID,
+		PrivateKey: hex.EncodeToString(cur.privateKey.Seed()),
+		PublicKey:  cur.publicKey,
+		Name:       cur.name,
+		BaseURL:    c.BaseURL(),
+	}, nil
+}
+
+// UsePortableIdentity configures the client's keys and agent ID from id,
+// continuing an agent registered by any SDK without re-registering. It
+// returns ErrIdentityLocked if id is encrypted.
+func (c *Client) UsePortableIdentity(id *PortableIdentity) error {
+	if id.Locked() {
+		return ErrIdentityLocked
+	}
+	if err := id.Validate(); err != nil {
+		return err
+	}
+	priv, err := parsePrivateKey(id.PrivateKey)
+	if err != nil {
+		return err
+	}
+	c.idMu.Lock()
+	c.id = identity{
+		agentID:    id.AgentID,
+		privateKey: priv,
+		publicKey:  hex.EncodeToString(priv.Public().(ed25519.PublicKey)),
+		name:       id.Name,
+	}
+	c.idMu.Unlock()
+	return nil
+}
+
+// SaveIdentity writes the client's identity to path in the portable
+// format. State saved by other SDKs in an existing file for the same agent
+// is kept, as is the file's creation time. It will not replace an
+// encrypted file with an unencrypted one; use SaveEncryptedIdentity.
+func (c *Client) SaveIdentity(path string) error {
+	return c.saveIdentity(path, "", false)
+}
+
+// SaveEncryptedIdentity is SaveIdentity with the private key encrypted
+// with passphrase.
+func (c *Client) SaveEncryptedIdentity(path, passphrase string) error {
+	return c.saveIdentity(path, passphrase, true)
+}
+
+func (c *Client) saveIdentity(path, passphrase string, encrypt bool) error {
+	id, err := c.PortableIdentity()
+	if err != nil {
+		return err
+	}
+	if old, err := LoadPortableIdentity(path); err == nil {
+		if old.Locked() && !encrypt {
+			return fmt.Errorf("%w: %s; use SaveEncryptedIdentity", ErrIdentityLocked, path)
+		}
+		if old.AgentID == id.AgentID {
+			id.State = old.State
+			id.CreatedAt = old.CreatedAt
+		}
+	}
+	if id.CreatedAt == "" {
+		id.CreatedAt = time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	}
+	if encrypt {
+		if err := id.Encrypt(passphrase); err != nil {
+			return err
+		}
+	}
+	return SavePortableIdentity(path, id)
+}
+
+// LoadIdentity configures the client from the identity file at path. It
+// fails if the file names a different server than the client's, and with
+// ErrIdentityLocked if the file is encrypted.
+func (c *Client) LoadIdentity(path string) error {
+	return c.loadIdentity(path, nil)
+}
+
+// LoadEncryptedIdentity is LoadIdentity for a file saved with
+// SaveEncryptedIdentity. It also accepts unencrypted files.
+func (c *Client) LoadEncryptedIdentity(path, passphrase string) error {
+	return c.loadIdentity(path, &passphrase)
+}
+
+func (c *Client) loadIdentity(path string, passphrase *string) error {
+	id, err := LoadPortableIdentity(path)
+	if err != nil {
+		return err
+	}
+	if id.BaseURL != "" && strings.TrimSuffix(id.BaseURL, "/") != strings.TrimSuffix(c.BaseURL(), "/") {
+		return fmt.Errorf("identity %s belongs to %s, not %s", path, id.BaseURL, c.BaseURL())
+	}
+	if passphrase != nil {
+		if err := id.Decrypt(*passphrase); err != nil {
+			return err
+		}
+	}
+	return c.UsePortableIdentity(id)
+}
+
+// RegisterOrLoad loads the identity at path if it exists, and otherwise
+// registers a new agent and saves its identity there, so restarts keep the
+// same agent instead of registering a duplicate. The returned agent is
+// fetched from the server when loading.
+func (c *Client) RegisterOrLoad(ctx context.Context, path, name string, opts *RegisterOptions, reqOpts ...RequestOption) (*Agent, error) {
+	err := c.LoadIdentity(path)
+	switch {
+	case err == nil:
+		return c.GetAgent(ctx, c.AgentID(), reqOpts...)
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+	agent, err := c.Register(ctx, name, opts, reqOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.SaveIdentity(path); err != nil {
+		return agent, fmt.Errorf("save identity: %w", err)
+	}
+	return agent, nil
+}
+
+// parsePrivateKey decodes a hex Ed25519 private key given either as a
+// 32-byte seed (JS and Python SDKs) or a 64-byte expanded key (Go).
+func parsePrivateKey(privateKeyHex string) (ed25519.PrivateKey, error) {
+	b, err := hex.DecodeString(privateKeyHex)
+	if err != nil {
+		return nil, err
+	}
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	}
+	return nil, fmt.Errorf("invalid private key length: %d", len(b))
+}
@@ -0,0 +1,209 @@
+package ping
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testSeed is the RFC 8032 test 1 secret key.
+const (
+	testSeed   = "9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60"
+	testPublic = "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a"
+)
+
+func testIdentity() *PortableIdentity {
+	return &PortableIdentity{
+		Version:    PortableIdentityVersion,
+		AgentID:    "agent-1",
+		PrivateKey: testSeed,
+		PublicKey:  testPublic,
+		Name:       "Test Agent",
+		BaseURL:    "http://ping.test",
+		CreatedAt:  "2025-01-01T00:00:00.000Z",
+		State:      map[string]json.RawMessage{"js": json.RawMessage(`{"cursor":42}`)},
+	}
+}
+
+func TestPortableIdentityRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.json")
+	want := testIdentity()
+	if err := SavePortableIdentity(path, want); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("file mode = %o, want 600", perm)
+	}
+	got, err := LoadPortableIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(got)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("loaded %s, want %s", gotJSON, wantJSON)
+	}
+}
+
+func TestClientIdentityRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.json")
+	// Another SDK wrote the file first; its state must survive a re-save.
+	if err := SavePortableIdentity(path, testIdentity()); err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewClient("http://ping.test")
+	if err := a.LoadIdentity(path); err != nil {
+		t.Fatal(err)
+	}
+	if a.AgentID() != "agent-1" || a.identity().publicKey != testPublic {
+		t.Fatalf("loaded agent %q key %q", a.AgentID(), a.identity().publicKey)
+	}
+	if err := a.SaveIdentity(path); err != nil {
+		t.Fatal(err)
+	}
+	id, err := LoadPortableIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.PrivateKey != testSeed {
+		t.Errorf("saved private key %s, want the 32-byte seed", id.PrivateKey)
+	}
+	var state bytes.Buffer
+	json.Compact(&state, id.State["js"])
+	if state.String() != `{"cursor":42}` || id.CreatedAt != "2025-01-01T00:00:00.000Z" {
+		t.Errorf("re-save lost state %s or createdAt %q", state.String(), id.CreatedAt)
+	}
+
+	b := NewClient("http://other.test")
+	if err := b.LoadIdentity(path); err == nil {
+		t.Error("LoadIdentity accepted an identity for another server")
+	}
+}
+
+func TestPortableIdentityExpandedKey(t *testing.T) {
+	seed, _ := hex.DecodeString(testSeed)
+	id := testIdentity()
+	id.PrivateKey = hex.EncodeToString(ed25519.NewKeyFromSeed(seed))
+	if err := id.Validate(); err != nil {
+		t.Fatalf("64-byte key rejected: %v", err)
+	}
+	c := NewClient("http://ping.test")
+	if err := c.UsePortableIdentity(id); err != nil {
+		t.Fatal(err)
+	}
+	if c.identity().publicKey != testPublic {
+		t.Errorf("public key %s, want %s", c.identity().publicKey, testPublic)
+	}
+}
+
+func TestPortableIdentityMismatchedKeys(t *testing.T) {
+	id := testIdentity()
+	id.PublicKey = strings.Repeat("00", ed25519.PublicKeySize)
+	if err := id.Validate(); err == nil {
+		t.Error("Validate accepted a public key that does not match")
+	}
+}
+
+func TestEncryptedIdentityRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.json")
+	id := testIdentity()
+	if err := id.Encrypt("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SavePortableIdentity(path, id); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), testSeed) || strings.Contains(string(data), `"privateKey"`) {
+		t.Fatalf("encrypted file contains the private key:\n%s", data)
+	}
+
+	loaded, err := LoadPortableIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Locked() {
+		t.Fatal("loaded identity is not locked")
+	}
+	c := NewClient("http://ping.test")
+	if err := c.UsePortableIdentity(loaded); !errors.Is(err, ErrIdentityLocked) {
+		t.Fatalf("UsePortableIdentity on a locked identity = %v, want ErrIdentityLocked", err)
+	}
+	if err := loaded.Decrypt("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.PrivateKey != testSeed || loaded.EncryptedPrivateKey != nil {
+		t.Errorf("decrypted key %q, encrypted %v", loaded.PrivateKey, loaded.EncryptedPrivateKey)
+	}
+
+	if err := c.LoadEncryptedIdentity(path, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if c.AgentID() != "agent-1" || c.identity().publicKey != testPublic {
+		t.Errorf("loaded agent %q key %q", c.AgentID(), c.identity().publicKey)
+	}
+	if err := c.SaveIdentity(path); !errors.Is(err, ErrIdentityLocked) {
+		t.Errorf("SaveIdentity over an encrypted file = %v, want ErrIdentityLocked", err)
+	}
+	if err := c.SaveEncryptedIdentity(path, "battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewClient("http://ping.test").LoadEncryptedIdentity(path, "battery staple"); err != nil {
+		t.Errorf("load after re-encrypting: %v", err)
+	}
+}
+
+func TestEncryptedIdentityWrongPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.json")
+	id := testIdentity()
+	if err := id.Encrypt("correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SavePortableIdentity(path, id); err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient("http://ping.test")
+	if err := c.LoadIdentity(path); !errors.Is(err, ErrIdentityLocked) {
+		t.Errorf("LoadIdentity without a passphrase = %v, want ErrIdentityLocked", err)
+	}
+	if err := c.LoadEncryptedIdentity(path, "wrong"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("LoadEncryptedIdentity with the wrong passphrase = %v, want ErrWrongPassphrase", err)
+	}
+	if c.AgentID() != "" {
+		t.Errorf("failed load set agent ID %q", c.AgentID())
+	}
+
+	// The ciphertext is bound to the agent ID.
+	id.AgentID = "agent-2"
+	if err := id.Decrypt("correct horse"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Decrypt under another agent ID = %v, want ErrWrongPassphrase", err)
+	}
+}
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// RFC 7914 section 11.
+	got := hex.EncodeToString(pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1, 64))
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if got != want {
+		t.Errorf("PBKDF2(passwd, salt, 1) = %s, want %s", got, want)
+	}
+	got = hex.EncodeToString(pbkdf2SHA256([]byte("Password"), []byte("NaCl"), 80000, 64))
+	want = "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56" +
+		"a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"
+	if got != want {
+		t.Errorf("PBKDF2(Password, NaCl, 80000) = %s, want %s", got, want)
+	}
+}
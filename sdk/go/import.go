@@ -0,0 +1,222 @@
+package ping
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ImportOptions controls how ImportMessages maps and replays records.
+type ImportOptions struct {
+	// Map converts one decoded JSONL record into a Message. Returning a nil
+	// message skips the record. Defaults to MapImportRecord.
+	Map func(record map[string]interface{}) (*Message, error)
+	// Replay selects imported messages to resend to the server as this
+	// agent. Messages without a recipient are never replayed.
+	Replay func(Message) bool
+	// SkipInvalid skips lines that fail to decode or map instead of
+	// aborting the import.
+	SkipInvalid bool
+}
+
+// ImportResult summarizes an import.
+type ImportResult struct {
+	Imported int
+	// Skipped counts records mapped to nil, invalid records skipped with
+	// SkipInvalid and records already in the store.
+	Skipped  int
+	Replayed int
+}
+
+// ImportMessages reads JSON Lines exported from another messaging system,
+// maps each record into a Message and saves it to the client's store.
+// Records without an ID get a stable one derived from their content.
+// Records already in the store are skipped, and not replayed again, so
+// re-importing the same file does not duplicate history. A replayed
+// message is kept in the store once, under its imported ID. reqOpts
+// apply to the replayed sends.
+func (c *Client) ImportMessages(ctx context.Context, r io.Reader, opts *ImportOptions, reqOpts ...RequestOption) (*ImportResult, error) {
+	if c.store == nil {
+		return nil, fmt.Errorf("no store configured")
+	}
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+	mapRecord := opts.Map
+	if mapRecord == nil {
+		mapRecord = MapImportRecord
+	}
+
+	result := &ImportResult{}
+	br := bufio.NewReader(r)
+	for lineNo := 1; ; lineNo++ {
+		line, readErr := br.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return result, readErr
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			msg, err := decodeImportLine(line, mapRecord)
+			if err != nil {
+				if !opts.SkipInvalid {
+					return result, fmt.Errorf("line %d: %w", lineNo, err)
+				}
+				result.Skipped++
+			} else if msg == nil {
+				result.Skipped++
+			} else {
+				if msg.ID == "" {
+					sum := sha256.Sum256(line)
+					msg.ID = "import-" + hex.EncodeToString(sum[:12])
+				}
+				if err := c.importMessage(ctx, *msg, opts, result, reqOpts); err != nil {
+					return result, fmt.Errorf("line %d: %w", lineNo, err)
+				}
+			}
+		}
+		if readErr == io.EOF {
+			return result, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+	}
+}
+
+// importMessage saves msg and replays it if opts select it, unless the
+// store already holds it.
+func (c *Client) importMessage(ctx context.Context, msg Message, opts *ImportOptions, result *ImportResult, reqOpts []RequestOption) error {
+	_, err := c.store.Load(ctx, msg.ID)
+	if err == nil {
+		result.Skipped++
+		return nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if err := c.store.Save(ctx, msg); err != nil {
+		return err
+	}
+	result.Imported++
+
+	if opts.Replay == nil || msg.To == "" || !opts.Replay(msg) {
+		return nil
+	}
+	payload, err := msg.PayloadMap()
+	if err != nil {
+		return fmt.Errorf("replay: %w", err)
+	}
+	// The imported record stands for the replayed message in the store.
+	sendOpts := append(append([]RequestOption(nil), reqOpts...), withoutRecord())
+	if _, err := c.Send(ctx, msg.To, msg.Type, payload, "", sendOpts...); err != nil {
+		return fmt.Errorf("replay: %w", err)
+	}
+	result.Replayed++
+	return nil
+}
+
+// withoutRecord keeps a sent message out of the client's store.
+func withoutRecord() RequestOption {
+	return func(cfg *callConfig) {
+		cfg.noRecord = true
+	}
+}
+
+func decodeImportLine(line []byte, mapRecord func(map[string]interface{}) (*Message, error)) (*Message, error) {
+	var record map[string]interface{}
+	if err := json.Unmarshal(line, &record); err != nil {
+		return nil, err
+	}
+	return mapRecord(record)
+}
+
+// MapImportRecord is the default import mapping. It accepts PING's own
+// message shape as well as common field names used by chat exports:
+// sender/author/user for from, recipient/channel for to, text/content/body
+// for a text payload, and ts/time/created_at (RFC 3339 or unix seconds or
+// milliseconds) for the timestamp.
+func MapImportRecord(record map[string]interface{}) (*Message, error) {
+	msg := &Message{
+		ID:      firstString(record, "id", "message_id", "messageId", "uuid"),
+		Type:    MessageType(firstString(record, "type", "kind")),
+		From:    firstString(record, "from", "sender", "author", "user", "from_agent"),
+		To:      firstString(record, "to", "recipient", "channel", "to_agent"),
+		ReplyTo: firstString(record, "replyTo", "reply_to", "parent_id", "thread_ts"),
+	}
+	if msg.From == "" && msg.To == "" {
+		return nil, fmt.Errorf("record has no sender or recipient")
+	}
+
+	var payload map[string]interface{}
+	if p, ok := record["payload"].(map[string]interface{}); ok {
+		payload = p
+	} else if text := firstString(record, "text", "content", "body", "message"); text != "" {
+		payload = map[string]interface{}{"text": text}
+	}
+	raw, err := marshalPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	msg.Payload = raw
+	if msg.Type == "" || msg.Type == "message" {
+		msg.Type = "text"
+	}
+
+	for _, key := range []string{"timestamp", "ts", "time", "created_at", "createdAt", "date"} {
+		if v, ok := record[key]; ok {
+			msg.Timestamp = importTimestamp(v)
+			break
+		}
+	}
+	return msg, nil
+}
+
+func firstString(record map[string]interface{}, keys ...string) string {
+	for _, k := range keys {
+		switch v := record[k].(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return ""
+}
+
+// importTimestamp parses a timestamp. Numbers (or numeric strings) are
+// treated as unix milliseconds when large enough, otherwise as unix
+// seconds. Unparseable values yield the zero Timestamp.
+func importTimestamp(v interface{}) Timestamp {
+	var n float64
+	switch t := v.(type) {
+	case float64:
+		n = t
+	case string:
+		f, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			ts, _ := parseTimestamp(t)
+			return Timestamp{ts}
+		}
+		n = f
+	default:
+		return Timestamp{}
+	}
+	var ts time.Time
+	if n > 1e12 {
+		ts = time.UnixMilli(int64(n))
+	} else {
+		sec := int64(n)
+		ts = time.Unix(sec, int64((n-float64(sec))*1e9))
+	}
+	return NewTimestamp(ts)
+}
@@ -0,0 +1,156 @@
+package ping
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MessageTypeClose tells a peer that the conversation was closed.
+const MessageTypeClose MessageType = "close"
+
+// InactivityEvent reports a conversation that went idle.
+type InactivityEvent struct {
+	Peer         string
+	LastActivity time.Time
+	Idle         time.Duration
+}
+
+// InactivityMonitor is a Service that tracks the last activity of each
+// conversation and closes conversations that stay idle too long. Activity
+// is recorded with Touch, or automatically for incoming messages by
+// wrapping a handler with Middleware.
+//
+// A closed conversation is forgotten, so its state costs nothing until the
+// peer becomes active again.
+type InactivityMonitor struct {
+	Client *Client
+	// IdleAfter is how long a conversation may be idle before it is
+	// closed. Defaults to 30 minutes.
+	IdleAfter time.Duration
+	// CheckInterval is how often conversations are checked. Defaults to a
+	// quarter of IdleAfter.
+	CheckInterval time.Duration
+	// OnIdle, if set, is called for each conversation as it is closed.
+	OnIdle func(ctx context.Context, ev InactivityEvent)
+	// ClosePayload, if set, is sent to the peer in a MessageTypeClose
+	// message when the conversation is closed.
+	ClosePayload map[string]interface{}
+	// Archive removes the conversation's messages from the client's local
+	// store when it is closed.
+	Archive bool
+	// OnError, if set, receives errors from sending close messages and
+	// archiving.
+	OnError func(error)
+
+	mu       sync.Mutex
+	last     map[string]time.Time
+	timeouts map[string]time.Duration
+}
+
+// Touch records activity in the conversation with peer.
+func (m *InactivityMonitor) Touch(peer string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.last == nil {
+		m.last = make(map[string]time.Time)
+	}
+	m.last[peer] = time.Now()
+}
+
+// SetIdleTimeout overrides IdleAfter for conversations with peer. A
+// timeout of 0 restores the default.
+func (m *InactivityMonitor) SetIdleTimeout(peer string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if d <= 0 {
+		delete(m.timeouts, peer)
+		return
+	}
+	if m.timeouts == nil {
+		m.timeouts = make(map[string]time.Duration)
+	}
+	m.timeouts[peer] = d
+}
+
+// Active returns the number of conversations being tracked.
+func (m *InactivityMonitor) Active() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.last)
+}
+
+// Middleware records activity for the sender of each handled message.
+func (m *InactivityMonitor) Middleware(next Handler) Handler {
+	return func(ctx context.Context, msg Message) error {
+		m.Touch(msg.From)
+		return next(ctx, msg)
+	}
+}
+
+// Run implements Service.
+func (m *InactivityMonitor) Run(ctx context.Context) error {
+	idle := durationOr(m.IdleAfter, 30*time.Minute)
+	ticker := time.NewTicker(durationOr(m.CheckInterval, idle/4))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			for _, ev := range m.expire(now, idle) {
+				m.close(ctx, ev)
+			}
+		}
+	}
+}
+
+// expire removes and returns the conversations idle at now.
+func (m *InactivityMonitor) expire(now time.Time, def time.Duration) []InactivityEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []InactivityEvent
+	for peer, last := range m.last {
+		limit := def
+		if d, ok := m.timeouts[peer]; ok {
+			limit = d
+		}
+		if idle := now.Sub(last); idle >= limit {
+			out = append(out, InactivityEvent{Peer: peer, LastActivity: last, Idle: idle})
+			delete(m.last, peer)
+		}
+	}
+	return out
+}
+
+func (m *InactivityMonitor) close(ctx context.Context, ev InactivityEvent) {
+	if m.ClosePayload != nil {
+		if _, err := m.Client.Send(ctx, ev.Peer, MessageTypeClose, m.ClosePayload, ""); err != nil {
+			reportError(m.OnError, err)
+		}
+	}
+	if m.Archive {
+		if err := m.archive(ctx, ev.Peer); err != nil {
+			reportError(m.OnError, err)
+		}
+	}
+	if m.OnIdle != nil {
+		m.OnIdle(ctx, ev)
+	}
+}
+
+func (m *InactivityMonitor) archive(ctx context.Context, peer string) error {
+	store := m.Client.Store()
+	if store == nil {
+		return nil
+	}
+	msgs, err := store.List(ctx, StoreFilter{Peer: peer})
+	if err != nil {
+		return err
+	}
+	ids := make([]string, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.ID
+	}
+	return store.Delete(ctx, ids...)
+}
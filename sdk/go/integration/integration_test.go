@@ -0,0 +1,25 @@
+//go:build integration
+
+package integration_test
+
+import (
+	"testing"
+
+	"github.com/aetos53t/ping/sdk/go/integration"
+	"github.com/aetos53t/ping/sdk/go/pingtest"
+)
+
+// TestSuite runs the suite against the server named by PING_URL, or one
+// started in Docker.
+func TestSuite(t *testing.T) {
+	srv := integration.NewServer(t)
+	integration.RunSuite(t, srv.URL)
+}
+
+// TestSuiteInMemory runs the suite against the in-memory server, so the
+// suite itself is exercised where neither Docker nor PING_URL is available.
+func TestSuiteInMemory(t *testing.T) {
+	srv := pingtest.NewServer()
+	defer srv.Close()
+	integration.RunSuite(t, srv.URL)
+}
@@ -0,0 +1,178 @@
+// Package integration runs the PING Go SDK against a real server.
+//
+// It can start the PING server in Docker, or use an existing deployment
+// named by the PING_URL environment variable, and provides a suite of
+// end-to-end checks that downstream users can run against their own
+// deployments. Keep such tests behind a build tag:
+//
+//	//go:build integration
+//
+//	package mypkg_test
+//
+//	func TestPing(t *testing.T) {
+//		srv := integration.NewServer(t)
+//		integration.RunSuite(t, srv.URL)
+//	}
+//
+// and run them with:
+//
+//	go test -tags integration ./...
+package integration
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// DefaultImage is the Docker image started when ServerOptions.Image is empty.
+const DefaultImage = "ping:latest"
+
+// containerPort is the port the server listens on inside the container.
+const containerPort = "3100/tcp"
+
+// ServerOptions configures a dockerized PING server.
+type ServerOptions struct {
+	// Image is the server image to run. Defaults to DefaultImage.
+	Image string
+	// BuildContext, if set, builds Image from this directory first
+	// (the repository root contains the server Dockerfile).
+	BuildContext string
+	// Env adds environment variables to the container, e.g. DATABASE_URL.
+	Env map[string]string
+	// StartTimeout bounds how long to wait for /health. Defaults to 60s.
+	StartTimeout time.Duration
+}
+
+// Server is a running PING server.
+type Server struct {
+	// URL is the server's base URL.
+	URL string
+
+	containerID string
+}
+
+// StartServer starts a PING server container and waits until it is healthy.
+func StartServer(ctx context.Context, opts *ServerOptions) (*Server, error) {
+	if opts == nil {
+		opts = &ServerOptions{}
+	}
+	image := opts.Image
+	if image == "" {
+		image = DefaultImage
+	}
+	timeout := opts.StartTimeout
+	if timeout == 0 {
+		timeout = 60 * time.Second
+	}
+
+	if opts.BuildContext != "" {
+		if _, err := docker(ctx, "build", "-t", image, opts.BuildContext); err != nil {
+			return nil, err
+		}
+	}
+
+	args := []string{"run", "-d", "--rm", "-p", "127.0.0.1::" + containerPort}
+	for k, v := range opts.Env {
+		args = append(args, "-e", k+"="+v)
+	}
+	args = append(args, image)
+	id, err := docker(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	srv := &Server{containerID: id}
+
+	hostPort, err := docker(ctx, "port", id, containerPort)
+	if err != nil {
+		srv.Close()
+		return nil, err
+	}
+	// docker port may list several bindings; the first is ours.
+	hostPort = strings.SplitN(hostPort, "\n", 2)[0]
+	if _, port, err := net.SplitHostPort(hostPort); err == nil {
+		hostPort = net.JoinHostPort("127.0.0.1", port)
+	}
+	srv.URL = "http://" + hostPort
+
+	if err := WaitHealthy(ctx, srv.URL, timeout); err != nil {
+		srv.Close()
+		return nil, err
+	}
+	return srv, nil
+}
+
+// Close stops and removes the server container.
+func (s *Server) Close() error {
+	if s.containerID == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := docker(ctx, "stop", s.containerID)
+	s.containerID = ""
+	return err
+}
+
+// NewServer returns a server for tests. If PING_URL is set the deployment it
+// names is used; otherwise a container is started from PING_IMAGE (or
+// DefaultImage) and stopped when the test ends. The test is skipped if
+// neither is possible.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	if url := os.Getenv("PING_URL"); url != "" {
+		return &Server{URL: strings.TrimRight(url, "/")}
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("integration: docker not available and PING_URL not set")
+	}
+
+	srv, err := StartServer(context.Background(), &ServerOptions{Image: os.Getenv("PING_IMAGE")})
+	if err != nil {
+		t.Fatalf("integration: start server: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// WaitHealthy polls baseURL/health until it returns 200 or timeout elapses.
+func WaitHealthy(ctx context.Context, baseURL string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/health", nil)
+		if err != nil {
+			return err
+		}
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("server at %s not healthy: %w", baseURL, ctx.Err())
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+func docker(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("docker %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
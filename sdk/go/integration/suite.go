@@ -0,0 +1,129 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	ping "github.com/aetos53t/ping/sdk/go"
+)
+
+// RunSuite runs the SDK end-to-end checks against the server at baseURL as
+// subtests of t. Each run registers fresh agents, so it is safe to point at
+// a shared deployment.
+func RunSuite(t *testing.T, baseURL string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	tag := fmt.Sprintf("it-%d", time.Now().UnixNano())
+	alice := ping.NewClient(baseURL)
+	bob := ping.NewClient(baseURL)
+
+	steps := []struct {
+		name string
+		fn   func(t *testing.T)
+	}{
+		{"Register", func(t *testing.T) {
+			for _, c := range []struct {
+				client *ping.Client
+				name   string
+			}{{alice, "alice"}, {bob, "bob"}} {
+				agent, err := c.client.Register(ctx, c.name+"-"+tag, &ping.RegisterOptions{
+					Provider:     "go-integration",
+					Capabilities: []string{tag},
+					IsPublic:     true,
+				})
+				if err != nil {
+					t.Fatalf("register %s: %v", c.name, err)
+				}
+				if agent.ID == "" {
+					t.Fatalf("register %s: empty agent ID", c.name)
+				}
+			}
+		}},
+		{"GetAgent", func(t *testing.T) {
+			agent, err := alice.GetAgent(ctx, bob.AgentID())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if agent.Name != "bob-"+tag {
+				t.Fatalf("name = %q, want %q", agent.Name, "bob-"+tag)
+			}
+		}},
+		{"SendAndReceive", func(t *testing.T) {
+			text := "hello <" + tag + "> & welcome"
+			result, err := alice.Text(ctx, bob.AgentID(), text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msgs, err := bob.Inbox(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var found *ping.Message
+			for i := range msgs {
+				if msgs[i].ID == result.ID {
+					found = &msgs[i]
+				}
+			}
+			if found == nil {
+				t.Fatalf("message %s not in inbox", result.ID)
+			}
+			if found.From != alice.AgentID() || found.PayloadString("text") != text {
+				t.Fatalf("unexpected message: %+v", found)
+			}
+			if err := bob.Ack(ctx, result.ID); err != nil {
+				t.Fatal(err)
+			}
+			msgs, err = bob.Inbox(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range msgs {
+				if m.ID == result.ID {
+					t.Fatalf("acknowledged message %s still in inbox", m.ID)
+				}
+			}
+		}},
+		{"History", func(t *testing.T) {
+			msgs, err := alice.History(ctx, bob.AgentID(), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(msgs) == 0 {
+				t.Fatal("empty history")
+			}
+		}},
+		{"Directory", func(t *testing.T) {
+			agents, err := alice.Search(ctx, &ping.SearchOptions{Capability: tag})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(agents) != 2 {
+				t.Fatalf("search found %d agents, want 2", len(agents))
+			}
+		}},
+		{"Contacts", func(t *testing.T) {
+			if err := alice.AddContact(ctx, bob.AgentID(), "bob", "integration"); err != nil {
+				t.Fatal(err)
+			}
+			contacts, err := alice.Contacts(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(contacts) != 1 || contacts[0].ContactID != bob.AgentID() {
+				t.Fatalf("contacts = %+v", contacts)
+			}
+			if err := alice.RemoveContact(ctx, bob.AgentID()); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+
+	for _, step := range steps {
+		if !t.Run(step.name, step.fn) {
+			return
+		}
+	}
+}
@@ -0,0 +1,987 @@
+// Package binenc encodes and decodes the CBOR (RFC 8949) and MessagePack
+// data models used as alternative PING wire encodings, keeping the SDK free
+// of third-party dependencies.
+//
+// Values are encoded through their JSON form, so json struct tags and
+// Marshaler implementations apply, except that []byte values held directly
+// in maps and slices are encoded as binary strings rather than base64 text.
+// Decoding goes the other way: binary strings become base64 JSON strings,
+// which json.Unmarshal turns back into []byte fields.
+package binenc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Format is a binary encoding.
+type Format int
+
+const (
+	CBOR Format = iota
+	MessagePack
+)
+
+func (f Format) String() string {
+	if f == MessagePack {
+		return "msgpack"
+	}
+	return "cbor"
+}
+
+// maxDepth bounds the nesting of decoded values.
+const maxDepth = 512
+
+var errTruncated = errors.New("binenc: unexpected end of data")
+
+// Marshal encodes v in format f.
+func Marshal(f Format, v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	e := encoder{buf: &buf, format: f}
+	if err := e.encode(v, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes data in format f and stores the result in v using
+// encoding/json semantics.
+func Unmarshal(f Format, data []byte, v interface{}) error {
+	b, err := ToJSON(f, data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// ToJSON converts a value encoded in format f to JSON. Map keys keep their
+// encoded order and binary strings become base64 strings.
+func ToJSON(f Format, data []byte) ([]byte, error) {
+	tree, err := decode(f, data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(tree)
+}
+
+// decode decodes a single value encoded in format f into objects, slices,
+// strings, []byte, json.Number, bool and nil. Trailing data is an error.
+func decode(f Format, data []byte) (interface{}, error) {
+	d := decoder{data: data}
+	var (
+		v   interface{}
+		err error
+	)
+	if f == MessagePack {
+		v, err = d.msgpack(0)
+	} else {
+		v, err = d.cbor(0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("binenc: %d bytes of trailing data", len(d.data)-d.pos)
+	}
+	return v, nil
+}
+
+type encoder struct {
+	buf    *bytes.Buffer
+	format Format
+}
+
+func (e *encoder) encode(v interface{}, depth int) error {
+	if depth > maxDepth {
+		return errors.New("binenc: value nested too deeply")
+	}
+	switch v := v.(type) {
+	case nil:
+		e.null()
+	case bool:
+		e.bool(v)
+	case string:
+		e.text(v)
+	case []byte:
+		e.bytes(v)
+	case json.Number:
+		return e.number(string(v))
+	case json.RawMessage:
+		return e.encodeJSON(v, depth)
+	case float64:
+		e.float(v)
+	case float32:
+		e.float(float64(v))
+	case int:
+		e.int(int64(v))
+	case int8:
+		e.int(int64(v))
+	case int16:
+		e.int(int64(v))
+	case int32:
+		e.int(int64(v))
+	case int64:
+		e.int(v)
+	case uint:
+		e.uint(uint64(v))
+	case uint8:
+		e.uint(uint64(v))
+	case uint16:
+		e.uint(uint64(v))
+	case uint32:
+		e.uint(uint64(v))
+	case uint64:
+		e.uint(v)
+	case map[string]interface{}:
+		// Sorted, as json.Marshal orders them, so the server re-serializes
+		// payloads to the JSON they were signed as.
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.mapHeader(len(v))
+		for _, k := range keys {
+			e.text(k)
+			if err := e.encode(v[k], depth+1); err != nil {
+				return err
+			}
+		}
+	case *object:
+		e.mapHeader(len(v.keys))
+		for i, k := range v.keys {
+			e.text(k)
+			if err := e.encode(v.vals[i], depth+1); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		e.arrayHeader(len(v))
+		for _, item := range v {
+			if err := e.encode(item, depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return e.encodeJSON(b, depth)
+	}
+	return nil
+}
+
+// encodeJSON encodes a JSON document, keeping the order of object keys.
+func (e *encoder) encodeJSON(data []byte, depth int) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	tree, err := parseJSON(dec, 0)
+	if err != nil {
+		return err
+	}
+	return e.encode(tree, depth)
+}
+
+// parseJSON reads the next JSON value from dec, decoding objects into
+// *object so their key order survives.
+func parseJSON(dec *json.Decoder, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("binenc: value nested too deeply")
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := &object{}
+		for dec.More() {
+			k, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := parseJSON(dec, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			obj.set(k.(string), v)
+		}
+		_, err = dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []interface{}{}
+		for dec.More() {
+			v, err := parseJSON(dec, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err = dec.Token()
+		return arr, err
+	}
+	return tok, nil
+}
+
+// object is a map that keeps its keys in order.
+type object struct {
+	keys []string
+	vals []interface{}
+}
+
+func (o *object) set(k string, v interface{}) {
+	o.keys = append(o.keys, k)
+	o.vals = append(o.vals, v)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (o *object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		kb, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(kb)
+		buf.WriteByte(':')
+		vb, err := json.Marshal(o.vals[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(vb)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// number encodes a JSON number as an integer if it is one, else a float.
+func (e *encoder) number(s string) error {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		e.int(i)
+		return nil
+	}
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		e.uint(u)
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("binenc: invalid number %q", s)
+	}
+	e.float(f)
+	return nil
+}
+
+func (e *encoder) null() {
+	if e.format == MessagePack {
+		e.buf.WriteByte(0xc0)
+	} else {
+		e.buf.WriteByte(0xf6)
+	}
+}
+
+func (e *encoder) bool(b bool) {
+	switch {
+	case e.format == MessagePack && b:
+		e.buf.WriteByte(0xc3)
+	case e.format == MessagePack:
+		e.buf.WriteByte(0xc2)
+	case b:
+		e.buf.WriteByte(0xf5)
+	default:
+		e.buf.WriteByte(0xf4)
+	}
+}
+
+// float encodes integral values as integers, which JSON does not
+// distinguish, and others as 64-bit floats.
+func (e *encoder) float(f float64) {
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		e.int(int64(f))
+		return
+	}
+	bits := math.Float64bits(f)
+	if e.format == MessagePack {
+		e.buf.WriteByte(0xcb)
+	} else {
+		e.buf.WriteByte(0xfb)
+	}
+	e.be(bits, 8)
+}
+
+func (e *encoder) int(i int64) {
+	if i >= 0 {
+		e.uint(uint64(i))
+		return
+	}
+	if e.format == MessagePack {
+		switch {
+		case i >= -32:
+			e.buf.WriteByte(byte(i))
+		case i >= math.MinInt8:
+			e.buf.WriteByte(0xd0)
+			e.be(uint64(i), 1)
+		case i >= math.MinInt16:
+			e.buf.WriteByte(0xd1)
+			e.be(uint64(i), 2)
+		case i >= math.MinInt32:
+			e.buf.WriteByte(0xd2)
+			e.be(uint64(i), 4)
+		default:
+			e.buf.WriteByte(0xd3)
+			e.be(uint64(i), 8)
+		}
+		return
+	}
+	e.cborHead(1, uint64(-(i + 1)))
+}
+
+func (e *encoder) uint(u uint64) {
+	if e.format == MessagePack {
+		switch {
+		case u < 0x80:
+			e.buf.WriteByte(byte(u))
+		case u <= math.MaxUint8:
+			e.buf.WriteByte(0xcc)
+			e.be(u, 1)
+		case u <= math.MaxUint16:
+			e.buf.WriteByte(0xcd)
+			e.be(u, 2)
+		case u <= math.MaxUint32:
+			e.buf.WriteByte(0xce)
+			e.be(u, 4)
+		default:
+			e.buf.WriteByte(0xcf)
+			e.be(u, 8)
+		}
+		return
+	}
+	e.cborHead(0, u)
+}
+
+func (e *encoder) text(s string) {
+	if e.format == MessagePack {
+		n := uint64(len(s))
+		switch {
+		case n < 32:
+			e.buf.WriteByte(0xa0 | byte(n))
+		case n <= math.MaxUint8:
+			e.buf.WriteByte(0xd9)
+			e.be(n, 1)
+		case n <= math.MaxUint16:
+			e.buf.WriteByte(0xda)
+			e.be(n, 2)
+		default:
+			e.buf.WriteByte(0xdb)
+			e.be(n, 4)
+		}
+	} else {
+		e.cborHead(3, uint64(len(s)))
+	}
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) bytes(b []byte) {
+	if e.format == MessagePack {
+		n := uint64(len(b))
+		switch {
+		case n <= math.MaxUint8:
+			e.buf.WriteByte(0xc4)
+			e.be(n, 1)
+		case n <= math.MaxUint16:
+			e.buf.WriteByte(0xc5)
+			e.be(n, 2)
+		default:
+			e.buf.WriteByte(0xc6)
+			e.be(n, 4)
+		}
+	} else {
+		e.cborHead(2, uint64(len(b)))
+	}
+	e.buf.Write(b)
+}
+
+func (e *encoder) arrayHeader(n int) {
+	if e.format == MessagePack {
+		e.msgpackHead(0x90, 0xdc, n)
+		return
+	}
+	e.cborHead(4, uint64(n))
+}
+
+func (e *encoder) mapHeader(n int) {
+	if e.format == MessagePack {
+		e.msgpackHead(0x80, 0xde, n)
+		return
+	}
+	e.cborHead(5, uint64(n))
+}
+
+// msgpackHead writes a fixarray/fixmap header or its 16/32-bit form.
+func (e *encoder) msgpackHead(fix, wide byte, n int) {
+	switch {
+	case n < 16:
+		e.buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		e.buf.WriteByte(wide)
+		e.be(uint64(n), 2)
+	default:
+		e.buf.WriteByte(wide + 1)
+		e.be(uint64(n), 4)
+	}
+}
+
+// cborHead writes a CBOR initial byte with the shortest argument encoding.
+func (e *encoder) cborHead(major byte, arg uint64) {
+	m := major << 5
+	switch {
+	case arg < 24:
+		e.buf.WriteByte(m | byte(arg))
+	case arg <= math.MaxUint8:
+		e.buf.WriteByte(m | 24)
+		e.be(arg, 1)
+	case arg <= math.MaxUint16:
+		e.buf.WriteByte(m | 25)
+		e.be(arg, 2)
+	case arg <= math.MaxUint32:
+		e.buf.WriteByte(m | 26)
+		e.be(arg, 4)
+	default:
+		e.buf.WriteByte(m | 27)
+		e.be(arg, 8)
+	}
+}
+
+// be writes the low n bytes of v big-endian.
+func (e *encoder) be(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		e.buf.WriteByte(byte(v >> (8 * i)))
+	}
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *decoder) byte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, errTruncated
+	}
+	b := d.data[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *decoder) take(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errTruncated
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// be reads an n-byte big-endian unsigned integer.
+func (d *decoder) be(n int) (uint64, error) {
+	b, err := d.take(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// count checks that a collection of n items, each at least one byte, can
+// fit in the remaining data, so corrupt lengths can't force huge
+// allocations.
+func (d *decoder) count(n uint64) (int, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return 0, errTruncated
+	}
+	return int(n), nil
+}
+
+func intValue(i int64) json.Number {
+	return json.Number(strconv.FormatInt(i, 10))
+}
+
+func uintValue(u uint64) json.Number {
+	return json.Number(strconv.FormatUint(u, 10))
+}
+
+func floatValue(f float64) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		// JSON has no representation for these.
+		return nil, nil
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+func mapKey(k interface{}) (string, error) {
+	switch k := k.(type) {
+	case string:
+		return k, nil
+	case json.Number:
+		return string(k), nil
+	case bool, nil:
+		return fmt.Sprint(k), nil
+	case []byte:
+		return string(k), nil
+	}
+	return "", fmt.Errorf("binenc: unsupported map key type %T", k)
+}
+
+func (d *decoder) cbor(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("binenc: value nested too deeply")
+	}
+	ib, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	major, info := ib>>5, ib&0x1f
+	if major == 7 {
+		return d.cborSimple(info)
+	}
+	if info == 31 {
+		return d.cborIndefinite(major, depth)
+	}
+	arg, err := d.cborArg(info)
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		return uintValue(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			n := new(big.Int).SetUint64(arg)
+			return json.Number(n.Not(n).String()), nil
+		}
+		return intValue(-1 - int64(arg)), nil
+	case 2:
+		b, err := d.take(arg)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, b...), nil
+	case 3:
+		b, err := d.take(arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case 4:
+		n, err := d.count(arg)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := d.cbor(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case 5:
+		n, err := d.count(arg)
+		if err != nil {
+			return nil, err
+		}
+		out := &object{}
+		for i := 0; i < n; i++ {
+			if err := d.cborEntry(out, depth); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	default: // 6: tag
+		v, err := d.cbor(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return cborTag(arg, v)
+	}
+}
+
+func (d *decoder) cborEntry(out *object, depth int) error {
+	k, err := d.cbor(depth + 1)
+	if err != nil {
+		return err
+	}
+	key, err := mapKey(k)
+	if err != nil {
+		return err
+	}
+	v, err := d.cbor(depth + 1)
+	if err != nil {
+		return err
+	}
+	out.set(key, v)
+	return nil
+}
+
+func (d *decoder) cborArg(info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info == 24:
+		return d.be(1)
+	case info == 25:
+		return d.be(2)
+	case info == 26:
+		return d.be(4)
+	case info == 27:
+		return d.be(8)
+	}
+	return 0, fmt.Errorf("binenc: invalid CBOR argument %d", info)
+}
+
+// cborBreak reports whether the next byte is the break stop code, and
+// consumes it if so.
+func (d *decoder) cborBreak() (bool, error) {
+	if d.pos >= len(d.data) {
+		return false, errTruncated
+	}
+	if d.data[d.pos] == 0xff {
+		d.pos++
+		return true, nil
+	}
+	return false, nil
+}
+
+func (d *decoder) cborIndefinite(major byte, depth int) (interface{}, error) {
+	switch major {
+	case 2, 3:
+		var buf []byte
+		for {
+			done, err := d.cborBreak()
+			if err != nil {
+				return nil, err
+			}
+			if done {
+				break
+			}
+			// Chunks must be definite-length strings of the same type.
+			if ib := d.data[d.pos]; ib>>5 != major || ib&0x1f == 31 {
+				return nil, errors.New("binenc: invalid CBOR string chunk")
+			}
+			chunk, err := d.cbor(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch c := chunk.(type) {
+			case []byte:
+				buf = append(buf, c...)
+			case string:
+				buf = append(buf, c...)
+			}
+		}
+		if major == 3 {
+			return string(buf), nil
+		}
+		if buf == nil {
+			buf = []byte{}
+		}
+		return buf, nil
+	case 4:
+		out := []interface{}{}
+		for {
+			done, err := d.cborBreak()
+			if err != nil {
+				return nil, err
+			}
+			if done {
+				return out, nil
+			}
+			v, err := d.cbor(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+	case 5:
+		out := &object{}
+		for {
+			done, err := d.cborBreak()
+			if err != nil {
+				return nil, err
+			}
+			if done {
+				return out, nil
+			}
+			if err := d.cborEntry(out, depth); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, fmt.Errorf("binenc: invalid indefinite length for CBOR major type %d", major)
+}
+
+func (d *decoder) cborSimple(info byte) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		h, err := d.be(2)
+		if err != nil {
+			return nil, err
+		}
+		return floatValue(halfToFloat(uint16(h)))
+	case 26:
+		b, err := d.be(4)
+		if err != nil {
+			return nil, err
+		}
+		return floatValue(float64(math.Float32frombits(uint32(b))))
+	case 27:
+		b, err := d.be(8)
+		if err != nil {
+			return nil, err
+		}
+		return floatValue(math.Float64frombits(b))
+	}
+	return nil, fmt.Errorf("binenc: unsupported CBOR simple value %d", info)
+}
+
+// cborTag interprets the tags with a JSON equivalent and passes through
+// the content of others.
+func cborTag(tag uint64, v interface{}) (interface{}, error) {
+	switch tag {
+	case 2, 3: // bignums
+		b, ok := v.([]byte)
+		if !ok {
+			return nil, errors.New("binenc: invalid CBOR bignum")
+		}
+		n := new(big.Int).SetBytes(b)
+		if tag == 3 {
+			n.Not(n)
+		}
+		return json.Number(n.String()), nil
+	}
+	return v, nil
+}
+
+// halfToFloat converts an IEEE 754 half-precision float.
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
+
+func (d *decoder) msgpack(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, errors.New("binenc: value nested too deeply")
+	}
+	b, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b <= 0x7f:
+		return intValue(int64(b)), nil
+	case b >= 0xe0:
+		return intValue(int64(int8(b))), nil
+	case b&0xe0 == 0xa0:
+		return d.msgpackString(uint64(b & 0x1f))
+	case b&0xf0 == 0x90:
+		return d.msgpackArray(uint64(b&0x0f), depth)
+	case b&0xf0 == 0x80:
+		return d.msgpackMap(uint64(b&0x0f), depth)
+	}
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.be(1 << (b - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		data, err := d.take(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, data...), nil
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.be(1 << (b - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.msgpackExt(n)
+	case 0xca:
+		v, err := d.be(4)
+		if err != nil {
+			return nil, err
+		}
+		return floatValue(float64(math.Float32frombits(uint32(v))))
+	case 0xcb:
+		v, err := d.be(8)
+		if err != nil {
+			return nil, err
+		}
+		return floatValue(math.Float64frombits(v))
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := d.be(1 << (b - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return uintValue(v), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		v, err := d.be(size)
+		if err != nil {
+			return nil, err
+		}
+		shift := 64 - 8*size
+		return intValue(int64(v<<shift) >> shift), nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.msgpackExt(1 << (b - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.be(1 << (b - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.msgpackString(n)
+	case 0xdc, 0xdd:
+		n, err := d.be(2 << (b - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.msgpackArray(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.be(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.msgpackMap(n, depth)
+	}
+	return nil, fmt.Errorf("binenc: invalid MessagePack type byte 0x%02x", b)
+}
+
+func (d *decoder) msgpackString(n uint64) (interface{}, error) {
+	b, err := d.take(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (d *decoder) msgpackArray(count uint64, depth int) (interface{}, error) {
+	n, err := d.count(count)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := d.msgpack(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (d *decoder) msgpackMap(count uint64, depth int) (interface{}, error) {
+	n, err := d.count(count)
+	if err != nil {
+		return nil, err
+	}
+	out := &object{}
+	for i := 0; i < n; i++ {
+		k, err := d.msgpack(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, err := mapKey(k)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.msgpack(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		out.set(key, v)
+	}
+	return out, nil
+}
+
+// msgpackExt decodes an extension value of n data bytes. Only the
+// timestamp extension (type -1) is supported; it decodes to an RFC 3339
+// string.
+func (d *decoder) msgpackExt(n uint64) (interface{}, error) {
+	typ, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.take(n)
+	if err != nil {
+		return nil, err
+	}
+	if int8(typ) != -1 {
+		return nil, fmt.Errorf("binenc: unsupported MessagePack extension type %d", int8(typ))
+	}
+	var sec, nsec uint64
+	switch len(data) {
+	case 4:
+		sec = uint64(data[0])<<24 | uint64(data[1])<<16 | uint64(data[2])<<8 | uint64(data[3])
+	case 8:
+		var v uint64
+		for _, c := range data {
+			v = v<<8 | uint64(c)
+		}
+		nsec, sec = v>>34, v&(1<<34-1)
+	case 12:
+		for _, c := range data[:4] {
+			nsec = nsec<<8 | uint64(c)
+		}
+		for _, c := range data[4:] {
+			sec = sec<<8 | uint64(c)
+		}
+	default:
+		return nil, errors.New("binenc: invalid MessagePack timestamp")
+	}
+	return time.Unix(int64(sec), int64(nsec)).UTC().Format(time.RFC3339Nano), nil
+}
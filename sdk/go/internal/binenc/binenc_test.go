@@ -0,0 +1,305 @@
+package binenc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type record struct {
+	ID     string                 `json:"id"`
+	Count  int64                  `json:"count"`
+	Big    uint64                 `json:"big"`
+	Ratio  float64                `json:"ratio"`
+	OK     bool                   `json:"ok"`
+	Tags   []string               `json:"tags"`
+	Blob   []byte                 `json:"blob"`
+	Nested map[string]interface{} `json:"nested"`
+	None   *string                `json:"none"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := record{
+		ID:     "rec-é😀",
+		Count:  math.MinInt64,
+		Big:    math.MaxUint64,
+		Ratio:  -1.5e-300,
+		OK:     true,
+		Tags:   []string{"", strings.Repeat("x", 31), strings.Repeat("y", 32), strings.Repeat("z", 70000)},
+		Blob:   []byte{0, 1, 2, 0xff},
+		Nested: map[string]interface{}{"b": []interface{}{1.0, "two", nil, false}, "a": map[string]interface{}{}},
+	}
+	ints := []int64{0, 23, 24, 127, 128, 255, 256, 65535, 65536, 1<<32 - 1, 1 << 32, math.MaxInt64,
+		-1, -24, -25, -32, -33, -128, -129, -32768, -32769, -1 << 31, -1<<31 - 1}
+
+	for _, f := range []Format{CBOR, MessagePack} {
+		data, err := Marshal(f, in)
+		if err != nil {
+			t.Fatalf("%s: Marshal: %v", f, err)
+		}
+		var out record
+		if err := Unmarshal(f, data, &out); err != nil {
+			t.Fatalf("%s: Unmarshal: %v", f, err)
+		}
+		if !reflect.DeepEqual(out, in) {
+			t.Errorf("%s: round trip = %+v, want %+v", f, out, in)
+		}
+
+		data, err = Marshal(f, ints)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gotInts []int64
+		if err := Unmarshal(f, data, &gotInts); err != nil || !reflect.DeepEqual(gotInts, ints) {
+			t.Errorf("%s: integers round-tripped to %v, %v", f, gotInts, err)
+		}
+
+		// A raw JSON document keeps its key order.
+		raw := json.RawMessage(`{"z":1,"a":{"y":[true,null],"b":"c"},"m":1.25}`)
+		if data, err = Marshal(f, raw); err != nil {
+			t.Fatal(err)
+		}
+		j, err := ToJSON(f, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(j) != string(raw) {
+			t.Errorf("%s: ToJSON = %s, want %s", f, j, raw)
+		}
+	}
+}
+
+type vector struct {
+	hex  string
+	json string
+}
+
+var cborVectors = []vector{
+	// RFC 8949, Appendix A.
+	{"00", `0`},
+	{"17", `23`},
+	{"1818", `24`},
+	{"1864", `100`},
+	{"1903e8", `1000`},
+	{"1a000f4240", `1000000`},
+	{"1b000000e8d4a51000", `1000000000000`},
+	{"1bffffffffffffffff", `18446744073709551615`},
+	{"c249010000000000000000", `18446744073709551616`},
+	{"3bffffffffffffffff", `-18446744073709551616`},
+	{"c349010000000000000000", `-18446744073709551617`},
+	{"20", `-1`},
+	{"29", `-10`},
+	{"3863", `-100`},
+	{"3903e7", `-1000`},
+	{"f93e00", `1.5`},
+	{"fa47c35000", `100000`},
+	{"fb3ff199999999999a", `1.1`},
+	{"fb7e37e43c8800759c", `1e+300`},
+	{"f97c00", `null`},
+	{"f97e00", `null`},
+	{"f4", `false`},
+	{"f5", `true`},
+	{"f6", `null`},
+	{"f7", `null`},
+	{"c074323031332d30332d32315432303a30343a30305a", `"2013-03-21T20:04:00Z"`},
+	{"4401020304", `"AQIDBA=="`},
+	{"60", `""`},
+	{"6161", `"a"`},
+	{"6449455446", `"IETF"`},
+	{"62c3bc", `"ü"`},
+	{"80", `[]`},
+	{"83010203", `[1,2,3]`},
+	{"a0", `{}`},
+	{"a26161016162820203", `{"a":1,"b":[2,3]}`},
+	{"5f42010243030405ff", `"AQIDBAU="`},
+	{"7f657374726561646d696e67ff", `"streaming"`},
+	{"9fff", `[]`},
+	{"9f018202039f0405ffff", `[1,[2,3],[4,5]]`},
+	{"bf61610161629f0203ffff", `{"a":1,"b":[2,3]}`},
+	{"bf6346756ef563416d7421ff", `{"Fun":true,"Amt":-2}`},
+}
+
+var msgpackVectors = []vector{
+	{"00", `0`},
+	{"7f", `127`},
+	{"cc80", `128`},
+	{"cd0100", `256`},
+	{"ce00010000", `65536`},
+	{"cfffffffffffffffff", `18446744073709551615`},
+	{"ff", `-1`},
+	{"e0", `-32`},
+	{"d0df", `-33`},
+	{"d1ff7f", `-129`},
+	{"d3ffffffffffffffff", `-1`},
+	{"cb3ff8000000000000", `1.5`},
+	{"ca3fc00000", `1.5`},
+	{"c0", `null`},
+	{"c2", `false`},
+	{"c3", `true`},
+	{"a0", `""`},
+	{"a161", `"a"`},
+	{"d90161", `"a"`},
+	{"c40401020304", `"AQIDBA=="`},
+	{"90", `[]`},
+	{"920102", `[1,2]`},
+	{"dc00020102", `[1,2]`},
+	{"80", `{}`},
+	{"82a17a01a16102", `{"z":1,"a":2}`},
+	{"d6ff00000000", `"1970-01-01T00:00:00Z"`},
+	{"d7ff0000000400000001", `"1970-01-01T00:00:01.000000001Z"`},
+}
+
+func TestVectors(t *testing.T) {
+	for _, tc := range []struct {
+		f       Format
+		vectors []vector
+	}{{CBOR, cborVectors}, {MessagePack, msgpackVectors}} {
+		for _, v := range tc.vectors {
+			data, err := hex.DecodeString(v.hex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ToJSON(tc.f, data)
+			if err != nil {
+				t.Errorf("%s: ToJSON(%s): %v", tc.f, v.hex, err)
+				continue
+			}
+			if string(got) != v.json {
+				t.Errorf("%s: ToJSON(%s) = %s, want %s", tc.f, v.hex, got, v.json)
+			}
+		}
+	}
+}
+
+// TestMarshalVectors checks that Marshal picks the shortest encodings.
+func TestMarshalVectors(t *testing.T) {
+	for _, tc := range []struct {
+		f    Format
+		v    interface{}
+		want string
+	}{
+		{CBOR, 0, "00"},
+		{CBOR, 24, "1818"},
+		{CBOR, uint64(math.MaxUint64), "1bffffffffffffffff"},
+		{CBOR, -1000, "3903e7"},
+		{CBOR, 1.1, "fb3ff199999999999a"},
+		{CBOR, 2.0, "02"},
+		{CBOR, "IETF", "6449455446"},
+		{CBOR, []byte{1, 2, 3, 4}, "4401020304"},
+		{CBOR, json.RawMessage(`{"a":1,"b":[2,3]}`), "a26161016162820203"},
+		{CBOR, map[string]interface{}{"b": true, "a": nil}, "a26161f66162f5"},
+		{MessagePack, 127, "7f"},
+		{MessagePack, 128, "cc80"},
+		{MessagePack, -33, "d0df"},
+		{MessagePack, int64(math.MinInt64), "d38000000000000000"},
+		{MessagePack, 1.5, "cb3ff8000000000000"},
+		{MessagePack, strings.Repeat("a", 32), "d920" + strings.Repeat("61", 32)},
+		{MessagePack, []interface{}{1, 2}, "920102"},
+		{MessagePack, nil, "c0"},
+	} {
+		got, err := Marshal(tc.f, tc.v)
+		if err != nil {
+			t.Errorf("%s: Marshal(%v): %v", tc.f, tc.v, err)
+			continue
+		}
+		if hex.EncodeToString(got) != tc.want {
+			t.Errorf("%s: Marshal(%v) = %x, want %s", tc.f, tc.v, got, tc.want)
+		}
+	}
+}
+
+// TestTruncated decodes every proper prefix of every vector, which must
+// fail without panicking.
+func TestTruncated(t *testing.T) {
+	for _, tc := range []struct {
+		f       Format
+		vectors []vector
+	}{{CBOR, cborVectors}, {MessagePack, msgpackVectors}} {
+		for _, v := range tc.vectors {
+			data, _ := hex.DecodeString(v.hex)
+			for n := 0; n < len(data); n++ {
+				if got, err := ToJSON(tc.f, data[:n]); err == nil {
+					t.Errorf("%s: ToJSON(%x), truncated from %s, = %s", tc.f, data[:n], v.hex, got)
+				}
+			}
+		}
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	deep := func(open byte, leaf byte) []byte {
+		return append(bytes.Repeat([]byte{open}, maxDepth+2), leaf)
+	}
+	for _, tc := range []struct {
+		name string
+		f    Format
+		data []byte
+	}{
+		{"trailing data", CBOR, []byte{0x01, 0x02}},
+		{"reserved argument", CBOR, []byte{0x1c}},
+		{"huge array", CBOR, []byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"huge string", CBOR, []byte{0x7b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"array map key", CBOR, []byte{0xa1, 0x80, 0x01}},
+		{"mixed chunks", CBOR, []byte{0x5f, 0x61, 0x61, 0xff}},
+		{"nested chunk", CBOR, []byte{0x7f, 0x7f, 0xff, 0xff}},
+		{"indefinite integer", CBOR, []byte{0x1f}},
+		{"bignum of text", CBOR, []byte{0xc2, 0x61, 0x61}},
+		{"simple value", CBOR, []byte{0xe0}},
+		{"lone break", CBOR, []byte{0xff}},
+		{"unterminated indefinite", CBOR, []byte{0x9f, 0x01}},
+		{"nested too deeply", CBOR, deep(0x81, 0x00)},
+		{"indefinite nested too deeply", CBOR, deep(0x9f, 0x00)},
+		{"trailing data", MessagePack, []byte{0xc0, 0xc0}},
+		{"never used", MessagePack, []byte{0xc1}},
+		{"huge array", MessagePack, []byte{0xdd, 0xff, 0xff, 0xff, 0xff}},
+		{"huge binary", MessagePack, []byte{0xc6, 0xff, 0xff, 0xff, 0xff}},
+		{"array map key", MessagePack, []byte{0x81, 0x90, 0x01}},
+		{"unknown extension", MessagePack, []byte{0xd4, 0x05, 0x00}},
+		{"bad timestamp size", MessagePack, []byte{0xd5, 0xff, 0x00, 0x00}},
+		{"nested too deeply", MessagePack, deep(0x91, 0x00)},
+	} {
+		if got, err := ToJSON(tc.f, tc.data); err == nil {
+			t.Errorf("%s: %s: ToJSON(%x) = %s, want an error", tc.f, tc.name, tc.data, got)
+		}
+	}
+
+	var v interface{}
+	if err := Unmarshal(CBOR, []byte{0x61, 0x61}, &[]int{}); err == nil {
+		t.Error("Unmarshal of a string into []int succeeded")
+	}
+	if err := Unmarshal(MessagePack, nil, &v); err == nil {
+		t.Error("Unmarshal of no data succeeded")
+	}
+	if _, err := Marshal(CBOR, json.Number("1x")); err == nil {
+		t.Error("Marshal of an invalid json.Number succeeded")
+	}
+	if _, err := Marshal(CBOR, json.RawMessage(`{"a":`)); err == nil {
+		t.Error("Marshal of truncated raw JSON succeeded")
+	}
+}
+
+// FuzzToJSON checks that arbitrary input never panics and that whatever
+// decodes is valid JSON.
+func FuzzToJSON(f *testing.F) {
+	for _, v := range cborVectors {
+		data, _ := hex.DecodeString(v.hex)
+		f.Add(false, data)
+	}
+	for _, v := range msgpackVectors {
+		data, _ := hex.DecodeString(v.hex)
+		f.Add(true, data)
+	}
+	f.Fuzz(func(t *testing.T, msgpack bool, data []byte) {
+		format := CBOR
+		if msgpack {
+			format = MessagePack
+		}
+		if out, err := ToJSON(format, data); err == nil && !json.Valid(out) {
+			t.Errorf("%s: ToJSON(%x) = %s, which is not valid JSON", format, data, out)
+		}
+	})
+}
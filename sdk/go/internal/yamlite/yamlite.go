@@ -0,0 +1,555 @@
+// Package yamlite parses the subset of YAML used by PING configuration and
+// scenario files, keeping the SDK free of third-party dependencies.
+//
+// Supported: block mappings and sequences, "- key: value" sequence items,
+// single-line flow collections ([a, b] and {k: v}), plain, single- and
+// double-quoted scalars, literal (|) and folded (>) block scalars, and
+// comments. Anchors, aliases, tags and multiple documents are not.
+package yamlite
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Unmarshal parses YAML data and stores the result in v using
+// encoding/json semantics, so json struct tags apply.
+func Unmarshal(data []byte, v interface{}) error {
+	tree, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(tree)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Parse parses YAML data into maps, slices and scalars
+// (string, int64, float64, bool or nil).
+func Parse(data []byte) (interface{}, error) {
+	p := &parser{}
+	for i, raw := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		p.lines = append(p.lines, line{raw: raw, num: i + 1})
+	}
+	p.skip()
+	if p.pos < len(p.lines) && strings.TrimSpace(p.lines[p.pos].raw) == "---" {
+		p.pos++
+		p.skip()
+	}
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	ind, err := p.indent()
+	if err != nil {
+		return nil, err
+	}
+	v, err := p.block(ind)
+	if err != nil {
+		return nil, err
+	}
+	p.skip()
+	if p.pos < len(p.lines) {
+		return nil, p.errorf("unexpected content")
+	}
+	return v, nil
+}
+
+type line struct {
+	raw string
+	num int
+	// override replaces the line's content and indent after a "- " prefix
+	// has been consumed.
+	override *string
+	indent   int
+}
+
+type parser struct {
+	lines []line
+	pos   int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	num := 0
+	if p.pos < len(p.lines) {
+		num = p.lines[p.pos].num
+	} else if len(p.lines) > 0 {
+		num = p.lines[len(p.lines)-1].num
+	}
+	return fmt.Errorf("yaml: line %d: %s", num, fmt.Sprintf(format, args...))
+}
+
+// skip advances past blank and comment-only lines.
+func (p *parser) skip() {
+	for p.pos < len(p.lines) {
+		c := strings.TrimSpace(p.content())
+		if c != "" && !strings.HasPrefix(c, "#") {
+			return
+		}
+		p.pos++
+	}
+}
+
+func (p *parser) content() string {
+	l := &p.lines[p.pos]
+	if l.override != nil {
+		return *l.override
+	}
+	return l.raw
+}
+
+func (p *parser) indent() (int, error) {
+	l := &p.lines[p.pos]
+	if l.override != nil {
+		return l.indent, nil
+	}
+	n := 0
+	for n < len(l.raw) && l.raw[n] == ' ' {
+		n++
+	}
+	if n < len(l.raw) && l.raw[n] == '\t' {
+		return 0, p.errorf("tabs are not allowed for indentation")
+	}
+	return n, nil
+}
+
+// text returns the current line without indentation and trailing comment.
+func (p *parser) text() string {
+	c := p.content()
+	if p.lines[p.pos].override == nil {
+		c = strings.TrimLeft(c, " ")
+	}
+	return strings.TrimSpace(stripComment(c))
+}
+
+func isSeqItem(s string) bool {
+	return s == "-" || strings.HasPrefix(s, "- ")
+}
+
+func (p *parser) block(ind int) (interface{}, error) {
+	if isSeqItem(p.text()) {
+		return p.seq(ind)
+	}
+	return p.mapping(ind)
+}
+
+func (p *parser) seq(ind int) (interface{}, error) {
+	out := []interface{}{}
+	for {
+		p.skip()
+		if p.pos >= len(p.lines) {
+			return out, nil
+		}
+		cur, err := p.indent()
+		if err != nil {
+			return nil, err
+		}
+		t := p.text()
+		if cur != ind || !isSeqItem(t) {
+			if cur > ind {
+				return nil, p.errorf("bad indentation")
+			}
+			return out, nil
+		}
+
+		rest := strings.TrimLeft(strings.TrimPrefix(t, "-"), " ")
+		var item interface{}
+		switch {
+		case rest == "":
+			p.pos++
+			item, err = p.nested(ind)
+		case isSeqItem(rest) || (mappingKey(rest) >= 0 && !isFlow(rest)):
+			// Re-read the remainder as the first line of a nested block
+			// indented to where it starts.
+			itemInd := cur + len(t) - len(rest)
+			l := &p.lines[p.pos]
+			l.override, l.indent = &rest, itemInd
+			item, err = p.block(itemInd)
+		default:
+			item, err = p.inline(rest, ind)
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, item)
+	}
+}
+
+func (p *parser) mapping(ind int) (interface{}, error) {
+	out := map[string]interface{}{}
+	for {
+		p.skip()
+		if p.pos >= len(p.lines) {
+			return out, nil
+		}
+		cur, err := p.indent()
+		if err != nil {
+			return nil, err
+		}
+		t := p.text()
+		if cur < ind || isSeqItem(t) && cur == ind {
+			return out, nil
+		}
+		if cur > ind {
+			return nil, p.errorf("bad indentation")
+		}
+
+		i := mappingKey(t)
+		if i < 0 {
+			return nil, p.errorf("expected key: value")
+		}
+		key, err := unquoteKey(strings.TrimSpace(t[:i]))
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		if _, dup := out[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		rest := strings.TrimSpace(t[i+1:])
+
+		var v interface{}
+		if rest == "" {
+			p.pos++
+			v, err = p.nested(ind)
+			if err == nil && v == nil {
+				// "key:" followed by a sequence at the same indentation.
+				p.skip()
+				if p.pos < len(p.lines) {
+					if c, _ := p.indent(); c == ind && isSeqItem(p.text()) {
+						v, err = p.seq(ind)
+					}
+				}
+			}
+		} else {
+			v, err = p.inline(rest, ind)
+		}
+		if err != nil {
+			return nil, err
+		}
+		out[key] = v
+	}
+}
+
+// nested parses the block below a "key:" or "-" line, or returns nil if the
+// next line is not indented further than ind.
+func (p *parser) nested(ind int) (interface{}, error) {
+	p.skip()
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	cur, err := p.indent()
+	if err != nil {
+		return nil, err
+	}
+	if cur <= ind {
+		return nil, nil
+	}
+	return p.block(cur)
+}
+
+// inline parses the value after "key: " or "- " on the current line,
+// consuming following lines for block scalars.
+func (p *parser) inline(rest string, ind int) (interface{}, error) {
+	if rest[0] == '|' || rest[0] == '>' {
+		return p.blockScalar(rest, ind)
+	}
+	p.pos++
+	if isFlow(rest) {
+		v, n, err := parseFlow(rest, 0)
+		if err != nil {
+			p.pos--
+			return nil, p.errorf("%v", err)
+		}
+		if strings.TrimSpace(rest[n:]) != "" {
+			p.pos--
+			return nil, p.errorf("unexpected content after flow collection")
+		}
+		return v, nil
+	}
+	v, err := scalar(rest)
+	if err != nil {
+		p.pos--
+		return nil, p.errorf("%v", err)
+	}
+	return v, nil
+}
+
+func (p *parser) blockScalar(header string, ind int) (interface{}, error) {
+	folded := header[0] == '>'
+	chomp := strings.TrimSpace(header[1:])
+	p.pos++
+
+	var lines []string
+	blockInd := -1
+	for p.pos < len(p.lines) {
+		raw := p.lines[p.pos].raw
+		if strings.TrimSpace(raw) == "" {
+			lines = append(lines, "")
+			p.pos++
+			continue
+		}
+		n := len(raw) - len(strings.TrimLeft(raw, " "))
+		if n <= ind {
+			break
+		}
+		if blockInd < 0 {
+			blockInd = n
+		}
+		if n < blockInd {
+			break
+		}
+		lines = append(lines, raw[blockInd:])
+		p.pos++
+	}
+	// Trailing blank lines belong to chomping, not content.
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+
+	var s string
+	if folded {
+		var b strings.Builder
+		for i, l := range lines {
+			switch {
+			case i == 0:
+			case l == "" || lines[i-1] == "":
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(' ')
+			}
+			b.WriteString(l)
+		}
+		s = b.String()
+	} else {
+		s = strings.Join(lines, "\n")
+	}
+	switch chomp {
+	case "-":
+	case "+":
+		s += "\n" + strings.Repeat("\n", trailing)
+	default:
+		if len(lines) > 0 {
+			s += "\n"
+		}
+	}
+	return s, nil
+}
+
+func isFlow(s string) bool {
+	return s[0] == '[' || s[0] == '{'
+}
+
+// mappingKey returns the index of the colon ending a mapping key in s,
+// or -1 if s is not a key: value line.
+func mappingKey(s string) int {
+	if s == "" || isFlow(s) {
+		return -1
+	}
+	i := 0
+	if s[0] == '"' || s[0] == '\'' {
+		end := closingQuote(s)
+		if end < 0 {
+			return -1
+		}
+		i = end + 1
+	}
+	for ; i < len(s); i++ {
+		if s[i] == ':' && (i+1 == len(s) || s[i+1] == ' ') {
+			return i
+		}
+	}
+	return -1
+}
+
+func unquoteKey(k string) (string, error) {
+	if k != "" && (k[0] == '"' || k[0] == '\'') {
+		v, err := scalar(k)
+		if err != nil {
+			return "", err
+		}
+		return v.(string), nil
+	}
+	return k, nil
+}
+
+// closingQuote returns the index of the quote closing the string that
+// starts at s[0], or -1.
+func closingQuote(s string) int {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case q == '"' && s[i] == '\\':
+			i++
+		case s[i] == q:
+			if q == '\'' && i+1 < len(s) && s[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// stripComment removes a trailing "# comment" outside of quotes.
+func stripComment(s string) string {
+	var q byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case q != 0:
+			if q == '"' && c == '\\' {
+				i++
+			} else if c == q {
+				q = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" :[{,-", rune(s[i-1])) {
+				q = c
+			}
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// scalar resolves a plain or quoted scalar.
+func scalar(s string) (interface{}, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	switch s[0] {
+	case '"':
+		if closingQuote(s) != len(s)-1 {
+			return nil, fmt.Errorf("unterminated string")
+		}
+		var v string
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, fmt.Errorf("bad string %s", s)
+		}
+		return v, nil
+	case '\'':
+		if closingQuote(s) != len(s)-1 {
+			return nil, fmt.Errorf("unterminated string")
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	if strings.ContainsAny(s, "0123456789") && !strings.ContainsAny(s, "_xXoObB") {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f, nil
+		}
+	}
+	return s, nil
+}
+
+// parseFlow parses a flow collection or scalar starting at s[i] and returns
+// the value and the index just past it.
+func parseFlow(s string, i int) (interface{}, int, error) {
+	i = skipSpace(s, i)
+	if i >= len(s) {
+		return nil, i, fmt.Errorf("unexpected end of flow collection")
+	}
+	switch s[i] {
+	case '[':
+		out := []interface{}{}
+		i = skipSpace(s, i+1)
+		if i < len(s) && s[i] == ']' {
+			return out, i + 1, nil
+		}
+		for {
+			v, n, err := parseFlow(s, i)
+			if err != nil {
+				return nil, n, err
+			}
+			out = append(out, v)
+			i = skipSpace(s, n)
+			if i >= len(s) {
+				return nil, i, fmt.Errorf("unterminated flow sequence")
+			}
+			if s[i] == ']' {
+				return out, i + 1, nil
+			}
+			if s[i] != ',' {
+				return nil, i, fmt.Errorf("expected , or ] in flow sequence")
+			}
+			i++
+		}
+	case '{':
+		out := map[string]interface{}{}
+		i = skipSpace(s, i+1)
+		if i < len(s) && s[i] == '}' {
+			return out, i + 1, nil
+		}
+		for {
+			k, n, err := parseFlow(s, i)
+			if err != nil {
+				return nil, n, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				key = fmt.Sprint(k)
+			}
+			i = skipSpace(s, n)
+			if i >= len(s) || s[i] != ':' {
+				return nil, i, fmt.Errorf("expected : in flow mapping")
+			}
+			v, n, err := parseFlow(s, i+1)
+			if err != nil {
+				return nil, n, err
+			}
+			out[key] = v
+			i = skipSpace(s, n)
+			if i >= len(s) {
+				return nil, i, fmt.Errorf("unterminated flow mapping")
+			}
+			if s[i] == '}' {
+				return out, i + 1, nil
+			}
+			if s[i] != ',' {
+				return nil, i, fmt.Errorf("expected , or } in flow mapping")
+			}
+			i++
+		}
+	case '"', '\'':
+		end := closingQuote(s[i:])
+		if end < 0 {
+			return nil, i, fmt.Errorf("unterminated string")
+		}
+		v, err := scalar(s[i : i+end+1])
+		return v, i + end + 1, err
+	}
+
+	start := i
+	for i < len(s) && !strings.ContainsRune(",]}", rune(s[i])) &&
+		!(s[i] == ':' && (i+1 == len(s) || s[i+1] == ' ')) {
+		i++
+	}
+	v, err := scalar(s[start:i])
+	return v, i, err
+}
+
+func skipSpace(s string, i int) int {
+	for i < len(s) && s[i] == ' ' {
+		i++
+	}
+	return i
+}
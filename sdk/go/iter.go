@@ -0,0 +1,208 @@
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// The iterator methods return functions with the shape of Go 1.23's
+// iter.Seq2[T, error], so callers can range over them:
+//
+//	for msg, err := range client.InboxIter(ctx) {
+//		if err != nil {
+//			return err
+//		}
+//		handle(msg)
+//	}
+//
+// Pages are fetched lazily as the loop advances, and except for InboxIter
+// and HistoryIter each page is decoded item by item as it streams in, so iterating a
+// directory of tens of thousands of agents needs memory for one agent at a
+// time. Iteration stops after the first error, which is yielded with a
+// zero value, or when ctx is done.
+
+// nextCursorHeader carries the cursor for the next page of a list
+// response. Servers that do not paginate omit it and return one page.
+const nextCursorHeader = "X-Ping-Next-Cursor"
+
+// historyPageSize is the page size requested by HistoryIter.
+const historyPageSize = 100
+
+// InboxIter iterates over unacknowledged messages, like Inbox.
+func (c *Client) InboxIter(ctx context.Context, reqOpts ...RequestOption) func(yield func(Message, error) bool) {
+	return func(yield func(Message, error) bool) {
+		if c.AgentID() == "" {
+			yield(Message{}, fmt.Errorf("not registered"))
+			return
+		}
+		w := windowOf(reqOpts)
+		paginate(ctx, c, w.query("/agents/"+c.AgentID()+"/inbox"), func(msgs []Message) []Message {
+			return w.filter(c.receive(ctx, msgs))
+		}, reqOpts)(yield)
+	}
+}
+
+// HistoryIter iterates over the conversation with otherID, newest first.
+// Servers that do not paginate history return only the newest page. With
+// WithSince, iteration stops at the first older message. As in History,
+// edit messages are folded into the messages they edit when both are on
+// the same page.
+func (c *Client) HistoryIter(ctx context.Context, otherID string, reqOpts ...RequestOption) func(yield func(Message, error) bool) {
+	return func(yield func(Message, error) bool) {
+		if c.AgentID() == "" {
+			yield(Message{}, fmt.Errorf("not registered"))
+			return
+		}
+		w := windowOf(reqOpts)
+		path := w.query(fmt.Sprintf("/agents/%s/messages/%s?limit=%d", c.AgentID(), otherID, historyPageSize))
+		paginate(ctx, c, path, func(msgs []Message) []Message {
+			c.decodePayloads(ctx, msgs)
+			return applyEdits(msgs)
+		}, reqOpts)(func(msg Message, err error) bool {
+			if err == nil {
+				if !w.since.IsZero() && msg.Timestamp.Before(w.since) {
+					return false
+				}
+				if !w.match(&msg) {
+					return true
+				}
+			}
+			return yield(msg, err)
+		})
+	}
+}
+
+// DirectoryIter iterates over public agents, like Directory.
+func (c *Client) DirectoryIter(ctx context.Context, reqOpts ...RequestOption) func(yield func(Agent, error) bool) {
+	return paginate[Agent](ctx, c, "/directory", nil, reqOpts)
+}
+
+// SearchIter iterates over agents matching opts, like Search.
+func (c *Client) SearchIter(ctx context.Context, opts *SearchOptions, reqOpts ...RequestOption) func(yield func(Agent, error) bool) {
+	return func(yield func(Agent, error) bool) {
+		paginate[Agent](ctx, c, searchPath(opts), nil, reqOpts)(func(a Agent, err error) bool {
+			if err == nil && !opts.matches(&a) {
+				return true
+			}
+			return yield(a, err)
+		})
+	}
+}
+
+// ContactsIter iterates over contacts, like Contacts.
+func (c *Client) ContactsIter(ctx context.Context, reqOpts ...RequestOption) func(yield func(Contact, error) bool) {
+	return func(yield func(Contact, error) bool) {
+		if c.AgentID() == "" {
+			yield(Contact{}, fmt.Errorf("not registered"))
+			return
+		}
+		paginate[Contact](ctx, c, "/agents/"+c.AgentID()+"/contacts", nil, reqOpts)(yield)
+	}
+}
+
+// paginate fetches path page by page, following the next-page cursor, and
+// yields each item. Without prepare, items are decoded and yielded one at
+// a time as the response arrives, so a page is never held in memory.
+// prepare, if set, needs the whole page: it runs on every page before it
+// is yielded and may replace its items.
+func paginate[T any](ctx context.Context, c *Client, path string, prepare func([]T) []T, reqOpts []RequestOption) func(yield func(T, error) bool) {
+	return func(yield func(T, error) bool) {
+		var zero T
+		next := path
+		for next != "" {
+			if err := ctx.Err(); err != nil {
+				yield(zero, err)
+				return
+			}
+			var (
+				cursor string
+				more   = true
+				err    error
+			)
+			if prepare == nil {
+				cursor, err = streamPage(ctx, c, next, reqOpts, func(item T) bool {
+					more = yield(item, nil)
+					return more
+				})
+			} else {
+				var page []T
+				cursor, err = streamPage(ctx, c, next, reqOpts, func(item T) bool {
+					page = append(page, item)
+					return true
+				})
+				if err == nil {
+					for _, item := range prepare(page) {
+						if more = yield(item, nil); !more {
+							break
+						}
+					}
+				}
+			}
+			if !more {
+				return
+			}
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			next = ""
+			if cursor != "" {
+				next = withQuery(path, "cursor", cursor)
+			}
+		}
+	}
+}
+
+// streamPage GETs path and passes each element of the JSON array response
+// to fn until fn returns false. It returns the next-page cursor.
+func streamPage[T any](ctx context.Context, c *Client, path string, reqOpts []RequestOption, fn func(T) bool) (string, error) {
+	resp, err := c.do(ctx, "GET", path, nil, reqOpts...)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return "", readAPIError(resp)
+	}
+	if err := decodeArray(json.NewDecoder(resp.Body), fn); err != nil {
+		return "", err
+	}
+	return resp.Header.Get(nextCursorHeader), nil
+}
+
+// decodeArray decodes a JSON array from dec one element at a time, passing
+// each to fn until fn returns false. A null array has no elements.
+func decodeArray[T any](dec *json.Decoder, fn func(T) bool) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("expected JSON array, got %v", tok)
+	}
+	for dec.More() {
+		var item T
+		if err := dec.Decode(&item); err != nil {
+			return err
+		}
+		if !fn(item) {
+			return nil
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+func withQuery(path, key, value string) string {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + key + "=" + url.QueryEscape(value)
+}
@@ -0,0 +1,60 @@
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aetos53t/ping/sdk/go/pingtest"
+)
+
+// TestHistoryIterAppliesEdits checks that HistoryIter folds edits into the
+// messages they edit, as History does.
+func TestHistoryIterAppliesEdits(t *testing.T) {
+	srv := pingtest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	alice := registerTestClient(t, srv, "alice")
+	bob := registerTestClient(t, srv, "bob")
+
+	sent, err := alice.Text(ctx, bob.AgentID(), "helo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.Send(ctx, bob.AgentID(), MessageTypeEdit, map[string]interface{}{"text": "hello"}, sent.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.Text(ctx, alice.AgentID(), "hi"); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := bob.History(ctx, alice.AgentID(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Message
+	bob.HistoryIter(ctx, alice.AgentID())(func(msg Message, err error) bool {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, msg)
+		return true
+	})
+	if len(got) != 2 || len(want) != 2 {
+		t.Fatalf("HistoryIter yielded %d messages and History returned %d, want 2", len(got), len(want))
+	}
+	for i := range got {
+		if got[i].ID != want[i].ID || string(got[i].Payload) != string(want[i].Payload) || len(got[i].Edits) != len(want[i].Edits) {
+			t.Errorf("message %d: HistoryIter yielded %s %s with %d edits, History %s %s with %d",
+				i, got[i].ID, got[i].Payload, len(got[i].Edits), want[i].ID, want[i].Payload, len(want[i].Edits))
+		}
+	}
+	edited := got[1]
+	var payload struct{ Text string }
+	if err := json.Unmarshal(edited.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if edited.ID != sent.ID || payload.Text != "hello" || len(edited.Edits) != 1 {
+		t.Errorf("edited message = %s %s with %d edits, want %s hello with 1", edited.ID, edited.Payload, len(edited.Edits), sent.ID)
+	}
+}
@@ -0,0 +1,202 @@
+package ping
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxResponseBytes is the default limit on the size of a response
+// body, stream event or decompressed payload. See WithMaxResponseBytes.
+const DefaultMaxResponseBytes = 32 << 20
+
+// maxJSONDepth bounds the nesting of objects and arrays in JSON received
+// from the server or peers.
+const maxJSONDepth = 256
+
+// maxErrorBody bounds how much of an error response is read.
+const maxErrorBody = 64 << 10
+
+// ErrResponseTooLarge is returned when a response body, stream event or
+// decompressed payload exceeds the client's size limit.
+var ErrResponseTooLarge = errors.New("response too large")
+
+// ErrJSONTooDeep is returned when received JSON nests objects and arrays
+// more deeply than the client accepts.
+var ErrJSONTooDeep = errors.New("JSON nested too deeply")
+
+// WithMaxResponseBytes limits the size of each response body, each event
+// of a message stream and each payload decompressed by the gzip and
+// deflate codecs to n bytes, so a buggy or malicious server or peer cannot
+// exhaust the client's memory. Larger ones fail with ErrResponseTooLarge.
+// The default is DefaultMaxResponseBytes; n < 0 removes the limit.
+func WithMaxResponseBytes(n int64) Option {
+	return func(c *Client) {
+		c.maxResponse = n
+	}
+}
+
+// responseLimit returns the configured size limit, or 0 for none.
+func (c *Client) responseLimit() int64 {
+	switch {
+	case c.maxResponse < 0:
+		return 0
+	case c.maxResponse == 0:
+		return DefaultMaxResponseBytes
+	}
+	return c.maxResponse
+}
+
+// limitResponse bounds resp's body by the client's size limit. Event
+// streams are left alone; their events are bounded one at a time as they
+// are read.
+func (c *Client) limitResponse(resp *http.Response) error {
+	limit := c.responseLimit()
+	if limit == 0 || isEventStream(resp) {
+		return nil
+	}
+	if resp.ContentLength > limit {
+		resp.Body.Close()
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrResponseTooLarge, resp.ContentLength, limit)
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: limit, limit: limit}
+	return nil
+}
+
+// guardDepth bounds the nesting of a JSON response body by maxJSONDepth.
+func guardDepth(resp *http.Response) {
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mt == "" || mt == "application/json" || strings.HasSuffix(mt, "+json") {
+		resp.Body = &depthGuard{ReadCloser: resp.Body}
+	}
+}
+
+func isEventStream(resp *http.Response) bool {
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mt == "text/event-stream"
+}
+
+// limitedBody fails with ErrResponseTooLarge once more than limit bytes
+// have been read, rather than silently truncating.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	limit     int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// Probe for a byte past the limit to tell a body of exactly
+		// limit bytes from a larger one.
+		var one [1]byte
+		if n, err := b.ReadCloser.Read(one[:]); n == 0 {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%w: limit %d bytes", ErrResponseTooLarge, b.limit)
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// depthGuard fails with ErrJSONTooDeep if the JSON read through it nests
+// more than maxJSONDepth levels.
+type depthGuard struct {
+	io.ReadCloser
+	depth    int
+	inString bool
+	escaped  bool
+}
+
+func (g *depthGuard) Read(p []byte) (int, error) {
+	n, err := g.ReadCloser.Read(p)
+	if scanErr := g.scan(p[:n]); scanErr != nil {
+		return 0, scanErr
+	}
+	return n, err
+}
+
+// scan tracks nesting across b, which continues the bytes already seen.
+func (g *depthGuard) scan(b []byte) error {
+	for _, ch := range b {
+		switch {
+		case g.escaped:
+			g.escaped = false
+		case g.inString:
+			switch ch {
+			case '\\':
+				g.escaped = true
+			case '"':
+				g.inString = false
+			}
+		case ch == '"':
+			g.inString = true
+		case ch == '{' || ch == '[':
+			if g.depth++; g.depth > maxJSONDepth {
+				return fmt.Errorf("%w: more than %d levels", ErrJSONTooDeep, maxJSONDepth)
+			}
+		case ch == '}' || ch == ']':
+			g.depth--
+		}
+	}
+	return nil
+}
+
+// checkJSONDepth returns an error matching ErrJSONTooDeep if data nests
+// more than maxJSONDepth levels.
+func checkJSONDepth(data []byte) error {
+	var g depthGuard
+	return g.scan(data)
+}
+
+// readLimited reads all of r, failing with ErrResponseTooLarge past limit
+// bytes. A limit of 0 reads without bound.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: limit %d bytes", ErrResponseTooLarge, limit)
+	}
+	return data, nil
+}
+
+// readLine reads one line from r, without its line ending. A line longer
+// than limit bytes is consumed and reported as ErrResponseTooLarge. A
+// limit of 0 reads without bound.
+func readLine(r *bufio.Reader, limit int64) (string, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLong {
+			if limit > 0 && int64(len(line)+len(chunk)) > limit {
+				tooLong, line = true, nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if tooLong {
+			return "", fmt.Errorf("%w: line exceeds %d bytes", ErrResponseTooLarge, limit)
+		}
+		return string(bytes.TrimRight(line, "\r\n")), nil
+	}
+}
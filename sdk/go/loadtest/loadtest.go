@@ -0,0 +1,453 @@
+// Package loadtest drives synthetic agents against a PING server and
+// reports send latency, delivery latency and delivery success, for
+// checking a server's capacity before a rollout.
+//
+// A run registers Agents fresh agents, sends messages between them in the
+// configured Pattern for Duration or until Messages have been sent, and
+// polls their inboxes until every message arrives or DeliveryTimeout
+// passes:
+//
+//	report, err := loadtest.Run(ctx, loadtest.Config{
+//		BaseURL:  "http://staging:3100",
+//		Agents:   50,
+//		Pattern:  loadtest.PatternRandom,
+//		Rate:     200,
+//		Duration: time.Minute,
+//	})
+//	report.WriteTo(os.Stdout)
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	ping "github.com/aetos53t/ping/sdk/go"
+	"github.com/aetos53t/ping/sdk/go/pingtest"
+)
+
+// Pattern chooses the sender and recipient of each message.
+type Pattern string
+
+// Send patterns.
+const (
+	// PatternRandom sends from a random agent to another random agent.
+	PatternRandom Pattern = "random"
+	// PatternRing has each agent send to the next, in turn.
+	PatternRing Pattern = "ring"
+	// PatternFanIn has every other agent send to the first, as clients of
+	// a busy service agent would.
+	PatternFanIn Pattern = "fan-in"
+	// PatternFanOut has the first agent send to every other, as a
+	// coordinator would.
+	PatternFanOut Pattern = "fan-out"
+)
+
+// Payload fields identifying load-test messages.
+const (
+	seqField    = "loadtestSeq"
+	sentAtField = "loadtestSentAt"
+)
+
+// Config describes a load test. Zero fields take their defaults.
+type Config struct {
+	// BaseURL is the server under test. If empty, an in-memory server is
+	// started, which measures the SDK alone.
+	BaseURL string
+	// Agents is how many synthetic agents to register. Defaults to 10;
+	// at least 2 are needed.
+	Agents int
+	// Pattern defaults to PatternRandom.
+	Pattern Pattern
+	// Rate is the target number of messages sent per second across all
+	// agents. Zero sends as fast as Concurrency allows.
+	Rate float64
+	// Duration bounds the sending phase. Defaults to 10s.
+	Duration time.Duration
+	// Messages, if positive, stops sending after this many messages.
+	Messages int
+	// Concurrency is the most sends in flight at once. Defaults to Agents.
+	Concurrency int
+	// PayloadSize is the size in bytes of each message's text. Defaults
+	// to 64.
+	PayloadSize int
+	// PollInterval is how often each agent polls its inbox. Defaults to
+	// 100ms.
+	PollInterval time.Duration
+	// DeliveryTimeout is how long to keep polling for undelivered
+	// messages once sending stops. Defaults to 5s.
+	DeliveryTimeout time.Duration
+	// Options apply to every agent's client, e.g. ping.WithRetries(0).
+	Options []ping.Option
+}
+
+// Percentiles summarizes a latency distribution.
+type Percentiles struct {
+	P50, P90, P95, P99, Max, Mean time.Duration
+}
+
+// Report is the outcome of a load test.
+type Report struct {
+	Agents  int
+	Pattern Pattern
+	// Sent counts messages the server accepted; SendErrors counts sends
+	// that failed, by error message.
+	Sent       int
+	SendErrors map[string]int
+	// Delivered counts accepted messages that reached their recipient's
+	// inbox.
+	Delivered int
+	// Duration is the length of the sending phase.
+	Duration time.Duration
+	// SendLatency is the time for the server to accept a message;
+	// DeliveryLatency the time from sending until the recipient polled it.
+	SendLatency     Percentiles
+	DeliveryLatency Percentiles
+}
+
+// Failed returns the number of sends that failed.
+func (r *Report) Failed() int {
+	n := 0
+	for _, c := range r.SendErrors {
+		n += c
+	}
+	return n
+}
+
+// Throughput returns accepted messages per second.
+func (r *Report) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Sent) / r.Duration.Seconds()
+}
+
+// DeliveryRate returns the fraction of accepted messages that were
+// delivered, from 0 to 1.
+func (r *Report) DeliveryRate() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	return float64(r.Delivered) / float64(r.Sent)
+}
+
+// WriteTo writes a human-readable summary to w.
+func (r *Report) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d agents, %s, %s\n", r.Agents, r.Pattern, r.Duration.Round(time.Millisecond))
+	fmt.Fprintf(&b, "  sent %d (%.1f/s), failed %d, delivered %d (%.2f%%)\n",
+		r.Sent, r.Throughput(), r.Failed(), r.Delivered, 100*r.DeliveryRate())
+	tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "  latency\tp50\tp90\tp95\tp99\tmax\tmean\n")
+	for _, row := range []struct {
+		name string
+		p    Percentiles
+	}{{"send", r.SendLatency}, {"delivery", r.DeliveryLatency}} {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\t%s\t%s\n", row.name,
+			round(row.p.P50), round(row.p.P90), round(row.p.P95), round(row.p.P99), round(row.p.Max), round(row.p.Mean))
+	}
+	tw.Flush()
+	errs := make([]string, 0, len(r.SendErrors))
+	for msg := range r.SendErrors {
+		errs = append(errs, msg)
+	}
+	sort.Strings(errs)
+	for _, msg := range errs {
+		fmt.Fprintf(&b, "  %dx %s\n", r.SendErrors[msg], msg)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
+
+// run holds the state of one load test.
+type run struct {
+	cfg     Config
+	clients []*ping.Client
+
+	mu        sync.Mutex
+	sent      map[int64]bool // accepted, not yet delivered
+	delivered map[int64]bool
+	sendLat   []time.Duration
+	delivLat  []time.Duration
+	errors    map[string]int
+}
+
+// Run executes a load test. The returned error reports setup failures;
+// send and delivery failures are counted in the report.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	cfg.setDefaults()
+	if cfg.Agents < 2 {
+		return nil, fmt.Errorf("loadtest needs at least 2 agents, got %d", cfg.Agents)
+	}
+	switch cfg.Pattern {
+	case PatternRandom, PatternRing, PatternFanIn, PatternFanOut:
+	default:
+		return nil, fmt.Errorf("unknown pattern %q", cfg.Pattern)
+	}
+	if cfg.BaseURL == "" {
+		srv := pingtest.NewServer()
+		defer srv.Close()
+		cfg.BaseURL = srv.URL
+	}
+
+	r := &run{
+		cfg:       cfg,
+		sent:      make(map[int64]bool),
+		delivered: make(map[int64]bool),
+		errors:    make(map[string]int),
+	}
+	if err := r.register(ctx); err != nil {
+		return nil, err
+	}
+
+	pollCtx, stopPolling := context.WithCancel(ctx)
+	var pollers sync.WaitGroup
+	for _, c := range r.clients {
+		pollers.Add(1)
+		go func(c *ping.Client) {
+			defer pollers.Done()
+			r.poll(pollCtx, c)
+		}(c)
+	}
+
+	start := time.Now()
+	r.send(ctx)
+	duration := time.Since(start)
+
+	deadline := time.NewTimer(cfg.DeliveryTimeout)
+wait:
+	for r.outstanding() > 0 {
+		select {
+		case <-ctx.Done():
+			break wait
+		case <-deadline.C:
+			break wait
+		case <-time.After(cfg.PollInterval):
+		}
+	}
+	deadline.Stop()
+	stopPolling()
+	pollers.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &Report{
+		Agents:          cfg.Agents,
+		Pattern:         cfg.Pattern,
+		Sent:            len(r.sendLat),
+		SendErrors:      r.errors,
+		Delivered:       len(r.delivLat),
+		Duration:        duration,
+		SendLatency:     percentiles(r.sendLat),
+		DeliveryLatency: percentiles(r.delivLat),
+	}, nil
+}
+
+func (cfg *Config) setDefaults() {
+	if cfg.Agents == 0 {
+		cfg.Agents = 10
+	}
+	if cfg.Pattern == "" {
+		cfg.Pattern = PatternRandom
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = 10 * time.Second
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = cfg.Agents
+	}
+	if cfg.PayloadSize <= 0 {
+		cfg.PayloadSize = 64
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 100 * time.Millisecond
+	}
+	if cfg.DeliveryTimeout <= 0 {
+		cfg.DeliveryTimeout = 5 * time.Second
+	}
+}
+
+// register creates the synthetic agents.
+func (r *run) register(ctx context.Context) error {
+	suffix := fmt.Sprintf("%d", time.Now().UnixNano())
+	for i := 0; i < r.cfg.Agents; i++ {
+		c := ping.NewClient(r.cfg.BaseURL, r.cfg.Options...)
+		if _, err := c.Register(ctx, fmt.Sprintf("loadtest-%d-%s", i, suffix), &ping.RegisterOptions{Provider: "loadtest"}); err != nil {
+			return fmt.Errorf("register agent %d: %w", i, err)
+		}
+		r.clients = append(r.clients, c)
+	}
+	return nil
+}
+
+// send runs the sending phase. Sends in flight when Duration ends are
+// completed, so every message the server accepts is counted.
+func (r *run) send(ctx context.Context) {
+	genCtx, cancel := context.WithTimeout(ctx, r.cfg.Duration)
+	defer cancel()
+
+	// seqs hands out message numbers, paced by Rate if set.
+	seqs := make(chan int64)
+	go func() {
+		ctx := genCtx
+		defer close(seqs)
+		var tick <-chan time.Time
+		if interval := time.Duration(float64(time.Second) / r.cfg.Rate); r.cfg.Rate > 0 && interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for seq := int64(0); r.cfg.Messages <= 0 || seq < int64(r.cfg.Messages); seq++ {
+			if tick != nil {
+				select {
+				case <-ctx.Done():
+					return
+				case <-tick:
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case seqs <- seq:
+			}
+		}
+	}()
+
+	text := strings.Repeat("x", r.cfg.PayloadSize)
+	var wg sync.WaitGroup
+	for w := 0; w < r.cfg.Concurrency; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(w)))
+			for seq := range seqs {
+				from, to := r.pick(rng, seq)
+				r.sendOne(ctx, from, to, seq, text)
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// pick returns the sender and recipient of message seq.
+func (r *run) pick(rng *rand.Rand, seq int64) (from, to int) {
+	n := len(r.clients)
+	switch r.cfg.Pattern {
+	case PatternRing:
+		from = int(seq % int64(n))
+		return from, (from + 1) % n
+	case PatternFanIn:
+		return 1 + int(seq%int64(n-1)), 0
+	case PatternFanOut:
+		return 0, 1 + int(seq%int64(n-1))
+	}
+	from = rng.Intn(n)
+	to = rng.Intn(n - 1)
+	if to >= from {
+		to++
+	}
+	return from, to
+}
+
+func (r *run) sendOne(ctx context.Context, from, to int, seq int64, text string) {
+	t0 := time.Now()
+	_, err := r.clients[from].Send(ctx, r.clients[to].AgentID(), ping.MessageTypeText, map[string]interface{}{
+		"text":      text,
+		seqField:    seq,
+		sentAtField: t0.UnixNano(),
+	}, "")
+	lat := time.Since(t0)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		if ctx.Err() == nil {
+			r.errors[err.Error()]++
+		}
+		return
+	}
+	r.sendLat = append(r.sendLat, lat)
+	if !r.delivered[seq] {
+		r.sent[seq] = true
+	}
+}
+
+// poll drains c's inbox until ctx is done, recording deliveries.
+func (r *run) poll(ctx context.Context, c *ping.Client) {
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		msgs, err := c.Inbox(ctx)
+		if err == nil {
+			now := time.Now()
+			for _, m := range msgs {
+				r.deliver(m, now)
+				c.Ack(ctx, m.ID)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliver records the arrival of m, once per message.
+func (r *run) deliver(m ping.Message, now time.Time) {
+	var p struct {
+		Seq    *int64 `json:"loadtestSeq"`
+		SentAt int64  `json:"loadtestSentAt"`
+	}
+	if m.DecodePayload(&p) != nil || p.Seq == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.delivered[*p.Seq] {
+		return
+	}
+	r.delivered[*p.Seq] = true
+	delete(r.sent, *p.Seq)
+	r.delivLat = append(r.delivLat, now.Sub(time.Unix(0, p.SentAt)))
+}
+
+// outstanding returns how many accepted messages are not yet delivered.
+func (r *run) outstanding() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.sent)
+}
+
+func percentiles(ds []time.Duration) Percentiles {
+	if len(ds) == 0 {
+		return Percentiles{}
+	}
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	at := func(q float64) time.Duration {
+		return sorted[int(q*float64(len(sorted)-1))]
+	}
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	return Percentiles{
+		P50:  at(0.50),
+		P90:  at(0.90),
+		P95:  at(0.95),
+		P99:  at(0.99),
+		Max:  sorted[len(sorted)-1],
+		Mean: sum / time.Duration(len(sorted)),
+	}
+}
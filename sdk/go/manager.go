@@ -0,0 +1,293 @@
+package ping
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// ErrUnknownAgent is returned by Manager when a delivery is addressed to an
+// agent it does not hold.
+var ErrUnknownAgent = errors.New("unknown agent")
+
+// maxWebhookBody bounds the size of a webhook delivery read by Manager.
+const maxWebhookBody = 4 << 20
+
+// HeaderWebhookSecret carries the shared secret of a webhook delivery.
+const HeaderWebhookSecret = "X-Ping-Webhook-Secret"
+
+// Manager runs several agent identities against one server in one process.
+// Its clients share a single HTTP client, and so one connection pool, and
+// inbound deliveries from webhooks (ServeHTTP) or streams (Run) are routed
+// to the handler of the addressed identity.
+type Manager struct {
+	// OnError, if set, receives handler and stream errors from Run.
+	OnError func(error)
+	// WebhookSecret, if set, is required of webhook deliveries to
+	// ServeHTTP, in the X-Ping-Webhook-Secret header or the "secret"
+	// query parameter, e.g. in a webhook URL ending "?secret=...".
+	WebhookSecret string
+
+	baseURL string
+	opts    []Option
+	http    *http.Client
+
+	mu       sync.RWMutex
+	clients  map[string]*Client
+	handlers map[string]Handler
+	runCtx   context.Context
+	wg       sync.WaitGroup
+	pool     *workerPool
+}
+
+// NewManager creates a manager for baseURL. opts apply to every client it
+// creates; transport options are applied once to the shared HTTP client.
+func NewManager(baseURL string, opts ...Option) *Manager {
+	template := NewClient(baseURL, opts...)
+	return &Manager{
+		baseURL:  baseURL,
+		opts:     opts,
+		http:     template.httpClient,
+		clients:  make(map[string]*Client),
+		handlers: make(map[string]Handler),
+		pool:     template.newWorkerPool(),
+	}
+}
+
+// newClient creates a client on the shared HTTP client, unless opts give
+// it an HTTP client of its own.
+func (m *Manager) newClient(opts []Option) *Client {
+	var shared *http.Client
+	own := false
+	all := append([]Option{}, m.opts...)
+	all = append(all, func(c *Client) { shared = c.httpClient })
+	all = append(all, opts...)
+	all = append(all, func(c *Client) { own = c.httpClient != shared })
+	c := NewClient(m.baseURL, all...)
+	if !own {
+		c.httpClient = m.http
+	}
+	return c
+}
+
+// Register registers a new agent and adds it to the manager.
+func (m *Manager) Register(ctx context.Context, name string, opts *RegisterOptions, reqOpts ...RequestOption) (*Client, error) {
+	c := m.newClient(nil)
+	if _, err := c.Register(ctx, name, opts, reqOpts...); err != nil {
+		return nil, err
+	}
+	m.add(c)
+	return c, nil
+}
+
+// Add adds an existing identity, e.g. one read with LoadPortableIdentity.
+// opts apply to this identity's client only; with WithHTTPClient among
+// them it uses that HTTP client instead of the shared one.
+func (m *Manager) Add(id *PortableIdentity, opts ...Option) (*Client, error) {
+	if id.AgentID == "" {
+		return nil, fmt.Errorf("identity has no agent ID")
+	}
+	c := m.newClient(opts)
+	if err := c.UsePortableIdentity(id); err != nil {
+		return nil, err
+	}
+	m.add(c)
+	return c, nil
+}
+
+// RegisterOrLoad adds the identity saved at path, registering and saving
+// a new one if the file does not exist; see Client.RegisterOrLoad.
+func (m *Manager) RegisterOrLoad(ctx context.Context, path, name string, opts *RegisterOptions, reqOpts ...RequestOption) (*Client, error) {
+	c := m.newClient(nil)
+	if _, err := c.RegisterOrLoad(ctx, path, name, opts, reqOpts...); err != nil {
+		return nil, err
+	}
+	m.add(c)
+	return c, nil
+}
+
+func (m *Manager) add(c *Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := c.AgentID()
+	m.clients[id] = c
+	if m.runCtx != nil {
+		m.subscribe(m.runCtx, id)
+	}
+}
+
+// Remove drops an identity. Its stream, if running, stops at the next
+// message. Adding an identity again replaces its client.
+func (m *Manager) Remove(agentID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.clients, agentID)
+	delete(m.handlers, agentID)
+}
+
+// Client returns the client for agentID, or nil.
+func (m *Manager) Client(agentID string) *Client {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.clients[agentID]
+}
+
+// AgentIDs returns the IDs of the managed identities, sorted.
+func (m *Manager) AgentIDs() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ids := make([]string, 0, len(m.clients))
+	for id := range m.clients {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Handle sets the handler for messages delivered to agentID.
+func (m *Manager) Handle(agentID string, h Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[agentID] = h
+}
+
+// Dispatch passes msg to the handler of its recipient after decoding its
+// payload with that identity's client. Pieces of a chunked message are
+// held until the whole message has arrived. It returns an error wrapping
+// ErrUnknownAgent if the recipient is not managed or has no handler.
+func (m *Manager) Dispatch(ctx context.Context, msg Message) error {
+	m.mu.RLock()
+	c, h := m.clients[msg.To], m.handlers[msg.To]
+	m.mu.RUnlock()
+	if c == nil || h == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownAgent, msg.To)
+	}
+	for _, m := range c.receive(ctx, []Message{msg}) {
+		if err := h(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP receives webhook deliveries for all managed identities. Point
+// each agent's webhook URL at it. If WebhookSecret is set, deliveries
+// without it get 401. Unknown recipients get 404 and handler errors 500,
+// so the server falls back to the inbox. Test deliveries from TestWebhook
+// are answered without reaching handlers. Signatures are not checked;
+// handlers that need to can call VerifyMessage.
+func (m *Manager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkJSONDepth(body); err != nil {
+		http.Error(w, "invalid message: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var msg Message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		http.Error(w, "invalid message: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if m.Client(msg.To) == nil {
+		http.Error(w, ErrUnknownAgent.Error(), http.StatusNotFound)
+		return
+	}
+	if m.WebhookSecret != "" && !m.hasWebhookSecret(r) {
+		http.Error(w, "missing webhook secret", http.StatusUnauthorized)
+		return
+	}
+	if msg.Type == MessageTypeWebhookTest {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := m.Dispatch(r.Context(), msg); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrUnknownAgent) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// hasWebhookSecret reports whether r carries the manager's webhook secret.
+func (m *Manager) hasWebhookSecret(r *http.Request) bool {
+	if m.WebhookSecret == "" {
+		return false
+	}
+	got := r.Header.Get(HeaderWebhookSecret)
+	if got == "" {
+		got = r.URL.Query().Get("secret")
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(m.WebhookSecret)) == 1
+}
+
+// Run implements Service. It streams messages for every managed identity,
+// including ones added while it runs, and dispatches them until ctx is
+// done. With WithWorkers among the manager's options, the identities
+// share one pool of n workers. The server must support FeatureStream.
+func (m *Manager) Run(ctx context.Context) error {
+	m.mu.Lock()
+	if m.runCtx != nil {
+		m.mu.Unlock()
+		return fmt.Errorf("manager already running")
+	}
+	m.runCtx = ctx
+	for id := range m.clients {
+		m.subscribe(ctx, id)
+	}
+	m.mu.Unlock()
+
+	<-ctx.Done()
+	m.wg.Wait()
+	m.pool.wait()
+	m.mu.Lock()
+	m.runCtx = nil
+	m.mu.Unlock()
+	return nil
+}
+
+// subscribe streams messages for agentID until ctx is done or the
+// identity is removed. m.mu must be held.
+func (m *Manager) subscribe(ctx context.Context, agentID string) {
+	c := m.clients[agentID]
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		msgs, err := c.Stream(ctx)
+		if err != nil {
+			reportError(m.OnError, fmt.Errorf("stream %s: %w", agentID, err))
+			return
+		}
+		hctx := context.WithoutCancel(ctx)
+		for msg := range msgs {
+			m.mu.RLock()
+			cur, h := m.clients[agentID], m.handlers[agentID]
+			m.mu.RUnlock()
+			if cur != c {
+				return
+			}
+			if h == nil {
+				reportError(m.OnError, fmt.Errorf("%w: %s has no handler", ErrUnknownAgent, agentID))
+				continue
+			}
+			m.pool.handle(hctx, msg, h, m.OnError)
+		}
+	}()
+}
@@ -0,0 +1,109 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aetos53t/ping/sdk/go/pingtest"
+)
+
+// TestManagerWebhook delivers messages from pingtest to a Manager's
+// webhook endpoint, as the server delivers them, with its receipt time as
+// the timestamp.
+func TestManagerWebhook(t *testing.T) {
+	srv := pingtest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	sender := registerTestClient(t, srv, "sender")
+
+	for _, secret := range []string{"", "s3cret"} {
+		m := NewManager(srv.URL)
+		m.WebhookSecret = secret
+		hook := httptest.NewServer(m)
+		defer hook.Close()
+
+		support, err := m.Register(ctx, "support", &RegisterOptions{WebhookURL: hook.URL + "?secret=" + secret})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sales, err := m.Register(ctx, "sales", &RegisterOptions{WebhookURL: hook.URL + "?secret=" + secret})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var mu sync.Mutex
+		var got []Message
+		m.Handle(support.AgentID(), func(ctx context.Context, msg Message) error {
+			if err := support.VerifyMessage(ctx, msg); err != nil {
+				t.Errorf("VerifyMessage on webhook delivery: %v", err)
+			}
+			mu.Lock()
+			got = append(got, msg)
+			mu.Unlock()
+			return nil
+		})
+		m.Handle(sales.AgentID(), func(ctx context.Context, msg Message) error {
+			return errors.New("sales is closed")
+		})
+
+		res, err := sender.Text(ctx, support.AgentID(), "hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.DeliveryMethod != "webhook" || !res.Delivered {
+			t.Errorf("secret %q: delivered %v by %q, want by webhook", secret, res.Delivered, res.DeliveryMethod)
+		}
+		mu.Lock()
+		if len(got) != 1 || got[0].ID != res.ID || got[0].PayloadString("text") != "hello" {
+			t.Errorf("secret %q: handler got %+v", secret, got)
+		}
+		mu.Unlock()
+
+		// A handler error makes the server fall back to the inbox.
+		res, err = sender.Text(ctx, sales.AgentID(), "anyone there?")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.DeliveryMethod != "polling" {
+			t.Errorf("secret %q: failed delivery went by %q, want polling", secret, res.DeliveryMethod)
+		}
+		inbox, err := sales.Inbox(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(inbox) != 1 || inbox[0].ID != res.ID {
+			t.Errorf("secret %q: sales inbox has %d messages, want the failed delivery", secret, len(inbox))
+		}
+	}
+}
+
+func TestManagerWebhookRejects(t *testing.T) {
+	m := NewManager("http://ping.test")
+	m.WebhookSecret = "s3cret"
+	if _, err := m.Add(testIdentity()); err != nil {
+		t.Fatal(err)
+	}
+	m.Handle("agent-1", func(ctx context.Context, msg Message) error { return nil })
+
+	for _, tc := range []struct {
+		name, query, body string
+		want              int
+	}{
+		{"no secret", "", `{"id":"m1","type":"text","from":"x","to":"agent-1","payload":{}}`, http.StatusUnauthorized},
+		{"wrong secret", "?secret=nope", `{"id":"m1","type":"text","from":"x","to":"agent-1","payload":{}}`, http.StatusUnauthorized},
+		{"unknown agent", "?secret=s3cret", `{"id":"m1","type":"text","from":"x","to":"agent-2","payload":{}}`, http.StatusNotFound},
+		{"invalid JSON", "?secret=s3cret", `{"id":`, http.StatusBadRequest},
+		{"test delivery", "?secret=s3cret", `{"id":"m1","type":"webhook.test","from":"x","to":"agent-1"}`, http.StatusNoContent},
+		{"delivery", "?secret=s3cret", `{"id":"m1","type":"text","from":"x","to":"agent-1","payload":{}}`, http.StatusNoContent},
+	} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest("POST", "/hook"+tc.query, strings.NewReader(tc.body)))
+		if rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+}
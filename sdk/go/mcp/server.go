@@ -0,0 +1,292 @@
+// Package mcp exposes a PING client as a Model Context Protocol tool
+// server, so LLM hosts that speak MCP can message other agents directly.
+//
+// The server offers four tools, all acting as the wrapped client's agent:
+// send_message, check_inbox, search_agents and reply. Serve speaks MCP's
+// stdio transport; Server is also an http.Handler for hosts that connect
+// over HTTP:
+//
+//	client := ping.NewClient("http://localhost:3100")
+//	client.GenerateKeys()
+//	if _, err := client.Register(ctx, "assistant", nil); err != nil {
+//		log.Fatal(err)
+//	}
+//	log.Fatal(mcp.NewServer(client).Serve(ctx, os.Stdin, os.Stdout))
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	ping "github.com/aetos53t/ping/sdk/go"
+)
+
+// LatestProtocolVersion is the newest MCP revision the server speaks. It
+// also accepts the older revisions hosts still commonly request.
+const LatestProtocolVersion = "2025-06-18"
+
+var supportedVersions = map[string]bool{
+	"2024-11-05":          true,
+	"2025-03-26":          true,
+	LatestProtocolVersion: true,
+}
+
+// JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+// Server is an MCP tool server backed by a PING client.
+type Server struct {
+	client       *ping.Client
+	name         string
+	version      string
+	instructions string
+
+	// seen remembers who sent the messages check_inbox returned, so reply
+	// works on servers without message lookup.
+	mu       sync.Mutex
+	seen     map[string]ping.Message
+	seenList []string
+}
+
+// maxSeen bounds how many inbox messages the server remembers for reply.
+const maxSeen = 1024
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithServerInfo sets the name and version the server reports to hosts.
+// They default to "ping" and the PING protocol version.
+func WithServerInfo(name, version string) Option {
+	return func(s *Server) {
+		s.name, s.version = name, version
+	}
+}
+
+// WithInstructions sets guidance the host may add to the model's context,
+// such as which agents to talk to.
+func WithInstructions(text string) Option {
+	return func(s *Server) {
+		s.instructions = text
+	}
+}
+
+// NewServer creates an MCP server for c. The client must be registered, or
+// have its keys loaded, before tools are called.
+func NewServer(c *ping.Client, opts ...Option) *Server {
+	s := &Server{client: c, name: "ping", version: ping.ProtocolVersion, seen: map[string]ping.Message{}}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+// Serve reads newline-delimited JSON-RPC messages from r and writes the
+// responses to w, as MCP's stdio transport does, until r reaches EOF or
+// ctx is done. Requests are handled one at a time, in order.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	lines := make(chan []byte)
+	errc := make(chan error, 1)
+	go func() {
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), 16<<20)
+		for sc.Scan() {
+			line := append([]byte(nil), sc.Bytes()...)
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+		errc <- sc.Err()
+	}()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errc:
+			return err
+		case line := <-lines:
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			out := s.handle(ctx, line)
+			if out == nil {
+				continue
+			}
+			if err := enc.Encode(out); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ServeHTTP handles JSON-RPC messages posted to it, in the single-response
+// form of MCP's HTTP transport. It does not open server-sent event streams,
+// since the server never sends requests of its own.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 16<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	out := s.handle(r.Context(), body)
+	if out == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// handle processes one message or batch and returns what to send back, or
+// nil if it held only notifications.
+func (s *Server) handle(ctx context.Context, data []byte) interface{} {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return errorResponse(nil, codeParseError, "parse error: "+err.Error())
+		}
+		if len(batch) == 0 {
+			return errorResponse(nil, codeInvalidRequest, "empty batch")
+		}
+		var out []*response
+		for _, m := range batch {
+			if resp := s.handleOne(ctx, m); resp != nil {
+				out = append(out, resp)
+			}
+		}
+		if len(out) == 0 {
+			return nil
+		}
+		return out
+	}
+	if resp := s.handleOne(ctx, data); resp != nil {
+		return resp
+	}
+	return nil
+}
+
+func (s *Server) handleOne(ctx context.Context, data json.RawMessage) *response {
+	var req request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return errorResponse(nil, codeParseError, "parse error: "+err.Error())
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return errorResponse(req.ID, codeInvalidRequest, "invalid request")
+	}
+	result, err := s.dispatch(ctx, &req)
+	if len(req.ID) == 0 {
+		// Notifications get no response, even when they fail.
+		return nil
+	}
+	if err != nil {
+		if rerr, ok := err.(*rpcError); ok {
+			return errorResponse(req.ID, rerr.Code, rerr.Message)
+		}
+		return errorResponse(req.ID, codeInternalError, err.Error())
+	}
+	if result == nil {
+		result = struct{}{}
+	}
+	return &response{JSONRPC: "2.0", ID: req.ID, Result: result}
+}
+
+func (s *Server) dispatch(ctx context.Context, req *request) (interface{}, error) {
+	switch req.Method {
+	case "initialize":
+		var p struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		if err := unmarshalParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		version := p.ProtocolVersion
+		if !supportedVersions[version] {
+			version = LatestProtocolVersion
+		}
+		result := map[string]interface{}{
+			"protocolVersion": version,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{"listChanged": false}},
+			"serverInfo":      map[string]string{"name": s.name, "version": s.version},
+		}
+		if s.instructions != "" {
+			result["instructions"] = s.instructions
+		}
+		return result, nil
+	case "ping":
+		return struct{}{}, nil
+	case "tools/list":
+		return map[string]interface{}{"tools": toolList}, nil
+	case "tools/call":
+		var p struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := unmarshalParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return s.callTool(ctx, p.Name, p.Arguments)
+	}
+	if strings.HasPrefix(req.Method, "notifications/") {
+		return nil, nil
+	}
+	return nil, &rpcError{Code: codeMethodNotFound, Message: "method not found: " + req.Method}
+}
+
+func unmarshalParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{Code: codeInvalidParams, Message: "invalid params: " + err.Error()}
+	}
+	return nil
+}
+
+func errorResponse(id json.RawMessage, code int, msg string) *response {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return &response{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: msg}}
+}
@@ -0,0 +1,263 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	ping "github.com/aetos53t/ping/sdk/go"
+)
+
+type tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"inputSchema"`
+}
+
+var toolList = []tool{
+	{
+		Name:        "send_message",
+		Description: "Send a message to another agent. Give text for a text message, or a type and payload for anything else.",
+		InputSchema: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"to": {"type": "string", "description": "ID of the recipient agent"},
+				"text": {"type": "string", "description": "Text to send"},
+				"type": {"type": "string", "description": "Message type, such as text or request; defaults to text"},
+				"payload": {"type": "object", "description": "Message payload, used instead of text"},
+				"replyTo": {"type": "string", "description": "ID of the message this one answers"}
+			},
+			"required": ["to"]
+		}`),
+	},
+	{
+		Name:        "check_inbox",
+		Description: "List unacknowledged messages sent to this agent, oldest first. Set ack to mark them handled so they are not returned again.",
+		InputSchema: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"ack": {"type": "boolean", "description": "Acknowledge the returned messages"},
+				"limit": {"type": "integer", "minimum": 1, "description": "Return at most this many messages"}
+			}
+		}`),
+	},
+	{
+		Name:        "search_agents",
+		Description: "Search the directory for agents by name, capability or provider.",
+		InputSchema: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"query": {"type": "string", "description": "Text to match against agent names"},
+				"capability": {"type": "string", "description": "Capability the agents must have"},
+				"provider": {"type": "string", "description": "Provider the agents must belong to"}
+			}
+		}`),
+	},
+	{
+		Name:        "reply",
+		Description: "Reply to a received message. Requests get a response message; other messages get the type given, or text.",
+		InputSchema: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"messageId": {"type": "string", "description": "ID of the message to reply to"},
+				"text": {"type": "string", "description": "Text to send"},
+				"type": {"type": "string", "description": "Message type; defaults to response for requests and text otherwise"},
+				"payload": {"type": "object", "description": "Message payload, used instead of text"}
+			},
+			"required": ["messageId"]
+		}`),
+	},
+}
+
+// messageView is how messages are shown to the model: only the fields it
+// can act on.
+type messageView struct {
+	ID        string           `json:"id"`
+	Type      ping.MessageType `json:"type"`
+	From      string           `json:"from"`
+	Payload   json.RawMessage  `json:"payload,omitempty"`
+	ReplyTo   string           `json:"replyTo,omitempty"`
+	Timestamp ping.Timestamp   `json:"timestamp"`
+}
+
+type agentView struct {
+	ID           string                      `json:"id"`
+	Name         string                      `json:"name"`
+	Provider     string                      `json:"provider,omitempty"`
+	Capabilities []string                    `json:"capabilities,omitempty"`
+	Descriptors  []ping.CapabilityDescriptor `json:"capabilityDescriptors,omitempty"`
+}
+
+type sendArgs struct {
+	To        string                 `json:"to"`
+	MessageID string                 `json:"messageId"`
+	Text      string                 `json:"text"`
+	Type      string                 `json:"type"`
+	Payload   map[string]interface{} `json:"payload"`
+	ReplyTo   string                 `json:"replyTo"`
+}
+
+// content builds the message to send from text or payload.
+func (a *sendArgs) content(defaultType ping.MessageType) (ping.MessageType, map[string]interface{}) {
+	msgType := defaultType
+	if a.Type != "" {
+		msgType = ping.MessageType(a.Type)
+	}
+	payload := a.Payload
+	if payload == nil && a.Text != "" {
+		payload = map[string]interface{}{"text": a.Text}
+	}
+	return msgType, payload
+}
+
+// callTool runs a tool. Failures of the tool itself are reported in the
+// result, with isError set, so the model sees them; only unknown tools and
+// malformed arguments are protocol errors.
+func (s *Server) callTool(ctx context.Context, name string, args json.RawMessage) (interface{}, error) {
+	var (
+		out interface{}
+		err error
+	)
+	switch name {
+	case "send_message":
+		var a sendArgs
+		if err := unmarshalArgs(args, &a); err != nil {
+			return nil, err
+		}
+		if a.To == "" {
+			return nil, &rpcError{Code: codeInvalidParams, Message: "send_message: to is required"}
+		}
+		msgType, payload := a.content(ping.MessageTypeText)
+		out, err = s.client.Send(ctx, a.To, msgType, payload, a.ReplyTo)
+	case "check_inbox":
+		var a struct {
+			Ack   bool `json:"ack"`
+			Limit int  `json:"limit"`
+		}
+		if err := unmarshalArgs(args, &a); err != nil {
+			return nil, err
+		}
+		out, err = s.checkInbox(ctx, a.Ack, a.Limit)
+	case "search_agents":
+		var a struct {
+			Query      string `json:"query"`
+			Capability string `json:"capability"`
+			Provider   string `json:"provider"`
+		}
+		if err := unmarshalArgs(args, &a); err != nil {
+			return nil, err
+		}
+		var agents []ping.Agent
+		agents, err = s.client.Search(ctx, &ping.SearchOptions{Query: a.Query, Capability: a.Capability, Provider: a.Provider})
+		views := make([]agentView, 0, len(agents))
+		for _, ag := range agents {
+			views = append(views, agentView{ID: ag.ID, Name: ag.Name, Provider: ag.Provider, Capabilities: ag.Capabilities, Descriptors: ag.Descriptors})
+		}
+		out = views
+	case "reply":
+		var a sendArgs
+		if err := unmarshalArgs(args, &a); err != nil {
+			return nil, err
+		}
+		if a.MessageID == "" {
+			return nil, &rpcError{Code: codeInvalidParams, Message: "reply: messageId is required"}
+		}
+		out, err = s.reply(ctx, &a)
+	default:
+		return nil, &rpcError{Code: codeInvalidParams, Message: "unknown tool: " + name}
+	}
+	if err != nil {
+		return toolResult(err.Error(), true), nil
+	}
+	text, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	return toolResult(string(text), false), nil
+}
+
+func (s *Server) checkInbox(ctx context.Context, ack bool, limit int) ([]messageView, error) {
+	msgs, err := s.client.Inbox(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(msgs) > limit {
+		msgs = msgs[:limit]
+	}
+	views := make([]messageView, 0, len(msgs))
+	for _, m := range msgs {
+		views = append(views, messageView{ID: m.ID, Type: m.Type, From: m.From, Payload: m.Payload, ReplyTo: m.ReplyTo, Timestamp: m.Timestamp})
+		s.remember(m)
+	}
+	if ack {
+		var errs []error
+		for _, m := range msgs {
+			if err := s.client.Ack(ctx, m.ID); err != nil {
+				errs = append(errs, fmt.Errorf("ack %s: %w", m.ID, err))
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			return nil, err
+		}
+	}
+	return views, nil
+}
+
+// remember records m for reply, forgetting the oldest message once
+// maxSeen are held.
+func (s *Server) remember(m ping.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.seen[m.ID]; ok {
+		return
+	}
+	if len(s.seenList) == maxSeen {
+		delete(s.seen, s.seenList[0])
+		s.seenList = s.seenList[1:]
+	}
+	s.seen[m.ID] = m
+	s.seenList = append(s.seenList, m.ID)
+}
+
+// lookup finds a message to reply to: one check_inbox returned, or else
+// one the server can look up.
+func (s *Server) lookup(ctx context.Context, id string) (*ping.Message, error) {
+	s.mu.Lock()
+	m, ok := s.seen[id]
+	s.mu.Unlock()
+	if ok {
+		return &m, nil
+	}
+	return s.client.GetMessage(ctx, id)
+}
+
+func (s *Server) reply(ctx context.Context, a *sendArgs) (*ping.SendResult, error) {
+	orig, err := s.lookup(ctx, a.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	defaultType := ping.MessageTypeText
+	if orig.Type == ping.MessageTypeRequest {
+		defaultType = ping.MessageTypeResponse
+	}
+	msgType, payload := a.content(defaultType)
+	return s.client.Reply(ctx, *orig, msgType, payload)
+}
+
+func unmarshalArgs(args json.RawMessage, v interface{}) error {
+	if len(args) == 0 || string(args) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(args, v); err != nil {
+		return &rpcError{Code: codeInvalidParams, Message: "invalid arguments: " + err.Error()}
+	}
+	return nil
+}
+
+func toolResult(text string, isError bool) map[string]interface{} {
+	return map[string]interface{}{
+		"content": []map[string]string{{"type": "text", "text": text}},
+		"isError": isError,
+	}
+}
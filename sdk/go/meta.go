@@ -0,0 +1,65 @@
+package ping
+
+import "context"
+
+type metaKey struct{}
+
+// WithMeta returns a copy of ctx carrying metadata key=value, such as a
+// trace, tenant or run ID. Messages sent with the context carry its
+// metadata in the "meta" field of their envelope, outside the signed
+// payload, where the recipient reads it with Message.Meta. A later value
+// for the same key replaces an earlier one.
+func WithMeta(ctx context.Context, key, value string) context.Context {
+	return withMetaMap(ctx, map[string]string{key: value})
+}
+
+// MetaFromContext returns the metadata added to ctx with WithMeta, or nil.
+// The map must not be modified.
+func MetaFromContext(ctx context.Context) map[string]string {
+	meta, _ := ctx.Value(metaKey{}).(map[string]string)
+	return meta
+}
+
+// Meta returns the metadata m was sent with, or nil if it has none. The
+// metadata is not signed, so it must not be trusted for authorization.
+func (m Message) Meta() map[string]string {
+	return m.Metadata
+}
+
+// MetaMiddleware adds the metadata of the message being handled to the
+// handler's context, so messages the handler sends, such as replies and
+// requests to other agents, carry it on. Metadata already in the context
+// takes precedence.
+func MetaMiddleware(next Handler) Handler {
+	return func(ctx context.Context, msg Message) error {
+		incoming := msg.Meta()
+		if len(incoming) == 0 {
+			return next(ctx, msg)
+		}
+		meta := make(map[string]string, len(incoming))
+		for k, v := range incoming {
+			meta[k] = v
+		}
+		for k, v := range MetaFromContext(ctx) {
+			meta[k] = v
+		}
+		return next(context.WithValue(ctx, metaKey{}, meta), msg)
+	}
+}
+
+// withMetaMap returns a copy of ctx with the metadata in add added,
+// replacing values for the same keys.
+func withMetaMap(ctx context.Context, add map[string]string) context.Context {
+	if len(add) == 0 {
+		return ctx
+	}
+	prev := MetaFromContext(ctx)
+	meta := make(map[string]string, len(prev)+len(add))
+	for k, v := range prev {
+		meta[k] = v
+	}
+	for k, v := range add {
+		meta[k] = v
+	}
+	return context.WithValue(ctx, metaKey{}, meta)
+}
@@ -0,0 +1,29 @@
+package ping
+
+import "context"
+
+// Middleware wraps a Handler, e.g. to enforce a policy before it runs.
+type Middleware func(Handler) Handler
+
+// Chain wraps h with middleware. The first middleware is outermost.
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// Middleware drops messages from senders the organization policy does not
+// allow: next is not called, the drop is logged at warning level to
+// LoggerFromContext, and nil is returned so the message is acknowledged
+// rather than delivered again.
+func (p *OrgPolicy) Middleware(next Handler) Handler {
+	return func(ctx context.Context, msg Message) error {
+		if !p.Allows(msg.From) {
+			LoggerFromContext(ctx).Warn("dropped message: sender not allowed by org policy",
+				"msg_id", msg.ID, "from", msg.From, "type", string(msg.Type))
+			return nil
+		}
+		return next(ctx, msg)
+	}
+}
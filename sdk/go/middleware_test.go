@@ -0,0 +1,60 @@
+package ping
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aetos53t/ping/sdk/go/pingtest"
+)
+
+// TestOrgPolicyMiddlewareAcks checks that a message from a blocked sender
+// is skipped and acknowledged, so it is not delivered again.
+func TestOrgPolicyMiddlewareAcks(t *testing.T) {
+	srv := pingtest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	friend := registerTestClient(t, srv, "friend")
+	spammer := registerTestClient(t, srv, "spammer")
+	c := registerTestClient(t, srv, "agent")
+
+	if _, err := spammer.Text(ctx, c.AgentID(), "buy now"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := friend.Text(ctx, c.AgentID(), "hi"); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &OrgPolicy{Blocked: []string{spammer.AgentID()}}
+	handled := make(chan Message, 2)
+	var errs []error
+	poller := &Poller{
+		Client: c,
+		Handler: policy.Middleware(func(ctx context.Context, msg Message) error {
+			handled <- msg
+			return nil
+		}),
+		OnError: func(err error) { errs = append(errs, err) },
+	}
+	if !poller.poll(ctx) {
+		t.Fatal("poll found no messages")
+	}
+	close(handled)
+
+	var from []string
+	for msg := range handled {
+		from = append(from, msg.From)
+	}
+	if len(from) != 1 || from[0] != friend.AgentID() {
+		t.Errorf("handler saw messages from %v, want only %s", from, friend.AgentID())
+	}
+	if len(errs) != 0 {
+		t.Errorf("OnError got %v, want no errors", errs)
+	}
+	inbox, err := c.Inbox(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox) != 0 {
+		t.Errorf("inbox still has %d messages, want the dropped one acknowledged", len(inbox))
+	}
+}
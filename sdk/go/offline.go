@@ -0,0 +1,242 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrOffline is returned, without contacting the server, by calls made
+// while the client is offline that cannot be queued or served from the
+// local store.
+var ErrOffline = errors.New("client is offline")
+
+// DeliveryQueued is the DeliveryMethod of a send queued while offline.
+const DeliveryQueued = "queued"
+
+// Connectivity is the connection state of a client. See GoOffline.
+type Connectivity int
+
+const (
+	// Online sends requests to the server; it is the normal state.
+	Online Connectivity = iota
+	// Offline queues sends and acks and serves reads from the local store.
+	Offline
+	// Reconnecting is the state while Reconnect checks the server and
+	// flushes the queue.
+	Reconnecting
+)
+
+func (s Connectivity) String() string {
+	switch s {
+	case Offline:
+		return "offline"
+	case Reconnecting:
+		return "reconnecting"
+	}
+	return "online"
+}
+
+// WithOfflineOutbox sets the outbox that Send queues messages in while the
+// client is offline, and that Reconnect flushes. Give the outbox a Path
+// so queued messages survive restarts. If o.Client is nil it is set to
+// the new client.
+func WithOfflineOutbox(o *Outbox) Option {
+	return func(c *Client) {
+		if o.Client == nil {
+			o.Client = c
+		}
+		c.offline.outbox = o
+	}
+}
+
+// WithOnConnectivity registers fn to be called after every change of the
+// client's Connectivity.
+func WithOnConnectivity(fn func(Connectivity)) Option {
+	return func(c *Client) {
+		c.offline.notify = fn
+	}
+}
+
+// offlineState tracks a client's connectivity and the acks it queued
+// while offline.
+type offlineState struct {
+	outbox *Outbox
+	notify func(Connectivity)
+
+	mu    sync.Mutex
+	state Connectivity
+	acks  []string
+}
+
+// Connectivity returns the client's connection state.
+func (c *Client) Connectivity() Connectivity {
+	c.offline.mu.Lock()
+	defer c.offline.mu.Unlock()
+	return c.offline.state
+}
+
+// Offline reports whether the client is offline.
+func (c *Client) Offline() bool {
+	return c.Connectivity() == Offline
+}
+
+// GoOffline switches the client to offline mode, for agents that lose
+// connectivity routinely, such as those at the edge. Until Reconnect
+// succeeds no request reaches the server:
+//
+//   - Send queues messages in the outbox set by WithOfflineOutbox and
+//     returns a result with DeliveryMethod DeliveryQueued and no ID.
+//     Without an outbox it fails with ErrOffline.
+//   - Ack queues the acknowledgement and marks the stored message
+//     acknowledged. Queued acks are kept in memory only.
+//   - Inbox and History are served from the client's Store: Inbox returns
+//     stored messages to the agent that are not acknowledged. Without a
+//     store they fail with ErrOffline.
+//   - Every other call fails with ErrOffline.
+func (c *Client) GoOffline() {
+	c.setConnectivity(Offline)
+}
+
+// Reconnect brings an offline client back online. It checks that the
+// server is reachable, sends the messages and acks queued while offline,
+// and syncs the inbox into the local store. If the server cannot be
+// reached the client stays offline. Errors from the server itself while
+// flushing leave the client online, with the failed message still queued.
+// reqOpts apply to the reachability check, the queued acks and the inbox
+// sync, not to the queued messages.
+func (c *Client) Reconnect(ctx context.Context, reqOpts ...RequestOption) error {
+	id := c.AgentID()
+	if id == "" {
+		return fmt.Errorf("not registered")
+	}
+	c.setConnectivity(Reconnecting)
+	if err := c.request(ctx, "GET", "/agents/"+id, nil, nil, reqOpts...); err != nil {
+		c.reconnectFailed(err)
+		return err
+	}
+	c.setConnectivity(Online)
+
+	if o := c.offline.outbox; o != nil {
+		if err := o.Flush(ctx); err != nil {
+			c.reconnectFailed(err)
+			return fmt.Errorf("flush outbox: %w", err)
+		}
+	}
+	if err := c.flushAcks(ctx, reqOpts); err != nil {
+		c.reconnectFailed(err)
+		return fmt.Errorf("flush acks: %w", err)
+	}
+	if c.store != nil {
+		if _, err := c.Inbox(ctx, reqOpts...); err != nil {
+			c.reconnectFailed(err)
+			return fmt.Errorf("sync inbox: %w", err)
+		}
+	}
+	return nil
+}
+
+// reconnectFailed returns the client to offline mode if err shows the
+// server is unreachable rather than refusing a request.
+func (c *Client) reconnectFailed(err error) {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		c.setConnectivity(Offline)
+	}
+}
+
+func (c *Client) setConnectivity(s Connectivity) {
+	c.offline.mu.Lock()
+	changed := c.offline.state != s
+	c.offline.state = s
+	c.offline.mu.Unlock()
+	if changed && c.offline.notify != nil {
+		c.offline.notify(s)
+	}
+}
+
+// queueSend queues a message sent while offline. Sends from an outbox
+// flush are not queued again.
+func (c *Client) queueSend(ctx context.Context, to string, msgType MessageType, payload map[string]interface{}, replyTo string, reqOpts []RequestOption) (*SendResult, error) {
+	o := c.offline.outbox
+	if o == nil || newCallConfig(reqOpts).noQueue {
+		return nil, ErrOffline
+	}
+	if err := o.enqueue(OutboxEntry{To: to, Type: msgType, Payload: payload, ReplyTo: replyTo, Meta: MetaFromContext(ctx)}); err != nil {
+		return nil, err
+	}
+	return &SendResult{DeliveryMethod: DeliveryQueued}, nil
+}
+
+// withoutQueue makes a send made while offline fail with ErrOffline
+// rather than be queued, for sends from an outbox.
+func withoutQueue() RequestOption {
+	return func(cfg *callConfig) {
+		cfg.noQueue = true
+	}
+}
+
+// queueAck queues an acknowledgement made while offline.
+func (c *Client) queueAck(ctx context.Context, messageID string) {
+	c.offline.mu.Lock()
+	c.offline.acks = append(c.offline.acks, messageID)
+	c.offline.mu.Unlock()
+	c.markAcked(ctx, messageID)
+}
+
+// flushAcks sends the acks queued while offline, keeping any that fail.
+func (c *Client) flushAcks(ctx context.Context, reqOpts []RequestOption) error {
+	c.offline.mu.Lock()
+	acks := c.offline.acks
+	c.offline.acks = nil
+	c.offline.mu.Unlock()
+	for i, id := range acks {
+		if err := c.ack(ctx, id, reqOpts); err != nil {
+			c.offline.mu.Lock()
+			c.offline.acks = append(acks[i:len(acks):len(acks)], c.offline.acks...)
+			c.offline.mu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+// markAcked records in the local store that a message was acknowledged,
+// so offline reads leave it out.
+func (c *Client) markAcked(ctx context.Context, messageID string) {
+	if c.store == nil {
+		return
+	}
+	if m, err := c.store.Load(ctx, messageID); err == nil && !m.Acknowledged {
+		m.Acknowledged = true
+		c.store.Save(ctx, *m)
+	}
+}
+
+// offlineInbox serves Inbox from the local store.
+func (c *Client) offlineInbox(ctx context.Context) ([]Message, error) {
+	if c.store == nil {
+		return nil, ErrOffline
+	}
+	stored, err := c.store.List(ctx, StoreFilter{})
+	if err != nil {
+		return nil, err
+	}
+	me := c.AgentID()
+	var msgs []Message
+	for _, m := range stored {
+		if m.To == me && !m.Acknowledged {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs, nil
+}
+
+// offlineHistory serves History from the local store.
+func (c *Client) offlineHistory(ctx context.Context, otherID string, limit int) ([]Message, error) {
+	if c.store == nil {
+		return nil, ErrOffline
+	}
+	return c.store.List(ctx, StoreFilter{Peer: otherID, Limit: limit})
+}
@@ -0,0 +1,221 @@
+package ping
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for API requests. Transport
+// options such as WithProxy are applied on top of its transport when it is
+// an *http.Transport.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithStore keeps a local copy of sent and received messages in s.
+func WithStore(s Store) Option {
+	return func(c *Client) {
+		c.store = s
+	}
+}
+
+// WithProxy routes API requests through the given HTTP or SOCKS5 proxy
+// instead of the one named by the environment.
+func WithProxy(proxyURL *url.URL) Option {
+	return func(c *Client) {
+		c.transport.proxy = proxyURL
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used to connect to the server,
+// e.g. to present client certificates for mTLS. WithClientCertificate
+// loads the certificate from files instead and follows rotations.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *Client) {
+		c.transport.tls = cfg
+	}
+}
+
+// WithRootCAs trusts the given certificate pool instead of the system
+// roots, for servers behind a private CA.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(c *Client) {
+		c.transport.rootCAs = pool
+	}
+}
+
+// WithTimeout sets the time limit for each request, including reading
+// the response. The default is 30s. WithCallTimeout overrides it for a
+// single call.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithRetries sets how many times idempotent requests (GET, PUT, DELETE)
+// are retried after a network error or a 429, 502, 503 or 504 response.
+// The default is 2; 0 disables retries.
+func WithRetries(n int) Option {
+	return func(c *Client) {
+		c.retries = n
+	}
+}
+
+// RequestOption configures a single API call.
+type RequestOption func(*callConfig)
+
+// callConfig is the per-call configuration built from RequestOptions.
+type callConfig struct {
+	timeout time.Duration
+	header  http.Header
+	noRetry bool
+	window  messageWindow
+	thread  string
+	// noRecord keeps a sent message out of the client's store.
+	noRecord bool
+	// noQueue fails a send made while offline with ErrOffline instead of
+	// queueing it.
+	noQueue bool
+}
+
+func newCallConfig(opts []RequestOption) *callConfig {
+	cfg := &callConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// WithCallTimeout bounds the call, including reading the response, by d
+// instead of the client's default timeout. It may be longer than the
+// default, e.g. for large history downloads.
+func WithCallTimeout(d time.Duration) RequestOption {
+	return func(cfg *callConfig) {
+		cfg.timeout = d
+	}
+}
+
+// WithHeader adds a header to the call's HTTP request. It cannot replace
+// the SDK's signature or session headers.
+func WithHeader(key, value string) RequestOption {
+	return func(cfg *callConfig) {
+		if cfg.header == nil {
+			cfg.header = make(http.Header)
+		}
+		cfg.header.Add(key, value)
+	}
+}
+
+// WithoutRetry sends the call once even if it is idempotent.
+func WithoutRetry() RequestOption {
+	return func(cfg *callConfig) {
+		cfg.noRetry = true
+	}
+}
+
+// Connection pool defaults for the client's own transport. The standard
+// library keeps only 2 idle connections per host, which forces a fleet of
+// agents on one server to keep opening new ones.
+const (
+	defaultMaxIdleConns        = 256
+	defaultMaxIdleConnsPerHost = 64
+)
+
+// WithConnectionPool sets how many connections the client keeps open to
+// the server: at most maxPerHost in use and maxPerHost idle. The default
+// is 64 idle and no limit on connections in use. It also applies to a
+// transport given with WithHTTPClient.
+func WithConnectionPool(maxPerHost int) Option {
+	return func(c *Client) {
+		c.transport.maxPerHost = maxPerHost
+	}
+}
+
+// transportConfig collects transport options until NewClient applies them.
+type transportConfig struct {
+	proxy      *url.URL
+	tls        *tls.Config
+	rootCAs    *x509.CertPool
+	maxPerHost int
+	clientCert *certReloader
+	// unixSocket is set by NewClient for unix:// base URLs.
+	unixSocket string
+}
+
+func (tc *transportConfig) empty() bool {
+	return tc.proxy == nil && tc.tls == nil && tc.rootCAs == nil && tc.clientCert == nil &&
+		tc.maxPerHost == 0 && tc.unixSocket == ""
+}
+
+// apply returns a copy of hc whose transport reflects the configured
+// options. hc itself is not modified. Without a transport of its own, hc
+// gets one tuned for throughput: HTTP/2 where the server offers it and a
+// larger idle connection pool.
+func (tc *transportConfig) apply(hc *http.Client) *http.Client {
+	var t *http.Transport
+	switch base := hc.Transport.(type) {
+	case nil:
+		t = http.DefaultTransport.(*http.Transport).Clone()
+		t.ForceAttemptHTTP2 = true
+		t.MaxIdleConns = defaultMaxIdleConns
+		t.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	case *http.Transport:
+		if tc.empty() {
+			return hc
+		}
+		t = base.Clone()
+	default:
+		// Custom round trippers own their connection settings.
+		return hc
+	}
+
+	if tc.proxy != nil {
+		t.Proxy = http.ProxyURL(tc.proxy)
+	}
+	if tc.unixSocket != "" {
+		socket := tc.unixSocket
+		var d net.Dialer
+		t.Proxy = nil
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, "unix", socket)
+		}
+	}
+	if tc.tls != nil {
+		t.TLSClientConfig = tc.tls.Clone()
+	}
+	if tc.rootCAs != nil {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		t.TLSClientConfig.RootCAs = tc.rootCAs
+	}
+	if tc.clientCert != nil {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		t.TLSClientConfig.Certificates = nil
+		t.TLSClientConfig.GetClientCertificate = tc.clientCert.GetClientCertificate
+	}
+	if tc.maxPerHost > 0 {
+		t.MaxConnsPerHost = tc.maxPerHost
+		t.MaxIdleConnsPerHost = tc.maxPerHost
+		if t.MaxIdleConns != 0 && t.MaxIdleConns < tc.maxPerHost {
+			t.MaxIdleConns = tc.maxPerHost
+		}
+	}
+
+	copied := *hc
+	copied.Transport = t
+	return &copied
+}
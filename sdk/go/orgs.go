@@ -0,0 +1,222 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// FeatureOrgs is organization accounts with shared contacts and policy.
+const FeatureOrgs Feature = "orgs"
+
+// Org is an organization of agents sharing a contact directory and
+// block/allow lists.
+type Org struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	OwnerID   string    `json:"ownerId"`
+	PublicKey string    `json:"publicKey,omitempty"` // hex key that signs role claims
+	Members   []string  `json:"members"`
+	CreatedAt Timestamp `json:"createdAt"`
+}
+
+// OrgPolicy holds an organization's shared block and allow lists. An empty
+// allow list allows every agent that is not blocked.
+type OrgPolicy struct {
+	Blocked []string `json:"blocked"`
+	Allowed []string `json:"allowed"`
+}
+
+// Allows reports whether the policy permits messages from agentID.
+func (p *OrgPolicy) Allows(agentID string) bool {
+	for _, id := range p.Blocked {
+		if id == agentID {
+			return false
+		}
+	}
+	if len(p.Allowed) == 0 {
+		return true
+	}
+	for _, id := range p.Allowed {
+		if id == agentID {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateOrg creates an organization owned by the client's agent, which
+// becomes its first member.
+func (c *Client) CreateOrg(ctx context.Context, name string, reqOpts ...RequestOption) (*Org, error) {
+	if err := c.requireOrgs(ctx); err != nil {
+		return nil, err
+	}
+	var org Org
+	body := map[string]interface{}{"name": name, "ownerId": c.AgentID()}
+	if err := c.request(ctx, "POST", "/orgs", body, &org, reqOpts...); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// GetOrg gets an organization by ID.
+func (c *Client) GetOrg(ctx context.Context, orgID string, reqOpts ...RequestOption) (*Org, error) {
+	if err := c.requireOrgs(ctx); err != nil {
+		return nil, err
+	}
+	var org Org
+	if err := c.request(ctx, "GET", orgPath(orgID), nil, &org, reqOpts...); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// Orgs lists the organizations the client's agent belongs to.
+func (c *Client) Orgs(ctx context.Context, reqOpts ...RequestOption) ([]Org, error) {
+	if err := c.requireOrgs(ctx); err != nil {
+		return nil, err
+	}
+	var orgs []Org
+	if err := c.request(ctx, "GET", "/agents/"+c.AgentID()+"/orgs", nil, &orgs, reqOpts...); err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+// JoinOrg adds agentID to an organization. Pass the client's own AgentID
+// to join; adding other agents requires being the owner.
+func (c *Client) JoinOrg(ctx context.Context, orgID, agentID string, reqOpts ...RequestOption) error {
+	if err := c.requireOrgs(ctx); err != nil {
+		return err
+	}
+	body := map[string]interface{}{"agentId": agentID}
+	return c.request(ctx, "POST", orgPath(orgID, "members"), body, nil, reqOpts...)
+}
+
+// LeaveOrg removes agentID from an organization.
+func (c *Client) LeaveOrg(ctx context.Context, orgID, agentID string, reqOpts ...RequestOption) error {
+	if err := c.requireOrgs(ctx); err != nil {
+		return err
+	}
+	return c.request(ctx, "DELETE", orgPath(orgID, "members", agentID), nil, nil, reqOpts...)
+}
+
+// OrgContacts lists an organization's shared contacts.
+func (c *Client) OrgContacts(ctx context.Context, orgID string, reqOpts ...RequestOption) ([]Contact, error) {
+	if err := c.requireOrgs(ctx); err != nil {
+		return nil, err
+	}
+	var contacts []Contact
+	if err := c.request(ctx, "GET", orgPath(orgID, "contacts"), nil, &contacts, reqOpts...); err != nil {
+		return nil, err
+	}
+	return contacts, nil
+}
+
+// AddOrgContact adds a shared contact to an organization.
+func (c *Client) AddOrgContact(ctx context.Context, orgID, contactID, alias, notes string, reqOpts ...RequestOption) error {
+	if err := c.requireOrgs(ctx); err != nil {
+		return err
+	}
+	body := map[string]interface{}{"contactId": contactID}
+	if alias != "" {
+		body["alias"] = alias
+	}
+	if notes != "" {
+		body["notes"] = notes
+	}
+	return c.request(ctx, "POST", orgPath(orgID, "contacts"), body, nil, reqOpts...)
+}
+
+// RemoveOrgContact removes a shared contact from an organization.
+func (c *Client) RemoveOrgContact(ctx context.Context, orgID, contactID string, reqOpts ...RequestOption) error {
+	if err := c.requireOrgs(ctx); err != nil {
+		return err
+	}
+	return c.request(ctx, "DELETE", orgPath(orgID, "contacts", contactID), nil, nil, reqOpts...)
+}
+
+// OrgPolicy gets an organization's shared block and allow lists.
+func (c *Client) OrgPolicy(ctx context.Context, orgID string, reqOpts ...RequestOption) (*OrgPolicy, error) {
+	if err := c.requireOrgs(ctx); err != nil {
+		return nil, err
+	}
+	var policy OrgPolicy
+	if err := c.request(ctx, "GET", orgPath(orgID, "policy"), nil, &policy, reqOpts...); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// OrgBlock adds agentID to an organization's block list.
+func (c *Client) OrgBlock(ctx context.Context, orgID, agentID string, reqOpts ...RequestOption) error {
+	return c.orgList(ctx, "PUT", orgID, "blocked", agentID, reqOpts...)
+}
+
+// OrgUnblock removes agentID from an organization's block list.
+func (c *Client) OrgUnblock(ctx context.Context, orgID, agentID string, reqOpts ...RequestOption) error {
+	return c.orgList(ctx, "DELETE", orgID, "blocked", agentID, reqOpts...)
+}
+
+// OrgAllow adds agentID to an organization's allow list.
+func (c *Client) OrgAllow(ctx context.Context, orgID, agentID string, reqOpts ...RequestOption) error {
+	return c.orgList(ctx, "PUT", orgID, "allowed", agentID, reqOpts...)
+}
+
+// OrgDisallow removes agentID from an organization's allow list.
+func (c *Client) OrgDisallow(ctx context.Context, orgID, agentID string, reqOpts ...RequestOption) error {
+	return c.orgList(ctx, "DELETE", orgID, "allowed", agentID, reqOpts...)
+}
+
+func (c *Client) orgList(ctx context.Context, method, orgID, list, agentID string, reqOpts ...RequestOption) error {
+	if err := c.requireOrgs(ctx); err != nil {
+		return err
+	}
+	return c.request(ctx, method, orgPath(orgID, "policy", list, agentID), nil, nil, reqOpts...)
+}
+
+// AllContacts returns the agent's own contacts merged with the shared
+// contacts of every organization it belongs to. Personal entries take
+// precedence over shared ones with the same contact ID.
+func (c *Client) AllContacts(ctx context.Context, reqOpts ...RequestOption) ([]Contact, error) {
+	contacts, err := c.Contacts(ctx, reqOpts...)
+	if err != nil {
+		return nil, err
+	}
+	orgs, err := c.Orgs(ctx, reqOpts...)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(contacts))
+	for _, ct := range contacts {
+		seen[ct.ContactID] = true
+	}
+	for _, org := range orgs {
+		shared, err := c.OrgContacts(ctx, org.ID, reqOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("org %s: %w", org.ID, err)
+		}
+		for _, ct := range shared {
+			if !seen[ct.ContactID] {
+				seen[ct.ContactID] = true
+				contacts = append(contacts, ct)
+			}
+		}
+	}
+	return contacts, nil
+}
+
+func (c *Client) requireOrgs(ctx context.Context) error {
+	if c.AgentID() == "" {
+		return fmt.Errorf("not registered")
+	}
+	return c.requireFeature(ctx, FeatureOrgs)
+}
+
+func orgPath(orgID string, parts ...string) string {
+	p := "/orgs/" + url.PathEscape(orgID)
+	for _, part := range parts {
+		p += "/" + url.PathEscape(part)
+	}
+	return p
+}
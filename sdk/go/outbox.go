@@ -0,0 +1,355 @@
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// DroppedEntryError is reported to Outbox.OnError for an entry dropped
+// from the queue because the server refused it for good.
+type DroppedEntryError struct {
+	Entry OutboxEntry
+	Err   error
+}
+
+func (e *DroppedEntryError) Error() string {
+	return fmt.Sprintf("outbox: dropped %s message to %s: %v", e.Entry.Type, e.Entry.To, e.Err)
+}
+
+func (e *DroppedEntryError) Unwrap() error { return e.Err }
+
+// OutboxEntry is a message queued for sending.
+type OutboxEntry struct {
+	To      string                 `json:"to"`
+	Type    MessageType            `json:"type"`
+	Payload map[string]interface{} `json:"payload"`
+	ReplyTo string                 `json:"replyTo,omitempty"`
+	// Meta is the metadata the message was queued with; see WithMeta.
+	Meta map[string]string `json:"meta,omitempty"`
+	// SendAt, if set, holds the entry until this time.
+	SendAt time.Time `json:"sendAt"`
+	// QueuedAt is when the entry was queued.
+	QueuedAt time.Time `json:"queuedAt,omitempty"`
+}
+
+func (e *OutboxEntry) due(now time.Time) bool {
+	return e.SendAt.IsZero() || !e.SendAt.After(now)
+}
+
+// Outbox is a Service that sends queued messages in the background,
+// retrying failed sends. A send that cannot succeed on retry, refused by
+// the server with a 4xx status other than 401, 408 or 429 or by a
+// preflight check, is dropped and reported to OnError as a *DroppedEntryError, so it
+// does not hold up the entries behind it. Entries still queued when the
+// Runner stops are sent by Flush, except scheduled entries that are not
+// yet due.
+type Outbox struct {
+	Client *Client
+	// RetryInterval is the delay before retrying a failed send. Defaults
+	// to 1s. A send refused for an exhausted quota is retried when the
+	// quota resets, if the server said when.
+	RetryInterval time.Duration
+	// Path, if set, is a JSON file the queue is saved to on every change
+	// and loaded from on first use, so queued and scheduled messages
+	// survive restarts.
+	Path string
+	// OnError, if set, receives send errors, including a
+	// *DroppedEntryError for each entry dropped.
+	OnError func(error)
+
+	sendMu  sync.Mutex // serializes Flush
+	mu      sync.Mutex
+	pending []OutboxEntry
+	loaded  bool
+	wake    chan struct{}
+}
+
+// Enqueue queues a message for sending. With Path set, an error saving
+// the queue is reported to OnError.
+func (o *Outbox) Enqueue(to string, msgType MessageType, payload map[string]interface{}, replyTo string) {
+	if err := o.enqueue(OutboxEntry{To: to, Type: msgType, Payload: payload, ReplyTo: replyTo}); err != nil {
+		reportError(o.OnError, err)
+	}
+}
+
+func (o *Outbox) enqueue(e OutboxEntry) error {
+	if e.QueuedAt.IsZero() {
+		e.QueuedAt = time.Now()
+	}
+	o.mu.Lock()
+	err := o.loadLocked()
+	if err == nil {
+		o.pending = append(o.pending, e)
+		err = o.saveLocked()
+	}
+	wake := o.wakeLocked()
+	o.mu.Unlock()
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// Len returns the number of queued messages, including scheduled ones.
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.loadLocked()
+	return len(o.pending)
+}
+
+// Scheduled returns the queued messages that are held until a later time.
+func (o *Outbox) Scheduled() []OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.loadLocked()
+	now := time.Now()
+	var out []OutboxEntry
+	for _, e := range o.pending {
+		if !e.due(now) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func (o *Outbox) wakeLocked() chan struct{} {
+	if o.wake == nil {
+		o.wake = make(chan struct{}, 1)
+	}
+	return o.wake
+}
+
+// loadLocked reads the queue from Path the first time it is needed.
+func (o *Outbox) loadLocked() error {
+	if o.loaded || o.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(o.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		o.loaded = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved []OutboxEntry
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	o.pending = append(saved, o.pending...)
+	o.loaded = true
+	return nil
+}
+
+// saveLocked writes the queue to Path.
+func (o *Outbox) saveLocked() error {
+	if o.Path == "" {
+		return nil
+	}
+	data, err := json.Marshal(o.pending)
+	if err != nil {
+		return err
+	}
+	tmp := o.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, o.Path)
+}
+
+// nextDue returns the time the earliest scheduled entry falls due, or zero
+// if there is none.
+func (o *Outbox) nextDue() time.Time {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var next time.Time
+	for _, e := range o.pending {
+		if !e.SendAt.IsZero() && (next.IsZero() || e.SendAt.Before(next)) {
+			next = e.SendAt
+		}
+	}
+	return next
+}
+
+// Run implements Service.
+func (o *Outbox) Run(ctx context.Context) error {
+	o.mu.Lock()
+	wake := o.wakeLocked()
+	o.mu.Unlock()
+	retry := durationOr(o.RetryInterval, time.Second)
+	for {
+		if err := o.Flush(ctx); err != nil && ctx.Err() == nil {
+			// Being offline is not a send failure; Reconnect flushes the
+			// queue once the client is back.
+			if !errors.Is(err, ErrOffline) {
+				reportError(o.OnError, err)
+			}
+			delay := retry
+			var qe *QuotaError
+			if errors.As(err, &qe) && time.Until(qe.ResetAt) > delay {
+				delay = time.Until(qe.ResetAt)
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(delay):
+			}
+			continue
+		}
+		var (
+			timer *time.Timer
+			due   <-chan time.Time
+		)
+		if next := o.nextDue(); !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			due = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-wake:
+		case <-due:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Flush sends queued messages that are due, in order, until none are
+// left, a send fails or ctx is done. A failed entry stays queued ahead of
+// later ones, unless the failure is permanent: then it is dropped and
+// reported to OnError, and Flush carries on. While the client is offline Flush sends nothing and returns
+// ErrOffline.
+func (o *Outbox) Flush(ctx context.Context) error {
+	o.sendMu.Lock()
+	defer o.sendMu.Unlock()
+	for {
+		if o.Client.Offline() {
+			return ErrOffline
+		}
+		o.mu.Lock()
+		if err := o.loadLocked(); err != nil {
+			o.mu.Unlock()
+			return err
+		}
+		i := o.firstDueLocked(time.Now())
+		if i < 0 {
+			o.mu.Unlock()
+			return nil
+		}
+		e := o.pending[i]
+		o.mu.Unlock()
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		_, err := o.Client.Send(withMetaMap(ctx, e.Meta), e.To, e.Type, e.Payload, e.ReplyTo, withoutQueue())
+		if err != nil && !permanentSendError(err) {
+			return err
+		}
+		if err != nil {
+			reportError(o.OnError, &DroppedEntryError{Entry: e, Err: err})
+		}
+
+		// Only Flush and Prune remove entries, and they are serialized, so
+		// entry i is still the one that was sent or dropped.
+		o.mu.Lock()
+		o.pending = append(o.pending[:i:i], o.pending[i+1:]...)
+		err = o.saveLocked()
+		o.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// permanentSendError reports whether a send failed in a way that retrying
+// cannot fix: a preflight check rejected it, or the server refused the
+// message itself with a 4xx status. A rejected signature (401), a timeout
+// (408), rate limiting (429) and an exhausted quota are not the entry's
+// fault and are retried.
+func permanentSendError(err error) bool {
+	var pe *PreflightError
+	if errors.As(err, &pe) {
+		return true
+	}
+	var qe *QuotaError
+	var apiErr *APIError
+	if errors.As(err, &qe) || !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 &&
+		!isStatus(err, http.StatusUnauthorized) && !isStatus(err, http.StatusRequestTimeout) &&
+		!isStatus(err, http.StatusTooManyRequests)
+}
+
+// Prune drops queued entries that policy does not keep and returns them.
+// An entry's age counts from when it fell due, so scheduled messages are
+// not dropped before they had a chance to be sent; entries queued before
+// QueuedAt was recorded are kept. Prune waits for a Flush in progress.
+func (o *Outbox) Prune(policy RetentionPolicy) ([]OutboxEntry, error) {
+	o.sendMu.Lock()
+	defer o.sendMu.Unlock()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.loadLocked(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var kept, dropped []OutboxEntry
+	var unpinned []int // indexes into kept
+	for _, e := range o.pending {
+		if policy.pinned(&Message{To: e.To}) {
+			kept = append(kept, e)
+			continue
+		}
+		since := e.QueuedAt
+		if e.SendAt.After(since) {
+			since = e.SendAt
+		}
+		if policy.MaxAge > 0 && !e.QueuedAt.IsZero() && now.Sub(since) > policy.MaxAge {
+			dropped = append(dropped, e)
+			continue
+		}
+		unpinned = append(unpinned, len(kept))
+		kept = append(kept, e)
+	}
+	if policy.MaxCount > 0 && len(unpinned) > policy.MaxCount {
+		drop := make(map[int]bool)
+		for _, i := range unpinned[:len(unpinned)-policy.MaxCount] {
+			drop[i] = true
+			dropped = append(dropped, kept[i])
+		}
+		out := kept[:0]
+		for i, e := range kept {
+			if !drop[i] {
+				out = append(out, e)
+			}
+		}
+		kept = out
+	}
+	if len(dropped) == 0 {
+		return nil, nil
+	}
+	o.pending = kept
+	return dropped, o.saveLocked()
+}
+
+func (o *Outbox) firstDueLocked(now time.Time) int {
+	for i := range o.pending {
+		if o.pending[i].due(now) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,137 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aetos53t/ping/sdk/go/pingtest"
+)
+
+// TestOfflineOutboxRunner runs the offline outbox in a Runner while the
+// client is offline: it must neither resend nor rewrite the queue, and
+// Stop must return.
+func TestOfflineOutboxRunner(t *testing.T) {
+	srv := pingtest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	recipient := registerTestClient(t, srv, "recipient")
+
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	outbox := &Outbox{Path: path, RetryInterval: 10 * time.Millisecond}
+	c := NewClient(srv.URL, WithOfflineOutbox(outbox))
+	if _, err := c.Register(ctx, "sender", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	c.GoOffline()
+	res, err := c.Text(ctx, recipient.AgentID(), "queued")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.DeliveryMethod != DeliveryQueued {
+		t.Fatalf("DeliveryMethod = %q, want %q", res.DeliveryMethod, DeliveryQueued)
+	}
+	if err := outbox.Flush(ctx); !errors.Is(err, ErrOffline) {
+		t.Errorf("Flush while offline = %v, want ErrOffline", err)
+	}
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRunner(outbox)
+	if err := r.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if now, err := os.ReadFile(path); err != nil || !bytes.Equal(now, saved) {
+		t.Errorf("outbox file changed while offline: %s, was %s", now, saved)
+	}
+	if n := outbox.Len(); n != 1 {
+		t.Errorf("outbox has %d entries while offline, want 1", n)
+	}
+
+	stopCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := r.Stop(stopCtx); errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Stop did not return while offline")
+	} else if !errors.Is(err, ErrOffline) {
+		t.Errorf("Stop = %v, want the flush to fail with ErrOffline", err)
+	}
+
+	if err := c.Reconnect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := outbox.Len(); n != 0 {
+		t.Errorf("outbox has %d entries after Reconnect, want 0", n)
+	}
+	inbox, err := recipient.Inbox(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox) != 1 || inbox[0].PayloadString("text") != "queued" {
+		t.Errorf("recipient inbox = %+v, want the queued message", inbox)
+	}
+}
+
+// TestOutboxDropsRefused checks that a message the server refuses is
+// dropped and reported without holding up the entries behind it.
+func TestOutboxDropsRefused(t *testing.T) {
+	srv := pingtest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	sender := registerTestClient(t, srv, "sender")
+	recipient := registerTestClient(t, srv, "recipient")
+
+	var reported []error
+	outbox := &Outbox{Client: sender, OnError: func(err error) { reported = append(reported, err) }}
+	outbox.Enqueue("no-such-agent", MessageTypeText, map[string]interface{}{"text": "lost"}, "")
+	outbox.Enqueue(recipient.AgentID(), MessageTypeText, map[string]interface{}{"text": "delivered"}, "")
+	if err := outbox.Flush(ctx); err != nil {
+		t.Fatalf("Flush = %v, want the refused entry dropped", err)
+	}
+	if n := outbox.Len(); n != 0 {
+		t.Errorf("outbox has %d entries, want 0", n)
+	}
+
+	var dropped *DroppedEntryError
+	if len(reported) != 1 || !errors.As(reported[0], &dropped) {
+		t.Fatalf("reported %v, want one *DroppedEntryError", reported)
+	}
+	if dropped.Entry.To != "no-such-agent" || !isStatus(dropped, http.StatusNotFound) {
+		t.Errorf("dropped %+v, want the entry to no-such-agent refused with 404", dropped)
+	}
+	inbox, err := recipient.Inbox(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox) != 1 || inbox[0].PayloadString("text") != "delivered" {
+		t.Errorf("recipient inbox = %+v, want the second entry", inbox)
+	}
+}
+
+func TestPermanentSendError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{&APIError{StatusCode: http.StatusNotFound}, true},
+		{&APIError{StatusCode: http.StatusBadRequest}, true},
+		{&PreflightError{To: "a", Type: MessageTypeText, Check: "recipient", Err: ErrForbidden}, true},
+		{&APIError{StatusCode: http.StatusUnauthorized}, false},
+		{&APIError{StatusCode: http.StatusTooManyRequests}, false},
+		{&APIError{StatusCode: http.StatusServiceUnavailable}, false},
+		{&QuotaError{APIError: &APIError{StatusCode: http.StatusForbidden}}, false},
+		{ErrOffline, false},
+	} {
+		if got := permanentSendError(tc.err); got != tc.want {
+			t.Errorf("permanentSendError(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package ping
+
+import "encoding/json"
+
+// PayloadMap decodes the payload into a generic map. It returns a nil map
+// for an empty or null payload.
+func (m Message) PayloadMap() (map[string]interface{}, error) {
+	var out map[string]interface{}
+	if err := m.DecodePayload(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DecodePayload decodes the payload into v. Decoding into a struct avoids
+// building a generic map for large payloads.
+func (m Message) DecodePayload(v interface{}) error {
+	if len(m.Payload) == 0 {
+		return nil
+	}
+	return json.Unmarshal(m.Payload, v)
+}
+
+// PayloadString returns the string field key of the payload, or "" if it
+// is missing or not a string.
+func (m Message) PayloadString(key string) string {
+	var fields map[string]json.RawMessage
+	if m.DecodePayload(&fields) != nil {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(fields[key], &s) != nil {
+		return ""
+	}
+	return s
+}
+
+// marshalPayload encodes payload the way it is sent on the wire, without
+// HTML escaping. A nil payload encodes as an empty object, as the server
+// stores it.
+func marshalPayload(payload map[string]interface{}) (json.RawMessage, error) {
+	if payload == nil {
+		return json.RawMessage("{}"), nil
+	}
+	return encodeJSON(payload, false)
+}
@@ -0,0 +1,84 @@
+package ping
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// PeerPrefs are the preferences the client has cached for a peer agent.
+type PeerPrefs struct {
+	// Codecs is the payload codec chain used when sending to the peer.
+	Codecs []string
+	// PublicKey is the peer's hex Ed25519 public key, cached from GetAgent.
+	PublicKey string
+	// RouteTo, if set, is the agent that took over conversations with the
+	// peer after a handoff. Messages to the peer are sent there instead.
+	RouteTo string
+	// Locale, if set, is the locale SendTemplate renders in for the peer.
+	Locale string
+}
+
+// PeerPrefs returns a copy of the preferences cached for peerID.
+func (c *Client) PeerPrefs(peerID string) PeerPrefs {
+	return c.peers.get(peerID)
+}
+
+// peerKey returns the peer's public key, fetching and caching it on first
+// use. A pinned key is used without asking the server.
+func (c *Client) peerKey(ctx context.Context, peerID string, reqOpts ...RequestOption) (ed25519.PublicKey, error) {
+	keyHex := c.peers.get(peerID).PublicKey
+	if keyHex == "" {
+		var err error
+		if keyHex, err = c.pinnedKey(ctx, peerID); err != nil {
+			return nil, err
+		}
+	}
+	if keyHex == "" {
+		agent, err := c.GetAgent(ctx, peerID, reqOpts...)
+		if err != nil {
+			return nil, err
+		}
+		keyHex = agent.PublicKey
+		c.peers.update(peerID, func(p *PeerPrefs) { p.PublicKey = keyHex })
+	}
+	key, err := hex.DecodeString(keyHex)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key for agent %s", peerID)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// peerCache holds per-peer preferences. The zero value is ready to use.
+type peerCache struct {
+	mu    sync.RWMutex
+	prefs map[string]*PeerPrefs
+}
+
+func (pc *peerCache) get(peerID string) PeerPrefs {
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+	p, ok := pc.prefs[peerID]
+	if !ok {
+		return PeerPrefs{}
+	}
+	out := *p
+	out.Codecs = append([]string(nil), p.Codecs...)
+	return out
+}
+
+func (pc *peerCache) update(peerID string, fn func(*PeerPrefs)) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.prefs == nil {
+		pc.prefs = make(map[string]*PeerPrefs)
+	}
+	p, ok := pc.prefs[peerID]
+	if !ok {
+		p = &PeerPrefs{}
+		pc.prefs[peerID] = p
+	}
+	fn(p)
+}
@@ -14,46 +14,127 @@ import (
 	"crypto/ed25519"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/aetos53t/ping/sdk/go/wire"
 )
 
-// Client is a PING API client.
+// unixBaseURL is the placeholder HTTP origin used for Unix socket clients.
+const unixBaseURL = "http://unix"
+
+// Client is a PING API client. It is safe for concurrent use by multiple
+// goroutines, including while Register, SetKeys or UsePortableIdentity
+// replace its identity; each request uses a consistent snapshot of it.
+// Options must not be changed after NewClient returns.
 type Client struct {
 	baseURL    string
 	httpClient *http.Client
-	privateKey ed25519.PrivateKey
-	publicKey  string
-	AgentID    string
+
+	idMu   sync.RWMutex
+	id     identity
+	claims []RoleClaim
+
+	mu             sync.RWMutex
+	serverVersion  string
+	serverFeatures map[Feature]bool
+	negotiated     bool
+
+	store     Store
+	auditLog  AuditLog
+	transport transportConfig
+	discovery *srvDiscovery
+	codecs    map[string]Codec
+	peers     peerCache
+
+	templates      Templates
+	contactLocales contactLocaleCache
+
+	loginMu     sync.Mutex
+	session     *Session
+	sessionKeys *sessionTable
+
+	retries     int
+	backoff     Backoff
+	compression bool
+	chunkSize   int
+	chunks      chunkBuffer
+	standby     passiveState
+	offline     offlineState
+	flows       flowTable
+	blocked     blockList
+	strict      *strictState
+	pinning     *pinningState
+	breaker     *CircuitBreaker
+	encoding    Encoding
+	schemas     schemaRegistry
+	schemaCheck bool
+	onSend      []func(*OutgoingMessage)
+	onReceive   []func(*Message)
+	onStatus    []func(context.Context, StatusUpdate)
+	workers     int
+	orderKey    func(Message) string
+	maxResponse int64
+	timeout     time.Duration
+	webhookURL  string // registration default, from Config
+
+	discoveryOpts DiscoveryOptions
 }
 
 // Agent represents a registered agent.
 type Agent struct {
-	ID           string   `json:"id"`
-	PublicKey    string   `json:"publicKey"`
-	Name         string   `json:"name"`
-	Provider     string   `json:"provider"`
-	Capabilities []string `json:"capabilities"`
-	WebhookURL   string   `json:"webhookUrl,omitempty"`
-	IsPublic     bool     `json:"isPublic"`
-	CreatedAt    string   `json:"createdAt"`
+	ID           string    `json:"id"`
+	PublicKey    string    `json:"publicKey"`
+	Name         string    `json:"name"`
+	Provider     string    `json:"provider"`
+	Capabilities []string  `json:"capabilities"`
+	WebhookURL   string    `json:"webhookUrl,omitempty"`
+	IsPublic     bool      `json:"isPublic"`
+	CreatedAt    Timestamp `json:"createdAt"`
+	// Descriptors holds structured capability descriptors, from servers
+	// with the capability-descriptors feature. See Capability.
+	Descriptors []CapabilityDescriptor `json:"capabilityDescriptors,omitempty"`
 }
 
 // Message represents a PING message.
+//
+// Payload holds the payload exactly as received, so it can be checked
+// against the signature and is only parsed when read through PayloadMap,
+// PayloadString or DecodePayload.
 type Message struct {
-	ID           string                 `json:"id"`
-	Type         string                 `json:"type"`
-	From         string                 `json:"from"`
-	To           string                 `json:"to"`
-	Payload      map[string]interface{} `json:"payload"`
-	ReplyTo      string                 `json:"replyTo,omitempty"`
-	Timestamp    string                 `json:"timestamp"`
-	Signature    string                 `json:"signature"`
-	Delivered    bool                   `json:"delivered"`
-	Acknowledged bool                   `json:"acknowledged"`
+	ID           string          `json:"id"`
+	Type         MessageType     `json:"type"`
+	From         string          `json:"from"`
+	To           string          `json:"to"`
+	Payload      json.RawMessage `json:"payload"`
+	ReplyTo      string          `json:"replyTo,omitempty"`
+	Timestamp    Timestamp       `json:"timestamp"`
+	Signature    string          `json:"signature"`
+	Delivered    bool            `json:"delivered"`
+	Acknowledged bool            `json:"acknowledged"`
+	// Recalled is set in History for messages their sender withdrew with
+	// Recall.
+	Recalled bool `json:"recalled,omitempty"`
+	// Reactions lists the reactions to the message. The client's store
+	// fills it in as reactions are sent and received.
+	Reactions []Reaction `json:"reactions,omitempty"`
+	// Edits lists the edits applied to the message, oldest first, in
+	// History and the client's store. Payload is then the latest version
+	// and OriginalPayload, which Signature covers, the one first sent.
+	Edits           []MessageEdit   `json:"edits,omitempty"`
+	OriginalPayload json.RawMessage `json:"originalPayload,omitempty"`
+	// Delegation is the sub-key chain of a message sent with a sub-key,
+	// from servers with the delegated-signing feature. See VerifyMessage.
+	Delegation []Delegation `json:"delegation,omitempty"`
+	// Metadata is the request-scoped metadata the message was sent with,
+	// from servers that keep the envelope's meta field. See Message.Meta.
+	Metadata map[string]string `json:"meta,omitempty"`
 }
 
 // SendResult is the result of sending a message.
@@ -65,26 +146,65 @@ type SendResult struct {
 
 // Contact represents a contact entry.
 type Contact struct {
-	ContactID string `json:"contactId"`
-	Alias     string `json:"alias,omitempty"`
-	Notes     string `json:"notes,omitempty"`
-	AddedAt   string `json:"addedAt"`
+	ContactID string    `json:"contactId"`
+	Alias     string    `json:"alias,omitempty"`
+	Notes     string    `json:"notes,omitempty"`
+	AddedAt   Timestamp `json:"addedAt"`
 }
 
 // RegisterOptions contains options for registering an agent.
 type RegisterOptions struct {
 	Provider     string
 	Capabilities []string
-	WebhookURL   string
-	IsPublic     bool
+	// Descriptors describe capabilities in detail. Their names are added
+	// to Capabilities; the descriptors themselves are stored only by
+	// servers with the capability-descriptors feature.
+	Descriptors []CapabilityDescriptor
+	WebhookURL  string
+	IsPublic    bool
 }
 
-// NewClient creates a new PING client.
-func NewClient(baseURL string) *Client {
-	return &Client{
+// NewClient creates a new PING client. A base URL of the form
+// unix:///path/to/ping.sock connects over a Unix domain socket, and one of
+// the form dns+srv://_ping._tcp.example.com discovers servers from SRV
+// records, failing over between them; see WithDiscovery.
+func NewClient(baseURL string, opts ...Option) *Client {
+	c := &Client{
 		baseURL:    baseURL,
 		httpClient: &http.Client{Timeout: 30 * time.Second},
+		codecs:     defaultCodecs(),
+		retries:    2,
 	}
+	if socket, ok := strings.CutPrefix(baseURL, "unix://"); ok {
+		c.transport.unixSocket = socket
+		c.baseURL = unixBaseURL
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if name, ok := strings.CutPrefix(baseURL, srvScheme); ok {
+		c.discovery = newSRVDiscovery(name, c.discoveryOpts)
+	}
+	if c.timeout > 0 {
+		hc := *c.httpClient
+		hc.Timeout = c.timeout
+		c.httpClient = &hc
+	}
+	c.httpClient = c.transport.apply(c.httpClient)
+	return c
+}
+
+// BaseURL returns the server URL the client was created with.
+func (c *Client) BaseURL() string {
+	if c.transport.unixSocket != "" {
+		return "unix://" + c.transport.unixSocket
+	}
+	return c.baseURL
+}
+
+// Store returns the client's local message store, or nil if none is configured.
+func (c *Client) Store() Store {
+	return c.store
 }
 
 // GenerateKeys generates a new Ed25519 keypair.
@@ -93,28 +213,72 @@ func (c *Client) GenerateKeys() (privateKey string, publicKey string, err error)
 	if err != nil {
 		return "", "", err
 	}
-	c.privateKey = priv
-	c.publicKey = hex.EncodeToString(pub)
-	return hex.EncodeToString(priv), c.publicKey, nil
+	c.setKey(priv)
+	return hex.EncodeToString(priv), hex.EncodeToString(pub), nil
 }
 
-// SetKeys sets the keypair from an existing private key.
+// SetKeys sets the keypair from an existing private key, given in hex as
+// either a 32-byte seed or a 64-byte Ed25519 private key.
 func (c *Client) SetKeys(privateKeyHex string) error {
-	privBytes, err := hex.DecodeString(privateKeyHex)
+	priv, err := parsePrivateKey(privateKeyHex)
 	if err != nil {
 		return err
 	}
-	if len(privBytes) != ed25519.PrivateKeySize {
-		return fmt.Errorf("invalid private key length: %d", len(privBytes))
-	}
-	c.privateKey = ed25519.PrivateKey(privBytes)
-	c.publicKey = hex.EncodeToString(c.privateKey.Public().(ed25519.PublicKey))
+	c.setKey(priv)
 	return nil
 }
 
+// AgentID returns the client's agent ID, or "" if it is not registered.
+func (c *Client) AgentID() string {
+	return c.identity().agentID
+}
+
+// SetAgentID sets the agent ID used by the client, for continuing an agent
+// registered earlier with the same keys.
+func (c *Client) SetAgentID(id string) {
+	c.idMu.Lock()
+	c.id.agentID = id
+	c.idMu.Unlock()
+}
+
+// identity is the client's agent ID, key pair and registered name. With a
+// sub-key, privateKey is the sub-key and delegation its chain.
+type identity struct {
+	agentID    string
+	privateKey ed25519.PrivateKey
+	publicKey  string
+	name       string
+	delegation []Delegation
+}
+
+// identity returns a snapshot of the client's agent ID and keys.
+func (c *Client) identity() identity {
+	c.idMu.RLock()
+	defer c.idMu.RUnlock()
+	return c.id
+}
+
+func (c *Client) setKey(priv ed25519.PrivateKey) {
+	c.idMu.Lock()
+	c.id.privateKey = priv
+	c.id.publicKey = hex.EncodeToString(priv.Public().(ed25519.PublicKey))
+	c.id.delegation = nil
+	c.idMu.Unlock()
+}
+
 // Register registers a new agent.
-func (c *Client) Register(ctx context.Context, name string, opts *RegisterOptions) (*Agent, error) {
-	if c.publicKey == "" {
+func (c *Client) Register(ctx context.Context, name string, opts *RegisterOptions, reqOpts ...RequestOption) (*Agent, error) {
+	agent, err := c.register(ctx, name, opts, reqOpts)
+	e := AuditEntry{Op: AuditRegister}
+	if agent != nil {
+		e.Agent = agent.ID
+	}
+	c.audit(ctx, e, err)
+	return agent, err
+}
+
+func (c *Client) register(ctx context.Context, name string, opts *RegisterOptions, reqOpts []RequestOption) (*Agent, error) {
+	if c.identity().publicKey == "" {
 		_, _, err := c.GenerateKeys()
 		if err != nil {
 			return nil, err
@@ -122,99 +286,225 @@ func (c *Client) Register(ctx context.Context, name string, opts *RegisterOption
 	}
 
 	body := map[string]interface{}{
-		"publicKey": c.publicKey,
+		"publicKey": c.identity().publicKey,
 		"name":      name,
 	}
 	if opts != nil {
 		if opts.Provider != "" {
 			body["provider"] = opts.Provider
 		}
-		if len(opts.Capabilities) > 0 {
-			body["capabilities"] = opts.Capabilities
+		if caps := capabilityNames(opts.Capabilities, opts.Descriptors); len(caps) > 0 {
+			body["capabilities"] = caps
+		}
+		if len(opts.Descriptors) > 0 && c.requireFeature(ctx, FeatureCapabilityDescriptors) == nil {
+			body["capabilityDescriptors"] = opts.Descriptors
 		}
 		if opts.WebhookURL != "" {
 			body["webhookUrl"] = opts.WebhookURL
 		}
 		body["isPublic"] = opts.IsPublic
 	}
+	if _, ok := body["webhookUrl"]; !ok && c.webhookURL != "" {
+		body["webhookUrl"] = c.webhookURL
+	}
 
 	var agent Agent
-	if err := c.request(ctx, "POST", "/agents", body, &agent); err != nil {
+	if err := c.request(ctx, "POST", "/agents", body, &agent, reqOpts...); err != nil {
 		return nil, err
 	}
-	c.AgentID = agent.ID
+	c.idMu.Lock()
+	c.id.agentID = agent.ID
+	c.id.name = agent.Name
+	c.idMu.Unlock()
 	return &agent, nil
 }
 
-// GetAgent gets an agent by ID.
-func (c *Client) GetAgent(ctx context.Context, id string) (*Agent, error) {
+// GetAgent gets an agent by ID. With WithKeyPinning, its public key is
+// checked against the pinned key, or pinned if it is the first seen.
+func (c *Client) GetAgent(ctx context.Context, id string, reqOpts ...RequestOption) (*Agent, error) {
 	var agent Agent
-	if err := c.request(ctx, "GET", "/agents/"+id, nil, &agent); err != nil {
+	if err := c.request(ctx, "GET", "/agents/"+id, nil, &agent, reqOpts...); err != nil {
+		return nil, err
+	}
+	if err := c.checkPin(ctx, &agent); err != nil {
 		return nil, err
 	}
 	return &agent, nil
 }
 
 // Send sends a message.
-func (c *Client) Send(ctx context.Context, to, msgType string, payload map[string]interface{}, replyTo string) (*SendResult, error) {
-	if c.AgentID == "" {
+func (c *Client) Send(ctx context.Context, to string, msgType MessageType, payload map[string]interface{}, replyTo string, reqOpts ...RequestOption) (res *SendResult, err error) {
+	if c.auditLog != nil {
+		// Deferred so the entry shows the recipient and type after send
+		// hooks and routing.
+		defer func() {
+			e := AuditEntry{Op: AuditSend, Target: to, Type: msgType}
+			if res != nil {
+				e.MessageID = res.ID
+			}
+			c.audit(ctx, e, err)
+		}()
+	}
+
+	id := c.identity()
+	if id.agentID == "" {
 		return nil, fmt.Errorf("not registered")
 	}
 
-	msg := map[string]interface{}{
-		"type":      msgType,
-		"from":      c.AgentID,
-		"to":        to,
-		"payload":   payload,
-		"timestamp": time.Now().UnixMilli(),
+	if id.privateKey == nil {
+		return nil, fmt.Errorf("no keys")
 	}
-	if replyTo != "" {
-		msg["replyTo"] = replyTo
+	if c.Passive() {
+		return nil, ErrStandby
+	}
+	if c.Offline() {
+		// Hooks, routing and encoding run when the outbox sends it.
+		return c.queueSend(ctx, to, msgType, attachThread(payload, reqOpts), replyTo, reqOpts)
 	}
 
-	// Sign the message
-	msgBytes, _ := json.Marshal(msg)
-	sig := ed25519.Sign(c.privateKey, msgBytes)
-	msg["signature"] = hex.EncodeToString(sig)
+	if len(c.onSend) > 0 {
+		out := OutgoingMessage{To: to, Type: msgType, Payload: payload, ReplyTo: replyTo}
+		c.runSendHooks(&out)
+		to, msgType, payload, replyTo = out.To, out.Type, out.Payload, out.ReplyTo
+	}
+	to = c.route(to)
+	if len(id.delegation) > 0 {
+		if err := c.checkDelegation(ctx, id, msgType); err != nil {
+			return nil, err
+		}
+	}
+	if c.strict != nil {
+		if err := c.preflight(ctx, to, msgType, payload); err != nil {
+			return nil, err
+		}
+	}
+	if c.schemaCheck || c.strict != nil {
+		if err := c.ValidatePayload(msgType, payload); err != nil {
+			return nil, &PreflightError{To: to, Type: msgType, Check: "schema", Err: err}
+		}
+	}
+	payload = attachThread(c.attachClaims(payload), reqOpts)
+	wirePayload, err := c.encodePayload(ctx, to, payload)
+	if err != nil {
+		return nil, err
+	}
+	if c.strict != nil {
+		if err := c.preflightSize(to, msgType, wirePayload); err != nil {
+			return nil, err
+		}
+	}
+
+	var sent *signedMessage
+	if c.chunkSize > 0 && c.oversized(wirePayload) {
+		sent, err = c.sendChunked(ctx, id, to, msgType, wirePayload, replyTo, reqOpts)
+	} else {
+		sent, err = c.post(ctx, id, to, msgType, wirePayload, replyTo, reqOpts)
+		if isStatus(err, http.StatusRequestEntityTooLarge) && c.chunkSize > 0 {
+			sent, err = c.sendChunked(ctx, id, to, msgType, wirePayload, replyTo, reqOpts)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if raw, err := marshalPayload(payload); err == nil && msgType != MessageTypeStatus && !newCallConfig(reqOpts).noRecord {
+		c.record(ctx, Message{
+			ID:        sent.result.ID,
+			Type:      msgType,
+			From:      id.agentID,
+			To:        to,
+			Payload:   raw,
+			ReplyTo:   replyTo,
+			Timestamp: NewTimestamp(time.UnixMilli(sent.timestamp)),
+			Signature: sent.signature,
+			Delivered: sent.result.Delivered,
+			Metadata:  MetaFromContext(ctx),
+		})
+	}
+	return &sent.result, nil
+}
+
+// signedMessage is the outcome of posting one signed message.
+type signedMessage struct {
+	result    SendResult
+	signature string
+	timestamp int64
+}
 
-	var result SendResult
-	if err := c.request(ctx, "POST", "/messages", msg, &result); err != nil {
+// signedAtField is the payload field carrying the timestamp a message was
+// signed with. Servers return their own receipt time as the timestamp, so
+// without it a recipient could not rebuild the signed bytes.
+const signedAtField = "$signedAt"
+
+// post signs a message with wirePayload and the signing time as its
+// payload and sends it, with the metadata from ctx on its envelope.
+func (c *Client) post(ctx context.Context, id identity, to string, msgType MessageType, wirePayload map[string]interface{}, replyTo string, reqOpts []RequestOption) (*signedMessage, error) {
+	now := time.Now()
+	signed := make(map[string]interface{}, len(wirePayload)+1)
+	for k, v := range wirePayload {
+		signed[k] = v
+	}
+	signed[signedAtField] = now.UnixMilli()
+	wirePayload = signed
+	env, err := wire.NewEnvelope(string(msgType), id.agentID, to, wirePayload, replyTo, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := env.Sign(id.privateKey); err != nil {
 		return nil, err
 	}
-	return &result, nil
+	env.Delegation = id.delegation
+	env.Meta = MetaFromContext(ctx)
+
+	sent := &signedMessage{signature: env.Signature, timestamp: now.UnixMilli()}
+	if err := c.request(ctx, "POST", "/messages", c.messageBody(ctx, env, wirePayload), &sent.result, reqOpts...); err != nil {
+		return nil, err
+	}
+	return sent, nil
 }
 
 // Text sends a text message.
-func (c *Client) Text(ctx context.Context, to, text string) (*SendResult, error) {
-	return c.Send(ctx, to, "text", map[string]interface{}{"text": text}, "")
+func (c *Client) Text(ctx context.Context, to, text string, reqOpts ...RequestOption) (*SendResult, error) {
+	return c.Send(ctx, to, "text", map[string]interface{}{"text": text}, "", reqOpts...)
 }
 
 // Ping sends a ping message.
-func (c *Client) Ping(ctx context.Context, to string) (*SendResult, error) {
-	return c.Send(ctx, to, "ping", nil, "")
+func (c *Client) Ping(ctx context.Context, to string, reqOpts ...RequestOption) (*SendResult, error) {
+	return c.Send(ctx, to, "ping", nil, "", reqOpts...)
 }
 
 // Request sends a request message.
-func (c *Client) Request(ctx context.Context, to, action string, data interface{}) (*SendResult, error) {
-	return c.Send(ctx, to, "request", map[string]interface{}{"action": action, "data": data}, "")
+func (c *Client) Request(ctx context.Context, to, action string, data interface{}, reqOpts ...RequestOption) (*SendResult, error) {
+	return c.Send(ctx, to, "request", map[string]interface{}{"action": action, "data": data}, "", reqOpts...)
 }
 
-// Inbox gets unacknowledged messages.
-func (c *Client) Inbox(ctx context.Context) ([]Message, error) {
-	if c.AgentID == "" {
+// Inbox gets unacknowledged messages. WithSince, WithUntil and
+// WithMessageType narrow the result.
+func (c *Client) Inbox(ctx context.Context, reqOpts ...RequestOption) ([]Message, error) {
+	if c.AgentID() == "" {
 		return nil, fmt.Errorf("not registered")
 	}
 
 	var messages []Message
-	if err := c.request(ctx, "GET", "/agents/"+c.AgentID+"/inbox", nil, &messages); err != nil {
+	w := windowOf(reqOpts)
+	if c.Offline() {
+		messages, err := c.offlineInbox(ctx)
+		return w.filter(messages), err
+	}
+	if err := c.request(ctx, "GET", w.query("/agents/"+c.AgentID()+"/inbox"), nil, &messages, reqOpts...); err != nil {
 		return nil, err
 	}
-	return messages, nil
+	return w.filter(c.receive(ctx, messages)), nil
 }
 
-// History gets conversation history with another agent.
-func (c *Client) History(ctx context.Context, otherID string, limit int) ([]Message, error) {
-	if c.AgentID == "" {
+// History gets conversation history with another agent. Edit messages
+// are folded into the messages they edit, so each shows its latest
+// version with the trail in Edits.
+//
+// WithSince, WithUntil and WithMessageType narrow the result. Servers that
+// cannot filter history return the matches among the newest limit
+// messages; use HistoryIter to search further back.
+func (c *Client) History(ctx context.Context, otherID string, limit int, reqOpts ...RequestOption) ([]Message, error) {
+	if c.AgentID() == "" {
 		return nil, fmt.Errorf("not registered")
 	}
 	if limit <= 0 {
@@ -222,22 +512,51 @@ func (c *Client) History(ctx context.Context, otherID string, limit int) ([]Mess
 	}
 
 	var messages []Message
-	path := fmt.Sprintf("/agents/%s/messages/%s?limit=%d", c.AgentID, otherID, limit)
-	if err := c.request(ctx, "GET", path, nil, &messages); err != nil {
+	w := windowOf(reqOpts)
+	if c.Offline() {
+		messages, err := c.offlineHistory(ctx, otherID, limit)
+		return w.filter(messages), err
+	}
+	path := w.query(fmt.Sprintf("/agents/%s/messages/%s?limit=%d", c.AgentID(), otherID, limit))
+	if err := c.request(ctx, "GET", path, nil, &messages, reqOpts...); err != nil {
 		return nil, err
 	}
-	return messages, nil
+	c.decodePayloads(ctx, messages)
+	return w.filter(applyEdits(messages)), nil
 }
 
 // Ack acknowledges a message.
-func (c *Client) Ack(ctx context.Context, messageID string) error {
-	return c.request(ctx, "POST", "/messages/"+messageID+"/ack", nil, nil)
+func (c *Client) Ack(ctx context.Context, messageID string, reqOpts ...RequestOption) error {
+	err := c.ack(ctx, messageID, reqOpts)
+	c.audit(ctx, AuditEntry{Op: AuditAck, MessageID: messageID}, err)
+	return err
+}
+
+func (c *Client) ack(ctx context.Context, messageID string, reqOpts []RequestOption) error {
+	if c.Passive() {
+		return ErrStandby
+	}
+	if c.Offline() {
+		c.queueAck(ctx, messageID)
+		return nil
+	}
+	if err := c.request(ctx, "POST", "/messages/"+messageID+"/ack", nil, nil, reqOpts...); err != nil {
+		return err
+	}
+	// A reassembled chunked message is acknowledged with all its pieces.
+	for _, id := range c.chunkParts(messageID) {
+		if err := c.request(ctx, "POST", "/messages/"+id+"/ack", nil, nil, reqOpts...); err != nil {
+			return err
+		}
+	}
+	c.markAcked(ctx, messageID)
+	return nil
 }
 
 // Directory lists public agents.
-func (c *Client) Directory(ctx context.Context) ([]Agent, error) {
+func (c *Client) Directory(ctx context.Context, reqOpts ...RequestOption) ([]Agent, error) {
 	var agents []Agent
-	if err := c.request(ctx, "GET", "/directory", nil, &agents); err != nil {
+	if err := c.request(ctx, "GET", "/directory", nil, &agents, reqOpts...); err != nil {
 		return nil, err
 	}
 	return agents, nil
@@ -247,11 +566,36 @@ func (c *Client) Directory(ctx context.Context) ([]Agent, error) {
 type SearchOptions struct {
 	Query      string
 	Capability string
-	Provider   string
+	// CapabilityVersion, with Capability, restricts results to agents
+	// whose descriptor for it has at least this version.
+	CapabilityVersion string
+	Provider          string
 }
 
 // Search searches for agents.
-func (c *Client) Search(ctx context.Context, opts *SearchOptions) ([]Agent, error) {
+func (c *Client) Search(ctx context.Context, opts *SearchOptions, reqOpts ...RequestOption) ([]Agent, error) {
+	var agents []Agent
+	if err := c.request(ctx, "GET", searchPath(opts), nil, &agents, reqOpts...); err != nil {
+		return nil, err
+	}
+	out := agents[:0]
+	for _, a := range agents {
+		if opts.matches(&a) {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+// matches applies the options the server may not filter by itself.
+func (opts *SearchOptions) matches(a *Agent) bool {
+	if opts == nil || opts.Capability == "" || opts.CapabilityVersion == "" {
+		return true
+	}
+	return a.hasCapabilityVersion(opts.Capability, opts.CapabilityVersion)
+}
+
+func searchPath(opts *SearchOptions) string {
 	params := url.Values{}
 	if opts != nil {
 		if opts.Query != "" {
@@ -263,36 +607,34 @@ func (c *Client) Search(ctx context.Context, opts *SearchOptions) ([]Agent, erro
 		if opts.Provider != "" {
 			params.Set("provider", opts.Provider)
 		}
+		if opts.CapabilityVersion != "" {
+			params.Set("capabilityVersion", opts.CapabilityVersion)
+		}
 	}
 
 	path := "/directory/search"
 	if len(params) > 0 {
 		path += "?" + params.Encode()
 	}
-
-	var agents []Agent
-	if err := c.request(ctx, "GET", path, nil, &agents); err != nil {
-		return nil, err
-	}
-	return agents, nil
+	return path
 }
 
 // Contacts lists contacts.
-func (c *Client) Contacts(ctx context.Context) ([]Contact, error) {
-	if c.AgentID == "" {
+func (c *Client) Contacts(ctx context.Context, reqOpts ...RequestOption) ([]Contact, error) {
+	if c.AgentID() == "" {
 		return nil, fmt.Errorf("not registered")
 	}
 
 	var contacts []Contact
-	if err := c.request(ctx, "GET", "/agents/"+c.AgentID+"/contacts", nil, &contacts); err != nil {
+	if err := c.request(ctx, "GET", "/agents/"+c.AgentID()+"/contacts", nil, &contacts, reqOpts...); err != nil {
 		return nil, err
 	}
 	return contacts, nil
 }
 
 // AddContact adds a contact.
-func (c *Client) AddContact(ctx context.Context, contactID, alias, notes string) error {
-	if c.AgentID == "" {
+func (c *Client) AddContact(ctx context.Context, contactID, alias, notes string, reqOpts ...RequestOption) error {
+	if c.AgentID() == "" {
 		return fmt.Errorf("not registered")
 	}
 
@@ -306,55 +648,262 @@ func (c *Client) AddContact(ctx context.Context, contactID, alias, notes string)
 		body["notes"] = notes
 	}
 
-	return c.request(ctx, "POST", "/agents/"+c.AgentID+"/contacts", body, nil)
+	err := c.request(ctx, "POST", "/agents/"+c.AgentID()+"/contacts", body, nil, reqOpts...)
+	c.contactLocales.invalidate()
+	c.audit(ctx, AuditEntry{Op: AuditAddContact, Target: contactID}, err)
+	return err
 }
 
 // RemoveContact removes a contact.
-func (c *Client) RemoveContact(ctx context.Context, contactID string) error {
-	if c.AgentID == "" {
+func (c *Client) RemoveContact(ctx context.Context, contactID string, reqOpts ...RequestOption) error {
+	if c.AgentID() == "" {
 		return fmt.Errorf("not registered")
 	}
-	return c.request(ctx, "DELETE", "/agents/"+c.AgentID+"/contacts/"+contactID, nil, nil)
+	err := c.request(ctx, "DELETE", "/agents/"+c.AgentID()+"/contacts/"+contactID, nil, nil, reqOpts...)
+	c.contactLocales.invalidate()
+	c.audit(ctx, AuditEntry{Op: AuditRemoveContact, Target: contactID}, err)
+	return err
+}
+
+// receive prepares messages delivered to the client for handlers: it
+// reassembles chunked messages, consumes acks for open flows and session
+// handshakes, decodes payloads, applies handoffs and records the result.
+func (c *Client) receive(ctx context.Context, msgs []Message) []Message {
+	msgs = c.consumeSessions(ctx, c.consumeFlowAcks(ctx, c.reassemble(c.consumeStatus(ctx, c.dropBlocked(ctx, msgs)))))
+	c.decodePayloads(ctx, msgs)
+	c.runReceiveHooks(msgs)
+	c.applyHandoffs(ctx, msgs)
+	c.record(ctx, msgs...)
+	return msgs
+}
+
+// record saves messages to the local store, if any. Store failures do not
+// fail the API call that produced the messages.
+func (c *Client) record(ctx context.Context, msgs ...Message) {
+	if c.store != nil && len(msgs) > 0 {
+		c.store.Save(ctx, msgs...)
+		c.storeReactions(ctx, msgs)
+		c.storeEdits(ctx, msgs)
+	}
 }
 
 // request makes an HTTP request to the API.
-func (c *Client) request(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+func (c *Client) request(ctx context.Context, method, path string, body interface{}, result interface{}, opts ...RequestOption) error {
+	resp, err := c.do(ctx, method, path, body, opts...)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return readAPIError(resp)
+	}
+
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return err
+		}
+	}
+	drainBody(resp)
+	return nil
+}
+
+// drainBody reads what is left of a small response body, such as the
+// newline after a JSON document, so the connection can be reused.
+func drainBody(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+}
+
+// do sends an API request with the SDK's standard headers and returns the
+// raw response. Idempotent requests are retried on transient failures
+// unless WithoutRetry is given. The caller must close the response body.
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, opts ...RequestOption) (*http.Response, error) {
+	cfg := newCallConfig(opts)
+	hc := c.httpClient
+	if cfg.timeout > 0 {
+		cp := *hc
+		cp.Timeout = cfg.timeout
+		hc = &cp
+	}
+	attempts := 1
+	if !cfg.noRetry && idempotent(method) && c.retries > 0 {
+		attempts += c.retries
+	}
+
+	var delay time.Duration
+	failovers := 0
+	for attempt := 1; ; attempt++ {
+		req, err := c.newRequest(ctx, method, path, body, cfg.header)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.roundTrip(hc, req)
+		if c.discovery != nil && c.discovery.observe(req, resp, err) && refused(err) && failovers < maxFailovers {
+			// Not a retry: the request never reached a server.
+			failovers++
+			attempt--
+			continue
+		}
+		if attempt >= attempts || !retryable(resp, err) || ctx.Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			drainBody(resp)
+			resp.Body.Close()
+		}
+		delay = c.retryBackoff().Next(attempt, delay)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, ErrCircuitOpen) && !errors.Is(err, ErrOffline)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// newRequest builds an API request with version, signature and session
+// headers set, plus any extra headers.
+func (c *Client) newRequest(ctx context.Context, method, path string, body interface{}, extra http.Header) (*http.Request, error) {
+	var bodyBytes []byte
 	var bodyReader io.Reader
+	var contentType string
+	gzipped := false
 	if body != nil {
-		bodyBytes, err := json.Marshal(body)
+		var err error
+		bodyBytes, contentType, err = c.encodeBody(ctx, path, body)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		bodyBytes, gzipped = c.compressBody(ctx, bodyBytes)
 		bodyReader = bytes.NewReader(bodyBytes)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bodyReader)
+	base, err := c.endpoint(ctx)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, base+path, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range extra {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
 	}
 	if body != nil {
-		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Type", contentType)
+	}
+	if _, ok := c.binaryEncoding(ctx, path); ok {
+		req.Header.Set("Accept", string(c.encoding)+", application/json;q=0.9")
 	}
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if c.compression {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	req.Header.Set(versionHeader, ProtocolVersion)
+	if err := c.signRequest(req, bodyBytes); err != nil {
+		return nil, err
+	}
+	if err := c.authorize(ctx, req, path); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
 
-	resp, err := c.httpClient.Do(req)
+// roundTrip sends req with hc and records the server version it reports.
+func (c *Client) roundTrip(hc *http.Client, req *http.Request) (*http.Response, error) {
+	if c.Offline() {
+		return nil, ErrOffline
+	}
+	if c.breaker != nil && !c.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+	resp, err := hc.Do(req)
+	if c.breaker != nil {
+		if req.Context().Err() != nil {
+			c.breaker.release()
+		} else {
+			c.breaker.record(serverFailure(resp, err))
+		}
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 400 {
-		var errResp struct {
-			Error string `json:"error"`
+	if v := resp.Header.Get(versionHeader); v != "" {
+		c.observeVersion(v)
+	}
+	if c.compression {
+		if err := decompressResponse(resp); err != nil {
+			return nil, err
 		}
-		json.NewDecoder(resp.Body).Decode(&errResp)
-		if errResp.Error != "" {
-			return fmt.Errorf("%s", errResp.Error)
+	}
+	// Limit the decompressed size, so a small gzip body cannot expand
+	// without bound.
+	if err := c.limitResponse(resp); err != nil {
+		return nil, err
+	}
+	if c.encoding != "" {
+		if err := transcodeResponse(resp); err != nil {
+			return nil, err
 		}
-		return fmt.Errorf("HTTP %d", resp.StatusCode)
 	}
+	guardDepth(resp)
+	return resp, nil
+}
 
-	if result != nil {
-		return json.NewDecoder(resp.Body).Decode(result)
+// APIError is an error response from the PING server.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return e.Message
 	}
-	return nil
+	return fmt.Sprintf("HTTP %d", e.StatusCode)
+}
+
+// readAPIError builds an APIError from an error response, or a
+// QuotaError if it refuses the request for an exhausted quota.
+func readAPIError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	drainBody(resp)
+	var errResp struct {
+		Error string `json:"error"`
+	}
+	json.Unmarshal(body, &errResp)
+	apiErr := &APIError{StatusCode: resp.StatusCode, Message: errResp.Error}
+	if err := quotaError(resp, apiErr, body); err != nil {
+		return err
+	}
+	return apiErr
+}
+
+// isStatus reports whether err is an APIError with the given status code.
+func isStatus(err error, code int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == code
 }
@@ -0,0 +1,489 @@
+// Package pingtest provides an in-memory PING server for tests and local
+// simulations. It implements the same HTTP API as the Node.js server,
+// including message signature verification and webhook delivery, without
+// any persistence.
+package pingtest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aetos53t/ping/sdk/go/wire"
+)
+
+// Version is the protocol version the in-memory server reports.
+const Version = "0.1.0"
+
+// Server is an in-memory PING server listening on a loopback address.
+type Server struct {
+	// URL is the base URL of the server.
+	URL string
+
+	srv *httptest.Server
+	h   *Handler
+}
+
+// NewServer starts an in-memory PING server. Call Close when done.
+func NewServer() *Server {
+	h := NewHandler()
+	srv := httptest.NewServer(h)
+	return &Server{URL: srv.URL, srv: srv, h: h}
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Handler returns the server's HTTP handler.
+func (s *Server) Handler() *Handler {
+	return s.h
+}
+
+type agent struct {
+	ID           string   `json:"id"`
+	PublicKey    string   `json:"publicKey"`
+	Name         string   `json:"name"`
+	Provider     string   `json:"provider"`
+	Capabilities []string `json:"capabilities"`
+	WebhookURL   *string  `json:"webhookUrl"`
+	IsPublic     bool     `json:"isPublic"`
+	CreatedAt    string   `json:"createdAt"`
+
+	seq int
+}
+
+type message struct {
+	ID           string          `json:"id"`
+	Type         string          `json:"type"`
+	From         string          `json:"from"`
+	To           string          `json:"to"`
+	Payload      json.RawMessage `json:"payload"`
+	ReplyTo      *string         `json:"replyTo"`
+	Timestamp    string          `json:"timestamp"`
+	Signature    string          `json:"signature"`
+	Delivered    bool            `json:"delivered"`
+	Acknowledged bool            `json:"acknowledged"`
+	// Meta is kept from the envelope, as servers that support message
+	// metadata do.
+	Meta map[string]string `json:"meta,omitempty"`
+
+	created time.Time
+}
+
+type contact struct {
+	ContactID string  `json:"contactId"`
+	Alias     *string `json:"alias"`
+	Notes     *string `json:"notes"`
+	AddedAt   string  `json:"addedAt"`
+}
+
+// Handler serves the PING API from memory. It is safe for concurrent use.
+type Handler struct {
+	mu       sync.Mutex
+	seq      int
+	agents   map[string]*agent
+	messages []*message
+	contacts map[string][]contact
+}
+
+// NewHandler creates an empty in-memory PING API handler.
+func NewHandler() *Handler {
+	return &Handler{
+		agents:   make(map[string]*agent),
+		contacts: make(map[string][]contact),
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Ping-Version", Version)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	if r.URL.Path == "/messages" && r.Method == "POST" {
+		// Locks only around its own state, so webhook handlers can call
+		// back into the server.
+		h.send(w, r)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/" && r.Method == "GET":
+		writeJSON(w, 200, map[string]interface{}{"name": "PING", "version": Version})
+	case r.URL.Path == "/health" && r.Method == "GET":
+		writeJSON(w, 200, map[string]interface{}{"status": "ok", "service": "ping", "version": Version})
+	case r.URL.Path == "/agents" && r.Method == "POST":
+		h.register(w, r)
+	case len(parts) == 2 && parts[0] == "agents" && r.Method == "GET":
+		h.getAgent(w, parts[1])
+	case len(parts) == 2 && parts[0] == "agents" && r.Method == "DELETE":
+		h.deleteAgent(w, parts[1])
+	case r.URL.Path == "/directory" && r.Method == "GET":
+		h.search(w, "", "", "")
+	case r.URL.Path == "/directory/search" && r.Method == "GET":
+		q := r.URL.Query()
+		h.search(w, q.Get("q"), q.Get("capability"), q.Get("provider"))
+	case len(parts) == 3 && parts[0] == "agents" && parts[2] == "contacts" && r.Method == "GET":
+		h.listContacts(w, parts[1])
+	case len(parts) == 3 && parts[0] == "agents" && parts[2] == "contacts" && r.Method == "POST":
+		h.addContact(w, r, parts[1])
+	case len(parts) == 4 && parts[0] == "agents" && parts[2] == "contacts" && r.Method == "DELETE":
+		h.removeContact(w, parts[1], parts[3])
+	case len(parts) == 3 && parts[0] == "agents" && parts[2] == "inbox" && r.Method == "GET":
+		h.inbox(w, parts[1], r.URL.Query().Get("all") == "true")
+	case len(parts) == 4 && parts[0] == "agents" && parts[2] == "messages" && r.Method == "GET":
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 50
+		}
+		h.history(w, parts[1], parts[3], limit)
+	case len(parts) == 3 && parts[0] == "messages" && parts[2] == "ack" && r.Method == "POST":
+		h.ack(w, parts[1])
+	default:
+		writeError(w, 404, "Not found")
+	}
+}
+
+func (h *Handler) register(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		PublicKey    string   `json:"publicKey"`
+		Name         string   `json:"name"`
+		Provider     string   `json:"provider"`
+		Capabilities []string `json:"capabilities"`
+		WebhookURL   *string  `json:"webhookUrl"`
+		IsPublic     bool     `json:"isPublic"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, 400, "Invalid JSON")
+		return
+	}
+	if body.PublicKey == "" || body.Name == "" {
+		writeError(w, 400, "publicKey and name required")
+		return
+	}
+	if b, err := hex.DecodeString(body.PublicKey); err != nil || len(b) != ed25519.PublicKeySize {
+		writeError(w, 400, "Invalid publicKey format. Expected 64 hex characters (Ed25519 public key)")
+		return
+	}
+	pub := strings.ToLower(body.PublicKey)
+	for _, a := range h.agents {
+		if a.PublicKey == pub {
+			writeJSON(w, 409, map[string]interface{}{"error": "Agent with this publicKey already exists", "id": a.ID})
+			return
+		}
+	}
+	if body.Provider == "" {
+		body.Provider = "unknown"
+	}
+	if body.Capabilities == nil {
+		body.Capabilities = []string{}
+	}
+
+	a := &agent{
+		ID:           newID(),
+		PublicKey:    pub,
+		Name:         body.Name,
+		Provider:     body.Provider,
+		Capabilities: body.Capabilities,
+		WebhookURL:   body.WebhookURL,
+		IsPublic:     body.IsPublic,
+		CreatedAt:    now(),
+	}
+	h.seq++
+	a.seq = h.seq
+	h.agents[a.ID] = a
+	writeJSON(w, 201, a)
+}
+
+func (h *Handler) getAgent(w http.ResponseWriter, id string) {
+	a, ok := h.agents[id]
+	if !ok {
+		writeError(w, 404, "Agent not found")
+		return
+	}
+	writeJSON(w, 200, a)
+}
+
+func (h *Handler) deleteAgent(w http.ResponseWriter, id string) {
+	if _, ok := h.agents[id]; !ok {
+		writeError(w, 404, "Agent not found")
+		return
+	}
+	delete(h.agents, id)
+	writeJSON(w, 200, map[string]bool{"success": true})
+}
+
+func (h *Handler) search(w http.ResponseWriter, q, capability, provider string) {
+	type summary struct {
+		ID           string   `json:"id"`
+		Name         string   `json:"name"`
+		Provider     string   `json:"provider"`
+		Capabilities []string `json:"capabilities"`
+	}
+	results := []summary{}
+	for _, a := range h.sortedAgents() {
+		if !a.IsPublic {
+			continue
+		}
+		if q != "" && !strings.Contains(strings.ToLower(a.Name), strings.ToLower(q)) &&
+			!strings.Contains(strings.ToLower(a.ID), strings.ToLower(q)) {
+			continue
+		}
+		if capability != "" && !contains(a.Capabilities, capability) {
+			continue
+		}
+		if provider != "" && a.Provider != provider {
+			continue
+		}
+		results = append(results, summary{a.ID, a.Name, a.Provider, a.Capabilities})
+	}
+	writeJSON(w, 200, results)
+}
+
+func (h *Handler) sortedAgents() []*agent {
+	agents := make([]*agent, 0, len(h.agents))
+	for _, a := range h.agents {
+		agents = append(agents, a)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].seq < agents[j].seq })
+	return agents
+}
+
+func (h *Handler) listContacts(w http.ResponseWriter, agentID string) {
+	if _, ok := h.agents[agentID]; !ok {
+		writeError(w, 404, "Agent not found")
+		return
+	}
+	contacts := h.contacts[agentID]
+	if contacts == nil {
+		contacts = []contact{}
+	}
+	writeJSON(w, 200, contacts)
+}
+
+func (h *Handler) addContact(w http.ResponseWriter, r *http.Request, agentID string) {
+	if _, ok := h.agents[agentID]; !ok {
+		writeError(w, 404, "Agent not found")
+		return
+	}
+	var body struct {
+		ContactID string  `json:"contactId"`
+		Alias     *string `json:"alias"`
+		Notes     *string `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, 400, "Invalid JSON")
+		return
+	}
+	if body.ContactID == "" {
+		writeError(w, 400, "contactId required")
+		return
+	}
+	if _, ok := h.agents[body.ContactID]; !ok {
+		writeError(w, 404, "Contact agent not found")
+		return
+	}
+	for _, c := range h.contacts[agentID] {
+		if c.ContactID == body.ContactID {
+			writeError(w, 409, "Already a contact")
+			return
+		}
+	}
+	c := contact{ContactID: body.ContactID, Alias: body.Alias, Notes: body.Notes, AddedAt: now()}
+	h.contacts[agentID] = append(h.contacts[agentID], c)
+	writeJSON(w, 201, c)
+}
+
+func (h *Handler) removeContact(w http.ResponseWriter, agentID, contactID string) {
+	contacts := h.contacts[agentID]
+	for i, c := range contacts {
+		if c.ContactID == contactID {
+			h.contacts[agentID] = append(contacts[:i:i], contacts[i+1:]...)
+			writeJSON(w, 200, map[string]bool{"success": true})
+			return
+		}
+	}
+	writeError(w, 404, "Contact not found")
+}
+
+func (h *Handler) send(w http.ResponseWriter, r *http.Request) {
+	var body wire.Envelope
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, 400, "Invalid JSON")
+		return
+	}
+	if body.Type == "" || body.From == "" || body.To == "" || body.Signature == "" {
+		writeError(w, 400, "type, from, to, and signature required")
+		return
+	}
+
+	h.mu.Lock()
+	sender, ok := h.agents[body.From]
+	if !ok {
+		h.mu.Unlock()
+		writeError(w, 404, "Sender agent not found")
+		return
+	}
+	recipient, ok := h.agents[body.To]
+	if !ok {
+		h.mu.Unlock()
+		writeError(w, 404, "Recipient agent not found")
+		return
+	}
+	if body.Verify(sender.PublicKey) != nil {
+		h.mu.Unlock()
+		writeError(w, 401, "Invalid signature")
+		return
+	}
+
+	payload := body.Payload
+	if len(payload) == 0 || string(payload) == "null" {
+		payload = json.RawMessage("{}")
+	}
+	m := &message{
+		ID:        newID(),
+		Type:      body.Type,
+		From:      body.From,
+		To:        body.To,
+		Payload:   payload,
+		Signature: body.Signature,
+		Meta:      body.Meta,
+		created:   time.Now(),
+	}
+	if body.ReplyTo != "" {
+		m.ReplyTo = &body.ReplyTo
+	}
+	m.Timestamp = m.created.UTC().Format("2006-01-02T15:04:05.000Z")
+	h.messages = append(h.messages, m)
+	delivery := *m
+	var webhookURL string
+	if recipient.WebhookURL != nil {
+		webhookURL = *recipient.WebhookURL
+	}
+	h.mu.Unlock()
+
+	method := "polling"
+	if webhookURL != "" && deliverWebhook(webhookURL, &delivery) {
+		h.mu.Lock()
+		m.Delivered = true
+		h.mu.Unlock()
+		method = "webhook"
+	}
+	writeJSON(w, 201, map[string]interface{}{
+		"id":             m.ID,
+		"delivered":      method != "polling",
+		"deliveryMethod": method,
+	})
+}
+
+// webhookClient posts webhook deliveries.
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// deliverWebhook posts m to url as the Node.js server does, with its
+// receipt time as the timestamp, and reports whether it was accepted.
+func deliverWebhook(url string, m *message) bool {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return false
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Ping-Message-Id", m.ID)
+	req.Header.Set("X-Ping-From", m.From)
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+func (h *Handler) inbox(w http.ResponseWriter, agentID string, all bool) {
+	if _, ok := h.agents[agentID]; !ok {
+		writeError(w, 404, "Agent not found")
+		return
+	}
+	out := []message{}
+	for i := len(h.messages) - 1; i >= 0; i-- {
+		m := h.messages[i]
+		if m.To != agentID || (m.Acknowledged && !all) {
+			continue
+		}
+		m.Delivered = true
+		out = append(out, *m)
+	}
+	writeJSON(w, 200, out)
+}
+
+func (h *Handler) history(w http.ResponseWriter, agentID, otherID string, limit int) {
+	out := []message{}
+	for i := len(h.messages) - 1; i >= 0 && len(out) < limit; i-- {
+		m := h.messages[i]
+		if (m.From == agentID && m.To == otherID) || (m.From == otherID && m.To == agentID) {
+			out = append(out, *m)
+		}
+	}
+	writeJSON(w, 200, out)
+}
+
+func (h *Handler) ack(w http.ResponseWriter, id string) {
+	for _, m := range h.messages {
+		if m.ID == id {
+			m.Acknowledged = true
+			writeJSON(w, 200, map[string]bool{"success": true})
+			return
+		}
+	}
+	writeError(w, 404, "Message not found")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func now() string {
+	return time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// newID returns a random UUIDv4 string, matching the server's IDs.
+func newID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
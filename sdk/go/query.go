@@ -0,0 +1,592 @@
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query is a compiled filter over stored messages, written in a small
+// expression language:
+//
+//	type = 'request' AND payload.action = 'summarize' AND ts > now()-24h
+//
+// Fields are id, type, from, to, replyTo, ts (or timestamp), delivered,
+// acknowledged and payload.<path>, where the path descends into nested
+// payload objects. Values are 'single-quoted' strings (a doubled quote
+// escapes one), numbers, true, false, null and now() optionally followed by
+// + or - a duration such as 30m, 24h or 7d. Operators are =, !=, <, <=,
+// >, >= and CONTAINS, which matches a substring case-insensitively or an
+// element of an array. Conditions combine with AND, OR, NOT and
+// parentheses; keywords are case-insensitive. A comparison with a missing
+// field is false, except = null and != null.
+type Query struct {
+	src  string
+	root queryNode
+}
+
+// ParseQuery compiles a query.
+func ParseQuery(q string) (*Query, error) {
+	toks, err := lexQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, fmt.Errorf("query: unexpected %q", p.peek().text)
+	}
+	return &Query{src: q, root: root}, nil
+}
+
+// String returns the query's source.
+func (q *Query) String() string {
+	return q.src
+}
+
+// Match reports whether msg satisfies the query.
+func (q *Query) Match(msg *Message) bool {
+	return q.root.eval(&queryEnv{msg: msg, now: time.Now()})
+}
+
+// QueryStore runs query q against s. Stores with their own Query method,
+// such as MemoryStore and FileStore, use their indexes; others are
+// scanned.
+func QueryStore(ctx context.Context, s Store, q string) ([]Message, error) {
+	if qs, ok := s.(interface {
+		Query(context.Context, string) ([]Message, error)
+	}); ok {
+		return qs.Query(ctx, q)
+	}
+	query, err := ParseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := s.List(ctx, StoreFilter{})
+	if err != nil {
+		return nil, err
+	}
+	out := msgs[:0]
+	for i := range msgs {
+		if query.Match(&msgs[i]) {
+			out = append(out, msgs[i])
+		}
+	}
+	return out, nil
+}
+
+// indexHint returns an equality condition on an indexed field that every
+// match must satisfy, if the query has one at the top level of an AND
+// chain.
+func (q *Query) indexHint() (field, value string, ok bool) {
+	var find func(n queryNode) (string, string, bool)
+	find = func(n queryNode) (string, string, bool) {
+		switch t := n.(type) {
+		case *queryAnd:
+			if f, v, ok := find(t.left); ok {
+				return f, v, true
+			}
+			return find(t.right)
+		case *queryCompare:
+			if t.op != "=" || t.value.kind != valString {
+				return "", "", false
+			}
+			switch t.field {
+			case "type", "from", "to":
+				return t.field, t.value.str, true
+			}
+		}
+		return "", "", false
+	}
+	return find(q.root)
+}
+
+// queryEnv is the evaluation state for one message. The payload is
+// decoded at most once.
+type queryEnv struct {
+	msg     *Message
+	now     time.Time
+	payload interface{}
+	decoded bool
+}
+
+func (e *queryEnv) field(name string) (interface{}, bool) {
+	switch name {
+	case "id":
+		return e.msg.ID, true
+	case "type":
+		return string(e.msg.Type), true
+	case "from":
+		return e.msg.From, true
+	case "to":
+		return e.msg.To, true
+	case "replyTo":
+		return e.msg.ReplyTo, e.msg.ReplyTo != ""
+	case "ts", "timestamp":
+		return e.msg.Timestamp.Time, !e.msg.Timestamp.IsZero()
+	case "delivered":
+		return e.msg.Delivered, true
+	case "acknowledged":
+		return e.msg.Acknowledged, true
+	}
+	path, ok := strings.CutPrefix(name, "payload.")
+	if !ok {
+		return nil, false
+	}
+	if !e.decoded {
+		e.decoded = true
+		json.Unmarshal(e.msg.Payload, &e.payload)
+	}
+	v := e.payload
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, v != nil
+}
+
+type queryNode interface {
+	eval(e *queryEnv) bool
+}
+
+type queryAnd struct{ left, right queryNode }
+type queryOr struct{ left, right queryNode }
+type queryNot struct{ inner queryNode }
+
+func (n *queryAnd) eval(e *queryEnv) bool { return n.left.eval(e) && n.right.eval(e) }
+func (n *queryOr) eval(e *queryEnv) bool  { return n.left.eval(e) || n.right.eval(e) }
+func (n *queryNot) eval(e *queryEnv) bool { return !n.inner.eval(e) }
+
+type valueKind int
+
+const (
+	valString valueKind = iota
+	valNumber
+	valBool
+	valNull
+	valNow
+)
+
+type queryValue struct {
+	kind   valueKind
+	str    string
+	num    float64
+	b      bool
+	offset time.Duration // for valNow
+}
+
+type queryCompare struct {
+	field string
+	op    string
+	value queryValue
+}
+
+func (n *queryCompare) eval(e *queryEnv) bool {
+	v, ok := e.field(n.field)
+	if n.value.kind == valNull {
+		switch n.op {
+		case "=":
+			return !ok
+		case "!=":
+			return ok
+		}
+		return false
+	}
+	if !ok {
+		return false
+	}
+	if n.op == "CONTAINS" {
+		return queryContains(v, n.value)
+	}
+	c, ok := compareQueryValue(v, n.value, e.now)
+	if !ok {
+		return n.op == "!="
+	}
+	switch n.op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// compareQueryValue compares a field value with a literal, reporting false
+// if they are not comparable.
+func compareQueryValue(v interface{}, lit queryValue, now time.Time) (int, bool) {
+	switch fv := v.(type) {
+	case time.Time:
+		var t time.Time
+		switch lit.kind {
+		case valNow:
+			t = now.Add(lit.offset)
+		case valNumber:
+			t = time.UnixMilli(int64(lit.num))
+		case valString:
+			var err error
+			if t, err = parseTimestamp(lit.str); err != nil {
+				return 0, false
+			}
+		default:
+			return 0, false
+		}
+		return fv.Compare(t), true
+	case string:
+		switch lit.kind {
+		case valString:
+			return strings.Compare(fv, lit.str), true
+		case valNow:
+			t, err := parseTimestamp(fv)
+			if err != nil {
+				return 0, false
+			}
+			return t.Compare(now.Add(lit.offset)), true
+		}
+	case float64:
+		if lit.kind == valNumber {
+			switch {
+			case fv < lit.num:
+				return -1, true
+			case fv > lit.num:
+				return 1, true
+			}
+			return 0, true
+		}
+	case bool:
+		if lit.kind == valBool {
+			if fv == lit.b {
+				return 0, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+func queryContains(v interface{}, lit queryValue) bool {
+	switch fv := v.(type) {
+	case string:
+		return lit.kind == valString && strings.Contains(strings.ToLower(fv), strings.ToLower(lit.str))
+	case []interface{}:
+		for _, x := range fv {
+			if c, ok := compareQueryValue(x, lit, time.Time{}); ok && c == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Lexer.
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+	tokDuration
+)
+
+type queryToken struct {
+	kind tokKind
+	text string
+}
+
+func lexQuery(q string) ([]queryToken, error) {
+	var toks []queryToken
+	for i := 0; i < len(q); {
+		ch := q[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case ch == '(':
+			toks = append(toks, queryToken{tokLParen, "("})
+			i++
+		case ch == ')':
+			toks = append(toks, queryToken{tokRParen, ")"})
+			i++
+		case ch == '\'':
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(q) {
+					return nil, fmt.Errorf("query: unterminated string")
+				}
+				if q[i] == '\'' {
+					if i+1 < len(q) && q[i+1] == '\'' {
+						b.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteByte(q[i])
+				i++
+			}
+			toks = append(toks, queryToken{tokString, b.String()})
+		case strings.ContainsRune("=!<>", rune(ch)):
+			op := string(ch)
+			if i+1 < len(q) && q[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("query: unexpected '!'")
+			}
+			toks = append(toks, queryToken{tokOp, op})
+			i += len(op)
+		case ch == '+' || ch == '-' || (ch >= '0' && ch <= '9') || ch == '.':
+			j := i + 1
+			for j < len(q) && (isQueryWordByte(q[j]) || q[j] == '.') {
+				j++
+			}
+			text := q[i:j]
+			kind := tokNumber
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				kind = tokDuration
+			}
+			if text == "+" || text == "-" {
+				kind = tokOp
+			}
+			toks = append(toks, queryToken{kind, text})
+			i = j
+		case isQueryWordByte(ch) || ch == '$':
+			j := i + 1
+			for j < len(q) && (isQueryWordByte(q[j]) || q[j] == '.' || q[j] == '$') {
+				j++
+			}
+			toks = append(toks, queryToken{tokIdent, q[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("query: unexpected %q", ch)
+		}
+	}
+	return append(toks, queryToken{kind: tokEOF}), nil
+}
+
+func isQueryWordByte(b byte) bool {
+	return b == '_' || b < 0x80 && (unicode.IsLetter(rune(b)) || unicode.IsDigit(rune(b)))
+}
+
+// Parser.
+
+type queryParser struct {
+	toks []queryToken
+	pos  int
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.toks[p.pos]
+}
+
+func (p *queryParser) next() queryToken {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *queryParser) keyword(kw string) bool {
+	t := p.peek()
+	if t.kind == tokIdent && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &queryOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &queryAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	if p.keyword("NOT") {
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &queryNot{inner}, nil
+	}
+	if p.peek().kind == tokLParen {
+		p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokRParen {
+			return nil, fmt.Errorf("query: missing ')'")
+		}
+		return n, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *queryParser) parseCompare() (queryNode, error) {
+	ft := p.next()
+	if ft.kind != tokIdent {
+		return nil, fmt.Errorf("query: expected field, got %q", ft.text)
+	}
+	field := ft.text
+	if strings.EqualFold(field, "timestamp") {
+		field = "ts"
+	}
+	if !validQueryField(field) {
+		return nil, fmt.Errorf("query: unknown field %q", ft.text)
+	}
+
+	var op string
+	switch t := p.peek(); {
+	case t.kind == tokOp && validQueryOp(t.text):
+		op = p.next().text
+	case p.keyword("CONTAINS"):
+		op = "CONTAINS"
+	default:
+		return nil, fmt.Errorf("query: expected operator after %s", field)
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return &queryCompare{field: field, op: op, value: value}, nil
+}
+
+func validQueryOp(op string) bool {
+	switch op {
+	case "=", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+func validQueryField(f string) bool {
+	switch f {
+	case "id", "type", "from", "to", "replyTo", "ts", "delivered", "acknowledged":
+		return true
+	}
+	path, ok := strings.CutPrefix(f, "payload.")
+	return ok && path != "" && !strings.Contains(path, "..") && !strings.HasSuffix(path, ".")
+}
+
+func (p *queryParser) parseValue() (queryValue, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return queryValue{kind: valString, str: t.text}, nil
+	case tokNumber:
+		n, _ := strconv.ParseFloat(t.text, 64)
+		return queryValue{kind: valNumber, num: n}, nil
+	case tokIdent:
+		switch strings.ToLower(t.text) {
+		case "true", "false":
+			return queryValue{kind: valBool, b: strings.EqualFold(t.text, "true")}, nil
+		case "null":
+			return queryValue{kind: valNull}, nil
+		case "now":
+			return p.parseNow()
+		}
+	}
+	return queryValue{}, fmt.Errorf("query: expected value, got %q", t.text)
+}
+
+// parseNow parses the rest of now() with an optional offset. The lexer
+// reads "-24h" as one token, or "-" and "24h" when spaced apart.
+func (p *queryParser) parseNow() (queryValue, error) {
+	if p.next().kind != tokLParen || p.next().kind != tokRParen {
+		return queryValue{}, fmt.Errorf("query: expected now()")
+	}
+	v := queryValue{kind: valNow}
+	t := p.peek()
+	var text string
+	switch {
+	case t.kind == tokDuration && (t.text[0] == '+' || t.text[0] == '-'):
+		text = p.next().text
+	case t.kind == tokOp && (t.text == "+" || t.text == "-"):
+		p.next()
+		d := p.next()
+		if d.kind != tokDuration && d.kind != tokNumber {
+			return queryValue{}, fmt.Errorf("query: expected duration after now() %s", t.text)
+		}
+		text = t.text + d.text
+	default:
+		return v, nil
+	}
+	d, err := parseQueryDuration(text[1:])
+	if err != nil {
+		return queryValue{}, err
+	}
+	if text[0] == '-' {
+		d = -d
+	}
+	v.offset = d
+	return v, nil
+}
+
+// parseQueryDuration parses a Go duration, also accepting a "d" (day)
+// unit.
+func parseQueryDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("query: invalid duration %q", s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("query: invalid duration %q", s)
+	}
+	return d, nil
+}
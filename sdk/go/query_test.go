@@ -0,0 +1,139 @@
+package ping
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryMatch(t *testing.T) {
+	msg := &Message{
+		ID:        "m1",
+		Type:      MessageTypeRequest,
+		From:      "alice",
+		To:        "bob",
+		Timestamp: Timestamp{time.Now().Add(-2 * time.Hour)},
+		Delivered: true,
+		Payload: json.RawMessage(`{"action":"summarize","text":"It's the Quarterly Report",` +
+			`"n":3,"ok":false,"tags":["urgent",7],"when":"2025-01-01T00:00:00Z",` +
+			`"meta":{"priority":"high","a.b":1},"none":null}`),
+	}
+
+	for _, tc := range []struct {
+		q    string
+		want bool
+	}{
+		// Fields and operators.
+		{`type = 'request'`, true},
+		{`type != 'request'`, false},
+		{`id = 'm1' AND from = 'alice' AND to = 'bob'`, true},
+		{`replyTo = null`, true},
+		{`replyTo != null`, false},
+		{`replyTo = ''`, false},
+		{`delivered = true AND acknowledged = false`, true},
+		{`payload.n = 3`, true},
+		{`payload.n >= 3 AND payload.n <= 3 AND payload.n > 2.5 AND payload.n < 4`, true},
+		{`payload.n > -5`, true},
+		{`payload.n = '3'`, false},
+		{`payload.n != '3'`, true},
+		{`payload.ok = false`, true},
+		{`payload.meta.priority = 'high'`, true},
+		{`payload.meta.missing = null`, true},
+		{`payload.none = null`, true},
+		{`payload.text.deeper = null`, true},
+		{`payload.missing > 1`, false},
+		{`payload.missing != 1`, false},
+		{`payload.action < 't'`, true},
+
+		// CONTAINS.
+		{`payload.text CONTAINS 'quarterly'`, true},
+		{`payload.text contains 'QUARTERLY REPORT'`, true},
+		{`payload.text CONTAINS 'annual'`, false},
+		{`payload.tags CONTAINS 'urgent'`, true},
+		{`payload.tags CONTAINS 7`, true},
+		{`payload.tags CONTAINS 'urg'`, false},
+		{`payload.n CONTAINS 3`, false},
+
+		// Timestamps.
+		{`ts > now()-24h`, true},
+		{`ts > now() - 1h`, false},
+		{`timestamp < now()`, true},
+		{`ts >= now()-1d AND ts <= now()+1m`, true},
+		{`ts > '2020-01-01T00:00:00Z'`, true},
+		{`ts > 0`, true},
+		{`payload.when < now()`, true},
+		{`payload.when > now()-7d`, false},
+
+		// Quoting.
+		{`payload.text = 'It''s the Quarterly Report'`, true},
+		{`payload.text CONTAINS ''''`, true},
+		{`payload.action = 'summarize '`, false},
+		{`payload.action = 'SUMMARIZE'`, false},
+
+		// Precedence: NOT binds tighter than AND, AND tighter than OR.
+		{`type = 'text' AND from = 'x' OR from = 'alice'`, true},
+		{`from = 'alice' OR type = 'text' AND from = 'x'`, true},
+		{`type = 'text' AND (from = 'x' OR from = 'alice')`, false},
+		{`(type = 'text' OR from = 'alice') AND to = 'bob'`, true},
+		{`NOT type = 'text' AND from = 'alice'`, true},
+		{`NOT (type = 'request' AND from = 'alice')`, false},
+		{`NOT NOT type = 'request'`, true},
+		{`not type = 'text' and from = 'alice' or id = 'x'`, true},
+		{`((((id = 'm1'))))`, true},
+	} {
+		q, err := ParseQuery(tc.q)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tc.q, err)
+			continue
+		}
+		if got := q.Match(msg); got != tc.want {
+			t.Errorf("%q matched %v, want %v", tc.q, got, tc.want)
+		}
+		if q.String() != tc.q {
+			t.Errorf("String() = %q, want %q", q.String(), tc.q)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, tc := range []struct{ q, err string }{
+		{``, "expected field"},
+		{`type`, "expected operator"},
+		{`type =`, "expected value"},
+		{`type = 'request`, "unterminated string"},
+		{`type = 'it''s`, "unterminated string"},
+		{`type ! 'x'`, "unexpected '!'"},
+		{`type = "request"`, "unexpected"},
+		{`type == 'x'`, "expected operator"},
+		{`type >== 'x'`, "expected value"},
+		{`color = 'red'`, "unknown field"},
+		{`payload. = 1`, "unknown field"},
+		{`payload.a..b = 1`, "unknown field"},
+		{`payload = 1`, "unknown field"},
+		{`(type = 'x'`, "missing ')'"},
+		{`type = 'x')`, "unexpected"},
+		{`type = 'x' AND`, "expected field"},
+		{`type = 'x' OR OR type = 'y'`, "unknown field"},
+		{`NOT`, "expected field"},
+		{`type = 'x' type = 'y'`, "unexpected"},
+		{`ts > now`, "expected now()"},
+		{`ts > now(`, "expected now()"},
+		{`ts > now() - `, "expected duration"},
+		{`ts > now() - 'x'`, "expected duration"},
+		{`ts > now()-24q`, "invalid duration"},
+		{`ts > now()-xd`, "invalid duration"},
+		{`type + 'x'`, "expected operator"},
+		{`type = -`, "expected value"},
+		{`type = 'x' # comment`, "unexpected"},
+	} {
+		_, err := ParseQuery(tc.q)
+		if err == nil {
+			t.Errorf("ParseQuery(%q) succeeded, want an error containing %q", tc.q, tc.err)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("ParseQuery(%q) = %v, want an error containing %q", tc.q, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+)
+
+// MessageTypeReaction reacts to an earlier message. Its ReplyTo is the
+// message reacted to and its payload is {"reaction": "👍"}.
+const MessageTypeReaction MessageType = "reaction"
+
+// Reaction is a lightweight signal about a message, such as "👍" for a
+// task accepted, that does not call for a reply.
+type Reaction struct {
+	// MessageID is the message reacted to.
+	MessageID string    `json:"messageId"`
+	From      string    `json:"from"`
+	Reaction  string    `json:"reaction"`
+	Timestamp Timestamp `json:"timestamp"`
+}
+
+// React sends reaction to the other party of the conversation messageID
+// belongs to. The message is looked up like GetMessage does, so without
+// the message-lookup feature it must be in the client's store.
+func (c *Client) React(ctx context.Context, messageID, reaction string, reqOpts ...RequestOption) (*SendResult, error) {
+	if reaction == "" {
+		return nil, fmt.Errorf("empty reaction")
+	}
+	msg, err := c.GetMessage(ctx, messageID, reqOpts...)
+	if err != nil {
+		return nil, err
+	}
+	to := msg.From
+	if to == c.AgentID() {
+		to = msg.To
+	}
+	return c.Send(ctx, to, MessageTypeReaction, map[string]interface{}{"reaction": reaction}, messageID, reqOpts...)
+}
+
+// DecodeReaction reads the reaction carried by a reaction message.
+func DecodeReaction(msg Message) (*Reaction, error) {
+	if msg.Type != MessageTypeReaction {
+		return nil, fmt.Errorf("not a reaction message: %s", msg.Type)
+	}
+	var p struct {
+		Reaction string `json:"reaction"`
+	}
+	if err := msg.DecodePayload(&p); err != nil {
+		return nil, err
+	}
+	if msg.ReplyTo == "" || p.Reaction == "" {
+		return nil, fmt.Errorf("malformed reaction message %s", msg.ID)
+	}
+	return &Reaction{MessageID: msg.ReplyTo, From: msg.From, Reaction: p.Reaction, Timestamp: msg.Timestamp}, nil
+}
+
+// HandleReactions returns middleware that passes reaction messages to fn
+// instead of the wrapped handler, so handlers for regular messages never
+// see them. Malformed reactions are dropped.
+func HandleReactions(fn func(ctx context.Context, r Reaction) error) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg Message) error {
+			if msg.Type != MessageTypeReaction {
+				return next(ctx, msg)
+			}
+			r, err := DecodeReaction(msg)
+			if err != nil {
+				return nil
+			}
+			return fn(ctx, *r)
+		}
+	}
+}
+
+// storeReactions adds the reactions among msgs to the stored messages they
+// react to, so Message.Reactions is filled in for stored copies.
+func (c *Client) storeReactions(ctx context.Context, msgs []Message) {
+	for _, m := range msgs {
+		if m.Type != MessageTypeReaction {
+			continue
+		}
+		r, err := DecodeReaction(m)
+		if err != nil {
+			continue
+		}
+		target, err := c.store.Load(ctx, r.MessageID)
+		if err != nil || target.hasReaction(r.From, r.Reaction) {
+			continue
+		}
+		target.Reactions = append(target.Reactions, *r)
+		c.store.Save(ctx, *target)
+	}
+}
+
+func (m *Message) hasReaction(from, reaction string) bool {
+	for _, r := range m.Reactions {
+		if r.From == from && r.Reaction == reaction {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// FeatureMessageRecall lets a sender delete a message from the recipient's
+// queue before it is acknowledged.
+const FeatureMessageRecall Feature = "message-recall"
+
+// ErrTooLateToRecall is returned by Recall when the recipient has already
+// acknowledged the message.
+var ErrTooLateToRecall = errors.New("message already acknowledged")
+
+// Recall withdraws a message the client sent, removing it from the
+// recipient's queue so it is never delivered or, if the recipient fetched
+// it but has not acknowledged it, never redelivered. It fails with
+// ErrTooLateToRecall once the message is acknowledged and with ErrNotFound
+// for unknown IDs. Recalled messages stay in History with Recalled set.
+// The stored copy, if the client has a store, is marked recalled too.
+func (c *Client) Recall(ctx context.Context, messageID string, reqOpts ...RequestOption) error {
+	if c.AgentID() == "" {
+		return fmt.Errorf("not registered")
+	}
+	if c.Passive() {
+		return ErrStandby
+	}
+	if err := c.requireFeature(ctx, FeatureMessageRecall); err != nil {
+		return err
+	}
+	err := c.request(ctx, "DELETE", "/messages/"+messageID, nil, nil, reqOpts...)
+	switch {
+	case isStatus(err, http.StatusConflict):
+		return fmt.Errorf("%w: %v", ErrTooLateToRecall, err)
+	case isStatus(err, http.StatusNotFound):
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	case err != nil:
+		return err
+	}
+	if c.store != nil {
+		if msg, err := c.store.Load(ctx, messageID); err == nil {
+			msg.Recalled = true
+			c.store.Save(ctx, *msg)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+)
+
+// threadField is the payload field carrying a message's thread ID.
+const threadField = "$thread"
+
+// MessageTypeError reports that a request could not be handled. Its
+// payload is a RemoteError; read it with Message.AsError.
+const MessageTypeError MessageType = "error"
+
+// WithThread sends a message as part of the conversation thread id.
+// Reply sets it automatically.
+func WithThread(id string) RequestOption {
+	return func(cfg *callConfig) {
+		cfg.thread = id
+	}
+}
+
+// Thread returns the ID of the conversation thread m belongs to, set by
+// WithThread or Reply, or "" if it has none.
+func (m Message) Thread() string {
+	return m.PayloadString(threadField)
+}
+
+// Reply sends a message of type msgType answering original. It goes to
+// the other party of original's conversation with ReplyTo set to
+// original's ID, and continues original's thread, or starts one rooted at
+// original.
+func (c *Client) Reply(ctx context.Context, original Message, msgType MessageType, payload map[string]interface{}, reqOpts ...RequestOption) (*SendResult, error) {
+	if original.ID == "" {
+		return nil, fmt.Errorf("reply to a message without an ID")
+	}
+	to := original.From
+	if to == c.AgentID() {
+		to = original.To
+	}
+	thread := original.Thread()
+	if thread == "" {
+		thread = original.ID
+	}
+	// Prepended so the caller's options can still name another thread.
+	reqOpts = append([]RequestOption{WithThread(thread)}, reqOpts...)
+	return c.Send(ctx, to, msgType, payload, original.ID, reqOpts...)
+}
+
+// ReplyText replies to original with a text message.
+func (c *Client) ReplyText(ctx context.Context, original Message, text string, reqOpts ...RequestOption) (*SendResult, error) {
+	return c.Reply(ctx, original, MessageTypeText, map[string]interface{}{"text": text}, reqOpts...)
+}
+
+// ReplyError tells the sender of original that it could not be handled,
+// with an error message. ReplyWithError sends a code and details too.
+func (c *Client) ReplyError(ctx context.Context, original Message, message string, reqOpts ...RequestOption) (*SendResult, error) {
+	return c.Reply(ctx, original, MessageTypeError, (&RemoteError{Message: message}).payload(), reqOpts...)
+}
+
+// attachThread returns payload with the thread ID from reqOpts added, or
+// payload itself if there is none.
+func attachThread(payload map[string]interface{}, reqOpts []RequestOption) map[string]interface{} {
+	thread := newCallConfig(reqOpts).thread
+	if thread == "" {
+		return payload
+	}
+	out := make(map[string]interface{}, len(payload)+1)
+	for k, v := range payload {
+		out[k] = v
+	}
+	out[threadField] = thread
+	return out
+}
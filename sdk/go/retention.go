@@ -0,0 +1,163 @@
+package ping
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RetentionPolicy bounds how much history a Store and an Outbox keep.
+// Zero fields impose no limit.
+type RetentionPolicy struct {
+	// MaxAge removes messages older than this. Messages without a
+	// timestamp are kept.
+	MaxAge time.Duration
+	// MaxCount keeps at most this many unpinned messages, removing the
+	// oldest first.
+	MaxCount int
+	// Pinned lists agents whose conversations are kept in full, whatever
+	// their age or size.
+	Pinned []string
+}
+
+// pinned reports whether m belongs to a pinned conversation.
+func (p *RetentionPolicy) pinned(m *Message) bool {
+	for _, peer := range p.Pinned {
+		if m.From == peer || m.To == peer {
+			return true
+		}
+	}
+	return false
+}
+
+// expired lists the IDs of the stored messages p removes, given msgs in
+// insertion order.
+func (p *RetentionPolicy) expired(msgs []Message, now time.Time) []string {
+	var ids []string
+	var kept []string
+	for i := range msgs {
+		m := &msgs[i]
+		if p.pinned(m) {
+			continue
+		}
+		if p.MaxAge > 0 && !m.Timestamp.IsZero() && now.Sub(m.Timestamp.Time) > p.MaxAge {
+			ids = append(ids, m.ID)
+			continue
+		}
+		kept = append(kept, m.ID)
+	}
+	if p.MaxCount > 0 && len(kept) > p.MaxCount {
+		ids = append(ids, kept[:len(kept)-p.MaxCount]...)
+	}
+	return ids
+}
+
+// ApplyRetention deletes the messages in store that policy does not keep,
+// in a single Delete, and returns how many were removed.
+func ApplyRetention(ctx context.Context, store Store, policy RetentionPolicy) (int, error) {
+	msgs, err := store.List(ctx, StoreFilter{})
+	if err != nil {
+		return 0, err
+	}
+	ids := policy.expired(msgs, time.Now())
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if err := store.Delete(ctx, ids...); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// Compactor is a Service that applies a RetentionPolicy to a client's
+// store and outbox in the background, so long-running agents do not grow
+// their local history without bound.
+type Compactor struct {
+	// Store is the store to prune. Either Store or Outbox may be nil.
+	Store Store
+	// Outbox is the outbox to prune. Dropped entries are never sent.
+	Outbox *Outbox
+	// Policy is the retention policy. Pin and Unpin change its Pinned
+	// list while the compactor runs.
+	Policy RetentionPolicy
+	// Interval between compactions. Defaults to 1h. The first compaction
+	// runs when Run starts.
+	Interval time.Duration
+	// OnCompact, if set, is called after each compaction with the number
+	// of messages removed from the store and the outbox entries dropped.
+	OnCompact func(removed int, dropped []OutboxEntry)
+	// OnError, if set, receives compaction errors.
+	OnError func(error)
+
+	mu sync.Mutex
+}
+
+// Pin keeps the conversation with peer in full from the next compaction
+// on.
+func (c *Compactor) Pin(peer string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range c.Policy.Pinned {
+		if p == peer {
+			return
+		}
+	}
+	c.Policy.Pinned = append(c.Policy.Pinned, peer)
+}
+
+// Unpin subjects the conversation with peer to the policy's limits again.
+func (c *Compactor) Unpin(peer string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pinned := c.Policy.Pinned[:0:0]
+	for _, p := range c.Policy.Pinned {
+		if p != peer {
+			pinned = append(pinned, p)
+		}
+	}
+	c.Policy.Pinned = pinned
+}
+
+// Compact applies the policy once.
+func (c *Compactor) Compact(ctx context.Context) error {
+	c.mu.Lock()
+	policy := c.Policy
+	c.mu.Unlock()
+
+	var removed int
+	var dropped []OutboxEntry
+	if c.Store != nil {
+		n, err := ApplyRetention(ctx, c.Store, policy)
+		if err != nil {
+			return err
+		}
+		removed = n
+	}
+	if c.Outbox != nil {
+		d, err := c.Outbox.Prune(policy)
+		if err != nil {
+			return err
+		}
+		dropped = d
+	}
+	if c.OnCompact != nil {
+		c.OnCompact(removed, dropped)
+	}
+	return nil
+}
+
+// Run implements Service.
+func (c *Compactor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(durationOr(c.Interval, time.Hour))
+	defer ticker.Stop()
+	for {
+		if err := c.Compact(ctx); err != nil && ctx.Err() == nil {
+			reportError(c.OnError, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
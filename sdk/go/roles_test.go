@@ -0,0 +1,29 @@
+package ping
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+)
+
+func TestRoleClaimSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim, err := IssueRoleClaim(priv, "org-1", "agent-1", []string{"viewer,admin"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := claim.Verify(pub); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	// Under the old comma-joined encoding, splitting the role kept the
+	// signature valid.
+	forged := *claim
+	forged.Roles = []string{"viewer", "admin"}
+	if err := forged.Verify(pub); err == nil {
+		t.Errorf("Verify accepted roles %q signed as %q", forged.Roles, claim.Roles)
+	}
+}
@@ -0,0 +1,131 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Service is a background component managed by a Runner, such as a Poller,
+// Heartbeat, Outbox or Subscription.
+//
+// Run blocks until ctx is cancelled or the service fails. When ctx is
+// cancelled it should finish any in-flight work (e.g. a running handler)
+// before returning.
+type Service interface {
+	Run(ctx context.Context) error
+}
+
+// Flusher is implemented by services that hold pending work, such as an
+// Outbox. A Runner calls Flush after Run has returned during Stop.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// Handler processes an incoming message.
+type Handler func(ctx context.Context, msg Message) error
+
+// ErrRunnerStarted is returned by Runner.Start if the runner is already
+// running.
+var ErrRunnerStarted = errors.New("runner already started")
+
+// Runner owns a set of background services and gives them a common
+// lifecycle: Start launches every service, Stop cancels them, waits for
+// in-flight handlers to drain and flushes pending work before returning.
+type Runner struct {
+	mu       sync.Mutex
+	services []Service
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	errs     []error
+}
+
+// NewRunner returns a runner managing services.
+func NewRunner(services ...Service) *Runner {
+	return &Runner{services: services}
+}
+
+// Add registers more services. Services added while the runner is running
+// are started immediately.
+func (r *Runner) Add(services ...Service) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.services = append(r.services, services...)
+	if r.cancel != nil {
+		for _, s := range services {
+			r.launch(r.ctx, s)
+		}
+	}
+}
+
+// Start launches every service in its own goroutine. The services run until
+// Stop is called or ctx is cancelled.
+func (r *Runner) Start(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil {
+		return ErrRunnerStarted
+	}
+	r.ctx, r.cancel = context.WithCancel(ctx)
+	r.errs = nil
+	for _, s := range r.services {
+		r.launch(r.ctx, s)
+	}
+	return nil
+}
+
+// launch must be called with r.mu held and the runner started.
+func (r *Runner) launch(ctx context.Context, s Service) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		if err := s.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			r.mu.Lock()
+			r.errs = append(r.errs, fmt.Errorf("%T: %w", s, err))
+			r.mu.Unlock()
+		}
+	}()
+}
+
+// Stop cancels all services, waits for them to return and flushes any
+// Flusher. If ctx expires first Stop returns ctx.Err() without waiting
+// further. Errors returned by services while running are joined into the
+// result.
+func (r *Runner) Stop(ctx context.Context) error {
+	r.mu.Lock()
+	cancel := r.cancel
+	services := append([]Service(nil), r.services...)
+	r.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	r.mu.Lock()
+	errs := r.errs
+	r.errs = nil
+	r.ctx, r.cancel = nil, nil
+	r.mu.Unlock()
+
+	for _, s := range services {
+		if f, ok := s.(Flusher); ok {
+			if err := f.Flush(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("%T: flush: %w", s, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,275 @@
+package scenario
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	ping "github.com/aetos53t/ping/sdk/go"
+	"github.com/aetos53t/ping/sdk/go/pingtest"
+)
+
+const defaultWithin = 5 * time.Second
+
+// Runner executes scenarios.
+type Runner struct {
+	// BaseURL is the PING server to run against. If empty, each run starts
+	// a fresh in-memory server.
+	BaseURL string
+	// PollInterval is how often expect steps check inboxes. Defaults to 50ms.
+	PollInterval time.Duration
+}
+
+// Report is the outcome of one scenario run.
+type Report struct {
+	Scenario string
+	Steps    []StepResult
+	Duration time.Duration
+}
+
+// StepResult is the outcome of one step. Steps after a failure are
+// reported as skipped.
+type StepResult struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+	Skipped  bool
+}
+
+// Passed reports whether every step succeeded.
+func (r *Report) Passed() bool {
+	for _, s := range r.Steps {
+		if s.Err != nil || s.Skipped {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteTo writes a human-readable table of step results to w.
+func (r *Report) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	status := "PASS"
+	if !r.Passed() {
+		status = "FAIL"
+	}
+	fmt.Fprintf(&b, "%s %s (%s)\n", status, r.Scenario, r.Duration.Round(time.Millisecond))
+	tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	for i, s := range r.Steps {
+		result := "ok"
+		switch {
+		case s.Skipped:
+			result = "skipped"
+		case s.Err != nil:
+			result = "FAIL: " + s.Err.Error()
+		}
+		fmt.Fprintf(tw, "  %d.\t%s\t%s\t%s\n", i+1, s.Name, s.Duration.Round(time.Millisecond), result)
+	}
+	tw.Flush()
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// run holds per-run agent state.
+type run struct {
+	r       *Runner
+	clients map[string]*ping.Client
+	names   map[string]string // agent ID -> scenario name
+	pending map[string][]ping.Message
+	seen    map[string]bool
+	last    map[string]ping.Message
+}
+
+// Run registers the scenario's agents and executes its steps in order,
+// stopping at the first failure. The returned error reports setup
+// failures only; step failures are recorded in the report.
+func (r *Runner) Run(ctx context.Context, s *Scenario) (*Report, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	baseURL := r.BaseURL
+	if baseURL == "" {
+		srv := pingtest.NewServer()
+		defer srv.Close()
+		baseURL = srv.URL
+	}
+
+	st := &run{
+		r:       r,
+		clients: make(map[string]*ping.Client),
+		names:   make(map[string]string),
+		pending: make(map[string][]ping.Message),
+		seen:    make(map[string]bool),
+		last:    make(map[string]ping.Message),
+	}
+	suffix := fmt.Sprintf("%d", time.Now().UnixNano())
+	for _, name := range s.Agents {
+		c := ping.NewClient(baseURL)
+		agent, err := c.Register(ctx, s.Name+"-"+name+"-"+suffix, &ping.RegisterOptions{Provider: "scenario"})
+		if err != nil {
+			return nil, fmt.Errorf("register %s: %w", name, err)
+		}
+		st.clients[name] = c
+		st.names[agent.ID] = name
+	}
+
+	report := &Report{Scenario: s.Name}
+	start := time.Now()
+	failed := false
+	for i := range s.Steps {
+		step := &s.Steps[i]
+		res := StepResult{Name: step.describe()}
+		if failed {
+			res.Skipped = true
+		} else {
+			t0 := time.Now()
+			res.Err = st.exec(ctx, step)
+			res.Duration = time.Since(t0)
+			failed = res.Err != nil
+		}
+		report.Steps = append(report.Steps, res)
+	}
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+func (st *run) exec(ctx context.Context, step *Step) error {
+	switch {
+	case step.Send != nil:
+		from := st.clients[step.Send.From]
+		for _, to := range step.Send.To {
+			if _, err := from.Send(ctx, st.clients[to].AgentID(), ping.MessageType(step.Send.Type), step.Send.Payload, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	case step.Reply != nil:
+		orig, ok := st.last[step.Reply.From]
+		if !ok {
+			return fmt.Errorf("%s has not received a message to reply to", step.Reply.From)
+		}
+		_, err := st.clients[step.Reply.From].Send(ctx, orig.From, ping.MessageType(step.Reply.Type), step.Reply.Payload, orig.ID)
+		return err
+	case step.Expect != nil:
+		return st.expect(ctx, step.Expect)
+	default:
+		select {
+		case <-time.After(time.Duration(step.Wait)):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (st *run) expect(ctx context.Context, e *ExpectStep) error {
+	within := time.Duration(e.Within)
+	if within == 0 {
+		within = defaultWithin
+	}
+	interval := st.r.PollInterval
+	if interval == 0 {
+		interval = 50 * time.Millisecond
+	}
+	deadline := time.Now().Add(within)
+	client := st.clients[e.Agent]
+
+	for {
+		if err := st.poll(ctx, e.Agent); err != nil {
+			return err
+		}
+		for i, m := range st.pending[e.Agent] {
+			if !st.matches(e, m) {
+				continue
+			}
+			if e.Absent {
+				return fmt.Errorf("unexpected %s message from %s", m.Type, st.names[m.From])
+			}
+			st.pending[e.Agent] = append(st.pending[e.Agent][:i:i], st.pending[e.Agent][i+1:]...)
+			st.last[e.Agent] = m
+			return client.Ack(ctx, m.ID)
+		}
+
+		if time.Now().After(deadline) {
+			if e.Absent {
+				return nil
+			}
+			return fmt.Errorf("no matching message within %s", within)
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// poll moves new inbox messages for agent into its pending queue, oldest
+// first.
+func (st *run) poll(ctx context.Context, agent string) error {
+	msgs, err := st.clients[agent].Inbox(ctx)
+	if err != nil {
+		return err
+	}
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if !st.seen[msgs[i].ID] {
+			st.seen[msgs[i].ID] = true
+			st.pending[agent] = append(st.pending[agent], msgs[i])
+		}
+	}
+	return nil
+}
+
+func (st *run) matches(e *ExpectStep, m ping.Message) bool {
+	if e.From != "" && st.names[m.From] != e.From {
+		return false
+	}
+	if e.Type != "" && m.Type != ping.MessageType(e.Type) {
+		return false
+	}
+	if len(e.Payload) == 0 {
+		return true
+	}
+	payload, err := m.PayloadMap()
+	if err != nil {
+		return false
+	}
+	for k, want := range e.Payload {
+		if !equalJSON(payload[k], want) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalJSON compares values decoded from different sources, treating all
+// numbers as float64.
+func equalJSON(a, b interface{}) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case int:
+		return float64(t)
+	case int64:
+		return float64(t)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, x := range t {
+			out[k] = normalize(x)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, x := range t {
+			out[i] = normalize(x)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,223 @@
+// Package scenario runs scripted multi-agent interactions against a PING
+// server and reports per-step timings, for regression-testing agent
+// interaction patterns.
+//
+// A scenario names its agents and lists steps. Agents are registered fresh
+// for every run. In YAML:
+//
+//	name: summarize
+//	agents: [planner, worker, auditor]
+//	steps:
+//	  - send: {from: planner, to: worker, type: request, payload: {action: summarize}}
+//	  - expect: {agent: worker, from: planner, type: request, within: 2s}
+//	  - reply: {from: worker, type: response, payload: {result: ok}}
+//	  - expect: {agent: planner, type: response, within: 2s}
+//	  - expect: {agent: auditor, absent: true, within: 500ms}
+package scenario
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aetos53t/ping/sdk/go/internal/yamlite"
+)
+
+// Scenario is a scripted interaction between named agents.
+type Scenario struct {
+	Name   string   `json:"name"`
+	Agents []string `json:"agents"`
+	Steps  []Step   `json:"steps"`
+}
+
+// Step is one action or assertion. Exactly one of Send, Reply, Expect and
+// Wait is set.
+type Step struct {
+	// Name labels the step in reports. Defaults to a description.
+	Name   string      `json:"name,omitempty"`
+	Send   *SendStep   `json:"send,omitempty"`
+	Reply  *ReplyStep  `json:"reply,omitempty"`
+	Expect *ExpectStep `json:"expect,omitempty"`
+	Wait   Duration    `json:"wait,omitempty"`
+}
+
+// SendStep sends a message from one agent to one or more others.
+type SendStep struct {
+	From    string                 `json:"from"`
+	To      Recipients             `json:"to"`
+	Type    string                 `json:"type"`
+	Payload map[string]interface{} `json:"payload,omitempty"`
+}
+
+// ReplyStep replies to the last message the From agent received.
+type ReplyStep struct {
+	From    string                 `json:"from"`
+	Type    string                 `json:"type"`
+	Payload map[string]interface{} `json:"payload,omitempty"`
+}
+
+// ExpectStep waits for a matching message in an agent's inbox. Payload
+// matches if every key it lists has an equal value in the message. With
+// Absent set, the step instead fails if a match arrives within the window.
+type ExpectStep struct {
+	Agent   string                 `json:"agent"`
+	From    string                 `json:"from,omitempty"`
+	Type    string                 `json:"type,omitempty"`
+	Payload map[string]interface{} `json:"payload,omitempty"`
+	// Within bounds the wait. Defaults to 5s.
+	Within Duration `json:"within,omitempty"`
+	Absent bool     `json:"absent,omitempty"`
+}
+
+// Recipients is one or more agent names. It unmarshals from a string or a
+// list of strings.
+type Recipients []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (r *Recipients) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*r = Recipients{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("recipients must be a name or list of names")
+	}
+	*r = many
+	return nil
+}
+
+// Duration is a time.Duration that unmarshals from strings like "2s" or
+// from a number of milliseconds.
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = Duration(v)
+		return nil
+	}
+	var ms float64
+	if err := json.Unmarshal(data, &ms); err != nil {
+		return fmt.Errorf("duration must be a string like \"2s\" or milliseconds")
+	}
+	*d = Duration(ms * float64(time.Millisecond))
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Parse parses a scenario from YAML or JSON and validates it.
+func Parse(data []byte) (*Scenario, error) {
+	var s Scenario
+	var err error
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		err = json.Unmarshal(trimmed, &s)
+	} else {
+		err = yamlite.Unmarshal(data, &s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse scenario: %w", err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Load reads and parses a scenario file.
+func Load(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Validate checks that every step is well-formed and names known agents.
+func (s *Scenario) Validate() error {
+	known := make(map[string]bool, len(s.Agents))
+	for _, a := range s.Agents {
+		if known[a] {
+			return fmt.Errorf("scenario %q: duplicate agent %q", s.Name, a)
+		}
+		known[a] = true
+	}
+	check := func(i int, names ...string) error {
+		for _, n := range names {
+			if !known[n] {
+				return fmt.Errorf("scenario %q: step %d: unknown agent %q", s.Name, i+1, n)
+			}
+		}
+		return nil
+	}
+
+	for i, st := range s.Steps {
+		set := 0
+		var err error
+		if st.Send != nil {
+			set++
+			if len(st.Send.To) == 0 || st.Send.Type == "" {
+				return fmt.Errorf("scenario %q: step %d: send needs to and type", s.Name, i+1)
+			}
+			err = check(i, append([]string{st.Send.From}, st.Send.To...)...)
+		}
+		if st.Reply != nil {
+			set++
+			if st.Reply.Type == "" {
+				return fmt.Errorf("scenario %q: step %d: reply needs type", s.Name, i+1)
+			}
+			err = check(i, st.Reply.From)
+		}
+		if st.Expect != nil {
+			set++
+			err = check(i, st.Expect.Agent)
+			if err == nil && st.Expect.From != "" {
+				err = check(i, st.Expect.From)
+			}
+		}
+		if st.Wait != 0 {
+			set++
+		}
+		if err != nil {
+			return err
+		}
+		if set != 1 {
+			return fmt.Errorf("scenario %q: step %d: exactly one of send, reply, expect or wait required", s.Name, i+1)
+		}
+	}
+	return nil
+}
+
+// describe returns a default label for step st.
+func (st *Step) describe() string {
+	switch {
+	case st.Name != "":
+		return st.Name
+	case st.Send != nil:
+		return fmt.Sprintf("%s sends %s to %v", st.Send.From, st.Send.Type, []string(st.Send.To))
+	case st.Reply != nil:
+		return fmt.Sprintf("%s replies %s", st.Reply.From, st.Reply.Type)
+	case st.Expect != nil && st.Expect.Absent:
+		return fmt.Sprintf("%s receives nothing within %s", st.Expect.Agent, time.Duration(st.Expect.Within))
+	case st.Expect != nil:
+		what := st.Expect.Type
+		if what == "" {
+			what = "a message"
+		}
+		return fmt.Sprintf("%s receives %s", st.Expect.Agent, what)
+	default:
+		return fmt.Sprintf("wait %s", time.Duration(st.Wait))
+	}
+}
@@ -0,0 +1,66 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// FeatureScheduledSend lets the server hold a message until a given time
+// before delivering it.
+const FeatureScheduledSend Feature = "scheduled-send"
+
+// sendAtHeader carries the delivery time, in Unix milliseconds, of a
+// scheduled message.
+const sendAtHeader = "X-Ping-Send-At"
+
+// SendOptions schedules a message for later delivery.
+type SendOptions struct {
+	// SendAt is when the message is delivered.
+	SendAt time.Time
+	// Delay, if SendAt is zero, delivers the message this long from now.
+	Delay time.Duration
+}
+
+// at returns the delivery time, or zero for immediate delivery.
+func (o SendOptions) at() time.Time {
+	if !o.SendAt.IsZero() {
+		return o.SendAt
+	}
+	if o.Delay > 0 {
+		return time.Now().Add(o.Delay)
+	}
+	return time.Time{}
+}
+
+// SendLater sends a message that the server holds until opts.SendAt (or
+// opts.Delay from now). It requires FeatureScheduledSend; use
+// Outbox.SendLater to fall back to client-side scheduling. A time in the
+// past sends the message immediately.
+func (c *Client) SendLater(ctx context.Context, to string, msgType MessageType, payload map[string]interface{}, replyTo string, opts SendOptions, reqOpts ...RequestOption) (*SendResult, error) {
+	at := opts.at()
+	if !at.After(time.Now()) {
+		return c.Send(ctx, to, msgType, payload, replyTo, reqOpts...)
+	}
+	if err := c.requireFeature(ctx, FeatureScheduledSend); err != nil {
+		return nil, err
+	}
+	reqOpts = append(reqOpts[:len(reqOpts):len(reqOpts)], WithHeader(sendAtHeader, strconv.FormatInt(at.UnixMilli(), 10)))
+	return c.Send(ctx, to, msgType, payload, replyTo, reqOpts...)
+}
+
+// SendLater schedules a message. On servers with FeatureScheduledSend it
+// is sent now and held by the server; otherwise it is queued in the
+// outbox and sent once due, in which case the result is nil. With Path
+// set the queued message survives restarts.
+func (o *Outbox) SendLater(ctx context.Context, to string, msgType MessageType, payload map[string]interface{}, replyTo string, opts SendOptions) (*SendResult, error) {
+	at := opts.at()
+	if at.IsZero() {
+		return nil, fmt.Errorf("SendLater: no SendAt or Delay")
+	}
+	if o.Client.requireFeature(ctx, FeatureScheduledSend) == nil {
+		return o.Client.SendLater(ctx, to, msgType, payload, replyTo, opts)
+	}
+	return nil, o.enqueue(OutboxEntry{To: to, Type: msgType, Payload: payload, ReplyTo: replyTo, Meta: MetaFromContext(ctx), SendAt: at})
+}
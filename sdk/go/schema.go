@@ -0,0 +1,613 @@
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ErrInvalidPayload is matched by errors.Is for a *SchemaError.
+var ErrInvalidPayload = errors.New("payload does not match schema")
+
+// Schema is a compiled JSON Schema. It supports the keywords that describe
+// message payloads: type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, uniqueItems, minLength,
+// maxLength, pattern, minimum, maximum, exclusiveMinimum,
+// exclusiveMaximum, multipleOf, allOf, anyOf, oneOf and not. Other
+// keywords, such as title, description and format, are ignored.
+type Schema struct {
+	// never is set for the schema false, which matches nothing.
+	never bool
+
+	types      []string
+	enum       []interface{}
+	constVal   interface{}
+	hasConst   bool
+	properties map[string]*Schema
+	required   []string
+	// additional applies to properties not listed in properties;
+	// noAdditional forbids them.
+	additional   *Schema
+	noAdditional bool
+	items        *Schema
+	minItems     *int
+	maxItems     *int
+	uniqueItems  bool
+	minLength    *int
+	maxLength    *int
+	pattern      *regexp.Regexp
+	minimum      *float64
+	maximum      *float64
+	exclMinimum  *float64
+	exclMaximum  *float64
+	multipleOf   *float64
+	allOf        []*Schema
+	anyOf        []*Schema
+	oneOf        []*Schema
+	not          *Schema
+}
+
+// ParseSchema compiles a JSON Schema document.
+func ParseSchema(data []byte) (*Schema, error) {
+	var tree interface{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("schema: %w", err)
+	}
+	return compileSchema(tree, "")
+}
+
+// SchemaViolation is one way a payload fails its schema.
+type SchemaViolation struct {
+	// Path is a JSON pointer to the offending value, "" for the payload.
+	Path    string
+	Message string
+}
+
+func (v SchemaViolation) String() string {
+	if v.Path == "" {
+		return v.Message
+	}
+	return v.Path + ": " + v.Message
+}
+
+// SchemaError reports a payload that does not match the schema registered
+// for its message. It matches ErrInvalidPayload with errors.Is.
+type SchemaError struct {
+	// Key is the schema's registration key, e.g. "request:summarize".
+	Key string
+	// MessageID is the ID of the received message that failed, or "" for
+	// a payload being sent.
+	MessageID  string
+	Violations []SchemaViolation
+}
+
+func (e *SchemaError) Error() string {
+	msg := fmt.Sprintf("payload does not match schema %s: %s", e.Key, e.Violations[0])
+	if n := len(e.Violations) - 1; n > 0 {
+		msg += fmt.Sprintf(" (and %d more)", n)
+	}
+	return msg
+}
+
+// Is reports whether target is ErrInvalidPayload.
+func (e *SchemaError) Is(target error) bool {
+	return target == ErrInvalidPayload
+}
+
+// Validate checks v, a value as decoded by encoding/json into
+// interface{}, against s and returns the violations found.
+func (s *Schema) Validate(v interface{}) []SchemaViolation {
+	var out []SchemaViolation
+	s.validate(v, "", &out)
+	return out
+}
+
+// schemaRegistry holds a client's registered payload schemas.
+type schemaRegistry struct {
+	mu      sync.RWMutex
+	schemas map[string]*Schema
+}
+
+// RegisterSchema registers a payload schema for a message type, e.g.
+// "proposal", or for a request action, e.g. "request:summarize", which
+// applies to request messages whose payload "action" is summarize and
+// takes precedence over a schema for the type. schema is a *Schema, a JSON
+// document as []byte, json.RawMessage or string, or any value that
+// marshals to one.
+//
+// Registered schemas are checked by ValidatePayload, by SchemaMiddleware
+// for received messages and, with WithSchemaValidation or strict mode, by
+// Send.
+func (c *Client) RegisterSchema(key string, schema interface{}) error {
+	var (
+		s   *Schema
+		err error
+	)
+	switch v := schema.(type) {
+	case *Schema:
+		s = v
+	case []byte:
+		s, err = ParseSchema(v)
+	case json.RawMessage:
+		s, err = ParseSchema(v)
+	case string:
+		s, err = ParseSchema([]byte(v))
+	default:
+		var data []byte
+		if data, err = json.Marshal(v); err == nil {
+			s, err = ParseSchema(data)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("schema %s: %w", key, err)
+	}
+	c.schemas.mu.Lock()
+	defer c.schemas.mu.Unlock()
+	if c.schemas.schemas == nil {
+		c.schemas.schemas = make(map[string]*Schema)
+	}
+	c.schemas.schemas[key] = s
+	return nil
+}
+
+// WithSchemaValidation makes Send check payloads against the registered
+// schemas, failing with a *PreflightError whose Err is a *SchemaError.
+// Strict mode does this too.
+func WithSchemaValidation() Option {
+	return func(c *Client) {
+		c.schemaCheck = true
+	}
+}
+
+// ValidatePayload checks payload against the schema registered for a
+// message of msgType, leaving out "$"-prefixed fields. It returns a *SchemaError if the payload does not
+// match, and nil if it does or no schema applies.
+func (c *Client) ValidatePayload(msgType MessageType, payload map[string]interface{}) error {
+	var action string
+	if msgType == MessageTypeRequest {
+		action, _ = payload["action"].(string)
+	}
+	key, s := c.schemaFor(msgType, action)
+	if s == nil {
+		return nil
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	return checkSchema(key, s, withoutSDKFields(v))
+}
+
+// validateMessage checks a received message against its schema.
+// Fields the SDK adds, such as "$thread" and "$meta", are not checked.
+func (c *Client) validateMessage(msg Message) error {
+	var action string
+	if msg.Type == MessageTypeRequest {
+		action = msg.PayloadString("action")
+	}
+	key, s := c.schemaFor(msg.Type, action)
+	if s == nil {
+		return nil
+	}
+	var v interface{}
+	if err := msg.DecodePayload(&v); err != nil {
+		return &SchemaError{Key: key, MessageID: msg.ID, Violations: []SchemaViolation{{Message: "payload is not JSON"}}}
+	}
+	err := checkSchema(key, s, withoutSDKFields(v))
+	if se, ok := err.(*SchemaError); ok {
+		se.MessageID = msg.ID
+	}
+	return err
+}
+
+// withoutSDKFields returns a payload without the "$"-prefixed fields the
+// SDK adds to it, so schemas need not allow them.
+func withoutSDKFields(v interface{}) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	out := make(map[string]interface{}, len(m))
+	for k, e := range m {
+		if !strings.HasPrefix(k, "$") {
+			out[k] = e
+		}
+	}
+	return out
+}
+
+func checkSchema(key string, s *Schema, v interface{}) error {
+	if violations := s.Validate(v); len(violations) > 0 {
+		return &SchemaError{Key: key, Violations: violations}
+	}
+	return nil
+}
+
+func (c *Client) schemaFor(msgType MessageType, action string) (string, *Schema) {
+	c.schemas.mu.RLock()
+	defer c.schemas.mu.RUnlock()
+	if action != "" {
+		key := string(msgType) + ":" + action
+		if s := c.schemas.schemas[key]; s != nil {
+			return key, s
+		}
+	}
+	return string(msgType), c.schemas.schemas[string(msgType)]
+}
+
+// SchemaMiddleware validates received payloads against the registered
+// schemas before next runs. For a message that does not match, next is
+// not called and its *SchemaError, with the message's ID, is returned to
+// the caller's error handler, such as a Poller's OnError. The message is
+// not acknowledged, so it stays in the inbox until the error handler acks
+// it.
+func (c *Client) SchemaMiddleware(next Handler) Handler {
+	return func(ctx context.Context, msg Message) error {
+		if err := c.validateMessage(msg); err != nil {
+			return err
+		}
+		return next(ctx, msg)
+	}
+}
+
+func compileSchema(tree interface{}, path string) (*Schema, error) {
+	switch v := tree.(type) {
+	case bool:
+		return &Schema{never: !v}, nil
+	case map[string]interface{}:
+		return compileObject(v, path)
+	}
+	return nil, fmt.Errorf("schema%s: must be an object or boolean", atPath(path))
+}
+
+func compileObject(m map[string]interface{}, path string) (*Schema, error) {
+	s := &Schema{}
+	bad := func(kw string) error {
+		return fmt.Errorf("schema%s: invalid %s", atPath(path+"/"+kw), kw)
+	}
+	sub := func(kw string) (*Schema, error) {
+		return compileSchema(m[kw], path+"/"+kw)
+	}
+	subs := func(kw string) ([]*Schema, error) {
+		list, ok := m[kw].([]interface{})
+		if !ok || len(list) == 0 {
+			return nil, bad(kw)
+		}
+		out := make([]*Schema, len(list))
+		for i, item := range list {
+			var err error
+			if out[i], err = compileSchema(item, path+"/"+kw+"/"+strconv.Itoa(i)); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	number := func(kw string) (*float64, error) {
+		f, ok := m[kw].(float64)
+		if !ok {
+			return nil, bad(kw)
+		}
+		return &f, nil
+	}
+	count := func(kw string) (*int, error) {
+		f, ok := m[kw].(float64)
+		if !ok || f < 0 || f != math.Trunc(f) {
+			return nil, bad(kw)
+		}
+		n := int(f)
+		return &n, nil
+	}
+
+	var err error
+	for kw, val := range m {
+		switch kw {
+		case "type":
+			switch t := val.(type) {
+			case string:
+				s.types = []string{t}
+			case []interface{}:
+				for _, item := range t {
+					name, ok := item.(string)
+					if !ok {
+						return nil, bad(kw)
+					}
+					s.types = append(s.types, name)
+				}
+			default:
+				return nil, bad(kw)
+			}
+			for _, name := range s.types {
+				switch name {
+				case "null", "boolean", "object", "array", "number", "integer", "string":
+				default:
+					return nil, fmt.Errorf("schema%s: unknown type %q", atPath(path+"/type"), name)
+				}
+			}
+		case "enum":
+			list, ok := val.([]interface{})
+			if !ok {
+				return nil, bad(kw)
+			}
+			s.enum = list
+		case "const":
+			s.constVal, s.hasConst = val, true
+		case "properties":
+			props, ok := val.(map[string]interface{})
+			if !ok {
+				return nil, bad(kw)
+			}
+			s.properties = make(map[string]*Schema, len(props))
+			for name, p := range props {
+				if s.properties[name], err = compileSchema(p, path+"/properties/"+escapePointer(name)); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			list, ok := val.([]interface{})
+			if !ok {
+				return nil, bad(kw)
+			}
+			for _, item := range list {
+				name, ok := item.(string)
+				if !ok {
+					return nil, bad(kw)
+				}
+				s.required = append(s.required, name)
+			}
+		case "additionalProperties":
+			if b, ok := val.(bool); ok {
+				s.noAdditional = !b
+			} else if s.additional, err = sub(kw); err != nil {
+				return nil, err
+			}
+		case "items":
+			if s.items, err = sub(kw); err != nil {
+				return nil, err
+			}
+		case "minItems":
+			s.minItems, err = count(kw)
+		case "maxItems":
+			s.maxItems, err = count(kw)
+		case "uniqueItems":
+			s.uniqueItems, _ = val.(bool)
+		case "minLength":
+			s.minLength, err = count(kw)
+		case "maxLength":
+			s.maxLength, err = count(kw)
+		case "pattern":
+			p, ok := val.(string)
+			if !ok {
+				return nil, bad(kw)
+			}
+			if s.pattern, err = regexp.Compile(p); err != nil {
+				return nil, fmt.Errorf("schema%s: %w", atPath(path+"/pattern"), err)
+			}
+		case "minimum":
+			s.minimum, err = number(kw)
+		case "maximum":
+			s.maximum, err = number(kw)
+		case "exclusiveMinimum":
+			s.exclMinimum, err = number(kw)
+		case "exclusiveMaximum":
+			s.exclMaximum, err = number(kw)
+		case "multipleOf":
+			if s.multipleOf, err = number(kw); err == nil && *s.multipleOf <= 0 {
+				err = bad(kw)
+			}
+		case "allOf":
+			s.allOf, err = subs(kw)
+		case "anyOf":
+			s.anyOf, err = subs(kw)
+		case "oneOf":
+			s.oneOf, err = subs(kw)
+		case "not":
+			s.not, err = sub(kw)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Schema) validate(v interface{}, path string, out *[]SchemaViolation) {
+	fail := func(format string, args ...interface{}) {
+		*out = append(*out, SchemaViolation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if s.never {
+		fail("no value is allowed")
+		return
+	}
+	if len(s.types) > 0 && !s.matchesType(v) {
+		fail("expected %s, got %s", strings.Join(s.types, " or "), jsonType(v))
+		return
+	}
+	if s.enum != nil {
+		found := false
+		for _, want := range s.enum {
+			if reflect.DeepEqual(v, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %s", compactJSON(s.enum))
+		}
+	}
+	if s.hasConst && !reflect.DeepEqual(v, s.constVal) {
+		fail("must be %s", compactJSON(s.constVal))
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			child := path + "/" + escapePointer(name)
+			if p, ok := s.properties[name]; ok {
+				p.validate(v[name], child, out)
+				continue
+			}
+			switch {
+			case s.noAdditional:
+				*out = append(*out, SchemaViolation{Path: child, Message: "property not allowed"})
+			case s.additional != nil:
+				s.additional.validate(v[name], child, out)
+			}
+		}
+	case []interface{}:
+		if s.minItems != nil && len(v) < *s.minItems {
+			fail("must have at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			fail("must have at most %d items", *s.maxItems)
+		}
+		if s.uniqueItems {
+			for i := range v {
+				for j := 0; j < i; j++ {
+					if reflect.DeepEqual(v[i], v[j]) {
+						fail("items %d and %d are equal", j, i)
+					}
+				}
+			}
+		}
+		if s.items != nil {
+			for i, item := range v {
+				s.items.validate(item, path+"/"+strconv.Itoa(i), out)
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			fail("must be at least %d characters", *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			fail("must be at most %d characters", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match %s", s.pattern)
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			fail("must be >= %v", *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			fail("must be <= %v", *s.maximum)
+		}
+		if s.exclMinimum != nil && v <= *s.exclMinimum {
+			fail("must be > %v", *s.exclMinimum)
+		}
+		if s.exclMaximum != nil && v >= *s.exclMaximum {
+			fail("must be < %v", *s.exclMaximum)
+		}
+		if s.multipleOf != nil {
+			if q := v / *s.multipleOf; math.Abs(q-math.Round(q)) > 1e-9 {
+				fail("must be a multiple of %v", *s.multipleOf)
+			}
+		}
+	}
+
+	for _, sub := range s.allOf {
+		sub.validate(v, path, out)
+	}
+	if len(s.anyOf) > 0 {
+		matched := false
+		for _, sub := range s.anyOf {
+			if len(sub.Validate(v)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("must match at least one schema in anyOf")
+		}
+	}
+	if len(s.oneOf) > 0 {
+		n := 0
+		for _, sub := range s.oneOf {
+			if len(sub.Validate(v)) == 0 {
+				n++
+			}
+		}
+		if n != 1 {
+			fail("must match exactly one schema in oneOf, matched %d", n)
+		}
+	}
+	if s.not != nil && len(s.not.Validate(v)) == 0 {
+		fail("must not match the schema in not")
+	}
+}
+
+func (s *Schema) matchesType(v interface{}) bool {
+	actual := jsonType(v)
+	for _, t := range s.types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType names the JSON Schema type of a decoded value, reporting
+// integral numbers as integer.
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func compactJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// escapePointer escapes a property name for use in a JSON pointer.
+func escapePointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+func atPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	return " at " + path
+}
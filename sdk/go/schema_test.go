@@ -0,0 +1,199 @@
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSchemaKeywords(t *testing.T) {
+	for _, tc := range []struct {
+		schema         string
+		accept, reject []string
+	}{
+		{`true`, []string{`null`, `{}`}, nil},
+		{`false`, nil, []string{`null`, `{}`}},
+		{`{}`, []string{`null`, `1`, `"x"`}, nil},
+		{`{"type":"string"}`, []string{`""`}, []string{`1`, `null`, `["x"]`}},
+		{`{"type":"integer"}`, []string{`1`, `-3`, `2.0`}, []string{`1.5`, `"1"`}},
+		{`{"type":"number"}`, []string{`1`, `1.5`}, []string{`"1"`, `true`}},
+		{`{"type":["null","boolean"]}`, []string{`null`, `false`}, []string{`0`, `""`}},
+		{`{"type":"object"}`, []string{`{}`}, []string{`[]`, `null`}},
+		{`{"type":"array"}`, []string{`[]`}, []string{`{}`}},
+		{`{"enum":["a",1,null,{"k":[1]}]}`, []string{`"a"`, `1`, `null`, `{"k":[1]}`}, []string{`"b"`, `1.5`, `{"k":[2]}`}},
+		{`{"const":{"a":[1,"x"]}}`, []string{`{"a":[1,"x"]}`}, []string{`{"a":[1]}`, `{}`}},
+		{`{"const":null}`, []string{`null`}, []string{`0`, `false`}},
+		{`{"properties":{"n":{"type":"number"}}}`, []string{`{}`, `{"n":1}`, `{"m":"x"}`, `"not an object"`}, []string{`{"n":"1"}`}},
+		{`{"required":["a","b"]}`, []string{`{"a":1,"b":null}`, `[]`}, []string{`{"a":1}`, `{}`}},
+		{`{"properties":{"a":{}},"additionalProperties":false}`, []string{`{"a":1}`, `{}`}, []string{`{"a":1,"b":2}`}},
+		{`{"properties":{"a":{}},"additionalProperties":{"type":"string"}}`, []string{`{"a":1,"b":"x"}`}, []string{`{"b":2}`}},
+		{`{"items":{"type":"integer"}}`, []string{`[]`, `[1,2]`, `{}`}, []string{`[1,"2"]`}},
+		{`{"minItems":2}`, []string{`[1,2]`, `"x"`}, []string{`[1]`}},
+		{`{"maxItems":1}`, []string{`[]`, `[1]`}, []string{`[1,2]`}},
+		{`{"uniqueItems":true}`, []string{`[1,"1",[1],{"a":1},{"a":2}]`}, []string{`[1,2,1]`, `[{"a":[1]},{"a":[1]}]`}},
+		{`{"uniqueItems":false}`, []string{`[1,1]`}, nil},
+		{`{"minLength":2}`, []string{`"ab"`, `"éé"`, `5`}, []string{`"a"`, `""`}},
+		{`{"maxLength":2}`, []string{`"ab"`, `"😀😀"`}, []string{`"abc"`}},
+		{`{"pattern":"^[a-z]+-\\d+$"}`, []string{`"ab-12"`, `1`}, []string{`"AB-12"`, `"ab-"`}},
+		{`{"pattern":"b"}`, []string{`"abc"`}, []string{`"xyz"`}},
+		{`{"minimum":1.5}`, []string{`1.5`, `2`, `"0"`}, []string{`1.49`, `-2`}},
+		{`{"maximum":10}`, []string{`10`, `-1`}, []string{`10.01`}},
+		{`{"exclusiveMinimum":0}`, []string{`0.001`}, []string{`0`, `-1`}},
+		{`{"exclusiveMaximum":1}`, []string{`0.999`}, []string{`1`}},
+		{`{"multipleOf":0.1}`, []string{`0.3`, `1`, `0`}, []string{`0.35`}},
+		{`{"multipleOf":3}`, []string{`9`, `-3`}, []string{`10`}},
+		{`{"allOf":[{"type":"integer"},{"minimum":2}]}`, []string{`2`}, []string{`1`, `2.5`}},
+		{`{"anyOf":[{"type":"string"},{"minimum":2}]}`, []string{`"x"`, `3`}, []string{`1`}},
+		{`{"oneOf":[{"type":"integer"},{"minimum":2}]}`, []string{`1`, `2.5`, `"x"`}, []string{`3`, `1.5`}},
+		{`{"not":{"type":"string"}}`, []string{`1`, `null`}, []string{`"x"`}},
+		{`{"title":"T","description":"D","format":"email","$id":"x"}`, []string{`"not an email"`}, nil},
+		{`{"type":"object","properties":{"items":{"type":"array","items":{"properties":{"qty":{"minimum":1}}}}}}`,
+			[]string{`{"items":[{"qty":1}]}`}, []string{`{"items":[{"qty":0}]}`}},
+	} {
+		s, err := ParseSchema([]byte(tc.schema))
+		if err != nil {
+			t.Errorf("ParseSchema(%s): %v", tc.schema, err)
+			continue
+		}
+		for _, in := range tc.accept {
+			var v interface{}
+			if err := json.Unmarshal([]byte(in), &v); err != nil {
+				t.Fatal(err)
+			}
+			if violations := s.Validate(v); len(violations) > 0 {
+				t.Errorf("schema %s rejected %s: %v", tc.schema, in, violations)
+			}
+		}
+		for _, in := range tc.reject {
+			var v interface{}
+			if err := json.Unmarshal([]byte(in), &v); err != nil {
+				t.Fatal(err)
+			}
+			if violations := s.Validate(v); len(violations) == 0 {
+				t.Errorf("schema %s accepted %s", tc.schema, in)
+			}
+		}
+	}
+}
+
+func TestSchemaViolationPaths(t *testing.T) {
+	s, err := ParseSchema([]byte(`{
+		"type": "object",
+		"required": ["id"],
+		"properties": {
+			"tags": {"items": {"type": "string"}},
+			"a/b~c": {"type": "integer"}
+		},
+		"additionalProperties": false
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(`{"tags":["x",2],"a/b~c":"no","extra":1}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, violation := range s.Validate(v) {
+		got = append(got, violation.String())
+	}
+	want := []string{
+		`missing required property "id"`,
+		`/a~1b~0c: expected integer, got string`,
+		`/extra: property not allowed`,
+		`/tags/1: expected string, got integer`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("violations:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseSchemaErrors(t *testing.T) {
+	for _, schema := range []string{
+		`not json`,
+		`1`,
+		`"string"`,
+		`{"type":"text"}`,
+		`{"type":["string",1]}`,
+		`{"type":1}`,
+		`{"enum":"a"}`,
+		`{"properties":[]}`,
+		`{"properties":{"a":1}}`,
+		`{"required":"a"}`,
+		`{"required":[1]}`,
+		`{"additionalProperties":1}`,
+		`{"items":"x"}`,
+		`{"minItems":-1}`,
+		`{"maxItems":1.5}`,
+		`{"minLength":"1"}`,
+		`{"pattern":"("}`,
+		`{"pattern":1}`,
+		`{"minimum":"1"}`,
+		`{"exclusiveMaximum":null}`,
+		`{"multipleOf":0}`,
+		`{"multipleOf":-2}`,
+		`{"allOf":[]}`,
+		`{"anyOf":{}}`,
+		`{"oneOf":[1]}`,
+		`{"not":[]}`,
+	} {
+		if _, err := ParseSchema([]byte(schema)); err == nil {
+			t.Errorf("ParseSchema(%s) succeeded", schema)
+		}
+	}
+}
+
+func TestRegisterSchema(t *testing.T) {
+	c := NewClient("http://ping.test")
+	if err := c.RegisterSchema("proposal", `{"required":["title"]}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RegisterSchema("request", map[string]interface{}{"required": []string{"action"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RegisterSchema("request:summarize", []byte(`{"required":["text"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RegisterSchema("bad", `{"type":"text"}`); err == nil {
+		t.Error("RegisterSchema accepted an invalid schema")
+	}
+
+	for _, tc := range []struct {
+		msgType MessageType
+		payload map[string]interface{}
+		key     string // schema that rejects it, "" if accepted
+	}{
+		{"proposal", map[string]interface{}{"title": "x", "$thread": "t1"}, ""},
+		{"proposal", map[string]interface{}{}, "proposal"},
+		{MessageTypeRequest, map[string]interface{}{"action": "summarize", "text": "x"}, ""},
+		{MessageTypeRequest, map[string]interface{}{"action": "summarize"}, "request:summarize"},
+		{MessageTypeRequest, map[string]interface{}{"action": "other"}, ""},
+		{MessageTypeRequest, map[string]interface{}{}, "request"},
+		{MessageTypeText, map[string]interface{}{}, ""},
+	} {
+		err := c.ValidatePayload(tc.msgType, tc.payload)
+		var se *SchemaError
+		switch {
+		case tc.key == "" && err != nil:
+			t.Errorf("ValidatePayload(%s, %v): %v", tc.msgType, tc.payload, err)
+		case tc.key != "" && (!errors.As(err, &se) || se.Key != tc.key || !errors.Is(err, ErrInvalidPayload)):
+			t.Errorf("ValidatePayload(%s, %v) = %v, want a *SchemaError for %s", tc.msgType, tc.payload, err, tc.key)
+		}
+	}
+
+	called := false
+	h := c.SchemaMiddleware(func(ctx context.Context, msg Message) error {
+		called = true
+		return nil
+	})
+	err := h(context.Background(), Message{ID: "m1", Type: "proposal", Payload: json.RawMessage(`{"body":"x"}`)})
+	var se *SchemaError
+	if !errors.As(err, &se) || se.MessageID != "m1" || called {
+		t.Errorf("SchemaMiddleware = %v (handler called %v), want a *SchemaError for m1", err, called)
+	}
+	if err := h(context.Background(), Message{ID: "m2", Type: "proposal", Payload: json.RawMessage(`{"title":"x"}`)}); err != nil || !called {
+		t.Errorf("SchemaMiddleware on a valid message = %v (handler called %v)", err, called)
+	}
+}
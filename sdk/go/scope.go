@@ -0,0 +1,229 @@
+package ping
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Scope holds request-scoped values for one handled message. Middleware
+// fills it in as the message passes through, and handlers read it with
+// SenderFromContext, ConversationFromContext, LoggerFromContext and
+// SpanFromContext, so handler signatures stay small.
+type Scope struct {
+	Message      Message
+	Sender       *Agent
+	Conversation *Conversation
+	Logger       *slog.Logger
+	Span         Span
+}
+
+type scopeKey struct{}
+
+// ScopeFromContext returns the scope of the message being handled, or nil
+// outside scoped middleware.
+func ScopeFromContext(ctx context.Context) *Scope {
+	s, _ := ctx.Value(scopeKey{}).(*Scope)
+	return s
+}
+
+// withScope returns ctx with a scope for msg, reusing the one an outer
+// middleware created.
+func withScope(ctx context.Context, msg Message) (context.Context, *Scope) {
+	if s := ScopeFromContext(ctx); s != nil && s.Message.ID == msg.ID {
+		return ctx, s
+	}
+	s := &Scope{Message: msg}
+	return context.WithValue(ctx, scopeKey{}, s), s
+}
+
+// SenderFromContext returns the sender resolved by Client.SenderMiddleware.
+func SenderFromContext(ctx context.Context) *Agent {
+	if s := ScopeFromContext(ctx); s != nil {
+		return s.Sender
+	}
+	return nil
+}
+
+// ConversationFromContext returns the conversation attached by
+// Conversations.Middleware.
+func ConversationFromContext(ctx context.Context) *Conversation {
+	if s := ScopeFromContext(ctx); s != nil {
+		return s.Conversation
+	}
+	return nil
+}
+
+// LoggerFromContext returns the logger attached by LoggerMiddleware, or
+// slog.Default() if there is none.
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if s := ScopeFromContext(ctx); s != nil && s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+// SpanFromContext returns the span started by TraceMiddleware, or a span
+// that does nothing if there is none.
+func SpanFromContext(ctx context.Context) Span {
+	if s := ScopeFromContext(ctx); s != nil && s.Span != nil {
+		return s.Span
+	}
+	return noopSpan{}
+}
+
+// SenderMiddleware resolves the sender's registered agent record before
+// next runs. The server verifies message signatures against this record
+// when accepting a message, so handlers can trust it. Messages from
+// unknown senders fail with the lookup error.
+func (c *Client) SenderMiddleware(next Handler) Handler {
+	return func(ctx context.Context, msg Message) error {
+		ctx, s := withScope(ctx, msg)
+		if s.Sender == nil {
+			agent, err := c.GetAgent(ctx, msg.From)
+			if err != nil {
+				return err
+			}
+			c.peers.update(msg.From, func(p *PeerPrefs) { p.PublicKey = agent.PublicKey })
+			s.Sender = agent
+		}
+		return next(ctx, msg)
+	}
+}
+
+// LoggerMiddleware attaches logger to the scope with the message's id,
+// sender and type as fields. A nil logger means slog.Default().
+func LoggerMiddleware(logger *slog.Logger) Middleware {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg Message) error {
+			ctx, s := withScope(ctx, msg)
+			s.Logger = logger.With("msg_id", msg.ID, "from", msg.From, "type", string(msg.Type))
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Span is a unit of tracing work, shaped so OpenTelemetry and similar
+// tracers can be adapted to it.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts spans for handled messages.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) RecordError(error)                {}
+func (noopSpan) End()                             {}
+
+// TraceMiddleware runs next inside a span named "ping.handle <type>" and
+// records the handler's error on it.
+func TraceMiddleware(t Tracer) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg Message) error {
+			ctx, span := t.Start(ctx, "ping.handle "+string(msg.Type))
+			defer span.End()
+			span.SetAttribute("ping.message_id", msg.ID)
+			span.SetAttribute("ping.from", msg.From)
+			ctx, s := withScope(ctx, msg)
+			s.Span = span
+			err := next(ctx, msg)
+			if err != nil {
+				span.RecordError(err)
+			}
+			return err
+		}
+	}
+}
+
+// Conversations keeps per-peer conversation state in memory for handlers.
+// The zero value is ready to use.
+type Conversations struct {
+	mu    sync.Mutex
+	convs map[string]*Conversation
+}
+
+// Conversation is the state of the conversation with one peer. It is safe
+// for concurrent use.
+type Conversation struct {
+	Peer string
+
+	mu       sync.Mutex
+	lastSeen time.Time
+	values   map[string]interface{}
+}
+
+// Get returns the conversation with peer, creating it if needed.
+func (cs *Conversations) Get(peer string) *Conversation {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.convs == nil {
+		cs.convs = make(map[string]*Conversation)
+	}
+	conv, ok := cs.convs[peer]
+	if !ok {
+		conv = &Conversation{Peer: peer, values: make(map[string]interface{})}
+		cs.convs[peer] = conv
+	}
+	return conv
+}
+
+// Forget drops the state kept for peer.
+func (cs *Conversations) Forget(peer string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	delete(cs.convs, peer)
+}
+
+// Middleware attaches the conversation with the message's sender to the
+// scope; see ConversationFromContext.
+func (cs *Conversations) Middleware(next Handler) Handler {
+	return func(ctx context.Context, msg Message) error {
+		ctx, s := withScope(ctx, msg)
+		conv := cs.Get(msg.From)
+		conv.mu.Lock()
+		conv.lastSeen = time.Now()
+		conv.mu.Unlock()
+		s.Conversation = conv
+		return next(ctx, msg)
+	}
+}
+
+// Value returns the value stored under key.
+func (c *Conversation) Value(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.values[key]
+	return v, ok
+}
+
+// Set stores value under key.
+func (c *Conversation) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] = value
+}
+
+// Delete removes key.
+func (c *Conversation) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.values, key)
+}
+
+// LastSeen returns when the last message in the conversation was handled.
+func (c *Conversation) LastSeen() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastSeen
+}
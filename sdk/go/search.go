@@ -0,0 +1,192 @@
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FeatureMessageSearch is server-side full-text search over an agent's
+// messages.
+const FeatureMessageSearch Feature = "message-search"
+
+// defaultMessageSearchLimit is the number of results SearchMessages returns
+// when no limit is given.
+const defaultMessageSearchLimit = 50
+
+// MessageSearchOptions selects messages for SearchMessages. Zero fields
+// match everything.
+type MessageSearchOptions struct {
+	// Query is matched case-insensitively against the payload's text;
+	// every whitespace-separated term must appear.
+	Query string
+	// With restricts results to the conversation with this agent.
+	With string
+	// Type restricts results to one message type.
+	Type MessageType
+	// Since and Until bound the message timestamp.
+	Since time.Time
+	Until time.Time
+	// Limit caps the number of results. Defaults to 50.
+	Limit int
+}
+
+// SearchMessages finds messages matching opts, newest first. It uses the
+// server's search endpoint when the server supports FeatureMessageSearch.
+// Otherwise it filters client-side: over the conversation history when
+// With is set, and over the local store when it is not. Without either it
+// fails with ErrUnsupported.
+func (c *Client) SearchMessages(ctx context.Context, opts MessageSearchOptions, reqOpts ...RequestOption) ([]Message, error) {
+	if c.AgentID() == "" {
+		return nil, fmt.Errorf("not registered")
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = defaultMessageSearchLimit
+	}
+
+	err := c.requireFeature(ctx, FeatureMessageSearch)
+	if err == nil {
+		var msgs []Message
+		if err := c.request(ctx, "GET", messageSearchPath(c.AgentID(), opts), nil, &msgs, reqOpts...); err != nil {
+			return nil, err
+		}
+		c.decodePayloads(ctx, msgs)
+		return msgs, nil
+	}
+	if !errors.Is(err, ErrUnsupported) {
+		return nil, err
+	}
+	return c.searchLocally(ctx, opts, reqOpts)
+}
+
+func messageSearchPath(agentID string, opts MessageSearchOptions) string {
+	params := url.Values{}
+	if opts.Query != "" {
+		params.Set("q", opts.Query)
+	}
+	if opts.With != "" {
+		params.Set("with", opts.With)
+	}
+	if opts.Type != "" {
+		params.Set("type", string(opts.Type))
+	}
+	if !opts.Since.IsZero() {
+		params.Set("since", strconv.FormatInt(opts.Since.UnixMilli(), 10))
+	}
+	if !opts.Until.IsZero() {
+		params.Set("until", strconv.FormatInt(opts.Until.UnixMilli(), 10))
+	}
+	params.Set("limit", strconv.Itoa(opts.Limit))
+	return "/agents/" + agentID + "/messages/search?" + params.Encode()
+}
+
+// searchLocally is the SearchMessages fallback for servers without search.
+func (c *Client) searchLocally(ctx context.Context, opts MessageSearchOptions, reqOpts []RequestOption) ([]Message, error) {
+	var found []Message
+	if opts.With != "" {
+		// History pages arrive newest first, so stop at the limit.
+		var iterErr error
+		c.HistoryIter(ctx, opts.With, reqOpts...)(func(msg Message, err error) bool {
+			if err != nil {
+				iterErr = err
+				return false
+			}
+			if opts.matches(msg) {
+				found = append(found, msg)
+			}
+			return len(found) < opts.Limit
+		})
+		if iterErr != nil {
+			return nil, iterErr
+		}
+		return found, nil
+	}
+
+	var msgs []Message
+	var err error
+	if c.store != nil {
+		msgs, err = c.store.List(ctx, StoreFilter{Type: opts.Type})
+	} else {
+		err = fmt.Errorf("%w: searching all messages needs a Store", ErrUnsupported)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range msgs {
+		if opts.matches(msg) {
+			found = append(found, msg)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].Timestamp.After(found[j].Timestamp.Time)
+	})
+	if len(found) > opts.Limit {
+		found = found[:opts.Limit]
+	}
+	return found, nil
+}
+
+func (o *MessageSearchOptions) matches(msg Message) bool {
+	if o.With != "" && msg.From != o.With && msg.To != o.With {
+		return false
+	}
+	if o.Type != "" && msg.Type != o.Type {
+		return false
+	}
+	if !o.Since.IsZero() && msg.Timestamp.Before(o.Since) {
+		return false
+	}
+	if !o.Until.IsZero() && msg.Timestamp.After(o.Until) {
+		return false
+	}
+	terms := strings.Fields(strings.ToLower(o.Query))
+	if len(terms) == 0 {
+		return true
+	}
+	text := strings.ToLower(payloadText(msg.Payload))
+	for _, term := range terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// payloadText joins the keys and string values of a JSON payload, so
+// searches match text rather than JSON syntax.
+func payloadText(raw json.RawMessage) string {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return string(raw)
+	}
+	var b strings.Builder
+	var walk func(interface{})
+	walk = func(v interface{}) {
+		switch t := v.(type) {
+		case string:
+			b.WriteString(t)
+			b.WriteByte('\n')
+		case map[string]interface{}:
+			for k, x := range t {
+				b.WriteString(k)
+				b.WriteByte('\n')
+				walk(x)
+			}
+		case []interface{}:
+			for _, x := range t {
+				walk(x)
+			}
+		case nil:
+		default:
+			fmt.Fprintf(&b, "%v\n", t)
+		}
+	}
+	walk(v)
+	return b.String()
+}
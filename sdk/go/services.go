@@ -0,0 +1,159 @@
+package ping
+
+import (
+	"context"
+	"time"
+)
+
+// Poller is a Service that polls the inbox and passes each message to
+// Handler. Messages are acknowledged once Handler returns nil. With
+// WithWorkers, a batch is handled concurrently and the next poll waits
+// for it to finish.
+type Poller struct {
+	Client  *Client
+	Handler Handler
+	// Interval between polls. Defaults to 5s, with 10% jitter.
+	Interval time.Duration
+	// Backoff, if set, replaces Interval. Its attempt counts consecutive
+	// polls that failed or found the inbox empty, so an idle agent can
+	// poll less often; it restarts at 1 after a poll that finds messages.
+	Backoff Backoff
+	// OnError, if set, receives poll, handler and ack errors.
+	OnError func(error)
+}
+
+// Run implements Service. A batch that is being handled when ctx is
+// cancelled is finished before Run returns.
+func (p *Poller) Run(ctx context.Context) error {
+	backoff := p.Backoff
+	if backoff == nil {
+		backoff = ConstantBackoff{Delay: durationOr(p.Interval, 5*time.Second), Jitter: 0.1}
+	}
+	var (
+		idle  int
+		delay time.Duration
+	)
+	for {
+		if p.poll(ctx) {
+			idle, delay = 0, 0
+		}
+		idle++
+		delay = backoff.Next(idle, delay)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// poll handles one inbox batch and reports whether it had messages.
+func (p *Poller) poll(ctx context.Context) bool {
+	msgs, err := p.Client.Inbox(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			reportError(p.OnError, err)
+		}
+		return false
+	}
+	// Handlers run to completion even after ctx is cancelled so a shutdown
+	// never interrupts a message half way through.
+	hctx := context.WithoutCancel(ctx)
+	pool := p.Client.newWorkerPool()
+	for _, msg := range msgs {
+		pool.do(msg, func(msg Message) {
+			if err := p.Handler(hctx, msg); err != nil {
+				reportError(p.OnError, err)
+				return
+			}
+			if err := p.Client.Ack(hctx, msg.ID); err != nil {
+				reportError(p.OnError, err)
+			}
+		})
+	}
+	// Unacknowledged messages would be polled again, so the batch is
+	// finished first.
+	pool.wait()
+	return len(msgs) > 0
+}
+
+// Subscription is a Service that receives messages from Client.Stream and
+// passes each one to Handler, concurrently with WithWorkers. As with
+// Poller, messages are acknowledged once Handler returns nil.
+type Subscription struct {
+	Client  *Client
+	Handler Handler
+	// OnError, if set, receives handler and ack errors.
+	OnError func(error)
+}
+
+// Run implements Service.
+func (s *Subscription) Run(ctx context.Context) error {
+	msgs, err := s.Client.Stream(ctx)
+	if err != nil {
+		return err
+	}
+	hctx := context.WithoutCancel(ctx)
+	pool := s.Client.newWorkerPool()
+	for msg := range msgs {
+		pool.do(msg, func(msg Message) {
+			if err := s.Handler(hctx, msg); err != nil {
+				reportError(s.OnError, err)
+				return
+			}
+			if err := s.Client.Ack(hctx, msg.ID); err != nil {
+				reportError(s.OnError, err)
+			}
+		})
+	}
+	pool.wait()
+	return nil
+}
+
+// Heartbeat is a Service that periodically checks in with the server.
+type Heartbeat struct {
+	Client *Client
+	// Interval between beats. Defaults to 30s.
+	Interval time.Duration
+	// Beat is called on each tick. Defaults to fetching the client's own
+	// agent record.
+	Beat func(ctx context.Context) error
+	// OnError, if set, receives beat errors.
+	OnError func(error)
+}
+
+// Run implements Service.
+func (h *Heartbeat) Run(ctx context.Context) error {
+	beat := h.Beat
+	if beat == nil {
+		beat = func(ctx context.Context) error {
+			_, err := h.Client.GetAgent(ctx, h.Client.AgentID())
+			return err
+		}
+	}
+	ticker := time.NewTicker(durationOr(h.Interval, 30*time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := beat(ctx); err != nil && ctx.Err() == nil {
+				reportError(h.OnError, err)
+			}
+		}
+	}
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+func reportError(fn func(error), err error) {
+	if fn != nil {
+		fn(err)
+	}
+}
@@ -0,0 +1,134 @@
+package ping
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// FeatureSessionAuth is the challenge-response login flow.
+const FeatureSessionAuth Feature = "session-auth"
+
+// sessionRefreshMargin is how long before expiry a session is renewed.
+const sessionRefreshMargin = 30 * time.Second
+
+const loginSigningPrefix = "PING-LOGIN-V1\n"
+
+// Session is a short-lived bearer token obtained by Login.
+type Session struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// Login authenticates with the server by signing a one-time challenge with
+// the agent key. The returned bearer token is attached to subsequent
+// requests and renewed automatically shortly before it expires.
+func (c *Client) Login(ctx context.Context, reqOpts ...RequestOption) (*Session, error) {
+	id := c.identity()
+	if id.agentID == "" {
+		return nil, fmt.Errorf("not registered")
+	}
+	if id.privateKey == nil {
+		return nil, fmt.Errorf("no keys")
+	}
+	if err := c.requireFeature(ctx, FeatureSessionAuth); err != nil {
+		return nil, err
+	}
+
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+	return c.login(ctx, reqOpts...)
+}
+
+// Logout forgets the current session token. Requests fall back to
+// per-request signatures only.
+func (c *Client) Logout() {
+	c.mu.Lock()
+	c.session = nil
+	c.mu.Unlock()
+}
+
+// login runs the challenge-response exchange. Callers hold loginMu.
+func (c *Client) login(ctx context.Context, reqOpts ...RequestOption) (*Session, error) {
+	id := c.identity()
+	var challenge struct {
+		Nonce string `json:"nonce"`
+	}
+	if err := c.request(ctx, "POST", "/auth/challenge", map[string]interface{}{
+		"agentId": id.agentID,
+	}, &challenge, reqOpts...); err != nil {
+		return nil, err
+	}
+	if challenge.Nonce == "" {
+		return nil, fmt.Errorf("empty login challenge")
+	}
+
+	sig := ed25519.Sign(id.privateKey, []byte(loginSigningPrefix+challenge.Nonce))
+	var resp struct {
+		Token     string `json:"token"`
+		ExpiresAt string `json:"expiresAt"`
+		ExpiresIn int64  `json:"expiresIn"`
+	}
+	if err := c.request(ctx, "POST", "/auth/token", map[string]interface{}{
+		"agentId":   id.agentID,
+		"nonce":     challenge.Nonce,
+		"signature": hex.EncodeToString(sig),
+	}, &resp, reqOpts...); err != nil {
+		return nil, err
+	}
+	if resp.Token == "" {
+		return nil, fmt.Errorf("empty session token")
+	}
+
+	s := &Session{Token: resp.Token}
+	if t, err := time.Parse(time.RFC3339, resp.ExpiresAt); err == nil {
+		s.ExpiresAt = t
+	} else if resp.ExpiresIn > 0 {
+		s.ExpiresAt = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+
+	c.mu.Lock()
+	c.session = s
+	c.mu.Unlock()
+	return s, nil
+}
+
+// authorize attaches the session token to req, renewing it first if it is
+// about to expire. Requests made by the login flow itself are skipped.
+func (c *Client) authorize(ctx context.Context, req *http.Request, path string) error {
+	if strings.HasPrefix(path, "/auth/") {
+		return nil
+	}
+	c.mu.RLock()
+	s := c.session
+	c.mu.RUnlock()
+	if s == nil {
+		return nil
+	}
+
+	if !s.ExpiresAt.IsZero() && time.Until(s.ExpiresAt) < sessionRefreshMargin {
+		c.loginMu.Lock()
+		c.mu.RLock()
+		current := c.session
+		c.mu.RUnlock()
+		if current == s {
+			var err error
+			if s, err = c.login(ctx); err != nil {
+				c.loginMu.Unlock()
+				return fmt.Errorf("refresh session: %w", err)
+			}
+		} else {
+			s = current
+		}
+		c.loginMu.Unlock()
+		if s == nil {
+			return nil
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+	return nil
+}
@@ -0,0 +1,576 @@
+package ping
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Session handshake message types. They are consumed by clients configured
+// WithSessionKeys and never reach handlers.
+const (
+	MessageTypeSessionInit   MessageType = "session.init"
+	MessageTypeSessionAccept MessageType = "session.accept"
+)
+
+const (
+	sessionField   = "$session"
+	sessionKeyInfo = "ping-session-v1"
+
+	// sessionCodecName is the name of the codec WithSessionKeys registers.
+	sessionCodecName = "session"
+
+	// maxPeerSessions is how many sessions per peer are kept for
+	// decrypting messages sent before a rekey.
+	maxPeerSessions = 3
+	// maxSkippedKeys bounds the message keys kept per session for
+	// messages that arrive out of order.
+	maxSkippedKeys = 256
+	// handshakeTimeout is how long a handshake waits for its accept
+	// before another is started.
+	handshakeTimeout = time.Minute
+)
+
+// Session codec modes, the first byte of its output.
+const (
+	sessionModeBox byte = iota // no session yet; encrypted as by BoxCodec
+	sessionModeKey             // encrypted with a session message key
+)
+
+// SessionOptions control when conversations negotiate fresh session keys.
+type SessionOptions struct {
+	// RekeyAfter starts a new handshake once this many messages have been
+	// sent in a session. Defaults to 1000.
+	RekeyAfter int
+	// RekeyInterval starts a new handshake once a session is this old.
+	// Defaults to 24h.
+	RekeyInterval time.Duration
+}
+
+// WithSessionKeys enables the "session" payload codec, which encrypts
+// conversations end-to-end with symmetric keys negotiated per peer rather
+// than with the agents' identity keys.
+//
+// Sending with the codec to a peer without a session starts a handshake:
+// the two clients exchange signed ephemeral X25519 keys in session.init
+// and session.accept messages and derive a session from them and their
+// identity keys. Until the peer accepts, messages are encrypted as by
+// BoxCodec. Within a session each message is encrypted with its own key
+// from a hash ratchet, whose earlier keys are discarded, and sessions are
+// replaced as opts sets, so a leaked key exposes little past traffic.
+//
+// Session state is kept in ks, or in memory if ks is nil. Both peers need
+// WithSessionKeys; enable the codec with SetPeerCodecs(peer, "session").
+// A session-encrypted message can be decrypted only once, so History
+// shows messages already received as encoded; keep a Store for a readable
+// copy.
+func WithSessionKeys(ks KeyStore, opts SessionOptions) Option {
+	return func(c *Client) {
+		if ks == nil {
+			ks = &MemoryKeyStore{}
+		}
+		c.sessionKeys = &sessionTable{
+			ks:      ks,
+			opts:    opts,
+			keys:    make(map[string][]SessionKey),
+			pending: make(map[string]*pendingSession),
+		}
+		c.codecs[sessionCodecName] = sessionCodec{t: c.sessionKeys}
+	}
+}
+
+// SessionKey is the state of one session with a peer, as kept in a
+// KeyStore. Its chain keys are secret.
+type SessionKey struct {
+	ID        string    `json:"id"`
+	Peer      string    `json:"peer"`
+	CreatedAt time.Time `json:"createdAt"`
+	// SendChain and RecvChain are the current chain keys for messages to
+	// and from the peer; SendCount and RecvCount count the messages
+	// their ratchets have produced keys for.
+	SendChain []byte `json:"sendChain"`
+	SendCount uint32 `json:"sendCount"`
+	RecvChain []byte `json:"recvChain"`
+	RecvCount uint32 `json:"recvCount"`
+	// Skipped holds the keys of messages not yet received whose
+	// successors have been, by counter.
+	Skipped map[uint32][]byte `json:"skipped,omitempty"`
+}
+
+// KeyStore keeps session keys. Implementations must be safe for
+// concurrent use.
+type KeyStore interface {
+	// Sessions returns the sessions kept for peer, newest first.
+	Sessions(ctx context.Context, peer string) ([]SessionKey, error)
+	// SaveSessions replaces the sessions kept for peer.
+	SaveSessions(ctx context.Context, peer string, keys []SessionKey) error
+}
+
+// MemoryKeyStore is an in-memory KeyStore. The zero value is ready to use.
+type MemoryKeyStore struct {
+	mu   sync.Mutex
+	keys map[string][]SessionKey
+}
+
+// Sessions implements KeyStore.
+func (s *MemoryKeyStore) Sessions(ctx context.Context, peer string) ([]SessionKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return copySessions(s.keys[peer]), nil
+}
+
+// SaveSessions implements KeyStore.
+func (s *MemoryKeyStore) SaveSessions(ctx context.Context, peer string, keys []SessionKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = make(map[string][]SessionKey)
+	}
+	s.keys[peer] = copySessions(keys)
+	return nil
+}
+
+// FileKeyStore is a KeyStore persisted as a JSON file, readable only by its
+// owner. The file is rewritten on every save.
+type FileKeyStore struct {
+	path string
+	mem  MemoryKeyStore
+	mu   sync.Mutex
+}
+
+// OpenKeyStore opens or creates a key store file at path.
+func OpenKeyStore(path string) (*FileKeyStore, error) {
+	s := &FileKeyStore{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.mem.keys); err != nil {
+		return nil, fmt.Errorf("key store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Sessions implements KeyStore.
+func (s *FileKeyStore) Sessions(ctx context.Context, peer string) ([]SessionKey, error) {
+	return s.mem.Sessions(ctx, peer)
+}
+
+// SaveSessions implements KeyStore.
+func (s *FileKeyStore) SaveSessions(ctx context.Context, peer string, keys []SessionKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mem.SaveSessions(ctx, peer, keys)
+
+	s.mem.mu.Lock()
+	data, err := json.Marshal(s.mem.keys)
+	s.mem.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func copySessions(keys []SessionKey) []SessionKey {
+	if keys == nil {
+		return nil
+	}
+	out := make([]SessionKey, len(keys))
+	for i, k := range keys {
+		k.Skipped = make(map[uint32][]byte, len(k.Skipped))
+		for n, mk := range keys[i].Skipped {
+			k.Skipped[n] = mk
+		}
+		out[i] = k
+	}
+	return out
+}
+
+// sessionFrame is the "$session" payload of handshake messages.
+type sessionFrame struct {
+	ID  string `json:"id"`
+	Key string `json:"key"` // hex X25519 ephemeral public key
+}
+
+// pendingSession is a handshake this client started.
+type pendingSession struct {
+	id     string
+	priv   *ecdh.PrivateKey
+	sentAt time.Time
+}
+
+// sessionTable holds a client's sessions, cached from its KeyStore.
+type sessionTable struct {
+	ks   KeyStore
+	opts SessionOptions
+
+	mu      sync.Mutex
+	keys    map[string][]SessionKey // by peer, newest first
+	pending map[string]*pendingSession
+}
+
+// load returns the sessions with peer. t.mu must be held.
+func (t *sessionTable) load(ctx context.Context, peer string) ([]SessionKey, error) {
+	if keys, ok := t.keys[peer]; ok {
+		return keys, nil
+	}
+	keys, err := t.ks.Sessions(ctx, peer)
+	if err != nil {
+		return nil, err
+	}
+	t.keys[peer] = keys
+	return keys, nil
+}
+
+// save replaces the sessions with peer. t.mu must be held.
+func (t *sessionTable) save(ctx context.Context, peer string, keys []SessionKey) error {
+	if len(keys) > maxPeerSessions {
+		keys = keys[:maxPeerSessions]
+	}
+	t.keys[peer] = keys
+	return t.ks.SaveSessions(ctx, peer, keys)
+}
+
+// stale reports whether k is due to be replaced.
+func (t *sessionTable) stale(k *SessionKey) bool {
+	after := t.opts.RekeyAfter
+	if after <= 0 {
+		after = 1000
+	}
+	return int(k.SendCount) >= after || time.Since(k.CreatedAt) > durationOr(t.opts.RekeyInterval, 24*time.Hour)
+}
+
+// ensureSession starts a handshake with peer if there is no fresh session
+// and none is under way.
+func (c *Client) ensureSession(ctx context.Context, peer string) error {
+	t := c.sessionKeys
+	t.mu.Lock()
+	keys, err := t.load(ctx, peer)
+	if err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	p := t.pending[peer]
+	if (len(keys) > 0 && !t.stale(&keys[0])) || (p != nil && time.Since(p.sentAt) < handshakeTimeout) {
+		t.mu.Unlock()
+		return nil
+	}
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	var sid [16]byte
+	if _, err := rand.Read(sid[:]); err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	p = &pendingSession{id: hex.EncodeToString(sid[:]), priv: priv, sentAt: time.Now()}
+	t.pending[peer] = p
+	t.mu.Unlock()
+
+	frame := sessionFrame{ID: p.id, Key: hex.EncodeToString(priv.PublicKey().Bytes())}
+	_, err = c.post(ctx, c.identity(), peer, MessageTypeSessionInit, map[string]interface{}{sessionField: frame}, "", nil)
+	return err
+}
+
+// consumeSessions completes handshakes from msgs, answering session.init
+// messages, and returns the other messages.
+func (c *Client) consumeSessions(ctx context.Context, msgs []Message) []Message {
+	if c.sessionKeys == nil {
+		return msgs
+	}
+	out := msgs[:0:0]
+	for _, m := range msgs {
+		if m.Type != MessageTypeSessionInit && m.Type != MessageTypeSessionAccept {
+			out = append(out, m)
+			continue
+		}
+		var p struct {
+			Frame sessionFrame `json:"$session"`
+		}
+		if m.DecodePayload(&p) == nil {
+			if m.Type == MessageTypeSessionInit {
+				c.acceptSession(ctx, m.From, p.Frame)
+			} else {
+				c.completeSession(ctx, m.From, p.Frame)
+			}
+		}
+		c.Ack(ctx, m.ID)
+	}
+	return out
+}
+
+// acceptSession answers a handshake started by peer.
+func (c *Client) acceptSession(ctx context.Context, peer string, f sessionFrame) {
+	if c.Passive() {
+		return
+	}
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return
+	}
+	if err := c.installSession(ctx, peer, f, priv, peer, c.AgentID()); err != nil {
+		return
+	}
+	reply := sessionFrame{ID: f.ID, Key: hex.EncodeToString(priv.PublicKey().Bytes())}
+	c.post(ctx, c.identity(), peer, MessageTypeSessionAccept, map[string]interface{}{sessionField: reply}, "", nil)
+}
+
+// completeSession finishes a handshake this client started with peer.
+func (c *Client) completeSession(ctx context.Context, peer string, f sessionFrame) {
+	t := c.sessionKeys
+	t.mu.Lock()
+	p := t.pending[peer]
+	if p == nil || p.id != f.ID {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.pending, peer)
+	t.mu.Unlock()
+	c.installSession(ctx, peer, f, p.priv, c.AgentID(), peer)
+}
+
+// installSession derives a session with peer from the local ephemeral key
+// priv and the peer's in f, and makes it the one used for sending.
+func (c *Client) installSession(ctx context.Context, peer string, f sessionFrame, priv *ecdh.PrivateKey, initiator, responder string) error {
+	raw, err := hex.DecodeString(f.Key)
+	if err != nil {
+		return err
+	}
+	theirs, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return err
+	}
+	ephemeral, err := priv.ECDH(theirs)
+	if err != nil {
+		return err
+	}
+	// Mixing in the identity keys' shared secret binds the session to the
+	// two agents even if the server substituted the ephemeral keys.
+	peerKey, err := c.peerKey(ctx, peer)
+	if err != nil {
+		return err
+	}
+	xpriv, err := x25519PrivateKey(c.identity().privateKey)
+	if err != nil {
+		return err
+	}
+	xpub, err := x25519PublicKey(peerKey)
+	if err != nil {
+		return err
+	}
+	static, err := xpriv.ECDH(xpub)
+	if err != nil {
+		return err
+	}
+	root := hkdfSHA256(append(ephemeral, static...), []byte(f.ID),
+		[]byte(sessionKeyInfo+"\n"+initiator+"\n"+responder), 32)
+	chain := func(sender string) []byte {
+		return hkdfSHA256(root, nil, []byte(sessionKeyInfo+" chain\n"+sender), 32)
+	}
+
+	k := SessionKey{
+		ID:        f.ID,
+		Peer:      peer,
+		CreatedAt: time.Now(),
+		SendChain: chain(c.AgentID()),
+		RecvChain: chain(peer),
+	}
+	t := c.sessionKeys
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys, err := t.load(ctx, peer)
+	if err != nil {
+		return err
+	}
+	return t.save(ctx, peer, append([]SessionKey{k}, keys...))
+}
+
+// ratchet returns the message key for chain and the chain's next key.
+func ratchet(chain []byte) (messageKey, next []byte) {
+	mac := hmac.New(sha256.New, chain)
+	mac.Write([]byte{1})
+	messageKey = mac.Sum(nil)
+	mac = hmac.New(sha256.New, chain)
+	mac.Write([]byte{2})
+	return messageKey, mac.Sum(nil)
+}
+
+// sessionAEAD returns the cipher for a message key. Each key encrypts one
+// message, so a fixed nonce is safe.
+func sessionAEAD(messageKey []byte) (cipher.AEAD, []byte, error) {
+	block, err := aes.NewCipher(messageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, make([]byte, aead.NonceSize()), nil
+}
+
+// sessionCodec encrypts payloads with the sessions in t. Its output is a
+// mode byte followed, for sessionModeKey, by the 16-byte session ID, the
+// message counter and the ciphertext, which authenticates that header.
+type sessionCodec struct {
+	t *sessionTable
+}
+
+// Name implements Codec.
+func (sessionCodec) Name() string { return sessionCodecName }
+
+// Encode implements Codec.
+func (sc sessionCodec) Encode(cc *CodecContext, data []byte) ([]byte, error) {
+	ctx := context.Background()
+	t := sc.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys, err := t.load(ctx, cc.PeerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		sealed, err := BoxCodec{}.Encode(cc, data)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{sessionModeBox}, sealed...), nil
+	}
+
+	keys = copySessions(keys)
+	k := &keys[0]
+	sid, err := hex.DecodeString(k.ID)
+	if err != nil || len(sid) != 16 {
+		return nil, fmt.Errorf("invalid session ID %q", k.ID)
+	}
+	messageKey, next := ratchet(k.SendChain)
+	header := append([]byte{sessionModeKey}, sid...)
+	header = binary.BigEndian.AppendUint32(header, k.SendCount)
+	k.SendChain, k.SendCount = next, k.SendCount+1
+	if err := t.save(ctx, cc.PeerID, keys); err != nil {
+		return nil, err
+	}
+
+	aead, nonce, err := sessionAEAD(messageKey)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, nonce, data, header), nil
+}
+
+// Decode implements Codec.
+func (sc sessionCodec) Decode(cc *CodecContext, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty session payload")
+	}
+	switch data[0] {
+	case sessionModeBox:
+		return BoxCodec{}.Decode(cc, data[1:])
+	case sessionModeKey:
+	default:
+		return nil, fmt.Errorf("unknown session mode %d", data[0])
+	}
+	if len(data) < 21 {
+		return nil, fmt.Errorf("session payload too short")
+	}
+	header, body := data[:21], data[21:]
+	sid := hex.EncodeToString(header[1:17])
+	n := binary.BigEndian.Uint32(header[17:21])
+
+	ctx := context.Background()
+	t := sc.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys, err := t.load(ctx, cc.PeerID)
+	if err != nil {
+		return nil, err
+	}
+	keys = copySessions(keys)
+	var k *SessionKey
+	for i := range keys {
+		if keys[i].ID == sid {
+			k = &keys[i]
+		}
+	}
+	if k == nil {
+		return nil, fmt.Errorf("unknown session %s", sid)
+	}
+
+	var messageKey []byte
+	switch {
+	case n < k.RecvCount:
+		if messageKey = k.Skipped[n]; messageKey == nil {
+			return nil, fmt.Errorf("message %d of session %s already decrypted", n, sid)
+		}
+		delete(k.Skipped, n)
+	case n-k.RecvCount > maxSkippedKeys:
+		return nil, fmt.Errorf("message %d of session %s too far ahead", n, sid)
+	default:
+		for k.RecvCount <= n {
+			mk, next := ratchet(k.RecvChain)
+			if k.RecvCount < n {
+				k.Skipped[k.RecvCount] = mk
+			} else {
+				messageKey = mk
+			}
+			k.RecvChain, k.RecvCount = next, k.RecvCount+1
+		}
+		for len(k.Skipped) > maxSkippedKeys {
+			oldest := k.RecvCount
+			for s := range k.Skipped {
+				if s < oldest {
+					oldest = s
+				}
+			}
+			delete(k.Skipped, oldest)
+		}
+	}
+
+	aead, nonce, err := sessionAEAD(messageKey)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, nonce, body, header)
+	if err != nil {
+		return nil, err
+	}
+	// The ratchet only moves once the message authenticates.
+	if err := t.save(ctx, cc.PeerID, keys); err != nil {
+		return nil, err
+	}
+	return plain, nil
+}
+
+// usesSessions reports whether chain includes the session codec.
+func (c *Client) usesSessions(chain []string) bool {
+	if c.sessionKeys == nil {
+		return false
+	}
+	for _, name := range chain {
+		if name == sessionCodecName {
+			return true
+		}
+	}
+	return false
+}
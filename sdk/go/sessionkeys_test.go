@@ -0,0 +1,203 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aetos53t/ping/sdk/go/pingtest"
+)
+
+// testSessionPair returns the codecs of two agents sharing one session,
+// with alice's send chain as bob's receive chain and the other way round.
+func testSessionPair(t *testing.T) (alice, bob sessionCodec) {
+	t.Helper()
+	aliceChain := bytes.Repeat([]byte{0xa1}, 32)
+	bobChain := bytes.Repeat([]byte{0xb0}, 32)
+	id := "000102030405060708090a0b0c0d0e0f"
+	alice = sessionCodec{t: &sessionTable{ks: &MemoryKeyStore{}, keys: map[string][]SessionKey{
+		"bob": {{ID: id, Peer: "bob", CreatedAt: time.Now(), SendChain: aliceChain, RecvChain: bobChain}},
+	}}}
+	bob = sessionCodec{t: &sessionTable{ks: &MemoryKeyStore{}, keys: map[string][]SessionKey{
+		"alice": {{ID: id, Peer: "alice", CreatedAt: time.Now(), SendChain: bobChain, RecvChain: aliceChain}},
+	}}}
+	return alice, bob
+}
+
+func sealN(t *testing.T, sc sessionCodec, peer string, n int) [][]byte {
+	t.Helper()
+	out := make([][]byte, n)
+	for i := range out {
+		sealed, err := sc.Encode(&CodecContext{PeerID: peer}, []byte(fmt.Sprintf(`{"n":%d}`, i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[i] = sealed
+	}
+	return out
+}
+
+func openAs(sc sessionCodec, peer string, sealed []byte) (string, error) {
+	plain, err := sc.Decode(&CodecContext{PeerID: peer}, sealed)
+	return string(plain), err
+}
+
+func TestSessionCodecRatchet(t *testing.T) {
+	alice, bob := testSessionPair(t)
+	toBob := sealN(t, alice, "bob", 3)
+	toAlice := sealN(t, bob, "alice", 2)
+
+	// The same plaintext under successive keys must not repeat.
+	again := sealN(t, alice, "bob", 1)[0]
+	if bytes.Equal(again[21:], toBob[0][21:]) {
+		t.Error("two messages encrypted to the same ciphertext")
+	}
+	for i, sealed := range toBob {
+		if got, err := openAs(bob, "alice", sealed); err != nil || got != fmt.Sprintf(`{"n":%d}`, i) {
+			t.Errorf("bob decoded message %d as %q, %v", i, got, err)
+		}
+	}
+	for i, sealed := range toAlice {
+		if got, err := openAs(alice, "bob", sealed); err != nil || got != fmt.Sprintf(`{"n":%d}`, i) {
+			t.Errorf("alice decoded message %d as %q, %v", i, got, err)
+		}
+	}
+	if k := bob.t.keys["alice"][0]; k.RecvCount != 3 || len(k.Skipped) != 0 {
+		t.Errorf("bob's receive ratchet at %d with %d skipped keys, want 3 and 0", k.RecvCount, len(k.Skipped))
+	}
+}
+
+func TestSessionCodecOutOfOrder(t *testing.T) {
+	alice, bob := testSessionPair(t)
+	sealed := sealN(t, alice, "bob", 5)
+	for _, i := range []int{3, 0, 4, 2, 1} {
+		if got, err := openAs(bob, "alice", sealed[i]); err != nil || got != fmt.Sprintf(`{"n":%d}`, i) {
+			t.Errorf("message %d decoded as %q, %v", i, got, err)
+		}
+	}
+	if k := bob.t.keys["alice"][0]; len(k.Skipped) != 0 {
+		t.Errorf("%d skipped keys left after every message arrived", len(k.Skipped))
+	}
+
+	// A message further ahead than the skipped-key bound is refused.
+	far := sealN(t, alice, "bob", maxSkippedKeys+2)
+	if _, err := openAs(bob, "alice", far[len(far)-1]); err == nil {
+		t.Error("decoded a message too far ahead of the ratchet")
+	}
+}
+
+func TestSessionCodecReplay(t *testing.T) {
+	alice, bob := testSessionPair(t)
+	sealed := sealN(t, alice, "bob", 3)
+	for _, i := range []int{2, 0} {
+		if _, err := openAs(bob, "alice", sealed[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Both the newest message and one received out of order are refused
+	// the second time; the message still outstanding is not affected.
+	for _, i := range []int{2, 0} {
+		if _, err := openAs(bob, "alice", sealed[i]); err == nil {
+			t.Errorf("message %d decoded twice", i)
+		}
+	}
+	if _, err := openAs(bob, "alice", sealed[1]); err != nil {
+		t.Errorf("outstanding message after replays: %v", err)
+	}
+}
+
+func TestSessionCodecTampered(t *testing.T) {
+	alice, bob := testSessionPair(t)
+	sealed := sealN(t, alice, "bob", 1)[0]
+	for name, offset := range map[string]int{"session ID": 5, "counter": 20, "ciphertext": 21, "tag": len(sealed) - 1} {
+		forged := append([]byte(nil), sealed...)
+		forged[offset] ^= 1
+		if _, err := openAs(bob, "alice", forged); err == nil {
+			t.Errorf("decoded a message with a tampered %s", name)
+		}
+	}
+	// Failed attempts leave the ratchet where it was.
+	if got, err := openAs(bob, "alice", sealed); err != nil || got != `{"n":0}` {
+		t.Errorf("genuine message after tampered copies: %q, %v", got, err)
+	}
+
+	for name, data := range map[string][]byte{
+		"empty":        nil,
+		"unknown mode": append([]byte{9}, sealed[1:]...),
+		"short header": sealed[:20],
+	} {
+		if _, err := openAs(bob, "alice", data); err == nil {
+			t.Errorf("decoded a payload with %s", name)
+		}
+	}
+	if _, err := openAs(bob, "carol", sealed); err == nil {
+		t.Error("decoded a message with no session for its sender")
+	}
+}
+
+// TestSessionKeysHandshake negotiates a session through pingtest: the
+// first message is box-encrypted and starts the handshake, later ones use
+// the session.
+func TestSessionKeysHandshake(t *testing.T) {
+	srv := pingtest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	aliceKeys, bobKeys := &MemoryKeyStore{}, &MemoryKeyStore{}
+	alice := registerTestClient(t, srv, "alice", WithSessionKeys(aliceKeys, SessionOptions{}))
+	bob := registerTestClient(t, srv, "bob", WithSessionKeys(bobKeys, SessionOptions{}))
+	if err := alice.SetPeerCodecs(bob.AgentID(), sessionCodecName); err != nil {
+		t.Fatal(err)
+	}
+
+	// received checks bob's inbox holds want, in any order, and acks it.
+	received := func(want ...string) {
+		t.Helper()
+		inbox, err := bob.Inbox(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, m := range inbox {
+			got = append(got, m.PayloadString("text"))
+			if err := bob.Ack(ctx, m.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sort.Strings(got)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("bob received %q, want %q", got, want)
+		}
+	}
+
+	if _, err := alice.Text(ctx, bob.AgentID(), "before"); err != nil {
+		t.Fatal(err)
+	}
+	received("before")                          // also answers alice's session.init
+	if _, err := alice.Inbox(ctx); err != nil { // consumes bob's session.accept
+		t.Fatal(err)
+	}
+	for _, text := range []string{"one", "two"} {
+		if _, err := alice.Text(ctx, bob.AgentID(), text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	received("one", "two")
+
+	sessions, err := aliceKeys.Sessions(ctx, bob.AgentID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].SendCount != 2 {
+		t.Fatalf("alice has sessions %+v, want one that sent 2 messages", sessions)
+	}
+	peerSessions, err := bobKeys.Sessions(ctx, alice.AgentID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peerSessions) != 1 || peerSessions[0].ID != sessions[0].ID || peerSessions[0].RecvCount != 2 {
+		t.Errorf("bob has sessions %+v, want session %s that received 2 messages", peerSessions, sessions[0].ID)
+	}
+}
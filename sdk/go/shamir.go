@@ -0,0 +1,204 @@
+package ping
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const sharePrefix = "ping-share-v1"
+
+// IdentityShare is one Shamir secret share of an agent's private key. Any
+// Threshold shares of the same split recover the key; fewer reveal nothing
+// about it.
+type IdentityShare struct {
+	AgentID   string
+	PublicKey string
+	Threshold int
+	Index     int
+	Data      []byte
+}
+
+// String encodes the share as
+// "ping-share-v1:<agentId>:<publicKey>:<threshold>:<index>:<hex data>".
+func (s *IdentityShare) String() string {
+	return strings.Join([]string{
+		sharePrefix, s.AgentID, s.PublicKey,
+		strconv.Itoa(s.Threshold), strconv.Itoa(s.Index),
+		hex.EncodeToString(s.Data),
+	}, ":")
+}
+
+// ParseIdentityShare decodes a share produced by IdentityShare.String.
+func ParseIdentityShare(s string) (*IdentityShare, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 6 || parts[0] != sharePrefix {
+		return nil, fmt.Errorf("invalid identity share")
+	}
+	threshold, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity share threshold")
+	}
+	index, err := strconv.Atoi(parts[4])
+	if err != nil || index < 1 || index > 255 {
+		return nil, fmt.Errorf("invalid identity share index")
+	}
+	data, err := hex.DecodeString(parts[5])
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity share data")
+	}
+	return &IdentityShare{
+		AgentID:   parts[1],
+		PublicKey: parts[2],
+		Threshold: threshold,
+		Index:     index,
+		Data:      data,
+	}, nil
+}
+
+// SplitIdentity splits the client's private key into n shares, any k of
+// which recover it with RecoverIdentity. Distribute the shares to separate
+// custodians so no single party holds the key.
+func (c *Client) SplitIdentity(n, k int) ([]*IdentityShare, error) {
+	id := c.identity()
+	if id.privateKey == nil {
+		return nil, fmt.Errorf("no keys")
+	}
+	if k < 2 || n < k || n > 255 {
+		return nil, fmt.Errorf("invalid split: need 2 <= k <= n <= 255, got n=%d k=%d", n, k)
+	}
+
+	secret := id.privateKey.Seed()
+	shares := make([]*IdentityShare, n)
+	for i := range shares {
+		shares[i] = &IdentityShare{
+			AgentID:   id.agentID,
+			PublicKey: id.publicKey,
+			Threshold: k,
+			Index:     i + 1,
+			Data:      make([]byte, len(secret)),
+		}
+	}
+
+	coeffs := make([]byte, k)
+	for b, s := range secret {
+		coeffs[0] = s
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, err
+		}
+		for _, share := range shares {
+			share.Data[b] = gfEval(coeffs, byte(share.Index))
+		}
+	}
+	return shares, nil
+}
+
+// RecoverIdentity reconstructs an identity from at least Threshold shares
+// of the same split. The recovered key is checked against the public key
+// recorded in the shares.
+func RecoverIdentity(shares []*IdentityShare) (*PortableIdentity, error) {
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("no shares")
+	}
+	first := shares[0]
+	if len(shares) < first.Threshold {
+		return nil, fmt.Errorf("need %d shares, have %d", first.Threshold, len(shares))
+	}
+	seen := make(map[int]bool)
+	for _, s := range shares {
+		if s.AgentID != first.AgentID || s.PublicKey != first.PublicKey ||
+			s.Threshold != first.Threshold || len(s.Data) != len(first.Data) {
+			return nil, fmt.Errorf("shares are from different splits")
+		}
+		if s.Index < 1 || s.Index > 255 {
+			return nil, fmt.Errorf("invalid share index %d", s.Index)
+		}
+		if seen[s.Index] {
+			return nil, fmt.Errorf("duplicate share index %d", s.Index)
+		}
+		seen[s.Index] = true
+	}
+
+	secret := make([]byte, len(first.Data))
+	for b := range secret {
+		// Lagrange interpolation at x = 0.
+		var acc byte
+		for i, si := range shares {
+			xi := byte(si.Index)
+			num, den := byte(1), byte(1)
+			for j, sj := range shares {
+				if i == j {
+					continue
+				}
+				xj := byte(sj.Index)
+				num = gfMul(num, xj)
+				den = gfMul(den, xi^xj)
+			}
+			acc ^= gfMul(si.Data[b], gfDiv(num, den))
+		}
+		secret[b] = acc
+	}
+
+	if len(secret) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid recovered key length: %d", len(secret))
+	}
+	priv := ed25519.NewKeyFromSeed(secret)
+	pub := hex.EncodeToString(priv.Public().(ed25519.PublicKey))
+	if first.PublicKey != "" && !strings.EqualFold(pub, first.PublicKey) {
+		return nil, fmt.Errorf("recovered key does not match public key")
+	}
+	return &PortableIdentity{
+		Version:    PortableIdentityVersion,
+		AgentID:    first.AgentID,
+		PrivateKey: hex.EncodeToString(secret),
+		PublicKey:  pub,
+	}, nil
+}
+
+// GF(2^8) arithmetic with the AES polynomial x^8 + x^4 + x^3 + x + 1.
+var gfExp, gfLog = gfTables()
+
+func gfTables() (exp [510]byte, log [256]byte) {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i] = x
+		log[x] = byte(i)
+		// Multiply by the generator 3.
+		hi := x & 0x80
+		x2 := x << 1
+		if hi != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+	for i := 255; i < len(exp); i++ {
+		exp[i] = exp[i-255]
+	}
+	return exp, log
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// gfEval evaluates the polynomial with the given coefficients at x.
+func gfEval(coeffs []byte, x byte) byte {
+	var y byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coeffs[i]
+	}
+	return y
+}
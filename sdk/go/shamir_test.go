@@ -0,0 +1,138 @@
+package ping
+
+import (
+	"testing"
+)
+
+func testSplit(t *testing.T, n, k int) []*IdentityShare {
+	t.Helper()
+	c := NewClient("http://ping.test")
+	if err := c.UsePortableIdentity(testIdentity()); err != nil {
+		t.Fatal(err)
+	}
+	shares, err := c.SplitIdentity(n, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return shares
+}
+
+func TestSplitRecoverIdentity(t *testing.T) {
+	for _, tc := range []struct{ n, k int }{{2, 2}, {3, 2}, {5, 3}, {255, 4}} {
+		shares := testSplit(t, tc.n, tc.k)
+		// Every window of k consecutive shares, wrapping around, recovers
+		// the key, as do all n shares, whatever their order.
+		for start := 0; start < tc.n; start++ {
+			subset := make([]*IdentityShare, tc.k)
+			for i := range subset {
+				subset[i] = shares[(start+i)%tc.n]
+			}
+			id, err := RecoverIdentity(subset)
+			if err != nil {
+				t.Fatalf("n=%d k=%d from share %d: %v", tc.n, tc.k, start+1, err)
+			}
+			if id.PrivateKey != testSeed || id.PublicKey != testPublic || id.AgentID != "agent-1" {
+				t.Errorf("n=%d k=%d from share %d: recovered %+v", tc.n, tc.k, start+1, id)
+			}
+		}
+		if _, err := RecoverIdentity(shares); err != nil {
+			t.Errorf("n=%d k=%d with all shares: %v", tc.n, tc.k, err)
+		}
+	}
+}
+
+func TestShareStringRoundTrip(t *testing.T) {
+	shares := testSplit(t, 3, 2)
+	parsed := make([]*IdentityShare, 0, 2)
+	for _, s := range shares[1:] {
+		p, err := ParseIdentityShare(s.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed = append(parsed, p)
+	}
+	id, err := RecoverIdentity(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.PrivateKey != testSeed {
+		t.Errorf("recovered %s from parsed shares, want %s", id.PrivateKey, testSeed)
+	}
+
+	for _, s := range []string{
+		"",
+		"ping-share-v2:agent-1:" + testPublic + ":2:1:00",
+		"ping-share-v1:agent-1:" + testPublic + ":2:0:00",
+		"ping-share-v1:agent-1:" + testPublic + ":2:256:00",
+		"ping-share-v1:agent-1:" + testPublic + ":x:1:00",
+		"ping-share-v1:agent-1:" + testPublic + ":2:1:zz",
+		"ping-share-v1:agent-1:" + testPublic + ":2:1",
+	} {
+		if _, err := ParseIdentityShare(s); err == nil {
+			t.Errorf("ParseIdentityShare(%q) succeeded", s)
+		}
+	}
+}
+
+func TestRecoverIdentityRejects(t *testing.T) {
+	shares := testSplit(t, 5, 3)
+	other := testSplit(t, 5, 3) // same key, different polynomial
+	withIndex := func(s *IdentityShare, index int) *IdentityShare {
+		c := *s
+		c.Index = index
+		return &c
+	}
+	tampered := *shares[2]
+	tampered.Data = append([]byte(nil), tampered.Data...)
+	tampered.Data[0] ^= 1
+	otherAgent := *shares[2]
+	otherAgent.AgentID = "agent-2"
+
+	for name, subset := range map[string][]*IdentityShare{
+		"no shares":        nil,
+		"fewer than k":     shares[:2],
+		"duplicate x":      {shares[0], shares[1], shares[1]},
+		"zero x":           {shares[0], shares[1], withIndex(shares[2], 0)},
+		"x out of range":   {shares[0], shares[1], withIndex(shares[2], 256)},
+		"x wraps to dup":   {shares[0], shares[1], withIndex(shares[2], 257)},
+		"tampered data":    {shares[0], shares[1], &tampered},
+		"mixed splits":     {shares[0], shares[1], other[2]},
+		"different agents": {shares[0], shares[1], &otherAgent},
+	} {
+		if id, err := RecoverIdentity(subset); err == nil {
+			t.Errorf("%s: recovered %s", name, id.PrivateKey)
+		}
+	}
+}
+
+func TestSplitIdentityRejects(t *testing.T) {
+	c := NewClient("http://ping.test")
+	if _, err := c.SplitIdentity(3, 2); err == nil {
+		t.Error("SplitIdentity without keys succeeded")
+	}
+	if err := c.UsePortableIdentity(testIdentity()); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ n, k int }{{3, 1}, {2, 3}, {256, 2}, {0, 0}} {
+		if _, err := c.SplitIdentity(tc.n, tc.k); err == nil {
+			t.Errorf("SplitIdentity(%d, %d) succeeded", tc.n, tc.k)
+		}
+	}
+}
+
+func TestGF256(t *testing.T) {
+	// 0x53 and 0xca are inverses in the AES field (FIPS 197, 4.2).
+	if got := gfMul(0x53, 0xca); got != 1 {
+		t.Errorf("gfMul(0x53, 0xca) = %#x, want 1", got)
+	}
+	if got := gfMul(0x57, 0x83); got != 0xc1 { // FIPS 197, 4.2
+		t.Errorf("gfMul(0x57, 0x83) = %#x, want 0xc1", got)
+	}
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			if got := gfDiv(gfMul(byte(a), byte(b)), byte(b)); got != byte(a) {
+				t.Fatalf("gfDiv(gfMul(%d, %d), %d) = %d", a, b, b, got)
+			}
+		}
+	}
+}
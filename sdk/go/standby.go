@@ -0,0 +1,231 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FeatureInboxLease is a per-agent lease that decides which of several
+// instances sharing one identity consumes the inbox.
+const FeatureInboxLease Feature = "inbox-lease"
+
+// ErrLeaseHeld is returned by AcquireLease while another instance holds an
+// unexpired lease.
+var ErrLeaseHeld = errors.New("inbox lease held by another instance")
+
+// ErrStandby is returned by Send and Ack on a client that is a passive
+// standby.
+var ErrStandby = errors.New("client is a passive standby")
+
+// Lease is an agent's inbox lease.
+type Lease struct {
+	Holder    string    `json:"holder"`
+	ExpiresAt Timestamp `json:"expiresAt"`
+}
+
+// AcquireLease takes or renews the client's inbox lease for holder. It
+// fails with ErrLeaseHeld while another holder's lease has not expired.
+func (c *Client) AcquireLease(ctx context.Context, holder string, ttl time.Duration, reqOpts ...RequestOption) (*Lease, error) {
+	if err := c.requireLease(ctx); err != nil {
+		return nil, err
+	}
+	var lease Lease
+	body := map[string]interface{}{"holder": holder, "ttlMs": ttl.Milliseconds()}
+	err := c.request(ctx, "PUT", c.leasePath(), body, &lease, reqOpts...)
+	if isStatus(err, http.StatusConflict) {
+		return nil, fmt.Errorf("%w: %v", ErrLeaseHeld, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lease, nil
+}
+
+// ReleaseLease gives up holder's inbox lease so a standby can take over
+// without waiting for it to expire.
+func (c *Client) ReleaseLease(ctx context.Context, holder string, reqOpts ...RequestOption) error {
+	if err := c.requireLease(ctx); err != nil {
+		return err
+	}
+	return c.request(ctx, "DELETE", c.leasePath()+"?holder="+url.QueryEscape(holder), nil, nil, reqOpts...)
+}
+
+// Lease returns the client's current inbox lease, or nil if none is held.
+func (c *Client) Lease(ctx context.Context, reqOpts ...RequestOption) (*Lease, error) {
+	if err := c.requireLease(ctx); err != nil {
+		return nil, err
+	}
+	var lease Lease
+	err := c.request(ctx, "GET", c.leasePath(), nil, &lease, reqOpts...)
+	if isStatus(err, http.StatusNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lease, nil
+}
+
+func (c *Client) requireLease(ctx context.Context) error {
+	if c.AgentID() == "" {
+		return fmt.Errorf("not registered")
+	}
+	return c.requireFeature(ctx, FeatureInboxLease)
+}
+
+func (c *Client) leasePath() string {
+	return "/agents/" + c.AgentID() + "/lease"
+}
+
+// Standby is a Service that runs Active only while it holds the agent's
+// inbox lease, for high availability with the same identity on several
+// hosts. Every instance runs a Standby: the one holding the lease renews
+// it as its heartbeat, and the others stay passive, with Send and Ack
+// failing with ErrStandby, until the lease expires and one of them takes
+// over.
+type Standby struct {
+	Client *Client
+	// Active is run while this instance is primary, typically the agent's
+	// Poller or Subscription.
+	Active Service
+	// Holder names this instance. Defaults to hostname and process ID.
+	Holder string
+	// TTL is the lease duration. A primary that stops renewing is replaced
+	// after at most TTL. Defaults to 15s; renewals happen every TTL/3.
+	TTL time.Duration
+	// OnPromote and OnDemote, if set, are called when this instance gains
+	// or loses the lease.
+	OnPromote func()
+	OnDemote  func()
+	// OnError, if set, receives lease and Active errors.
+	OnError func(error)
+}
+
+// Run implements Service. On return the lease is released if held.
+func (s *Standby) Run(ctx context.Context) error {
+	if err := s.Client.requireLease(ctx); err != nil {
+		return err
+	}
+	holder := s.holder()
+	ttl := durationOr(s.TTL, 15*time.Second)
+	s.Client.setPassive(true)
+	defer s.Client.setPassive(false)
+
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	var (
+		active  *activeRun
+		renewed time.Time
+	)
+	demote := func() {
+		if active == nil {
+			return
+		}
+		s.Client.setPassive(true)
+		active.stop()
+		active = nil
+		if s.OnDemote != nil {
+			s.OnDemote()
+		}
+	}
+	defer func() {
+		if active != nil {
+			demote()
+			releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), ttl/3)
+			defer cancel()
+			s.Client.ReleaseLease(releaseCtx, holder)
+		}
+	}()
+
+	for {
+		_, err := s.Client.AcquireLease(ctx, holder, ttl)
+		switch {
+		case err == nil:
+			renewed = time.Now()
+			if active == nil {
+				s.Client.setPassive(false)
+				active = startActive(ctx, s.Active, s.OnError)
+				if s.OnPromote != nil {
+					s.OnPromote()
+				}
+			}
+		case errors.Is(err, ErrLeaseHeld):
+			demote()
+		case ctx.Err() == nil:
+			reportError(s.OnError, err)
+			// The lease may lapse while the server is unreachable; stop
+			// acting as primary before another instance can take over.
+			if active != nil && time.Since(renewed) >= ttl*2/3 {
+				demote()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// activeRun is a running Standby.Active service.
+type activeRun struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func startActive(ctx context.Context, svc Service, onError func(error)) *activeRun {
+	ctx, cancel := context.WithCancel(ctx)
+	r := &activeRun{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		if svc == nil {
+			return
+		}
+		if err := svc.Run(ctx); err != nil {
+			reportError(onError, err)
+		}
+	}()
+	return r
+}
+
+// stop cancels the service and waits for it to return.
+func (r *activeRun) stop() {
+	r.cancel()
+	<-r.done
+}
+
+func (s *Standby) holder() string {
+	if s.Holder != "" {
+		return s.Holder
+	}
+	host, _ := os.Hostname()
+	return host + ":" + strconv.Itoa(os.Getpid())
+}
+
+// passiveState marks a client as a passive standby.
+type passiveState struct {
+	mu      sync.RWMutex
+	passive bool
+}
+
+func (c *Client) setPassive(v bool) {
+	c.standby.mu.Lock()
+	c.standby.passive = v
+	c.standby.mu.Unlock()
+}
+
+// Passive reports whether the client is a standby that must not send or
+// acknowledge messages.
+func (c *Client) Passive() bool {
+	c.standby.mu.RLock()
+	defer c.standby.mu.RUnlock()
+	return c.standby.passive
+}
@@ -0,0 +1,60 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// FeatureInboxStats is the inbox statistics endpoint, which counts
+// unacknowledged messages without returning them.
+const FeatureInboxStats Feature = "inbox-stats"
+
+// InboxStats counts the unacknowledged messages in an inbox.
+type InboxStats struct {
+	Total    int                 `json:"total"`
+	BySender map[string]int      `json:"bySender"`
+	ByType   map[MessageType]int `json:"byType"`
+	// Oldest is the timestamp of the oldest unacknowledged message, or zero
+	// if the inbox is empty.
+	Oldest Timestamp `json:"oldest"`
+}
+
+// InboxStats returns unacknowledged message counts, in total, per sender
+// and per type. Servers with FeatureInboxStats compute them without
+// sending message bodies; on other servers the inbox is fetched and
+// counted locally, without recording or decoding it.
+func (c *Client) InboxStats(ctx context.Context, reqOpts ...RequestOption) (*InboxStats, error) {
+	if c.AgentID() == "" {
+		return nil, fmt.Errorf("not registered")
+	}
+	err := c.requireFeature(ctx, FeatureInboxStats)
+	if err == nil {
+		var stats InboxStats
+		if err := c.request(ctx, "GET", "/agents/"+c.AgentID()+"/inbox/stats", nil, &stats, reqOpts...); err != nil {
+			return nil, err
+		}
+		return &stats, nil
+	}
+	if !errors.Is(err, ErrUnsupported) {
+		return nil, err
+	}
+
+	var msgs []Message
+	if err := c.request(ctx, "GET", "/agents/"+c.AgentID()+"/inbox", nil, &msgs, reqOpts...); err != nil {
+		return nil, err
+	}
+	stats := &InboxStats{
+		BySender: make(map[string]int),
+		ByType:   make(map[MessageType]int),
+	}
+	for _, m := range msgs {
+		stats.Total++
+		stats.BySender[m.From]++
+		stats.ByType[m.Type]++
+		if stats.Oldest.IsZero() || m.Timestamp.Before(stats.Oldest.Time) {
+			stats.Oldest = m.Timestamp
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,83 @@
+package ping
+
+import (
+	"context"
+	"time"
+)
+
+// MessageTypeStatus is an ephemeral progress signal, such as "still
+// processing", from an agent working on a request. Receiving clients
+// acknowledge status messages at once and pass them to WithOnStatus
+// callbacks instead of returning them, and neither side stores them.
+const MessageTypeStatus MessageType = "status"
+
+// StatusUpdate is a received status message.
+type StatusUpdate struct {
+	From      string
+	Status    string
+	Timestamp Timestamp
+}
+
+// WithOnStatus adds a callback for status messages sent by Working, e.g.
+// to extend a timeout while a peer is still processing. Callbacks run
+// while the batch is received and should return quickly.
+func WithOnStatus(fn func(ctx context.Context, s StatusUpdate)) Option {
+	return func(c *Client) {
+		c.onStatus = append(c.onStatus, fn)
+	}
+}
+
+// Working tells to that this agent is busy, with a short status such as
+// "searching flights". It is a hint for callers awaiting a reply and is
+// never stored.
+func (c *Client) Working(ctx context.Context, to, status string, reqOpts ...RequestOption) error {
+	_, err := c.Send(ctx, to, MessageTypeStatus, map[string]interface{}{"status": status}, "", reqOpts...)
+	return err
+}
+
+// KeepWorking sends status to to now and again every interval until the
+// returned stop function is called or ctx is done, for long-running work
+// whose callers time out without a sign of life. Send errors are ignored.
+func (c *Client) KeepWorking(ctx context.Context, to, status string, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(durationOr(interval, 10*time.Second))
+		defer ticker.Stop()
+		for {
+			c.Working(ctx, to, status)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return cancel
+}
+
+// consumeStatus removes status messages from msgs, acknowledging them and
+// passing them to the WithOnStatus callbacks.
+func (c *Client) consumeStatus(ctx context.Context, msgs []Message) []Message {
+	out := msgs[:0:0]
+	for _, m := range msgs {
+		if m.Type != MessageTypeStatus {
+			out = append(out, m)
+			continue
+		}
+		c.Ack(ctx, m.ID)
+		if len(c.onStatus) == 0 {
+			continue
+		}
+		var p struct {
+			Status string `json:"status"`
+		}
+		if err := m.DecodePayload(&p); err != nil {
+			continue
+		}
+		s := StatusUpdate{From: m.From, Status: p.Status, Timestamp: m.Timestamp}
+		for _, fn := range c.onStatus {
+			fn(ctx, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,389 @@
+package ping
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ErrNotFound is returned by stores when a message does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Store keeps a local copy of message history. When a client is configured
+// with a store, received and sent messages are saved to it.
+type Store interface {
+	// Save inserts or replaces messages by ID.
+	Save(ctx context.Context, msgs ...Message) error
+	// Load returns the message with the given ID, or ErrNotFound.
+	Load(ctx context.Context, id string) (*Message, error)
+	// List returns stored messages matching f in insertion order.
+	List(ctx context.Context, f StoreFilter) ([]Message, error)
+	// Delete removes messages by ID. Unknown IDs are ignored.
+	Delete(ctx context.Context, ids ...string) error
+}
+
+// StoreFilter selects messages from a Store. Zero fields match everything.
+type StoreFilter struct {
+	// Peer matches messages sent to or received from this agent.
+	Peer string
+	// Type matches the message type.
+	Type MessageType
+	// Limit keeps only the most recent Limit matches.
+	Limit int
+}
+
+func (f StoreFilter) match(m *Message) bool {
+	if f.Peer != "" && m.From != f.Peer && m.To != f.Peer {
+		return false
+	}
+	if f.Type != "" && m.Type != f.Type {
+		return false
+	}
+	return true
+}
+
+// MemoryStore is an in-memory Store. The zero value is ready to use.
+// Messages are indexed by type, sender and recipient for Query.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	order []string
+	msgs  map[string]Message
+	seq   map[string]uint64 // insertion sequence number per ID
+	next  uint64
+	index map[string]map[string][]string // field -> value -> IDs by seq
+}
+
+// indexedFields are the message fields MemoryStore indexes.
+var indexedFields = []string{"type", "from", "to"}
+
+func indexedValue(m *Message, field string) string {
+	switch field {
+	case "type":
+		return string(m.Type)
+	case "from":
+		return m.From
+	case "to":
+		return m.To
+	}
+	return ""
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Save implements Store.
+func (s *MemoryStore) Save(ctx context.Context, msgs ...Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.save(msgs)
+	return nil
+}
+
+// len returns the number of messages stored.
+func (s *MemoryStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.msgs)
+}
+
+func (s *MemoryStore) save(msgs []Message) {
+	if s.msgs == nil {
+		s.msgs = make(map[string]Message)
+		s.seq = make(map[string]uint64)
+		s.index = make(map[string]map[string][]string)
+	}
+	for _, m := range msgs {
+		old, ok := s.msgs[m.ID]
+		if !ok {
+			s.order = append(s.order, m.ID)
+			s.next++
+			s.seq[m.ID] = s.next
+		}
+		s.msgs[m.ID] = m
+		for _, field := range indexedFields {
+			v := indexedValue(&m, field)
+			if ok {
+				prev := indexedValue(&old, field)
+				if prev == v {
+					continue
+				}
+				s.unindex(field, prev, m.ID)
+			}
+			s.reindex(field, v, m.ID)
+		}
+	}
+}
+
+// reindex adds id to the index for field = value, keeping insertion order.
+func (s *MemoryStore) reindex(field, value, id string) {
+	byValue := s.index[field]
+	if byValue == nil {
+		byValue = make(map[string][]string)
+		s.index[field] = byValue
+	}
+	ids := byValue[value]
+	seq := s.seq[id]
+	i := sort.Search(len(ids), func(i int) bool { return s.seq[ids[i]] > seq })
+	ids = append(ids, "")
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	byValue[value] = ids
+}
+
+func (s *MemoryStore) unindex(field, value, id string) {
+	byValue := s.index[field]
+	ids := byValue[value]
+	for i, x := range ids {
+		if x == id {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(byValue, value)
+	} else {
+		byValue[value] = ids
+	}
+}
+
+// Load implements Store.
+func (s *MemoryStore) Load(ctx context.Context, id string) (*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.msgs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &m, nil
+}
+
+// List implements Store.
+func (s *MemoryStore) List(ctx context.Context, f StoreFilter) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []Message
+	for _, id := range s.order {
+		m := s.msgs[id]
+		if f.match(&m) {
+			out = append(out, m)
+		}
+	}
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[len(out)-f.Limit:]
+	}
+	return out, nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(ctx context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delete(ids)
+	return nil
+}
+
+func (s *MemoryStore) delete(ids []string) {
+	removed := false
+	for _, id := range ids {
+		if m, ok := s.msgs[id]; ok {
+			for _, field := range indexedFields {
+				s.unindex(field, indexedValue(&m, field), id)
+			}
+			delete(s.msgs, id)
+			delete(s.seq, id)
+			removed = true
+		}
+	}
+	if !removed {
+		return
+	}
+	order := s.order[:0]
+	for _, id := range s.order {
+		if _, ok := s.msgs[id]; ok {
+			order = append(order, id)
+		}
+	}
+	s.order = order
+}
+
+// Query returns the stored messages matching q, in insertion order. See
+// Query for the syntax. An equality on type, from or to in the query's
+// top-level AND chain is answered from the index instead of a full scan.
+func (s *MemoryStore) Query(ctx context.Context, q string) ([]Message, error) {
+	query, err := ParseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := s.order
+	if field, value, ok := query.indexHint(); ok {
+		ids = s.index[field][value]
+	}
+	var out []Message
+	for _, id := range ids {
+		m := s.msgs[id]
+		if query.Match(&m) {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+// Compaction thresholds for FileStore: the file is rewritten once it has
+// at least fileStoreCompactMin lines and fileStoreCompactRatio times as
+// many lines as live messages, since saving a message again appends it.
+const (
+	fileStoreCompactMin   = 1000
+	fileStoreCompactRatio = 2
+)
+
+// FileStore is a Store persisted as a JSON Lines file. Saves are appended;
+// the file is compacted when messages are deleted and when superseded
+// lines make up half of it.
+type FileStore struct {
+	path string
+	mem  MemoryStore
+	mu   sync.Mutex
+	// lines is the number of lines in the file.
+	lines int
+}
+
+// OpenFileStore opens or creates a JSON Lines message store at path. A
+// final line cut short by a crash during Save is dropped from the file.
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64 // end of the last complete line
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(bytes.TrimSpace(line)) > 0 {
+			// Save writes each line whole, so an unterminated last line
+			// is from an interrupted write.
+			if err := os.Truncate(path, offset); err != nil {
+				return nil, err
+			}
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		offset += int64(len(line))
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			var m Message
+			if jsonErr := json.Unmarshal(trimmed, &m); jsonErr != nil {
+				return nil, jsonErr
+			}
+			s.mem.save([]Message{m})
+			s.lines++
+		}
+		if err != nil {
+			break
+		}
+	}
+	return s, nil
+}
+
+// Save implements Store.
+func (s *FileStore) Save(ctx context.Context, msgs ...Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, m := range msgs {
+		if err := enc.Encode(m); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	s.lines += len(msgs)
+	if err := s.mem.Save(ctx, msgs...); err != nil {
+		return err
+	}
+	if s.lines >= fileStoreCompactMin && s.lines >= fileStoreCompactRatio*s.mem.len() {
+		return s.compact(ctx)
+	}
+	return nil
+}
+
+// Load implements Store.
+func (s *FileStore) Load(ctx context.Context, id string) (*Message, error) {
+	return s.mem.Load(ctx, id)
+}
+
+// List implements Store.
+func (s *FileStore) List(ctx context.Context, f StoreFilter) ([]Message, error) {
+	return s.mem.List(ctx, f)
+}
+
+// Query returns the stored messages matching q, as MemoryStore.Query does.
+func (s *FileStore) Query(ctx context.Context, q string) ([]Message, error) {
+	return s.mem.Query(ctx, q)
+}
+
+// Delete implements Store.
+func (s *FileStore) Delete(ctx context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.mem.Delete(ctx, ids...); err != nil {
+		return err
+	}
+	return s.compact(ctx)
+}
+
+// compact rewrites the file with one line per live message.
+func (s *FileStore) compact(ctx context.Context) error {
+	msgs, err := s.mem.List(ctx, StoreFilter{})
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, m := range msgs {
+		if err := enc.Encode(m); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.lines = len(msgs)
+	return nil
+}
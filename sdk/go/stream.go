@@ -0,0 +1,262 @@
+package ping
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FeatureStream is the Server-Sent Events inbox stream.
+const FeatureStream Feature = "stream"
+
+// defaultStreamRetry is the minimum reconnect delay used until the server
+// sends a retry field.
+const defaultStreamRetry = time.Second
+
+// Stream delivers inbox messages as they arrive over Server-Sent Events
+// (GET /agents/:id/stream), for environments where WebSockets are blocked.
+// The stream reconnects automatically, with the client's Backoff and no
+// sooner than the server's retry field, resuming from the last event ID.
+// After reconnecting it also replays, oldest first, the inbox messages
+// that arrived since the last one delivered, or since the stream started
+// by the server's Date header, so none are missed even by servers that
+// ignore the event ID. The client's clock is never used. Repeats are recognized by timestamp:
+// once a message has been delivered, one no newer than it is dropped
+// unless it shares its timestamp and has not been delivered, so messages
+// are delivered at most once per stream as long as the server sends them
+// in timestamp order. The channel is closed when ctx is done.
+//
+// An error is returned only if the first connection fails.
+func (c *Client) Stream(ctx context.Context, reqOpts ...RequestOption) (<-chan Message, error) {
+	if c.AgentID() == "" {
+		return nil, fmt.Errorf("not registered")
+	}
+	if err := c.requireFeature(ctx, FeatureStream); err != nil {
+		return nil, err
+	}
+
+	s := &sseStream{
+		c:       c,
+		reqOpts: reqOpts,
+		header:  newCallConfig(reqOpts).header,
+		retry:   defaultStreamRetry,
+	}
+	resp, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		s.since = date
+	}
+	body := resp.Body
+
+	ch := make(chan Message)
+	go func() {
+		defer close(ch)
+		for {
+			s.read(ctx, body, ch)
+			body.Close()
+			var delay time.Duration
+			for attempt := 1; ; attempt++ {
+				delay = c.reconnectBackoff().Next(attempt, delay)
+				if delay < s.retry {
+					delay = s.retry
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+				if resp, err = s.connect(ctx); err == nil {
+					body = resp.Body
+					break
+				}
+			}
+			if !s.backfill(ctx, ch) {
+				body.Close()
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// sseStream tracks resume state across reconnects.
+type sseStream struct {
+	c           *Client
+	lastEventID string
+	reqOpts     []RequestOption
+	header      http.Header
+	retry       time.Duration
+
+	// since is the server timestamp of the newest message delivered, or
+	// the Date of the first response; backfill replays inbox messages from
+	// then on. It is zero if the server sent neither.
+	since time.Time
+	// cursor is the timestamp of the newest message delivered, zero until
+	// one is, and atCursor the IDs delivered with that timestamp.
+	cursor   time.Time
+	atCursor map[string]bool
+}
+
+func (s *sseStream) connect(ctx context.Context) (*http.Response, error) {
+	req, err := s.c.newRequest(ctx, "GET", "/agents/"+s.c.AgentID()+"/stream", nil, s.header)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if s.lastEventID != "" {
+		req.Header.Set("Last-Event-ID", s.lastEventID)
+	}
+
+	// Streams are long-lived, so the client-wide timeout does not apply.
+	hc := *s.c.httpClient
+	hc.Timeout = 0
+	resp, err := s.c.roundTrip(&hc, req)
+	if s.c.discovery != nil {
+		// Reconnect to another server if this one failed.
+		s.c.discovery.observe(req, resp, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		return nil, readAPIError(resp)
+	}
+	return resp, nil
+}
+
+// read dispatches events from body until it ends or ctx is done. Events
+// larger than the client's size limit are skipped.
+func (s *sseStream) read(ctx context.Context, body io.Reader, ch chan<- Message) {
+	limit := s.c.responseLimit()
+	r := bufio.NewReader(body)
+	var event, id string
+	var data strings.Builder
+	tooLarge := false
+	for {
+		line, err := readLine(r, limit)
+		if errors.Is(err, ErrResponseTooLarge) {
+			tooLarge = true
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		if line == "" {
+			if data.Len() > 0 && !tooLarge && (event == "" || event == "message") {
+				if !s.dispatch(ctx, data.String(), ch) {
+					return
+				}
+			}
+			if id != "" {
+				s.lastEventID = id
+			}
+			event, id = "", ""
+			data.Reset()
+			tooLarge = false
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue // comment / keep-alive
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			if tooLarge || limit > 0 && int64(data.Len()+len(value)) >= limit {
+				tooLarge = true
+				data.Reset()
+				continue
+			}
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+		case "id":
+			id = value
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms > 0 {
+				s.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// dispatch decodes one message event and sends it on ch. It reports false
+// if ctx was cancelled.
+func (s *sseStream) dispatch(ctx context.Context, data string, ch chan<- Message) bool {
+	if checkJSONDepth([]byte(data)) != nil {
+		return true
+	}
+	var msg Message
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		return true
+	}
+	return s.deliver(ctx, msg, ch)
+}
+
+// backfill replays the inbox messages that arrived while the stream was
+// disconnected. Failures are ignored, leaving the messages to later polls,
+// and without a server timestamp to start from there is nothing to replay.
+// It reports false if ctx was cancelled.
+func (s *sseStream) backfill(ctx context.Context, ch chan<- Message) bool {
+	if s.since.IsZero() {
+		return true
+	}
+	w := messageWindow{since: s.since}
+	var msgs []Message
+	if err := s.c.request(ctx, "GET", w.query("/agents/"+s.c.AgentID()+"/inbox"), nil, &msgs, s.reqOpts...); err != nil {
+		return ctx.Err() == nil
+	}
+	msgs = w.filter(msgs)
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].Timestamp.Before(msgs[j].Timestamp.Time)
+	})
+	for _, msg := range msgs {
+		if !s.deliver(ctx, msg, ch) {
+			return false
+		}
+	}
+	return true
+}
+
+// deliver sends msg on ch unless it is at or behind the stream's cursor
+// and so already delivered. It reports false if ctx was cancelled.
+func (s *sseStream) deliver(ctx context.Context, msg Message, ch chan<- Message) bool {
+	ts := msg.Timestamp.Time
+	switch {
+	case s.cursor.IsZero() || ts.After(s.cursor):
+		s.cursor = ts
+		s.atCursor = map[string]bool{msg.ID: true}
+	case ts.Equal(s.cursor) && !s.atCursor[msg.ID]:
+		s.atCursor[msg.ID] = true
+	default:
+		return true
+	}
+	if ts.After(s.since) {
+		s.since = ts
+	}
+
+	for _, m := range s.c.receive(ctx, []Message{msg}) {
+		select {
+		case ch <- m:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestStreamBackfillCursor checks that the backfill after a reconnect
+// starts from server timestamps: the Date of the first response until a
+// message is delivered, then that message's timestamp.
+func TestStreamBackfillCursor(t *testing.T) {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	delivered := start.Add(90 * time.Minute)
+
+	var (
+		mu      sync.Mutex
+		streams int
+		since   = make(chan string, 4)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/info", "/":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"name":"PING","version":"0.2.0","features":["messaging","stream"]}`))
+		case "/agents/a1/stream":
+			mu.Lock()
+			streams++
+			n := streams
+			mu.Unlock()
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Date", start.Add(time.Duration(n)*time.Hour).Format(http.TimeFormat))
+			fmt.Fprint(w, "retry: 1\n\n")
+			if n == 2 {
+				fmt.Fprintf(w, "id: 1\ndata: {\"id\":\"m1\",\"from\":\"a2\",\"to\":\"a1\",\"type\":\"text\",\"payload\":{},\"timestamp\":%d}\n\n", delivered.UnixMilli())
+			}
+		case "/agents/a1/inbox":
+			since <- r.URL.Query().Get("since")
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewClient(srv.URL, WithBackoff(ConstantBackoff{Delay: time.Millisecond}))
+	c.SetAgentID("a1")
+	// The first response is dated an hour after start; the client's clock
+	// is years later and must not be used.
+	first := start.Add(time.Hour)
+	ch, err := c.Stream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []time.Time{first, delivered} {
+		select {
+		case got := <-since:
+			if got != fmt.Sprint(want.UnixMilli()) {
+				t.Errorf("backfill %d since = %s, want %d (%s)", i+1, got, want.UnixMilli(), want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no backfill %d", i+1)
+		}
+		if i == 0 {
+			select {
+			case msg := <-ch:
+				if msg.ID != "m1" {
+					t.Errorf("stream delivered %s, want m1", msg.ID)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("stream delivered nothing")
+			}
+		}
+	}
+}
@@ -0,0 +1,466 @@
+package ping
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Streamed reply message types. A streamed reply is a MessageTypeStreamStart
+// message, MessageTypeStreamChunk messages carrying the text, and a
+// MessageTypeStreamEnd message, all replying to the message answered.
+const (
+	MessageTypeStreamStart MessageType = "stream.start"
+	MessageTypeStreamChunk MessageType = "stream.chunk"
+	MessageTypeStreamEnd   MessageType = "stream.end"
+)
+
+// streamField holds the StreamFrame in streamed reply payloads.
+const streamField = "$stream"
+
+// Streamed reply defaults.
+const (
+	defaultStreamChunkSize     = 4 << 10
+	defaultStreamFlushInterval = 250 * time.Millisecond
+	defaultStreamIdleTimeout   = 2 * time.Minute
+)
+
+// ErrStreamTimeout is returned by StreamReader.Read when no part of the
+// stream arrives within the receiver's idle timeout.
+var ErrStreamTimeout = errors.New("stream timed out")
+
+// StreamFrame is the payload of a streamed reply message.
+type StreamFrame struct {
+	// ID identifies the stream.
+	ID string `json:"id"`
+	// Seq is 0 for the start message and numbers chunks from 1. The end
+	// message has the number after the last chunk, so a receiver knows
+	// when it has every chunk.
+	Seq int `json:"seq"`
+	// ContentType, in the start message, describes the text, e.g.
+	// "text/markdown".
+	ContentType string `json:"contentType,omitempty"`
+	// Data is a chunk's text.
+	Data string `json:"data,omitempty"`
+	// Error, in the end message, reports that the stream failed.
+	Error string `json:"error,omitempty"`
+}
+
+// MessageStreamFrame returns the frame of a streamed reply message.
+func MessageStreamFrame(msg Message) (*StreamFrame, bool) {
+	switch msg.Type {
+	case MessageTypeStreamStart, MessageTypeStreamChunk, MessageTypeStreamEnd:
+	default:
+		return nil, false
+	}
+	var env struct {
+		Stream *StreamFrame `json:"$stream"`
+	}
+	if msg.DecodePayload(&env) != nil || env.Stream == nil {
+		return nil, false
+	}
+	return env.Stream, true
+}
+
+// StreamError is returned by StreamReader.Read when the sender ended the
+// stream with CloseWithError.
+type StreamError struct {
+	Message string
+}
+
+func (e *StreamError) Error() string {
+	return "stream failed: " + e.Message
+}
+
+// StreamOptions configures a StreamWriter.
+type StreamOptions struct {
+	// ContentType describes the streamed text. Defaults to "text/plain".
+	ContentType string
+	// ChunkSize is the most text sent in one message. Defaults to 4 KiB.
+	ChunkSize int
+	// FlushInterval is how long written text may wait for more before it
+	// is sent. Defaults to 250ms.
+	FlushInterval time.Duration
+}
+
+// StreamWriter streams a reply to a message as it is written, so callers
+// see partial results, such as an LLM's tokens, before the answer is
+// complete. Writes are batched into chunks of up to ChunkSize, sent at
+// least every FlushInterval. The text must be UTF-8; chunks are split on
+// rune boundaries.
+type StreamWriter struct {
+	c       *Client
+	ctx     context.Context
+	to      string
+	replyTo string
+	id      string
+	opts    StreamOptions
+
+	mu      sync.Mutex
+	buf     []byte
+	seq     int
+	started bool
+	closed  bool
+	err     error // first send error, returned by later calls
+	timer   *time.Timer
+}
+
+// StreamReply starts a streamed reply to msg. Nothing is sent until the
+// first Write or Close. All messages are sent with ctx; if it is done, the
+// stream fails.
+func (c *Client) StreamReply(ctx context.Context, msg Message, opts *StreamOptions) *StreamWriter {
+	w := &StreamWriter{c: c, ctx: ctx, to: msg.From, replyTo: msg.ID}
+	if opts != nil {
+		w.opts = *opts
+	}
+	if w.opts.ContentType == "" {
+		w.opts.ContentType = "text/plain"
+	}
+	if w.opts.ChunkSize <= 0 {
+		w.opts.ChunkSize = defaultStreamChunkSize
+	}
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		w.err = err
+	}
+	w.id = hex.EncodeToString(id[:])
+	return w
+}
+
+// ID returns the stream's ID.
+func (w *StreamWriter) ID() string {
+	return w.id
+}
+
+// Write implements io.Writer. It sends full chunks right away and leaves
+// the rest for the next Write, the flush timer or Close.
+func (w *StreamWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, errors.New("stream closed")
+	}
+	if err := w.startLocked(); err != nil {
+		return 0, err
+	}
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= w.opts.ChunkSize {
+		if err := w.sendChunkLocked(w.opts.ChunkSize); err != nil {
+			return 0, err
+		}
+	}
+	if len(w.buf) > 0 && w.timer == nil {
+		w.timer = time.AfterFunc(durationOr(w.opts.FlushInterval, defaultStreamFlushInterval), w.flushTimer)
+	}
+	return len(p), nil
+}
+
+// Flush sends any buffered text now.
+func (w *StreamWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return w.err
+	}
+	return w.flushLocked()
+}
+
+func (w *StreamWriter) flushTimer() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timer = nil
+	if !w.closed {
+		w.flushLocked()
+	}
+}
+
+// Close sends any buffered text and ends the stream.
+func (w *StreamWriter) Close() error {
+	return w.CloseWithError(nil)
+}
+
+// CloseWithError sends any buffered text and ends the stream. A non-nil
+// err is reported to the reader as a *StreamError after the text.
+func (w *StreamWriter) CloseWithError(err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if serr := w.startLocked(); serr != nil {
+		return serr
+	}
+	if serr := w.flushLocked(); serr != nil {
+		return serr
+	}
+	w.seq++
+	frame := StreamFrame{ID: w.id, Seq: w.seq}
+	if err != nil {
+		frame.Error = err.Error()
+	}
+	return w.sendLocked(MessageTypeStreamEnd, frame)
+}
+
+func (w *StreamWriter) startLocked() error {
+	if w.started || w.err != nil {
+		return w.err
+	}
+	w.started = true
+	return w.sendLocked(MessageTypeStreamStart, StreamFrame{ID: w.id, ContentType: w.opts.ContentType})
+}
+
+func (w *StreamWriter) flushLocked() error {
+	for len(w.buf) > 0 && w.err == nil {
+		w.sendChunkLocked(len(w.buf))
+	}
+	return w.err
+}
+
+// sendChunkLocked sends up to n bytes of the buffer, backing off to a rune
+// boundary.
+func (w *StreamWriter) sendChunkLocked(n int) error {
+	if n > len(w.buf) {
+		n = len(w.buf)
+	}
+	for cut := n; cut > 0 && cut > n-utf8.UTFMax; cut-- {
+		if cut == len(w.buf) || utf8.RuneStart(w.buf[cut]) {
+			n = cut
+			break
+		}
+	}
+	data := string(w.buf[:n])
+	w.buf = append(w.buf[:0], w.buf[n:]...)
+	w.seq++
+	return w.sendLocked(MessageTypeStreamChunk, StreamFrame{ID: w.id, Seq: w.seq, Data: data})
+}
+
+func (w *StreamWriter) sendLocked(msgType MessageType, frame StreamFrame) error {
+	if w.err != nil {
+		return w.err
+	}
+	payload := map[string]interface{}{streamField: frame}
+	if _, err := w.c.Send(w.ctx, w.to, msgType, payload, w.replyTo); err != nil {
+		w.err = fmt.Errorf("stream %s: %w", w.id, err)
+	}
+	return w.err
+}
+
+// StreamReader reads a streamed reply as it arrives. Parts of the stream
+// may arrive in any order; chunks are reordered by sequence number, so
+// Read returns the text as it was written, and the stream ends only once
+// its start and end messages have both arrived.
+type StreamReader struct {
+	id      string
+	from    string
+	replyTo string
+	idle    time.Duration
+
+	mu          sync.Mutex
+	contentType string
+	started     bool
+	chunks      map[int]string
+	next        int // sequence number Read needs next
+	end         int // sequence number of the end message, or 0
+	errMsg      string
+	cur         string
+	last        time.Time     // when the last frame arrived
+	updated     chan struct{} // closed and replaced when a frame arrives
+}
+
+// ID returns the stream's ID.
+func (r *StreamReader) ID() string { return r.id }
+
+// From returns the ID of the agent streaming the reply.
+func (r *StreamReader) From() string { return r.from }
+
+// ReplyTo returns the ID of the message the stream answers.
+func (r *StreamReader) ReplyTo() string { return r.replyTo }
+
+// ContentType returns the content type given by the sender, or "" until
+// the stream's start message has arrived.
+func (r *StreamReader) ContentType() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.contentType
+}
+
+// Read implements io.Reader. It blocks until more text arrives and
+// returns io.EOF after the last chunk, a *StreamError if the sender ended
+// the stream with an error, or ErrStreamTimeout if the stream goes idle.
+func (r *StreamReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	timer := time.NewTimer(r.idle)
+	defer timer.Stop()
+	for {
+		r.mu.Lock()
+		for r.cur == "" {
+			data, ok := r.chunks[r.next]
+			if !ok {
+				break
+			}
+			delete(r.chunks, r.next)
+			r.next++
+			r.cur = data
+		}
+		if r.cur != "" {
+			n := copy(p, r.cur)
+			r.cur = r.cur[n:]
+			r.mu.Unlock()
+			return n, nil
+		}
+		if r.started && r.end > 0 && r.next >= r.end {
+			errMsg := r.errMsg
+			r.mu.Unlock()
+			if errMsg != "" {
+				return 0, &StreamError{Message: errMsg}
+			}
+			return 0, io.EOF
+		}
+		updated := r.updated
+		r.mu.Unlock()
+
+		select {
+		case <-updated:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(r.idle)
+		case <-timer.C:
+			return 0, ErrStreamTimeout
+		}
+	}
+}
+
+// add applies a frame and reports whether the reader now holds the whole
+// stream.
+func (r *StreamReader) add(msgType MessageType, f *StreamFrame) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch msgType {
+	case MessageTypeStreamStart:
+		r.contentType, r.started = f.ContentType, true
+	case MessageTypeStreamChunk:
+		if f.Seq >= r.next && f.Seq > 0 {
+			r.chunks[f.Seq] = f.Data
+		}
+	case MessageTypeStreamEnd:
+		r.end, r.errMsg = f.Seq, f.Error
+	}
+	r.last = time.Now()
+	close(r.updated)
+	r.updated = make(chan struct{})
+	return r.started && r.end > 0 && len(r.chunks) >= r.end-r.next
+}
+
+// StreamReceiver is a Middleware component that turns streamed replies
+// into StreamReaders. Each stream's Handler runs in its own goroutine as
+// soon as the stream's first message arrives, and stream messages are
+// acknowledged as they are passed to it. Other messages go to the next
+// handler.
+type StreamReceiver struct {
+	// Handler reads one stream. ctx is the context of the stream's first
+	// message.
+	Handler func(ctx context.Context, r *StreamReader) error
+	// IdleTimeout is how long a reader waits for the next part of a
+	// stream before failing with ErrStreamTimeout. Defaults to 2m.
+	IdleTimeout time.Duration
+	// OnError, if set, receives Handler errors.
+	OnError func(error)
+
+	mu      sync.Mutex
+	readers map[string]*StreamReader
+	seen    map[string]time.Time // streams already complete or abandoned
+}
+
+// Middleware routes stream messages to their readers.
+func (sr *StreamReceiver) Middleware(next Handler) Handler {
+	return func(ctx context.Context, msg Message) error {
+		f, ok := MessageStreamFrame(msg)
+		if !ok {
+			return next(ctx, msg)
+		}
+		r, isNew := sr.reader(msg, f)
+		if r == nil {
+			return nil
+		}
+		if isNew {
+			go func() {
+				if err := sr.Handler(ctx, r); err != nil {
+					reportError(sr.OnError, err)
+				}
+			}()
+		}
+		if r.add(msg.Type, f) {
+			sr.done(msg.From + "/" + f.ID)
+		}
+		return nil
+	}
+}
+
+// reader returns the reader for the stream msg belongs to, creating it on
+// the stream's first message, or nil for a stream already finished.
+func (sr *StreamReceiver) reader(msg Message, f *StreamFrame) (*StreamReader, bool) {
+	key := msg.From + "/" + f.ID
+	idle := durationOr(sr.IdleTimeout, defaultStreamIdleTimeout)
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if r, ok := sr.readers[key]; ok {
+		return r, false
+	}
+	if _, ok := sr.seen[key]; ok {
+		return nil, false
+	}
+	if sr.readers == nil {
+		sr.readers = make(map[string]*StreamReader)
+		sr.seen = make(map[string]time.Time)
+	}
+	// Streams that went idle are dropped here, so a receiver does not
+	// collect abandoned ones.
+	now := time.Now()
+	for k, at := range sr.seen {
+		if now.Sub(at) > 2*idle {
+			delete(sr.seen, k)
+		}
+	}
+	for k, r := range sr.readers {
+		r.mu.Lock()
+		stale := now.Sub(r.last) > 2*idle
+		r.mu.Unlock()
+		if stale {
+			delete(sr.readers, k)
+			sr.seen[k] = now
+		}
+	}
+	r := &StreamReader{
+		id:      f.ID,
+		from:    msg.From,
+		replyTo: msg.ReplyTo,
+		idle:    idle,
+		chunks:  make(map[int]string),
+		next:    1,
+		last:    now,
+		updated: make(chan struct{}),
+	}
+	sr.readers[key] = r
+	return r, true
+}
+
+func (sr *StreamReceiver) done(key string) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	delete(sr.readers, key)
+	sr.seen[key] = time.Now()
+}
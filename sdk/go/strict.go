@@ -0,0 +1,180 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AcceptsCapabilityPrefix marks the capabilities that declare which
+// message types an agent accepts, e.g. "accepts:request". Agents without
+// any are assumed to accept every type.
+const AcceptsCapabilityPrefix = "accepts:"
+
+// defaultStrictCacheTTL is how long strict mode caches recipient lookups.
+const defaultStrictCacheTTL = 5 * time.Minute
+
+// ErrBlocked is reported by strict mode when sending to an agent the
+// client has blocked.
+var ErrBlocked = errors.New("agent is blocked")
+
+// ErrTypeNotAccepted is reported by strict mode when the recipient's
+// capabilities do not list the message type.
+var ErrTypeNotAccepted = errors.New("message type not accepted by recipient")
+
+// ErrPayloadTooLarge is reported by strict mode when the encoded payload
+// exceeds StrictOptions.MaxPayloadSize.
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+// StrictOptions configures strict mode.
+type StrictOptions struct {
+	// MaxPayloadSize, if positive, is the largest encoded payload Send
+	// accepts, in bytes. It is not enforced when chunking is enabled.
+	MaxPayloadSize int
+	// Validators check payloads by message type before they are sent.
+	Validators map[MessageType]func(payload map[string]interface{}) error
+	// CacheTTL is how long recipient lookups are cached, including
+	// lookups of agents that do not exist. Defaults to 5m.
+	CacheTTL time.Duration
+}
+
+// WithStrictMode makes Send validate each message before posting it: the
+// recipient must exist, must not be blocked by the client, and must accept
+// the message type, and the payload must pass the configured validators
+// and size limit. Failures are returned as a *PreflightError instead of a
+// generic server error. opts may be nil.
+func WithStrictMode(opts *StrictOptions) Option {
+	return func(c *Client) {
+		c.strict = &strictState{}
+		if opts != nil {
+			c.strict.opts = *opts
+		}
+	}
+}
+
+// PreflightError is a message that strict mode refused to send.
+type PreflightError struct {
+	To   string
+	Type MessageType
+	// Check names the failed check: "recipient", "blocked", "type",
+	// "schema" or "size".
+	Check string
+	Err   error
+}
+
+func (e *PreflightError) Error() string {
+	return fmt.Sprintf("preflight %s check failed sending %s to %s: %v", e.Check, e.Type, e.To, e.Err)
+}
+
+func (e *PreflightError) Unwrap() error {
+	return e.Err
+}
+
+// AcceptsType reports whether the agent's capabilities allow messages of
+// type t. Ping and pong are always accepted.
+func (a *Agent) AcceptsType(t MessageType) bool {
+	if t == MessageTypePing || t == MessageTypePong {
+		return true
+	}
+	declared := false
+	for _, capability := range a.Capabilities {
+		accepted, ok := strings.CutPrefix(capability, AcceptsCapabilityPrefix)
+		if !ok {
+			continue
+		}
+		if MessageType(accepted) == t || accepted == "*" {
+			return true
+		}
+		declared = true
+	}
+	return !declared
+}
+
+// strictState is the client's strict mode configuration and recipient
+// cache.
+type strictState struct {
+	opts StrictOptions
+
+	mu     sync.Mutex
+	agents map[string]strictLookup
+}
+
+type strictLookup struct {
+	agent *Agent // nil if the agent does not exist
+	at    time.Time
+}
+
+// preflight runs strict mode's recipient and payload checks.
+func (c *Client) preflight(ctx context.Context, to string, msgType MessageType, payload map[string]interface{}) error {
+	fail := func(check string, err error) error {
+		return &PreflightError{To: to, Type: msgType, Check: check, Err: err}
+	}
+	if c.blocked.has(to) {
+		return fail("blocked", ErrBlocked)
+	}
+	agent, err := c.strictLookup(ctx, to)
+	if err != nil {
+		return err
+	}
+	if agent == nil {
+		return fail("recipient", ErrNotFound)
+	}
+	if !agent.AcceptsType(msgType) {
+		return fail("type", ErrTypeNotAccepted)
+	}
+	if validate := c.strict.opts.Validators[msgType]; validate != nil {
+		if err := validate(payload); err != nil {
+			return fail("schema", err)
+		}
+	}
+	return nil
+}
+
+// preflightSize checks the encoded payload against the size limit.
+func (c *Client) preflightSize(to string, msgType MessageType, wirePayload map[string]interface{}) error {
+	limit := c.strict.opts.MaxPayloadSize
+	if limit <= 0 || c.chunkSize > 0 {
+		return nil
+	}
+	data, err := marshalPayload(wirePayload)
+	if err != nil {
+		return err
+	}
+	if len(data) > limit {
+		err := fmt.Errorf("%w: %d bytes, limit %d", ErrPayloadTooLarge, len(data), limit)
+		return &PreflightError{To: to, Type: msgType, Check: "size", Err: err}
+	}
+	return nil
+}
+
+// strictLookup returns the recipient's agent record, or nil if it does not
+// exist, from the cache when fresh.
+func (c *Client) strictLookup(ctx context.Context, agentID string) (*Agent, error) {
+	s := c.strict
+	ttl := durationOr(s.opts.CacheTTL, defaultStrictCacheTTL)
+	s.mu.Lock()
+	cached, ok := s.agents[agentID]
+	s.mu.Unlock()
+	if ok && time.Since(cached.at) < ttl {
+		return cached.agent, nil
+	}
+
+	agent, err := c.GetAgent(ctx, agentID)
+	if isStatus(err, http.StatusNotFound) {
+		agent, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	if s.agents == nil {
+		s.agents = make(map[string]strictLookup)
+	}
+	s.agents[agentID] = strictLookup{agent: agent, at: time.Now()}
+	s.mu.Unlock()
+	return agent, nil
+}
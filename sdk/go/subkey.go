@@ -0,0 +1,194 @@
+package ping
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aetos53t/ping/sdk/go/wire"
+)
+
+// FeatureDelegation is the server's acceptance of messages and requests
+// signed with sub-keys. Such servers check the delegation chain when
+// accepting a message and return it, with the signed timestamp, on the
+// stored message.
+const FeatureDelegation Feature = "delegated-signing"
+
+// Sub-key scopes. A scope also grants its extensions after a colon, so
+// ScopeSend grants "send:request".
+const (
+	// ScopeSend allows sending messages of any type; "send:<type>" allows
+	// one type.
+	ScopeSend = "send"
+	// ScopeInbox allows reading and acknowledging the agent's inbox.
+	ScopeInbox = "inbox"
+)
+
+// Delegation grants a sub-key scoped use of an agent's identity. See
+// wire.Delegation.
+type Delegation = wire.Delegation
+
+// ErrInvalidDelegation is returned for malformed, expired or wrongly
+// signed delegation chains and for actions a sub-key's scopes do not
+// allow.
+var ErrInvalidDelegation = wire.ErrInvalidDelegation
+
+// SubKey is a key a worker process can send with as the agent, within the
+// scopes and lifetime its delegation chain grants, without holding the
+// agent's own key. It marshals to JSON for handing to the worker.
+type SubKey struct {
+	AgentID string `json:"agentId"`
+	// PrivateKey is the sub-key's hex Ed25519 private key.
+	PrivateKey string       `json:"privateKey"`
+	Chain      []Delegation `json:"chain"`
+}
+
+// Scopes returns the scopes the sub-key holds.
+func (k *SubKey) Scopes() []string {
+	if len(k.Chain) == 0 {
+		return nil
+	}
+	return k.Chain[len(k.Chain)-1].Scopes
+}
+
+// ExpiresAt returns when the sub-key stops being valid.
+func (k *SubKey) ExpiresAt() time.Time {
+	if len(k.Chain) == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(k.Chain[len(k.Chain)-1].ExpiresAt)
+}
+
+// IssueSubKey mints a sub-key for the client's agent with scopes, valid
+// for ttl. A client that is itself using a sub-key can issue narrower
+// ones: their scopes must be within its own, and they expire no later.
+func (c *Client) IssueSubKey(scopes []string, ttl time.Duration) (*SubKey, error) {
+	id := c.identity()
+	if id.agentID == "" {
+		return nil, fmt.Errorf("not registered")
+	}
+	if id.privateKey == nil {
+		return nil, fmt.Errorf("no keys")
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("sub-key needs at least one scope")
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("sub-key needs a positive ttl")
+	}
+
+	d := Delegation{
+		AgentID:   id.agentID,
+		Scopes:    append([]string(nil), scopes...),
+		ExpiresAt: time.Now().Add(ttl).UnixMilli(),
+	}
+	if n := len(id.delegation); n > 0 {
+		if n >= wire.MaxDelegationDepth {
+			return nil, fmt.Errorf("%w: chain already %d long", ErrInvalidDelegation, n)
+		}
+		parent := id.delegation[n-1]
+		for _, s := range scopes {
+			if !wire.ScopeAllows(parent.Scopes, s) {
+				return nil, fmt.Errorf("%w: scope %q exceeds this sub-key's", ErrInvalidDelegation, s)
+			}
+		}
+		if d.ExpiresAt > parent.ExpiresAt {
+			d.ExpiresAt = parent.ExpiresAt
+		}
+	}
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+	d.PublicKey = hex.EncodeToString(pub)
+	d.Sign(id.privateKey)
+	return &SubKey{
+		AgentID:    id.agentID,
+		PrivateKey: hex.EncodeToString(priv),
+		Chain:      append(append([]Delegation(nil), id.delegation...), d),
+	}, nil
+}
+
+// UseSubKey makes the client act for the sub-key's agent, signing
+// messages and requests with the sub-key and attaching its chain. Sending
+// then needs a server with FeatureDelegation, and fails before contacting
+// the server for message types outside the sub-key's scopes.
+func (c *Client) UseSubKey(k *SubKey) error {
+	priv, err := parsePrivateKey(k.PrivateKey)
+	if err != nil {
+		return err
+	}
+	if len(k.Chain) == 0 {
+		return fmt.Errorf("%w: empty chain", ErrInvalidDelegation)
+	}
+	pub := hex.EncodeToString(priv.Public().(ed25519.PublicKey))
+	if k.Chain[len(k.Chain)-1].PublicKey != pub {
+		return fmt.Errorf("%w: chain does not end at this key", ErrInvalidDelegation)
+	}
+	c.idMu.Lock()
+	defer c.idMu.Unlock()
+	c.id = identity{
+		agentID:    k.AgentID,
+		privateKey: priv,
+		publicKey:  pub,
+		delegation: append([]Delegation(nil), k.Chain...),
+	}
+	return nil
+}
+
+// checkDelegation checks that the client's sub-key may send msgType now.
+func (c *Client) checkDelegation(ctx context.Context, id identity, msgType MessageType) error {
+	last := id.delegation[len(id.delegation)-1]
+	if !wire.ScopeAllows(last.Scopes, "send:"+string(msgType)) {
+		return fmt.Errorf("%w: sub-key may not send %s messages", ErrInvalidDelegation, msgType)
+	}
+	if time.Now().UnixMilli() > last.ExpiresAt {
+		return fmt.Errorf("%w: sub-key expired", ErrInvalidDelegation)
+	}
+	return c.requireFeature(ctx, FeatureDelegation)
+}
+
+// VerifyMessage checks msg's signature against its sender's registered
+// key or, for a message sent with a sub-key, its delegation chain, which
+// must be rooted at that key, grant its type and be valid now, as
+// Envelope.Verify requires; the timestamp a sender signs is never trusted
+// to show when the chain was valid.
+//
+// Servers return their receipt time as a message's timestamp, so the
+// signed timestamp is read from the payload's "$signedAt" field, which
+// this SDK adds to every message it sends. Messages from senders that do
+// not add it verify only if the server returns the signed timestamp, and
+// messages whose payload a codec has decoded do not verify.
+func (c *Client) VerifyMessage(ctx context.Context, msg Message) error {
+	root, err := c.peerKey(ctx, msg.From)
+	if err != nil {
+		return err
+	}
+	payload := msg.Payload
+	if len(msg.OriginalPayload) > 0 {
+		payload = msg.OriginalPayload
+	}
+	ts := json.RawMessage(strconv.FormatInt(msg.Timestamp.UnixMilli(), 10))
+	var signed struct {
+		SignedAt json.Number `json:"$signedAt"`
+	}
+	if json.Unmarshal(payload, &signed) == nil && signed.SignedAt != "" {
+		ts = json.RawMessage(signed.SignedAt)
+	}
+	env := &wire.Envelope{
+		Type:       string(msg.Type),
+		From:       msg.From,
+		To:         msg.To,
+		Payload:    payload,
+		ReplyTo:    msg.ReplyTo,
+		Timestamp:  ts,
+		Signature:  msg.Signature,
+		Delegation: msg.Delegation,
+	}
+	return env.Verify(hex.EncodeToString(root))
+}
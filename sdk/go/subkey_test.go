@@ -0,0 +1,61 @@
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aetos53t/ping/sdk/go/pingtest"
+)
+
+// TestVerifyMessageAfterDelivery verifies messages as the server returns
+// them, with its receipt time as the timestamp.
+func TestVerifyMessageAfterDelivery(t *testing.T) {
+	srv := pingtest.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	alice := registerTestClient(t, srv, "alice")
+	bob := registerTestClient(t, srv, "bob")
+
+	if _, err := alice.Text(ctx, bob.AgentID(), "hello <b> &  "); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.Ping(ctx, bob.AgentID()); err != nil {
+		t.Fatal(err)
+	}
+	payload := map[string]interface{}{
+		"zeta": 1.5, "a": "x", "10": 2, "9": 3,
+		"nested": map[string]interface{}{"bb": []interface{}{1, "2"}, "c": nil},
+	}
+	if _, err := alice.Send(ctx, bob.AgentID(), MessageTypeRequest, payload, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	inbox, err := bob.Inbox(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := bob.History(ctx, alice.AgentID(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox) != 3 || len(history) != 3 {
+		t.Fatalf("got %d inbox and %d history messages, want 3", len(inbox), len(history))
+	}
+	for _, m := range append(inbox, history...) {
+		if err := bob.VerifyMessage(ctx, m); err != nil {
+			t.Errorf("VerifyMessage(%s %s): %v", m.Type, m.Payload, err)
+		}
+	}
+
+	tampered := inbox[0]
+	var p map[string]interface{}
+	if err := json.Unmarshal(tampered.Payload, &p); err != nil {
+		t.Fatal(err)
+	}
+	p["text"] = "forged"
+	tampered.Payload, _ = json.Marshal(p)
+	if err := bob.VerifyMessage(ctx, tampered); err == nil {
+		t.Error("VerifyMessage accepted a tampered payload")
+	}
+}
@@ -0,0 +1,206 @@
+package ping
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MessageTypeTelemetry carries a TelemetryReport to a collector agent.
+const MessageTypeTelemetry MessageType = "telemetry"
+
+// TelemetryReport is one aggregation window of usage statistics, as sent
+// by TelemetryReporter.
+type TelemetryReport struct {
+	WindowStart time.Time          `json:"windowStart"`
+	WindowEnd   time.Time          `json:"windowEnd"`
+	Metrics     map[string]float64 `json:"metrics"`
+	// Suppressed counts metrics withheld for having too few contributors.
+	Suppressed int `json:"suppressed,omitempty"`
+	// Epsilon is the differential privacy budget the noise was drawn for,
+	// or 0 if the values are exact.
+	Epsilon float64 `json:"epsilon,omitempty"`
+}
+
+// DecodeTelemetryReport reads the report carried by a telemetry message.
+func DecodeTelemetryReport(msg Message) (*TelemetryReport, error) {
+	if msg.Type != MessageTypeTelemetry {
+		return nil, fmt.Errorf("not a telemetry message: %s", msg.Type)
+	}
+	var r TelemetryReport
+	if err := msg.DecodePayload(&r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// TelemetryReporter aggregates usage statistics locally and sends them to
+// a collector agent once per window, so the collector sees fleet-level
+// totals rather than individual conversations. It is a Service and a
+// Flusher: Run reports at the end of each window and Flush reports the
+// partial window on shutdown.
+type TelemetryReporter struct {
+	Client *Client
+	// Collector is the agent ID reports are sent to.
+	Collector string
+	// Window is the aggregation period. Defaults to 1h.
+	Window time.Duration
+	// MinContributors is the k-anonymity threshold: a metric is reported
+	// only if at least this many distinct peers contributed to it in the
+	// window. 0 or 1 reports every metric.
+	MinContributors int
+	// Epsilon, if positive, adds Laplace noise to every reported value for
+	// epsilon-differential privacy with respect to a single peer. Each
+	// peer's contribution to a metric is clipped to MaxContribution.
+	Epsilon float64
+	// MaxContribution bounds one peer's contribution to one metric per
+	// window when Epsilon is set. Defaults to 1, which suits counts.
+	MaxContribution float64
+	// OnError, if set, receives send errors from Run.
+	OnError func(error)
+
+	mu      sync.Mutex
+	start   time.Time
+	metrics map[string]map[string]float64 // metric -> peer -> total
+}
+
+// Record adds value to metric on behalf of peer, the conversation partner
+// it describes. Peers are only counted for k-anonymity and never leave the
+// process.
+func (r *TelemetryReporter) Record(metric, peer string, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.metrics == nil {
+		r.metrics = make(map[string]map[string]float64)
+		r.start = time.Now()
+	}
+	byPeer := r.metrics[metric]
+	if byPeer == nil {
+		byPeer = make(map[string]float64)
+		r.metrics[metric] = byPeer
+	}
+	byPeer[peer] += value
+}
+
+// Middleware counts handled messages as "messages.<type>" per sender, and
+// handler failures as "errors.<type>".
+func (r *TelemetryReporter) Middleware(next Handler) Handler {
+	return func(ctx context.Context, msg Message) error {
+		r.Record("messages."+string(msg.Type), msg.From, 1)
+		err := next(ctx, msg)
+		if err != nil {
+			r.Record("errors."+string(msg.Type), msg.From, 1)
+		}
+		return err
+	}
+}
+
+// Report closes the current window and returns its report without sending
+// it. It returns nil if nothing was recorded.
+func (r *TelemetryReporter) Report() (*TelemetryReport, error) {
+	r.mu.Lock()
+	metrics, start := r.metrics, r.start
+	r.metrics = nil
+	r.mu.Unlock()
+	if len(metrics) == 0 {
+		return nil, nil
+	}
+
+	report := &TelemetryReport{
+		WindowStart: start.UTC(),
+		WindowEnd:   time.Now().UTC(),
+		Metrics:     make(map[string]float64),
+		Epsilon:     math.Max(r.Epsilon, 0),
+	}
+	bound := r.MaxContribution
+	if bound <= 0 {
+		bound = 1
+	}
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		byPeer := metrics[name]
+		if len(byPeer) < r.MinContributors {
+			report.Suppressed++
+			continue
+		}
+		var total float64
+		for _, v := range byPeer {
+			if r.Epsilon > 0 {
+				v = math.Max(-bound, math.Min(bound, v))
+			}
+			total += v
+		}
+		if r.Epsilon > 0 {
+			noise, err := laplaceNoise(bound / r.Epsilon)
+			if err != nil {
+				return nil, err
+			}
+			total += noise
+		}
+		report.Metrics[name] = total
+	}
+	return report, nil
+}
+
+// Flush implements Flusher by sending the current window's report.
+func (r *TelemetryReporter) Flush(ctx context.Context) error {
+	report, err := r.Report()
+	if err != nil || report == nil {
+		return err
+	}
+	payload := map[string]interface{}{
+		"windowStart": report.WindowStart,
+		"windowEnd":   report.WindowEnd,
+		"metrics":     report.Metrics,
+	}
+	if report.Suppressed > 0 {
+		payload["suppressed"] = report.Suppressed
+	}
+	if report.Epsilon > 0 {
+		payload["epsilon"] = report.Epsilon
+	}
+	_, err = r.Client.Send(ctx, r.Collector, MessageTypeTelemetry, payload, "")
+	return err
+}
+
+// Run implements Service, sending a report at the end of every window
+// until ctx is done.
+func (r *TelemetryReporter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(durationOr(r.Window, time.Hour))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.Flush(ctx); err != nil {
+				reportError(r.OnError, err)
+			}
+		}
+	}
+}
+
+// laplaceNoise draws from a Laplace distribution centred on 0 with the
+// given scale, using crypto/rand so the noise cannot be predicted.
+func laplaceNoise(scale float64) (float64, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	// Uniform in (-0.5, 0.5), excluding the endpoints.
+	u := (float64(binary.BigEndian.Uint64(b[:])>>11)+0.5)/(1<<53) - 0.5
+	sign := 1.0
+	if u < 0 {
+		sign = -1
+	}
+	return -scale * sign * math.Log(1-2*math.Abs(u)), nil
+}
@@ -0,0 +1,247 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// ErrNoTemplate is returned when no template is registered under a name.
+var ErrNoTemplate = errors.New("no such template")
+
+// contactLocaleTTL is how long locales read from contacts are cached.
+const contactLocaleTTL = 5 * time.Minute
+
+// Template is a message template. String values in Payload, including
+// those nested in maps and slices, are text/template sources executed with
+// the data passed to SendTemplate; other values are sent as they are:
+//
+//	ping.Template{
+//	    Type:    ping.MessageTypeRequest,
+//	    Payload: map[string]interface{}{
+//	        "action": "review",
+//	        "text":   "Please review {{.Title}} by {{.Due.Format \"Jan 2\"}}",
+//	    },
+//	}
+type Template struct {
+	// Type is the message type. Defaults to MessageTypeText.
+	Type MessageType
+	// Locale is the locale this variant is for, e.g. "fr" or "pt-BR".
+	// The variant without a locale is used when no other matches.
+	Locale  string
+	Payload map[string]interface{}
+
+	parsed map[string]interface{}
+}
+
+// Templates holds a client's message templates by name and locale. It is
+// safe for concurrent use.
+type Templates struct {
+	mu     sync.RWMutex
+	byName map[string]map[string]*Template // by name, then lower-case locale
+}
+
+// Templates returns the client's template registry.
+func (c *Client) Templates() *Templates {
+	return &c.templates
+}
+
+// Register adds tmpl as the variant of template name for tmpl.Locale,
+// replacing any registered before. It fails if a payload string does not
+// parse.
+func (t *Templates) Register(name string, tmpl Template) error {
+	parsed, err := parseTemplateValue(name, tmpl.Payload)
+	if err != nil {
+		return fmt.Errorf("template %s: %w", name, err)
+	}
+	tmpl.parsed = parsed.(map[string]interface{})
+	if tmpl.Type == "" {
+		tmpl.Type = MessageTypeText
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.byName == nil {
+		t.byName = make(map[string]map[string]*Template)
+	}
+	if t.byName[name] == nil {
+		t.byName[name] = make(map[string]*Template)
+	}
+	t.byName[name][strings.ToLower(tmpl.Locale)] = &tmpl
+	return nil
+}
+
+// Render executes the variant of template name that best matches locale:
+// the exact locale, then its language ("fr" for "fr-CA"), then the
+// variant without a locale.
+func (t *Templates) Render(name, locale string, data interface{}) (MessageType, map[string]interface{}, error) {
+	tmpl := t.lookup(name, locale)
+	if tmpl == nil {
+		return "", nil, fmt.Errorf("%w: %s", ErrNoTemplate, name)
+	}
+	payload, err := executeTemplateValue(tmpl.parsed, data)
+	if err != nil {
+		return "", nil, fmt.Errorf("template %s: %w", name, err)
+	}
+	return tmpl.Type, payload.(map[string]interface{}), nil
+}
+
+func (t *Templates) lookup(name, locale string) *Template {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	variants := t.byName[name]
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	if tmpl, ok := variants[locale]; ok {
+		return tmpl
+	}
+	if lang, _, ok := strings.Cut(locale, "-"); ok {
+		if tmpl, ok := variants[lang]; ok {
+			return tmpl
+		}
+	}
+	return variants[""]
+}
+
+// parseTemplateValue returns v with its strings parsed as templates.
+func parseTemplateValue(name string, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return template.New(name).Option("missingkey=error").Parse(v)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			p, err := parseTemplateValue(name, e)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			out[k] = p
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			p, err := parseTemplateValue(name, e)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", i, err)
+			}
+			out[i] = p
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
+
+// executeTemplateValue returns v with its templates executed with data.
+func executeTemplateValue(v, data interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case *template.Template:
+		var b strings.Builder
+		if err := v.Execute(&b, data); err != nil {
+			return nil, err
+		}
+		return b.String(), nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			x, err := executeTemplateValue(e, data)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = x
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			x, err := executeTemplateValue(e, data)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = x
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
+
+// SendTemplate renders template name with data in the recipient's locale
+// and sends it. The locale is the one set with SetPeerLocale or else the
+// "locale=" tag in the recipient's contact notes, e.g. "locale=fr-CA";
+// without either the template's default variant is used.
+func (c *Client) SendTemplate(ctx context.Context, to, name string, data interface{}, reqOpts ...RequestOption) (*SendResult, error) {
+	msgType, payload, err := c.templates.Render(name, c.recipientLocale(ctx, to), data)
+	if err != nil {
+		return nil, err
+	}
+	return c.Send(ctx, to, msgType, payload, "", reqOpts...)
+}
+
+// SetPeerLocale sets the locale SendTemplate uses for peerID, overriding
+// its contact notes. An empty locale clears it.
+func (c *Client) SetPeerLocale(peerID, locale string) {
+	c.peers.update(peerID, func(p *PeerPrefs) { p.Locale = locale })
+}
+
+// Locale returns the locale tagged in the contact's notes with
+// "locale=" or "locale:", or "".
+func (ct Contact) Locale() string {
+	for _, field := range strings.FieldsFunc(ct.Notes, func(r rune) bool {
+		return r == ' ' || r == ',' || r == ';' || r == '\n' || r == '\t'
+	}) {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok {
+			k, v, ok = strings.Cut(field, ":")
+		}
+		if ok && strings.EqualFold(k, "locale") {
+			return v
+		}
+	}
+	return ""
+}
+
+// recipientLocale returns the locale to render templates in for to. A
+// failed contacts lookup leaves it empty rather than failing the send.
+func (c *Client) recipientLocale(ctx context.Context, to string) string {
+	if locale := c.peers.get(to).Locale; locale != "" {
+		return locale
+	}
+	return c.contactLocales.get(ctx, c, to)
+}
+
+// contactLocaleCache caches the locales tagged in the client's contacts.
+type contactLocaleCache struct {
+	mu       sync.Mutex
+	loadedAt time.Time
+	locales  map[string]string
+}
+
+func (lc *contactLocaleCache) get(ctx context.Context, c *Client, peerID string) string {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if time.Since(lc.loadedAt) > contactLocaleTTL {
+		contacts, err := c.Contacts(ctx)
+		if err != nil {
+			return lc.locales[peerID]
+		}
+		lc.locales = make(map[string]string)
+		for _, ct := range contacts {
+			if locale := ct.Locale(); locale != "" {
+				lc.locales[ct.ContactID] = locale
+			}
+		}
+		lc.loadedAt = time.Now()
+	}
+	return lc.locales[peerID]
+}
+
+// invalidate makes the next lookup reload the contacts.
+func (lc *contactLocaleCache) invalidate() {
+	lc.mu.Lock()
+	lc.loadedAt = time.Time{}
+	lc.mu.Unlock()
+}
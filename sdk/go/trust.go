@@ -0,0 +1,317 @@
+package ping
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrKeyChanged is returned when the server reports a public key for an
+// agent that differs from the key pinned for it.
+var ErrKeyChanged = errors.New("agent public key changed")
+
+// KeyPin is the public key pinned for an agent.
+type KeyPin struct {
+	AgentID   string `json:"agentId"`
+	PublicKey string `json:"publicKey"`
+	// Verified is set for keys pinned with TrustAgent, as opposed to keys
+	// trusted on first use.
+	Verified bool      `json:"verified,omitempty"`
+	PinnedAt time.Time `json:"pinnedAt"`
+}
+
+// KeyChange reports a server lookup that returned a key other than the
+// pinned one.
+type KeyChange struct {
+	Pinned    KeyPin
+	PublicKey string // the key the server returned
+}
+
+// PinningOptions configures key pinning.
+type PinningOptions struct {
+	// Store keeps pinned keys. Defaults to memory, so pins last as long as
+	// the client; use a FilePinStore to keep them across restarts.
+	Store PinStore
+	// Warn accepts a changed key, pinning it in place of the old one,
+	// instead of failing with ErrKeyChanged. Keys pinned with TrustAgent
+	// are never replaced this way; a change to one always fails.
+	Warn bool
+	// OnKeyChange is called for every changed key. If nil, changes
+	// accepted with Warn are logged with slog.
+	OnKeyChange func(KeyChange)
+}
+
+// WithKeyPinning pins peers' public keys on first use. The first time
+// GetAgent returns an agent, its key is saved; later lookups that return a
+// different key fail with ErrKeyChanged, or are accepted with a warning if
+// opts.Warn is set. Peer keys used to verify and encrypt messages come
+// from the pins once saved, so a server that substitutes keys later
+// cannot impersonate a peer. Use TrustAgent to pin a key verified out of
+// band and RevokeTrust to accept a peer's new key. opts may be nil.
+func WithKeyPinning(opts *PinningOptions) Option {
+	return func(c *Client) {
+		c.pinning = &pinningState{}
+		if opts != nil {
+			c.pinning.opts = *opts
+		}
+		if c.pinning.opts.Store == nil {
+			c.pinning.opts.Store = &MemoryPinStore{}
+		}
+	}
+}
+
+// pinningState is the client's key pinning configuration.
+type pinningState struct {
+	opts PinningOptions
+}
+
+// TrustAgent pins publicKeyHex as agentID's key, replacing any pin, e.g.
+// after comparing it with the peer over another channel. It needs
+// WithKeyPinning. Pins are kept in the pin store, not on the server, so
+// reqOpts, taken for symmetry with other calls, have no effect.
+func (c *Client) TrustAgent(ctx context.Context, agentID, publicKeyHex string, reqOpts ...RequestOption) error {
+	err := c.trustAgent(ctx, agentID, publicKeyHex)
+	c.audit(ctx, AuditEntry{Op: AuditTrust, Target: agentID}, err)
+	return err
+}
+
+func (c *Client) trustAgent(ctx context.Context, agentID, publicKeyHex string) error {
+	if c.pinning == nil {
+		return fmt.Errorf("key pinning not enabled")
+	}
+	if key, err := hex.DecodeString(publicKeyHex); err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key for agent %s", agentID)
+	}
+	pin := KeyPin{AgentID: agentID, PublicKey: strings.ToLower(publicKeyHex), Verified: true, PinnedAt: time.Now()}
+	if err := c.pinning.opts.Store.SavePin(ctx, pin); err != nil {
+		return err
+	}
+	c.peers.update(agentID, func(p *PeerPrefs) { p.PublicKey = pin.PublicKey })
+	return nil
+}
+
+// RevokeTrust removes agentID's pinned key, so the key the server returns
+// next is trusted on first use again. It needs WithKeyPinning. As with
+// TrustAgent, reqOpts have no effect.
+func (c *Client) RevokeTrust(ctx context.Context, agentID string, reqOpts ...RequestOption) error {
+	err := c.revokeTrust(ctx, agentID)
+	c.audit(ctx, AuditEntry{Op: AuditRevokeTrust, Target: agentID}, err)
+	return err
+}
+
+func (c *Client) revokeTrust(ctx context.Context, agentID string) error {
+	if c.pinning == nil {
+		return fmt.Errorf("key pinning not enabled")
+	}
+	if err := c.pinning.opts.Store.DeletePin(ctx, agentID); err != nil {
+		return err
+	}
+	c.peers.update(agentID, func(p *PeerPrefs) { p.PublicKey = "" })
+	return nil
+}
+
+// PinnedKey returns the key pinned for agentID, or nil if there is none or
+// pinning is not enabled. As with TrustAgent, reqOpts have no effect.
+func (c *Client) PinnedKey(ctx context.Context, agentID string, reqOpts ...RequestOption) (*KeyPin, error) {
+	if c.pinning == nil {
+		return nil, nil
+	}
+	return c.pinning.opts.Store.Pin(ctx, agentID)
+}
+
+// checkPin compares the key of an agent returned by the server with the
+// pinned one, pinning it if there is none.
+func (c *Client) checkPin(ctx context.Context, agent *Agent) error {
+	if c.pinning == nil || agent.PublicKey == "" {
+		return nil
+	}
+	store := c.pinning.opts.Store
+	pin, err := store.Pin(ctx, agent.ID)
+	if err != nil {
+		return err
+	}
+	if pin == nil {
+		return store.SavePin(ctx, KeyPin{AgentID: agent.ID, PublicKey: strings.ToLower(agent.PublicKey), PinnedAt: time.Now()})
+	}
+	if strings.EqualFold(pin.PublicKey, agent.PublicKey) {
+		return nil
+	}
+
+	change := KeyChange{Pinned: *pin, PublicKey: agent.PublicKey}
+	if fn := c.pinning.opts.OnKeyChange; fn != nil {
+		fn(change)
+	}
+	if !c.pinning.opts.Warn || pin.Verified {
+		return fmt.Errorf("%w: agent %s: pinned %s, server returned %s", ErrKeyChanged, agent.ID, pin.PublicKey, agent.PublicKey)
+	}
+	if c.pinning.opts.OnKeyChange == nil {
+		slog.Default().Warn("ping: agent public key changed", "agent", agent.ID, "pinned", pin.PublicKey, "publicKey", agent.PublicKey)
+	}
+	c.peers.update(agent.ID, func(p *PeerPrefs) { p.PublicKey = "" })
+	return store.SavePin(ctx, KeyPin{AgentID: agent.ID, PublicKey: strings.ToLower(agent.PublicKey), PinnedAt: time.Now()})
+}
+
+// pinnedKey returns the hex key pinned for peerID, or "".
+func (c *Client) pinnedKey(ctx context.Context, peerID string) (string, error) {
+	pin, err := c.PinnedKey(ctx, peerID)
+	if err != nil || pin == nil {
+		return "", err
+	}
+	return pin.PublicKey, nil
+}
+
+// PinStore keeps pinned keys. Implementations must be safe for concurrent
+// use.
+type PinStore interface {
+	// Pin returns the pin for agentID, or nil if there is none.
+	Pin(ctx context.Context, agentID string) (*KeyPin, error)
+	// SavePin inserts or replaces a pin.
+	SavePin(ctx context.Context, pin KeyPin) error
+	// DeletePin removes the pin for agentID, if any.
+	DeletePin(ctx context.Context, agentID string) error
+	// Pins returns all pins, ordered by agent ID.
+	Pins(ctx context.Context) ([]KeyPin, error)
+}
+
+// MemoryPinStore is an in-memory PinStore. The zero value is ready to use.
+type MemoryPinStore struct {
+	mu   sync.Mutex
+	pins map[string]KeyPin
+}
+
+// Pin implements PinStore.
+func (s *MemoryPinStore) Pin(ctx context.Context, agentID string) (*KeyPin, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pin, ok := s.pins[agentID]
+	if !ok {
+		return nil, nil
+	}
+	return &pin, nil
+}
+
+// SavePin implements PinStore.
+func (s *MemoryPinStore) SavePin(ctx context.Context, pin KeyPin) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pins == nil {
+		s.pins = make(map[string]KeyPin)
+	}
+	s.pins[pin.AgentID] = pin
+	return nil
+}
+
+// DeletePin implements PinStore.
+func (s *MemoryPinStore) DeletePin(ctx context.Context, agentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pins, agentID)
+	return nil
+}
+
+// Pins implements PinStore.
+func (s *MemoryPinStore) Pins(ctx context.Context) ([]KeyPin, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]KeyPin, 0, len(s.pins))
+	for _, pin := range s.pins {
+		out = append(out, pin)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].AgentID < out[j].AgentID })
+	return out, nil
+}
+
+// FilePinStore is a PinStore persisted as a JSON file. The file is
+// rewritten on every change.
+type FilePinStore struct {
+	path string
+	mem  MemoryPinStore
+	mu   sync.Mutex
+}
+
+// OpenPinStore opens or creates a pin store file at path.
+func OpenPinStore(path string) (*FilePinStore, error) {
+	s := &FilePinStore{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.mem.pins); err != nil {
+		return nil, fmt.Errorf("pin store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Pin implements PinStore.
+func (s *FilePinStore) Pin(ctx context.Context, agentID string) (*KeyPin, error) {
+	return s.mem.Pin(ctx, agentID)
+}
+
+// SavePin implements PinStore. The pin is kept in memory only once the
+// file has been written.
+func (s *FilePinStore) SavePin(ctx context.Context, pin KeyPin) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pins := s.snapshot()
+	pins[pin.AgentID] = pin
+	if err := s.write(pins); err != nil {
+		return err
+	}
+	return s.mem.SavePin(ctx, pin)
+}
+
+// DeletePin implements PinStore. The pin is removed from memory only once
+// the file has been written.
+func (s *FilePinStore) DeletePin(ctx context.Context, agentID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pins := s.snapshot()
+	if _, ok := pins[agentID]; !ok {
+		return nil
+	}
+	delete(pins, agentID)
+	if err := s.write(pins); err != nil {
+		return err
+	}
+	return s.mem.DeletePin(ctx, agentID)
+}
+
+// Pins implements PinStore.
+func (s *FilePinStore) Pins(ctx context.Context) ([]KeyPin, error) {
+	return s.mem.Pins(ctx)
+}
+
+// snapshot returns a copy of the pins in memory.
+func (s *FilePinStore) snapshot() map[string]KeyPin {
+	s.mem.mu.Lock()
+	defer s.mem.mu.Unlock()
+	pins := make(map[string]KeyPin, len(s.mem.pins)+1)
+	for id, pin := range s.mem.pins {
+		pins[id] = pin
+	}
+	return pins
+}
+
+func (s *FilePinStore) write(pins map[string]KeyPin) error {
+	data, err := json.Marshal(pins)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
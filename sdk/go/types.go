@@ -0,0 +1,89 @@
+package ping
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// MessageType identifies the kind of a message. Types are free-form on the
+// wire; the constants cover the ones the server and SDK know about.
+type MessageType string
+
+// Message types understood by the server.
+const (
+	MessageTypeText      MessageType = "text"
+	MessageTypePing      MessageType = "ping"
+	MessageTypePong      MessageType = "pong"
+	MessageTypeRequest   MessageType = "request"
+	MessageTypeResponse  MessageType = "response"
+	MessageTypeProposal  MessageType = "proposal"
+	MessageTypeSignature MessageType = "signature"
+	MessageTypeCustom    MessageType = "custom"
+)
+
+// Timestamp is a point in time as reported by the server. It accepts unix
+// milliseconds (as a number or numeric string) and RFC 3339 strings, and
+// marshals to RFC 3339 so values round-trip through the store and exports.
+// The zero Timestamp marshals to null.
+type Timestamp struct {
+	time.Time
+}
+
+// NewTimestamp returns t as a Timestamp in UTC.
+func NewTimestamp(t time.Time) Timestamp {
+	return Timestamp{t.UTC()}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.UTC().Format(time.RFC3339Nano))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		t.Time = time.Time{}
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		parsed, err := parseTimestamp(s)
+		if err != nil {
+			return err
+		}
+		t.Time = parsed
+		return nil
+	}
+	ms, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %s", data)
+	}
+	t.Time = time.UnixMilli(int64(ms)).UTC()
+	return nil
+}
+
+// parseTimestamp parses an RFC 3339 string or a unix-millisecond count.
+// The empty string is the zero time.
+func parseTimestamp(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return t.UTC(), nil
+}
@@ -0,0 +1,139 @@
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// FeatureUsage is the usage endpoint, which reports an agent's message
+// counts, storage and quotas.
+const FeatureUsage Feature = "usage"
+
+// quotaExceededCode is the error code servers send with a request refused
+// because a quota is used up.
+const quotaExceededCode = "quota_exceeded"
+
+// ErrQuotaExceeded is matched by errors returned when the server refuses a
+// request because one of the agent's quotas is used up. Such errors are a
+// *QuotaError.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Usage is an agent's consumption as reported by the server.
+type Usage struct {
+	MessagesSent     int64 `json:"messagesSent"`
+	MessagesReceived int64 `json:"messagesReceived"`
+	// StorageBytes is the size of the agent's stored messages.
+	StorageBytes int64 `json:"storageBytes"`
+	// StorageLimit is the most storage the agent may use, or 0 if
+	// unlimited.
+	StorageLimit int64 `json:"storageLimit"`
+	// PeriodStart and PeriodEnd bound the billing period the counts cover.
+	PeriodStart Timestamp `json:"periodStart"`
+	PeriodEnd   Timestamp `json:"periodEnd"`
+	Quotas      []Quota   `json:"quotas"`
+}
+
+// Quota is one limit on an agent's use of the server, such as messages per
+// day or requests per minute.
+type Quota struct {
+	// Name identifies the quota, e.g. "messages.daily".
+	Name      string `json:"name"`
+	Limit     int64  `json:"limit"`
+	Remaining int64  `json:"remaining"`
+	// ResetAt is when Remaining returns to Limit.
+	ResetAt Timestamp `json:"resetAt"`
+}
+
+// Used returns the fraction of the quota used, from 0 to 1.
+func (q Quota) Used() float64 {
+	if q.Limit <= 0 {
+		return 0
+	}
+	return float64(q.Limit-q.Remaining) / float64(q.Limit)
+}
+
+// Quota returns the quota named name.
+func (u *Usage) Quota(name string) (Quota, bool) {
+	for _, q := range u.Quotas {
+		if q.Name == name {
+			return q, true
+		}
+	}
+	return Quota{}, false
+}
+
+// Usage returns the client's agent's message counts, storage used and
+// quotas. It requires FeatureUsage.
+func (c *Client) Usage(ctx context.Context, reqOpts ...RequestOption) (*Usage, error) {
+	if c.AgentID() == "" {
+		return nil, fmt.Errorf("not registered")
+	}
+	if err := c.requireFeature(ctx, FeatureUsage); err != nil {
+		return nil, err
+	}
+	var u Usage
+	if err := c.request(ctx, "GET", "/agents/"+c.AgentID()+"/usage", nil, &u, reqOpts...); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// QuotaError is returned when the server refuses a request, typically a
+// send, because a quota is used up. It matches ErrQuotaExceeded and its
+// *APIError.
+type QuotaError struct {
+	*APIError
+	// Quota names the exhausted quota, if the server said.
+	Quota string
+	// Limit is the quota's limit, if the server said.
+	Limit int64
+	// ResetAt is when the quota resets, from the response or its
+	// Retry-After header, or zero if unknown.
+	ResetAt time.Time
+}
+
+func (e *QuotaError) Error() string {
+	msg := "quota exceeded"
+	if e.Quota != "" {
+		msg += ": " + e.Quota
+	}
+	if !e.ResetAt.IsZero() {
+		msg += " until " + e.ResetAt.UTC().Format(time.RFC3339)
+	}
+	return msg
+}
+
+// Unwrap returns ErrQuotaExceeded and the APIError.
+func (e *QuotaError) Unwrap() []error {
+	return []error{ErrQuotaExceeded, e.APIError}
+}
+
+// quotaError returns the QuotaError for an error response, or nil if it
+// is not a quota refusal.
+func quotaError(resp *http.Response, apiErr *APIError, body []byte) error {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusPaymentRequired {
+		return nil
+	}
+	var q struct {
+		Code    string    `json:"code"`
+		Quota   string    `json:"quota"`
+		Limit   int64     `json:"limit"`
+		ResetAt Timestamp `json:"resetAt"`
+	}
+	json.Unmarshal(body, &q)
+	if q.Code != quotaExceededCode {
+		return nil
+	}
+	e := &QuotaError{APIError: apiErr, Quota: q.Quota, Limit: q.Limit, ResetAt: q.ResetAt.Time}
+	if e.ResetAt.IsZero() {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			e.ResetAt = time.Now().Add(time.Duration(secs) * time.Second)
+		}
+	}
+	return e
+}
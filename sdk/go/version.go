@@ -0,0 +1,196 @@
+package ping
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ProtocolVersion is the PING protocol version this SDK speaks. It is sent
+// with every request in the X-Ping-Version header.
+const ProtocolVersion = "0.1.0"
+
+const versionHeader = "X-Ping-Version"
+
+// oldestVersion is the first PING server version. It is assumed for
+// servers that do not report one, so no newer feature is taken for granted.
+const oldestVersion = "0.1.0"
+
+// ErrUnsupported is returned when an operation needs a server feature that
+// the negotiated server version does not provide.
+var ErrUnsupported = errors.New("not supported by server")
+
+// Feature names an optional server capability.
+type Feature string
+
+// Known server features.
+const (
+	// FeatureMessaging covers the core agent, directory, contact and
+	// message endpoints every PING server provides.
+	FeatureMessaging Feature = "messaging"
+)
+
+// featureVersions maps each feature to the first server version that
+// provides it. Servers that advertise an explicit feature list override this.
+var featureVersions = map[Feature]string{
+	FeatureMessaging: "0.1.0",
+}
+
+// knownFeatures lists every feature this package defines, so CompatCheck
+// can report on each. Features missing from featureVersions are only
+// supported by servers that advertise them.
+var knownFeatures = []Feature{
+	FeatureMessaging,
+	FeatureBlocking,
+	FeatureCapabilityDescriptors,
+	FeatureCBOR,
+	FeatureDelegation,
+	FeatureGzipRequests,
+	FeatureInboxLease,
+	FeatureInboxStats,
+	FeatureMessageLookup,
+	FeatureMessagePack,
+	FeatureMessageRecall,
+	FeatureMessageSearch,
+	FeatureOrgs,
+	FeatureScheduledSend,
+	FeatureSessionAuth,
+	FeatureStream,
+	FeatureUsage,
+	FeatureWebhookManagement,
+}
+
+// ServerInfo describes a PING server.
+type ServerInfo struct {
+	Name        string    `json:"name"`
+	Version     string    `json:"version"`
+	Description string    `json:"description,omitempty"`
+	Features    []Feature `json:"features,omitempty"`
+}
+
+// Negotiate fetches the server's info document and records its version and
+// features. It is called lazily before the first use of an optional feature.
+func (c *Client) Negotiate(ctx context.Context, reqOpts ...RequestOption) (*ServerInfo, error) {
+	var info ServerInfo
+	err := c.request(ctx, "GET", "/info", nil, &info, reqOpts...)
+	if isStatus(err, 404) {
+		err = c.request(ctx, "GET", "/", nil, &info, reqOpts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Version == "" {
+		info.Version = oldestVersion
+	}
+
+	c.mu.Lock()
+	c.serverVersion = info.Version
+	c.serverFeatures = nil
+	if len(info.Features) > 0 {
+		c.serverFeatures = make(map[Feature]bool, len(info.Features))
+		for _, f := range info.Features {
+			c.serverFeatures[f] = true
+		}
+	}
+	c.negotiated = true
+	c.mu.Unlock()
+	return &info, nil
+}
+
+// NegotiatedVersion returns the server version seen in the most recent
+// response or info document, or "" if none has been seen yet.
+func (c *Client) NegotiatedVersion() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.serverVersion
+}
+
+// Supports reports whether the server is known to provide f. It never
+// contacts the server; call Negotiate first for an authoritative answer.
+func (c *Client) Supports(f Feature) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.serverFeatures != nil {
+		return c.serverFeatures[f]
+	}
+	minVersion, ok := featureVersions[f]
+	if !ok || c.serverVersion == "" {
+		return false
+	}
+	return compareVersions(c.serverVersion, minVersion) >= 0
+}
+
+// requireFeature negotiates with the server if needed and returns
+// ErrUnsupported if f is not available.
+func (c *Client) requireFeature(ctx context.Context, f Feature) error {
+	c.mu.RLock()
+	negotiated := c.negotiated
+	c.mu.RUnlock()
+	if !negotiated {
+		if _, err := c.Negotiate(ctx); err != nil {
+			return err
+		}
+	}
+	if !c.Supports(f) {
+		return &FeatureError{Feature: f, ServerVersion: c.NegotiatedVersion()}
+	}
+	return nil
+}
+
+// observeVersion records a version advertised in a response header.
+func (c *Client) observeVersion(v string) {
+	c.mu.Lock()
+	c.serverVersion = v
+	c.mu.Unlock()
+}
+
+// FeatureError reports a feature the server does not provide. It matches
+// ErrUnsupported with errors.Is.
+type FeatureError struct {
+	Feature       Feature
+	ServerVersion string
+}
+
+func (e *FeatureError) Error() string {
+	return "feature " + string(e.Feature) + " not supported by server version " + e.ServerVersion
+}
+
+func (e *FeatureError) Is(target error) bool {
+	return target == ErrUnsupported
+}
+
+// compareVersions compares two dotted version strings numerically,
+// ignoring any leading "v" and pre-release suffix.
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) []int {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	var parts []int
+	for _, s := range strings.Split(v, ".") {
+		n, _ := strconv.Atoi(s)
+		parts = append(parts, n)
+	}
+	return parts
+}
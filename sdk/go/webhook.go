@@ -0,0 +1,117 @@
+package ping
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// FeatureWebhookManagement lets an agent change, test and disable its
+// webhook after registration.
+const FeatureWebhookManagement Feature = "webhook-management"
+
+// MessageTypeWebhookTest is the type of the test delivery the server
+// sends for TestWebhook. Manager.ServeHTTP answers it without dispatching
+// it to handlers.
+const MessageTypeWebhookTest MessageType = "webhook.test"
+
+// WebhookEvent selects what the server delivers to a webhook.
+type WebhookEvent string
+
+// Webhook events.
+const (
+	// WebhookEventMessage delivers incoming messages.
+	WebhookEventMessage WebhookEvent = "message"
+	// WebhookEventAck reports when a recipient acknowledges a message the
+	// agent sent.
+	WebhookEventAck WebhookEvent = "ack"
+)
+
+// Webhook is an agent's webhook configuration.
+type Webhook struct {
+	URL     string         `json:"url"`
+	Events  []WebhookEvent `json:"events"`
+	Enabled bool           `json:"enabled"`
+}
+
+// WebhookTestResult is the outcome of a test delivery.
+type WebhookTestResult struct {
+	Delivered bool `json:"delivered"`
+	// Status is the HTTP status the webhook answered with, or 0 if it could
+	// not be reached.
+	Status    int    `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+// SetWebhook points the agent's webhook at url and enables it. events
+// selects what is delivered; nil means incoming messages only.
+func (c *Client) SetWebhook(ctx context.Context, url string, events []WebhookEvent, reqOpts ...RequestOption) (*Webhook, error) {
+	if err := c.requireWebhooks(ctx); err != nil {
+		return nil, err
+	}
+	if events == nil {
+		events = []WebhookEvent{WebhookEventMessage}
+	}
+	body := map[string]interface{}{"url": url, "events": events}
+	var hook Webhook
+	if err := c.request(ctx, "PUT", c.webhookPath(), body, &hook, reqOpts...); err != nil {
+		return nil, err
+	}
+	return &hook, nil
+}
+
+// Webhook returns the agent's webhook configuration, or nil if it has
+// none.
+func (c *Client) Webhook(ctx context.Context, reqOpts ...RequestOption) (*Webhook, error) {
+	if err := c.requireWebhooks(ctx); err != nil {
+		return nil, err
+	}
+	var hook Webhook
+	err := c.request(ctx, "GET", c.webhookPath(), nil, &hook, reqOpts...)
+	if isStatus(err, http.StatusNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &hook, nil
+}
+
+// TestWebhook asks the server to send a signed MessageTypeWebhookTest
+// delivery to the agent's webhook and reports how it went. A webhook that
+// could not be reached is not an error; check Delivered.
+func (c *Client) TestWebhook(ctx context.Context, reqOpts ...RequestOption) (*WebhookTestResult, error) {
+	if err := c.requireWebhooks(ctx); err != nil {
+		return nil, err
+	}
+	var result WebhookTestResult
+	if err := c.request(ctx, "POST", c.webhookPath()+"/test", nil, &result, reqOpts...); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// DisableWebhook removes the agent's webhook, so messages wait in the
+// inbox for polling or streaming.
+func (c *Client) DisableWebhook(ctx context.Context, reqOpts ...RequestOption) error {
+	if err := c.requireWebhooks(ctx); err != nil {
+		return err
+	}
+	err := c.request(ctx, "DELETE", c.webhookPath(), nil, nil, reqOpts...)
+	if isStatus(err, http.StatusNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (c *Client) requireWebhooks(ctx context.Context) error {
+	if c.AgentID() == "" {
+		return fmt.Errorf("not registered")
+	}
+	return c.requireFeature(ctx, FeatureWebhookManagement)
+}
+
+func (c *Client) webhookPath() string {
+	return "/agents/" + c.AgentID() + "/webhook"
+}
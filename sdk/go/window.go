@@ -0,0 +1,86 @@
+package ping
+
+import (
+	"strconv"
+	"time"
+)
+
+// messageWindow restricts which messages History, Inbox and their
+// iterators return. It is sent to the server as query parameters and also
+// applied client-side, for servers that ignore them.
+type messageWindow struct {
+	since   time.Time
+	until   time.Time
+	msgType MessageType
+}
+
+// WithSince restricts History, Inbox and their iterators to messages
+// sent at or after t. Other calls ignore it.
+func WithSince(t time.Time) RequestOption {
+	return func(cfg *callConfig) {
+		cfg.window.since = t
+	}
+}
+
+// WithUntil restricts History, Inbox and their iterators to messages
+// sent at or before t. Other calls ignore it.
+func WithUntil(t time.Time) RequestOption {
+	return func(cfg *callConfig) {
+		cfg.window.until = t
+	}
+}
+
+// WithMessageType restricts History, Inbox and their iterators to messages
+// of type t. Other calls ignore it.
+func WithMessageType(t MessageType) RequestOption {
+	return func(cfg *callConfig) {
+		cfg.window.msgType = t
+	}
+}
+
+func (w *messageWindow) empty() bool {
+	return w.since.IsZero() && w.until.IsZero() && w.msgType == ""
+}
+
+// query adds the window's query parameters to path.
+func (w *messageWindow) query(path string) string {
+	if !w.since.IsZero() {
+		path = withQuery(path, "since", strconv.FormatInt(w.since.UnixMilli(), 10))
+	}
+	if !w.until.IsZero() {
+		path = withQuery(path, "until", strconv.FormatInt(w.until.UnixMilli(), 10))
+	}
+	if w.msgType != "" {
+		path = withQuery(path, "type", string(w.msgType))
+	}
+	return path
+}
+
+func (w *messageWindow) match(m *Message) bool {
+	if !w.since.IsZero() && m.Timestamp.Before(w.since) {
+		return false
+	}
+	if !w.until.IsZero() && m.Timestamp.After(w.until) {
+		return false
+	}
+	return w.msgType == "" || m.Type == w.msgType
+}
+
+// filter keeps the messages in msgs that match the window, in place.
+func (w *messageWindow) filter(msgs []Message) []Message {
+	if w.empty() {
+		return msgs
+	}
+	out := msgs[:0]
+	for i := range msgs {
+		if w.match(&msgs[i]) {
+			out = append(out, msgs[i])
+		}
+	}
+	return out
+}
+
+// windowOf returns the message window set by reqOpts.
+func windowOf(reqOpts []RequestOption) *messageWindow {
+	return &newCallConfig(reqOpts).window
+}
@@ -0,0 +1,138 @@
+package wire
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeaderDelegation carries the delegation chain of a request signed with
+// a sub-key, as base64url-encoded JSON.
+const HeaderDelegation = "X-Ping-Delegation"
+
+// DelegationSigningPrefix is the first line of a delegation's signing
+// string.
+const DelegationSigningPrefix = "PING-DELEGATION-V2"
+
+// MaxDelegationDepth is the longest delegation chain VerifyDelegation
+// accepts.
+const MaxDelegationDepth = 4
+
+// ErrInvalidDelegation is returned when a delegation chain is malformed,
+// expired, wrongly signed or does not grant the scope needed.
+var ErrInvalidDelegation = errors.New("invalid delegation")
+
+// Delegation lets a sub-key act for an agent within scopes until it
+// expires. The first delegation in a chain is signed by the agent's own
+// key and each later one by the key the one before it delegates to, so a
+// holder of a sub-key can hand narrower sub-keys on without the root key.
+type Delegation struct {
+	AgentID string `json:"agentId"`
+	// PublicKey is the hex public key of the sub-key.
+	PublicKey string `json:"publicKey"`
+	// Scopes are what the sub-key may do, such as "send", "send:request"
+	// or "inbox". A scope "x" also grants "x:anything".
+	Scopes []string `json:"scopes"`
+	// ExpiresAt is the end of the delegation in Unix milliseconds.
+	ExpiresAt int64  `json:"expiresAt"`
+	Signature string `json:"signature"`
+}
+
+// SigningBytes returns the bytes a delegation signature covers: the
+// prefix, agent ID, sub-key, scopes and expiry, one per line. The scopes
+// are a JSON array, so no scope can pass for two.
+func (d *Delegation) SigningBytes() []byte {
+	scopes := d.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	rawScopes, _ := marshal(scopes)
+	return []byte(strings.Join([]string{
+		DelegationSigningPrefix, d.AgentID, d.PublicKey,
+		string(rawScopes), strconv.FormatInt(d.ExpiresAt, 10),
+	}, "\n"))
+}
+
+// Sign sets the delegation's signature using the issuer's private key: the
+// agent's key for the first delegation in a chain, otherwise the sub-key
+// of the delegation before it.
+func (d *Delegation) Sign(issuer ed25519.PrivateKey) {
+	d.Signature = hex.EncodeToString(ed25519.Sign(issuer, d.SigningBytes()))
+}
+
+// VerifyDelegation checks chain for agentID, whose own key is rootKeyHex,
+// at time at. It returns the hex key the chain finally delegates to and
+// the scopes it holds. Each delegation must be signed by its issuer,
+// unexpired at at, and grant no scope its issuer lacks.
+func VerifyDelegation(chain []Delegation, rootKeyHex, agentID string, at time.Time) (string, []string, error) {
+	if len(chain) == 0 || len(chain) > MaxDelegationDepth {
+		return "", nil, fmt.Errorf("%w: chain of %d", ErrInvalidDelegation, len(chain))
+	}
+	issuer := rootKeyHex
+	var scopes []string
+	for i := range chain {
+		d := &chain[i]
+		if d.AgentID != agentID {
+			return "", nil, fmt.Errorf("%w: for agent %s, not %s", ErrInvalidDelegation, d.AgentID, agentID)
+		}
+		pub, err := ParsePublicKey(issuer)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %v", ErrInvalidDelegation, err)
+		}
+		sig, err := hex.DecodeString(d.Signature)
+		if err != nil || !ed25519.Verify(pub, d.SigningBytes(), sig) {
+			return "", nil, fmt.Errorf("%w: bad signature at link %d", ErrInvalidDelegation, i)
+		}
+		if at.UnixMilli() > d.ExpiresAt {
+			return "", nil, fmt.Errorf("%w: expired at link %d", ErrInvalidDelegation, i)
+		}
+		if i > 0 {
+			for _, s := range d.Scopes {
+				if !ScopeAllows(scopes, s) {
+					return "", nil, fmt.Errorf("%w: link %d widens scope %q", ErrInvalidDelegation, i, s)
+				}
+			}
+		}
+		issuer, scopes = d.PublicKey, d.Scopes
+	}
+	return issuer, scopes, nil
+}
+
+// ScopeAllows reports whether scopes grant want: exactly, or through a
+// scope that want extends after a colon.
+func ScopeAllows(scopes []string, want string) bool {
+	for _, s := range scopes {
+		if s == want || strings.HasPrefix(want, s+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// EncodeDelegationHeader encodes chain for HeaderDelegation.
+func EncodeDelegationHeader(chain []Delegation) (string, error) {
+	data, err := json.Marshal(chain)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeDelegationHeader decodes a HeaderDelegation value.
+func DecodeDelegationHeader(v string) ([]Delegation, error) {
+	data, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDelegation, err)
+	}
+	var chain []Delegation
+	if err := json.Unmarshal(data, &chain); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDelegation, err)
+	}
+	return chain, nil
+}
@@ -0,0 +1,109 @@
+package wire
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Request signature headers. See docs/REQUEST_SIGNING.md.
+const (
+	HeaderAgent     = "X-Ping-Agent"
+	HeaderKey       = "X-Ping-Key"
+	HeaderTimestamp = "X-Ping-Timestamp"
+	HeaderNonce     = "X-Ping-Nonce"
+	HeaderSignature = "X-Ping-Signature"
+)
+
+// RequestSigningPrefix is the first line of a request signing string.
+const RequestSigningPrefix = "PING-REQUEST-V1"
+
+// DefaultMaxSkew is the clock skew VerifyRequest tolerates by default.
+const DefaultMaxSkew = 5 * time.Minute
+
+// ErrInvalidRequestSignature is returned by VerifyRequest when a request is
+// unsigned, stale or carries a bad signature.
+var ErrInvalidRequestSignature = errors.New("invalid request signature")
+
+// SignRequest adds signature headers to req, covering the method, path,
+// query, a timestamp, a random nonce and the hash of body, which must be
+// the exact bytes sent. The request names the sender by agentID, or by
+// its hex public key before the agent is registered.
+func SignRequest(req *http.Request, body []byte, key ed25519.PrivateKey, agentID string) error {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	n := hex.EncodeToString(nonce[:])
+
+	if agentID != "" {
+		req.Header.Set(HeaderAgent, agentID)
+	} else {
+		req.Header.Set(HeaderKey, hex.EncodeToString(key.Public().(ed25519.PublicKey)))
+	}
+	req.Header.Set(HeaderTimestamp, ts)
+	req.Header.Set(HeaderNonce, n)
+	sig := ed25519.Sign(key, RequestSigningString(req.Method, req.URL.RequestURI(), ts, n, body))
+	req.Header.Set(HeaderSignature, hex.EncodeToString(sig))
+	return nil
+}
+
+// RequestSigningString builds the bytes covered by a request signature.
+func RequestSigningString(method, requestURI, timestamp, nonce string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	return []byte(RequestSigningPrefix + "\n" +
+		method + "\n" +
+		requestURI + "\n" +
+		timestamp + "\n" +
+		nonce + "\n" +
+		hex.EncodeToString(sum[:]))
+}
+
+// VerifyRequest checks the signature headers on an incoming request against
+// the sender's hex public key. It reads and restores r.Body. Timestamps
+// further than maxSkew from now are rejected (DefaultMaxSkew if zero);
+// callers that need replay protection should also remember the
+// X-Ping-Nonce values they accept.
+func VerifyRequest(r *http.Request, publicKeyHex string, maxSkew time.Duration) error {
+	if maxSkew == 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	pub, err := ParsePublicKey(publicKeyHex)
+	if err != nil {
+		return err
+	}
+	sig, err := hex.DecodeString(r.Header.Get(HeaderSignature))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return ErrInvalidRequestSignature
+	}
+	ts := r.Header.Get(HeaderTimestamp)
+	millis, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrInvalidRequestSignature
+	}
+	if skew := time.Since(time.UnixMilli(millis)); skew > maxSkew || skew < -maxSkew {
+		return ErrInvalidRequestSignature
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	msg := RequestSigningString(r.Method, r.URL.RequestURI(), ts, r.Header.Get(HeaderNonce), body)
+	if !ed25519.Verify(pub, msg, sig) {
+		return ErrInvalidRequestSignature
+	}
+	return nil
+}
@@ -0,0 +1,398 @@
+// Package wire implements PING's signing, canonicalization and
+// verification rules without an HTTP client, so webhook receivers, custom
+// servers and test harnesses can create and check PING-signed messages and
+// requests. The ping package uses it for everything it signs.
+package wire
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSignature is returned by Envelope.Verify when a message's
+// signature does not match its contents and sender key.
+var ErrInvalidSignature = errors.New("invalid message signature")
+
+// Envelope is a message as posted to POST /messages: the signed fields and
+// the sender's signature over them.
+type Envelope struct {
+	Type    string          `json:"type"`
+	From    string          `json:"from"`
+	To      string          `json:"to"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	ReplyTo string          `json:"replyTo,omitempty"`
+	// Timestamp is the sender's clock in Unix milliseconds, as a JSON
+	// number.
+	Timestamp json.RawMessage `json:"timestamp,omitempty"`
+	Signature string          `json:"signature"`
+	// Delegation, for messages signed with a sub-key, is the chain from
+	// the sender's key to the sub-key. It is not covered by the signature;
+	// its own signatures authenticate it.
+	Delegation []Delegation `json:"delegation,omitempty"`
+	// Meta is request-scoped metadata, such as trace or tenant IDs. It is
+	// not covered by the signature, so it must not be trusted for
+	// authorization.
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// NewEnvelope builds an unsigned envelope. The payload is encoded as
+// JSON.stringify would encode it, without HTML escaping, with object keys
+// in the order PostgreSQL's jsonb stores them: shorter keys first, then
+// bytewise. Servers that store payloads as jsonb then return them with the
+// keys in the order they were signed. A nil payload is sent as null.
+func NewEnvelope(msgType, from, to string, payload interface{}, replyTo string, ts time.Time) (*Envelope, error) {
+	raw, err := marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var ordered bytes.Buffer
+	if err := restringify(&ordered, raw, true); err != nil {
+		return nil, err
+	}
+	raw = ordered.Bytes()
+	return &Envelope{
+		Type:      msgType,
+		From:      from,
+		To:        to,
+		Payload:   raw,
+		ReplyTo:   replyTo,
+		Timestamp: json.RawMessage(strconv.FormatInt(ts.UnixMilli(), 10)),
+	}, nil
+}
+
+// SigningBytes returns the bytes a message signature covers. They are the
+// signed fields in the order type, from, to, payload, replyTo, timestamp,
+// re-serialized the way the server's JSON.parse and JSON.stringify do:
+// compact, object keys in their original order and no HTML escaping.
+// Absent payload, replyTo and timestamp fields are left out.
+func (e *Envelope) SigningBytes() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"type":`)
+	writeString(&buf, e.Type)
+	buf.WriteString(`,"from":`)
+	writeString(&buf, e.From)
+	buf.WriteString(`,"to":`)
+	writeString(&buf, e.To)
+	if len(e.Payload) > 0 {
+		buf.WriteString(`,"payload":`)
+		if err := Restringify(&buf, e.Payload); err != nil {
+			return nil, err
+		}
+	}
+	if e.ReplyTo != "" {
+		buf.WriteString(`,"replyTo":`)
+		writeString(&buf, e.ReplyTo)
+	}
+	if len(e.Timestamp) > 0 {
+		buf.WriteString(`,"timestamp":`)
+		if err := json.Compact(&buf, e.Timestamp); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Sign sets the envelope's signature using the sender's private key.
+func (e *Envelope) Sign(key ed25519.PrivateKey) error {
+	b, err := e.SigningBytes()
+	if err != nil {
+		return err
+	}
+	e.Signature = hex.EncodeToString(ed25519.Sign(key, b))
+	return nil
+}
+
+// Verify checks the envelope's signature against the sender's hex public
+// key. An envelope with a delegation chain must instead be signed by the
+// chain's sub-key, and the chain must be rooted at publicKeyHex, valid now
+// and grant the scope "send:<type>".
+func (e *Envelope) Verify(publicKeyHex string) error {
+	if len(e.Delegation) > 0 {
+		key, scopes, err := VerifyDelegation(e.Delegation, publicKeyHex, e.From, time.Now())
+		if err != nil {
+			return err
+		}
+		if !ScopeAllows(scopes, "send:"+e.Type) {
+			return fmt.Errorf("%w: sub-key may not send %s messages", ErrInvalidDelegation, e.Type)
+		}
+		publicKeyHex = key
+	}
+	pub, err := ParsePublicKey(publicKeyHex)
+	if err != nil {
+		return err
+	}
+	sig, err := hex.DecodeString(e.Signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return ErrInvalidSignature
+	}
+	b, err := e.SigningBytes()
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if !ed25519.Verify(pub, b, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// ParsePublicKey decodes a hex Ed25519 public key.
+func ParsePublicKey(publicKeyHex string) (ed25519.PublicKey, error) {
+	pub, err := hex.DecodeString(publicKeyHex)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	return ed25519.PublicKey(pub), nil
+}
+
+// Restringify writes raw JSON to buf the way JSON.parse followed by
+// JSON.stringify would: compact, numbers in ECMAScript's shortest form (1.0
+// as 1, 1e2 as 100, 1e21 as 1e+21) and strings escaped only where JSON
+// requires it, so U+2028, U+2029 and HTML characters are written as they
+// are. Object keys keep their original order, except that array-index
+// keys ("0" to "4294967294") come first in ascending order, as V8 orders
+// them, and a repeated key keeps its first position and its last value.
+func Restringify(buf *bytes.Buffer, raw json.RawMessage) error {
+	return restringify(buf, raw, false)
+}
+
+// Canonicalize writes raw JSON to buf as Restringify does, but with the
+// keys of every object sorted as jsonb sorts them, so any two encodings of
+// the same value canonicalize to the same bytes whatever their key order.
+// It suits signatures over values that are decoded and re-encoded, or
+// stored by the server, between signing and verifying.
+func Canonicalize(buf *bytes.Buffer, raw json.RawMessage) error {
+	return restringify(buf, raw, true)
+}
+
+// restringify is Restringify, with other keys in jsonb order rather than
+// their original order if jsonb is set.
+func restringify(buf *bytes.Buffer, raw json.RawMessage, jsonb bool) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := restringifyValue(buf, dec, jsonb); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("invalid JSON: data after top-level value")
+	}
+	return nil
+}
+
+// restringifyValue writes the next value from dec to buf.
+func restringifyValue(buf *bytes.Buffer, dec *json.Decoder, jsonb bool) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '[' {
+			buf.WriteByte('[')
+			for i := 0; dec.More(); i++ {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				if err := restringifyValue(buf, dec, jsonb); err != nil {
+					return err
+				}
+			}
+			if _, err := dec.Token(); err != nil {
+				return err
+			}
+			buf.WriteByte(']')
+			return nil
+		}
+		return restringifyObject(buf, dec, jsonb)
+	case string:
+		writeString(buf, t)
+	case json.Number:
+		writeNumber(buf, t)
+	case bool:
+		fmt.Fprint(buf, t)
+	case nil:
+		buf.WriteString("null")
+	}
+	return nil
+}
+
+// restringifyObject writes the members of the object whose opening brace
+// was just read from dec, in the order JSON.stringify gives them, after
+// sorting them as jsonb does if jsonb is set.
+func restringifyObject(buf *bytes.Buffer, dec *json.Decoder, jsonb bool) error {
+	type member struct {
+		key     string
+		index   uint64
+		isIndex bool
+		value   []byte
+	}
+	var members []member
+	pos := make(map[string]int)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		var value bytes.Buffer
+		if err := restringifyValue(&value, dec, jsonb); err != nil {
+			return err
+		}
+		if i, ok := pos[key]; ok {
+			members[i].value = value.Bytes()
+			continue
+		}
+		pos[key] = len(members)
+		index, isIndex := arrayIndex(key)
+		members = append(members, member{key: key, index: index, isIndex: isIndex, value: value.Bytes()})
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	if jsonb {
+		sort.SliceStable(members, func(i, j int) bool {
+			a, b := members[i].key, members[j].key
+			if len(a) != len(b) {
+				return len(a) < len(b)
+			}
+			return a < b
+		})
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		a, b := members[i], members[j]
+		if a.isIndex && b.isIndex {
+			return a.index < b.index
+		}
+		return a.isIndex && !b.isIndex
+	})
+	buf.WriteByte('{')
+	for i, m := range members {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeString(buf, m.key)
+		buf.WriteByte(':')
+		buf.Write(m.value)
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// arrayIndex reports whether key is the canonical form of an array index,
+// an integer from 0 to 2^32-2, and returns it.
+func arrayIndex(key string) (uint64, bool) {
+	if key == "" || len(key) > 10 || (key[0] == '0' && len(key) > 1) {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(key, 10, 64)
+	if err != nil || n > math.MaxUint32-1 {
+		return 0, false
+	}
+	return n, true
+}
+
+// marshal encodes v as JSON without HTML escaping.
+func marshal(v interface{}) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// writeString writes s as a JSON string the way JSON.stringify does:
+// quotes, backslashes and control characters are escaped, with the short
+// escapes where they exist and \u00xx otherwise, and nothing else is.
+func writeString(buf *bytes.Buffer, s string) {
+	const hexDigits = "0123456789abcdef"
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if c < 0x20 {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hexDigits[c>>4])
+				buf.WriteByte(hexDigits[c&0xf])
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+// writeNumber writes n the way JSON.stringify writes the number JSON.parse
+// reads from it: the shortest digits that round-trip, in decimal notation
+// from 1e-7 up to 1e21 and in exponent notation outside it. Numbers too
+// large for a float64 become null, as Infinity does.
+func writeNumber(buf *bytes.Buffer, n json.Number) {
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil || math.IsInf(f, 0) {
+		buf.WriteString("null")
+		return
+	}
+	if f == 0 {
+		buf.WriteByte('0') // including -0
+		return
+	}
+	if f < 0 {
+		buf.WriteByte('-')
+		f = -f
+	}
+	// FormatFloat's 'e' form is d.ddde±xx with the shortest digits.
+	mant, exp, _ := strings.Cut(strconv.FormatFloat(f, 'e', -1, 64), "e")
+	digits := strings.Replace(mant, ".", "", 1)
+	e, _ := strconv.Atoi(exp)
+	k, point := len(digits), e+1 // point is where the decimal point goes
+	switch {
+	case k <= point && point <= 21:
+		buf.WriteString(digits)
+		buf.WriteString(strings.Repeat("0", point-k))
+	case 0 < point && point <= 21:
+		buf.WriteString(digits[:point])
+		buf.WriteByte('.')
+		buf.WriteString(digits[point:])
+	case -6 < point && point <= 0:
+		buf.WriteString("0.")
+		buf.WriteString(strings.Repeat("0", -point))
+		buf.WriteString(digits)
+	default:
+		buf.WriteByte(digits[0])
+		if k > 1 {
+			buf.WriteByte('.')
+			buf.WriteString(digits[1:])
+		}
+		buf.WriteByte('e')
+		if e >= 0 {
+			buf.WriteByte('+')
+		}
+		buf.WriteString(strconv.Itoa(e))
+	}
+}
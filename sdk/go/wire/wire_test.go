@@ -0,0 +1,103 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// The expected outputs are JSON.stringify(JSON.parse(in)) as run by Node.
+var restringifyVectors = []struct {
+	in, want string
+}{
+	// Numbers
+	{`1.0`, `1`},
+	{`-1.50`, `-1.5`},
+	{`1e2`, `100`},
+	{`1E+2`, `100`},
+	{`2.5E-3`, `0.0025`},
+	{`0.000001`, `0.000001`},
+	{`0.0000001`, `1e-7`},
+	{`1.5e-7`, `1.5e-7`},
+	{`123e-20`, `1.23e-18`},
+	{`1e20`, `100000000000000000000`},
+	{`1e21`, `1e+21`},
+	{`-0`, `0`},
+	{`-0.0`, `0`},
+	{`0.1`, `0.1`},
+	{`12345678901234567890`, `12345678901234567000`},
+	{`1.7976931348623157e308`, `1.7976931348623157e+308`},
+	{`5e-324`, `5e-324`},
+	{`1e400`, `null`},
+	{`{"b": 1.0, "a": [1e2, 0.50]}`, `{"b":1,"a":[100,0.5]}`},
+
+	// Strings
+	{`"line\u2028sep\u2029end"`, "\"line\u2028sep\u2029end\""},
+	{`"\u003cb\u003e \u0026"`, `"<b> &"`},
+	{`"\u0001\b\f\n\r\t\"\\\/"`, `"\u0001\b\f\n\r\t\"\\/"`},
+	{`"\u00e9\ud83d\ude00"`, `"é😀"`},
+	{`{"\u2028key": "v"}`, "{\"\u2028key\":\"v\"}"},
+
+	// Key order
+	{`{"b":1,"10":2,"9":3}`, `{"9":3,"10":2,"b":1}`},
+	{`{"b":1,"4294967295":1,"4294967294":2,"01":3,"1":4,"-1":5,"1.5":6}`, `{"1":4,"4294967294":2,"b":1,"4294967295":1,"01":3,"-1":5,"1.5":6}`},
+	{`{"a":1,"b":2,"a":3}`, `{"a":3,"b":2}`},
+	{`{"2":1,"1":2,"2":3}`, `{"1":2,"2":3}`},
+	{`[{"z":{"1":[],"0":{}}},{}]`, `[{"z":{"0":{},"1":[]}},{}]`},
+}
+
+func TestRestringify(t *testing.T) {
+	for _, v := range restringifyVectors {
+		var buf bytes.Buffer
+		if err := Restringify(&buf, json.RawMessage(v.in)); err != nil {
+			t.Errorf("Restringify(%s): %v", v.in, err)
+			continue
+		}
+		if got := buf.String(); got != v.want {
+			t.Errorf("Restringify(%s) = %s, want %s", v.in, got, v.want)
+		}
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	for _, in := range []string{
+		`{"state":{"b":[1.0,{"y":1,"x":2}],"a":"<&>"},"id":"r1"}`,
+		`{"id":"r1","state":{"a":"\u003c\u0026\u003e","b":[1,{"x":2,"y":1}]}}`,
+		`{ "id" : "r1", "state" : { "b" : [ 1e0, { "x" : 2.0, "y" : 1 } ], "a" : "<&>" } }`,
+	} {
+		var buf bytes.Buffer
+		if err := Canonicalize(&buf, json.RawMessage(in)); err != nil {
+			t.Fatalf("Canonicalize(%s): %v", in, err)
+		}
+		if got, want := buf.String(), `{"id":"r1","state":{"a":"<&>","b":[1,{"x":2,"y":1}]}}`; got != want {
+			t.Errorf("Canonicalize(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestRestringifyMalformed(t *testing.T) {
+	for _, in := range []string{``, `{`, `{"a"}`, `[1,]`, `{"a":1}x`, `{"a":1} {}`} {
+		var buf bytes.Buffer
+		if err := Restringify(&buf, json.RawMessage(in)); err == nil {
+			t.Errorf("Restringify(%q) = %s, want an error", in, buf.String())
+		}
+	}
+}
+
+func TestSigningBytesNormalizesPayload(t *testing.T) {
+	e := &Envelope{
+		Type:      "text",
+		From:      "a",
+		To:        "b",
+		Payload:   json.RawMessage(`{"n": 1.0, "text": "x\u2028y", "10": 2, "9": 3}`),
+		Timestamp: json.RawMessage(`1700000000000`),
+	}
+	got, err := e.SigningBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\"type\":\"text\",\"from\":\"a\",\"to\":\"b\",\"payload\":{\"9\":3,\"10\":2,\"n\":1,\"text\":\"x\u2028y\"},\"timestamp\":1700000000000}"
+	if string(got) != want {
+		t.Errorf("SigningBytes = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,126 @@
+package ping
+
+import (
+	"context"
+	"sync"
+)
+
+// WithWorkers makes Poller, Subscription and Manager.Run handle up to n
+// received messages at once instead of one at a time. Messages with the
+// same order key, by default those of one conversation, are still handled
+// one after another in the order received. Once n messages are in flight,
+// including ones waiting behind their order key, receiving pauses until
+// one finishes.
+func WithWorkers(n int) Option {
+	return func(c *Client) {
+		c.workers = n
+	}
+}
+
+// WithOrderKey sets the order key used with WithWorkers. Messages with the
+// same key are handled in order; messages with different keys may be
+// handled concurrently. The default, ConversationKey, orders each
+// conversation; SenderKey orders by sender alone, and a key of
+// msg.ID leaves all messages unordered.
+func WithOrderKey(key func(Message) string) Option {
+	return func(c *Client) {
+		c.orderKey = key
+	}
+}
+
+// ConversationKey is an order key for the conversation between a
+// message's sender and recipient.
+func ConversationKey(msg Message) string {
+	return msg.From + "\x00" + msg.To
+}
+
+// SenderKey is an order key for a message's sender.
+func SenderKey(msg Message) string {
+	return msg.From
+}
+
+// workerPool runs handlers on up to cap(slots) goroutines, serializing
+// those with the same order key. A nil pool runs them inline.
+type workerPool struct {
+	key   func(Message) string
+	slots chan struct{}
+	wg    sync.WaitGroup
+
+	mu     sync.Mutex
+	queues map[string][]func() // pending work per key being handled
+}
+
+// newWorkerPool returns the pool configured by WithWorkers, or nil to
+// handle messages inline.
+func (c *Client) newWorkerPool() *workerPool {
+	if c.workers <= 1 {
+		return nil
+	}
+	key := c.orderKey
+	if key == nil {
+		key = ConversationKey
+	}
+	return &workerPool{
+		key:    key,
+		slots:  make(chan struct{}, c.workers),
+		queues: make(map[string][]func()),
+	}
+}
+
+// do runs fn(msg), after any earlier work with the same key. It blocks
+// while the pool is full.
+func (p *workerPool) do(msg Message, fn func(Message)) {
+	if p == nil {
+		fn(msg)
+		return
+	}
+	p.slots <- struct{}{}
+	p.wg.Add(1)
+	work := func() {
+		defer p.wg.Done()
+		defer func() { <-p.slots }()
+		fn(msg)
+	}
+
+	k := p.key(msg)
+	p.mu.Lock()
+	if q, busy := p.queues[k]; busy {
+		p.queues[k] = append(q, work)
+		p.mu.Unlock()
+		return
+	}
+	p.queues[k] = nil
+	p.mu.Unlock()
+	go p.drain(k, work)
+}
+
+// drain runs work and then the work queued behind it for key k.
+func (p *workerPool) drain(k string, work func()) {
+	for work != nil {
+		work()
+		p.mu.Lock()
+		if q := p.queues[k]; len(q) > 0 {
+			work, p.queues[k] = q[0], q[1:]
+		} else {
+			delete(p.queues, k)
+			work = nil
+		}
+		p.mu.Unlock()
+	}
+}
+
+// wait blocks until all work passed to do has finished.
+func (p *workerPool) wait() {
+	if p != nil {
+		p.wg.Wait()
+	}
+}
+
+// handle runs h on msg in the pool, reporting its error to onError.
+func (p *workerPool) handle(ctx context.Context, msg Message, h Handler, onError func(error)) {
+	p.do(msg, func(msg Message) {
+		if err := h(ctx, msg); err != nil {
+			reportError(onError, err)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package ping
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"math/big"
+)
+
+// curve25519P is the field prime 2^255 - 19.
+var curve25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// x25519PrivateKey derives the X25519 key corresponding to an Ed25519
+// private key, as libsodium's crypto_sign_ed25519_sk_to_curve25519 does.
+func x25519PrivateKey(priv ed25519.PrivateKey) (*ecdh.PrivateKey, error) {
+	h := sha512.Sum512(priv.Seed())
+	return ecdh.X25519().NewPrivateKey(h[:32])
+}
+
+// x25519PublicKey converts an Ed25519 public key to its X25519 form using
+// the birational map u = (1 + y) / (1 - y).
+func x25519PublicKey(pub ed25519.PublicKey) (*ecdh.PublicKey, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key length: %d", len(pub))
+	}
+	// Decode y from little-endian, dropping the sign bit of x.
+	le := make([]byte, 32)
+	for i := range le {
+		le[i] = pub[31-i]
+	}
+	le[0] &= 0x7f
+	y := new(big.Int).SetBytes(le)
+
+	one := big.NewInt(1)
+	num := new(big.Int).Add(one, y)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, curve25519P)
+	if den.Sign() == 0 {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	u := num.Mul(num, den.ModInverse(den, curve25519P))
+	u.Mod(u, curve25519P)
+
+	be := u.FillBytes(make([]byte, 32))
+	out := make([]byte, 32)
+	for i := range out {
+		out[i] = be[31-i]
+	}
+	return ecdh.X25519().NewPublicKey(out)
+}
+
+// sharedKey derives a 32-byte symmetric key between an agent's Ed25519
+// private key and a peer's Ed25519 public key, bound to info.
+func sharedKey(priv ed25519.PrivateKey, peer ed25519.PublicKey, info string) ([]byte, error) {
+	xpriv, err := x25519PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	xpub, err := x25519PublicKey(peer)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := xpriv.ECDH(xpub)
+	if err != nil {
+		return nil, err
+	}
+	return hkdfSHA256(secret, nil, []byte(info), 32), nil
+}
+
+// hkdfSHA256 implements HKDF (RFC 5869) with SHA-256.
+func hkdfSHA256(secret, salt, info []byte, length int) []byte {
+	if salt == nil {
+		salt = make([]byte, sha256.Size)
+	}
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	var out, prev []byte
+	for counter := byte(1); len(out) < length; counter++ {
+		expand := hmac.New(sha256.New, prk)
+		expand.Write(prev)
+		expand.Write(info)
+		expand.Write([]byte{counter})
+		prev = expand.Sum(nil)
+		out = append(out, prev...)
+	}
+	return out[:length]
+}
@@ -0,0 +1,140 @@
+package ping
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"encoding/hex"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestX25519RFC7748 checks the X25519 function sharedKey relies on
+// against the vectors of RFC 7748, sections 5.2 and 6.1.
+func TestX25519RFC7748(t *testing.T) {
+	priv, err := ecdh.X25519().NewPrivateKey(mustHex(t, "a546e36bf0527c9d3b16154b82465edd62144c0ac1fc5a18506a2244ba449ac4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ecdh.X25519().NewPublicKey(mustHex(t, "e6db6867583030db3594c1a424b15f7c726624ec26b3353b10a903a6d0ab1c4c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := priv.ECDH(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "c3da55379de9c6908e94ea4df28d084f32eccf03491c71f754b4075577a28552"; hex.EncodeToString(out) != want {
+		t.Errorf("X25519 (5.2) = %x, want %s", out, want)
+	}
+
+	alice, err := ecdh.X25519().NewPrivateKey(mustHex(t, "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := ecdh.X25519().NewPrivateKey(mustHex(t, "5dab087e624a8a4b79e17f8b83800ee66f3bb1292618b6fd1c2f8b27ff88e0eb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(alice.PublicKey().Bytes()), "8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a"; got != want {
+		t.Errorf("Alice's public key = %s, want %s", got, want)
+	}
+	if got, want := hex.EncodeToString(bob.PublicKey().Bytes()), "de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f"; got != want {
+		t.Errorf("Bob's public key = %s, want %s", got, want)
+	}
+	k1, err := alice.ECDH(bob.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := bob.ECDH(alice.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742"; hex.EncodeToString(k1) != want || !bytes.Equal(k1, k2) {
+		t.Errorf("shared secrets %x and %x, want %s", k1, k2, want)
+	}
+}
+
+// TestX25519FromEd25519 converts the RFC 8032 test 1 key. The expected
+// public key is X25519 of the clamped SHA-512 half of the seed, as
+// computed by Node's crypto, so it checks the birational map against an
+// independent scalar multiplication.
+func TestX25519FromEd25519(t *testing.T) {
+	edPriv := ed25519.NewKeyFromSeed(mustHex(t, testSeed))
+	edPub := edPriv.Public().(ed25519.PublicKey)
+	const want = "d85e07ec22b0ad881537c2f44d662d1a143cf830c57aca4305d85c7a90f6b62e"
+
+	xpub, err := x25519PublicKey(edPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(xpub.Bytes()); got != want {
+		t.Errorf("x25519PublicKey = %s, want %s", got, want)
+	}
+	xpriv, err := x25519PrivateKey(edPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(xpriv.PublicKey().Bytes()); got != want {
+		t.Errorf("x25519PrivateKey public key = %s, want %s", got, want)
+	}
+
+	if _, err := x25519PublicKey(edPub[:31]); err == nil {
+		t.Error("x25519PublicKey accepted a short key")
+	}
+	one := make([]byte, 32)
+	one[0] = 1 // y = 1 has no Montgomery form
+	if _, err := x25519PublicKey(one); err == nil {
+		t.Error("x25519PublicKey accepted y = 1")
+	}
+}
+
+func TestSharedKey(t *testing.T) {
+	alicePub, alice, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobPub, bob, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k1, err := sharedKey(alice, bobPub, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := sharedKey(bob, alicePub, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k1) != 32 || !bytes.Equal(k1, k2) {
+		t.Errorf("sharedKey gave %x and %x, want equal 32-byte keys", k1, k2)
+	}
+	k3, err := sharedKey(alice, bobPub, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(k1, k3) {
+		t.Error("sharedKey ignored info")
+	}
+}
+
+// TestHKDFRFC5869 checks hkdfSHA256 against RFC 5869 test cases 1 and 3.
+func TestHKDFRFC5869(t *testing.T) {
+	ikm := bytes.Repeat([]byte{0x0b}, 22)
+	got := hkdfSHA256(ikm, mustHex(t, "000102030405060708090a0b0c"), mustHex(t, "f0f1f2f3f4f5f6f7f8f9"), 42)
+	if want := "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865"; hex.EncodeToString(got) != want {
+		t.Errorf("test case 1: %x, want %s", got, want)
+	}
+	got = hkdfSHA256(ikm, nil, nil, 42)
+	if want := "8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d9d201395faa4b61a96c8"; hex.EncodeToString(got) != want {
+		t.Errorf("test case 3: %x, want %s", got, want)
+	}
+}